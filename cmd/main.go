@@ -1,17 +1,25 @@
 package main
 
 import (
+	"context"
 	"flag"
+	"fmt"
+	"os"
+	"strings"
 	"time"
 
 	zaplogfmt "github.com/sykesm/zap-logfmt"
 	uzap "go.uber.org/zap"
 	"go.uber.org/zap/zapcore"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/client-go/kubernetes"
+	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/client/config"
 	logf "sigs.k8s.io/controller-runtime/pkg/log"
 	"sigs.k8s.io/controller-runtime/pkg/log/zap"
 
+	"github.com/tmax-cloud/image-validating-webhook/pkg/admissions/pods"
 	"github.com/tmax-cloud/image-validating-webhook/pkg/server"
 
 	_ "github.com/tmax-cloud/image-validating-webhook/pkg/admissions"
@@ -25,6 +33,23 @@ func main() {
 		Development: false,
 	}
 	opts.BindFlags(flag.CommandLine)
+	pods.BindFlags(flag.CommandLine)
+	selfTestImage := flag.String("selftest-image", "", "Image to be validated by the selftest command (optional)")
+	selfTestNamespace := flag.String("selftest-namespace", "default", "Namespace where the selftest image is validated")
+	reportFormat := flag.String("report-format", pods.ReportFormatJSON, "Format of the report written by the report command (json, sarif)")
+	reportNamespace := flag.String("report-namespace", "", "Namespace whose pods are validated by the report command, unless report-manifests is given")
+	var reportManifests []string
+	flag.Func("report-manifests", "Comma-separated manifest files whose pods are validated by the report command", func(s string) error {
+		reportManifests = strings.Split(s, ",")
+		return nil
+	})
+	var clientCAFiles []string
+	flag.Func("client-ca-files", "Comma-separated CA files verifying the client certificates (e.g., the API server's)", func(s string) error {
+		clientCAFiles = strings.Split(s, ",")
+		return nil
+	})
+	requireClientCert := flag.Bool("require-client-cert", false, "Reject the clients without a certificate verified by the client-ca-files")
+	grpcListen := flag.String("grpc-listen", "", "Address the gRPC validation service is served on (e.g., 0.0.0.0:9443). It's not served if it's empty")
 	flag.Parse()
 
 	configLog := uzap.NewProductionEncoderConfig()
@@ -53,6 +78,75 @@ func main() {
 		panic(err)
 	}
 
+	if flag.Arg(0) == "selftest" {
+		os.Exit(selfTest(&server.HandlerConfig{RestCfg: cfg, ClientSet: clientSet, RestClient: clientSet.RESTClient()}, *selfTestImage, *selfTestNamespace))
+	}
+
+	if flag.Arg(0) == "report" {
+		os.Exit(report(&server.HandlerConfig{RestCfg: cfg, ClientSet: clientSet, RestClient: clientSet.RESTClient()}, *reportFormat, *reportNamespace, reportManifests))
+	}
+
 	webhookServer := server.New(cert, key, listenOn, cfg, clientSet, clientSet.RESTClient())
-	webhookServer.Start()
+	if len(clientCAFiles) > 0 {
+		if err := webhookServer.SetClientCAs(clientCAFiles, *requireClientCert); err != nil {
+			panic(err)
+		}
+	} else if *requireClientCert {
+		panic("require-client-cert needs client-ca-files")
+	}
+	if *grpcListen != "" {
+		webhookServer.SetGRPCListen(*grpcListen)
+	}
+	webhookServer.Start(ctrl.SetupSignalHandler().Done())
+}
+
+// selfTest runs the self-test checks, prints the report and returns the exit code
+func selfTest(cfg *server.HandlerConfig, testImage, testNamespace string) int {
+	exitCode := 0
+	for _, r := range pods.RunSelfTest(cfg, testImage, testNamespace) {
+		if r.Passed() {
+			fmt.Printf("[PASS] %s\n", r.Name)
+		} else {
+			fmt.Printf("[FAIL] %s: %s\n", r.Name, r.Err)
+			exitCode = 1
+		}
+	}
+	return exitCode
+}
+
+// report validates the pods of the manifests (or the namespace, if there're no manifests), writes the report to stdout and returns the exit code.
+// It exits with 1 if any pod is not passed, and 2 if it couldn't validate the pods
+func report(cfg *server.HandlerConfig, format, namespace string, manifests []string) int {
+	var targets []*corev1.Pod
+	if len(manifests) > 0 {
+		read, err := pods.ReadPodManifests(manifests, namespace)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 2
+		}
+		targets = read
+	} else {
+		list, err := cfg.ClientSet.CoreV1().Pods(namespace).List(context.Background(), metav1.ListOptions{})
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 2
+		}
+		for i := range list.Items {
+			targets = append(targets, &list.Items[i])
+		}
+	}
+
+	r, err := pods.RunReport(cfg, targets)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+	if err := pods.WriteReport(os.Stdout, r, format); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+	if r.Failed > 0 {
+		return 1
+	}
+	return 0
 }
@@ -4,6 +4,7 @@ import (
 	"encoding/base64"
 	"encoding/json"
 	"fmt"
+	"io/ioutil"
 	"net/url"
 
 	corev1 "k8s.io/api/core/v1"
@@ -12,13 +13,25 @@ import (
 // Keys for docker configs
 const (
 	DockerConfigAuthKey     = "auth"
+	DockerConfigUsernameKey = "username"
 	DockerConfigUserKey     = "user"
 	DockerConfigPasswordKey = "password"
+	DockerConfigIDKey       = "ID"
+	DockerConfigPasswdKey   = "PASSWD"
 )
 
+// dockerConfigIDPWKeys are the pairs of the id and pw keys of the docker configs, tried in order if there is no basic auth
+var dockerConfigIDPWKeys = [][2]string{
+	{DockerConfigUsernameKey, DockerConfigPasswordKey},
+	{DockerConfigUserKey, DockerConfigPasswordKey},
+	{DockerConfigIDKey, DockerConfigPasswdKey},
+}
+
 // DockerConfigJSON is a top-level dcj
 type DockerConfigJSON struct {
 	Auths map[string]DockerLoginCredential `json:"auths"`
+	// CredHelpers are the credential helpers of the hosts. They are not executed, so the hosts are regarded as having no credential
+	CredHelpers map[string]string `json:"credHelpers,omitempty"`
 }
 
 // DockerLoginCredential is a [basic|id|pw]:auth map
@@ -52,12 +65,31 @@ func NewImagePullSecret(secret *corev1.Secret) (*ImagePullSecret, error) {
 	}, nil
 }
 
+// ReadDockerConfigFile reads a docker config file (e.g., ~/.docker/config.json)
+func ReadDockerConfigFile(path string) (*DockerConfigJSON, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var dockerConfigJSON DockerConfigJSON
+	if err := json.Unmarshal(b, &dockerConfigJSON); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal docker config file %s", path)
+	}
+	return &dockerConfigJSON, nil
+}
+
 // GetHostBasicAuth parses a PullSecret for the given host
 func (s *ImagePullSecret) GetHostBasicAuth(host string) (string, error) {
-	loginAuth, ok := s.json.Auths[host]
+	return s.json.GetHostBasicAuth(host)
+}
+
+// GetHostBasicAuth gets the basic auth of the given host from the auths
+func (c *DockerConfigJSON) GetHostBasicAuth(host string) (string, error) {
+	loginAuth, ok := c.Auths[host]
 	if !ok {
 		u, _ := url.Parse(host)
-		loginAuth, ok = s.json.Auths[u.Host]
+		loginAuth, ok = c.Auths[u.Host]
 		// DO NOT return error, image may be public
 		if !ok {
 			return "", nil
@@ -68,10 +100,21 @@ func (s *ImagePullSecret) GetHostBasicAuth(host string) (string, error) {
 		return basicAuth, nil
 	}
 
-	username, isUserPresent := loginAuth[DockerConfigUserKey]
-	password, isPasswordPresent := loginAuth[DockerConfigPasswordKey]
-	if isUserPresent && isPasswordPresent {
-		return base64.StdEncoding.EncodeToString([]byte(fmt.Sprintf("%s:%s", username, password))), nil
+	for _, keys := range dockerConfigIDPWKeys {
+		username, isUserPresent := loginAuth[keys[0]]
+		password, isPasswordPresent := loginAuth[keys[1]]
+		if isUserPresent && isPasswordPresent {
+			return base64.StdEncoding.EncodeToString([]byte(fmt.Sprintf("%s:%s", username, password))), nil
+		}
 	}
 	return "", fmt.Errorf("there is neither basic auth nor id/pw in docker config json for host %s", host)
 }
+
+// GetHostCredHelper returns the credential helper of the given host, if there is
+func (c *DockerConfigJSON) GetHostCredHelper(host string) string {
+	if helper, ok := c.CredHelpers[host]; ok {
+		return helper
+	}
+	u, _ := url.Parse(host)
+	return c.CredHelpers[u.Host]
+}
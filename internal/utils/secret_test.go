@@ -2,6 +2,8 @@ package utils
 
 import (
 	"encoding/base64"
+	"io/ioutil"
+	"path/filepath"
 	"testing"
 
 	"github.com/stretchr/testify/require"
@@ -149,6 +151,33 @@ func TestImagePullSecret_GetHostBasicAuth(t *testing.T) {
 			expectedErrorOccurs: false,
 			expectedErrorString: "",
 		},
+		"usernamePassword": {
+			host: "https://found-host",
+			auths: map[string]DockerLoginCredential{
+				"https://found-host": {"username": "testID", "password": "testPW"},
+			},
+			expectedAuth:        base64.StdEncoding.EncodeToString([]byte("testID:testPW")),
+			expectedErrorOccurs: false,
+			expectedErrorString: "",
+		},
+		"idPasswd": {
+			host: "https://found-host",
+			auths: map[string]DockerLoginCredential{
+				"https://found-host": {"ID": "testID", "PASSWD": "testPW"},
+			},
+			expectedAuth:        base64.StdEncoding.EncodeToString([]byte("testID:testPW")),
+			expectedErrorOccurs: false,
+			expectedErrorString: "",
+		},
+		"basicAuthFirst": {
+			host: "https://found-host",
+			auths: map[string]DockerLoginCredential{
+				"https://found-host": {"auth": "dummy", "username": "testID", "password": "testPW"},
+			},
+			expectedAuth:        "dummy",
+			expectedErrorOccurs: false,
+			expectedErrorString: "",
+		},
 		"noProperKeys": {
 			host: "https://found-host",
 			auths: map[string]DockerLoginCredential{
@@ -179,3 +208,22 @@ func TestImagePullSecret_GetHostBasicAuth(t *testing.T) {
 		})
 	}
 }
+
+func TestReadDockerConfigFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.json")
+	require.NoError(t, ioutil.WriteFile(path, []byte(`{"auths":{"https://found-host":{"auth":"dummy"}},"credHelpers":{"helper-host":"ecr-login"}}`), 0600))
+
+	cfg, err := ReadDockerConfigFile(path)
+	require.NoError(t, err)
+
+	auth, err := cfg.GetHostBasicAuth("https://found-host")
+	require.NoError(t, err)
+	require.Equal(t, "dummy", auth)
+	require.Equal(t, "ecr-login", cfg.GetHostCredHelper("https://helper-host"))
+	require.Equal(t, "", cfg.GetHostCredHelper("https://found-host"))
+
+	// Malformed file
+	require.NoError(t, ioutil.WriteFile(path, []byte(`{"auths":`), 0600))
+	_, err = ReadDockerConfigFile(path)
+	require.Error(t, err)
+}
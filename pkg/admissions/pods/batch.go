@@ -0,0 +1,74 @@
+package pods
+
+import (
+	"strings"
+	"sync"
+
+	"github.com/tmax-cloud/image-validating-webhook/pkg/notary"
+	corev1 "k8s.io/api/core/v1"
+)
+
+// PodValidationResult is the validation result of a pod in a batch
+type PodValidationResult struct {
+	Valid bool
+	// Reason is why the pod is not valid
+	Reason string
+	// Err is the internal error occurred while validating the pod
+	Err error
+}
+
+// batchValidator validates the pods in a batch, sharing the signatures fetched across them
+type batchValidator interface {
+	CheckPodsValidAndAddDigest(pods []*corev1.Pod) []PodValidationResult
+}
+
+// CheckPodsValidAndAddDigest validates each of the pods as CheckIsValidAndAddDigest, adding digests to their images.
+// The signatures fetched for a pod are reused for the others in the batch, so the same images are fetched only once.
+// An error of a pod doesn't stop validating the others
+func (h *validator) CheckPodsValidAndAddDigest(pods []*corev1.Pod) []PodValidationResult {
+	batch := *h
+	batch.batchSignatures = newSignatureBatch()
+
+	results := make([]PodValidationResult, len(pods))
+	for i, pod := range pods {
+		valid, reason, err := batch.CheckIsValidAndAddDigest(pod)
+		results[i] = PodValidationResult{Valid: valid, Reason: reason, Err: err}
+	}
+	return results
+}
+
+// signatureBatch holds the signatures fetched in a batch. Unlike notary.SignatureCache, they're kept by the basic auth
+// and the release roles they're fetched by, so that a pod can't be validated by the signatures it has no access to
+type signatureBatch struct {
+	lock       sync.Mutex
+	signatures map[string]*notary.Signature
+}
+
+func newSignatureBatch() *signatureBatch {
+	return &signatureBatch{signatures: map[string]*notary.Signature{}}
+}
+
+func signatureBatchKey(ref *imageRef, basicAuth, notaryURL string, releaseRoles []string) string {
+	return strings.Join([]string{notaryURL, ref.String(), credentialScope(basicAuth), strings.Join(releaseRoles, ",")}, "|")
+}
+
+func (b *signatureBatch) get(key string) (*notary.Signature, bool) {
+	if b == nil {
+		return nil, false
+	}
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	sig, exist := b.signatures[key]
+	return sig, exist
+}
+
+func (b *signatureBatch) set(key string, sig *notary.Signature) {
+	if b == nil {
+		return
+	}
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	b.signatures[key] = sig
+}
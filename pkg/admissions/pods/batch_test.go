@@ -0,0 +1,81 @@
+package pods
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/http/httputil"
+	"net/url"
+	"sync/atomic"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	notarytest "github.com/tmax-cloud/image-validating-webhook/pkg/notary/test"
+	whv1 "github.com/tmax-cloud/image-validating-webhook/pkg/type"
+	watcherfake "github.com/tmax-cloud/image-validating-webhook/pkg/watcher/fake"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestValidator_CheckPodsValidAndAddDigest(t *testing.T) {
+	testSrv, err := notarytest.New(false)
+	require.NoError(t, err)
+	u, err := url.Parse(testSrv.URL)
+	require.NoError(t, err)
+	_, err = testSrv.SignImage(testSrv.URL, u.Host, testImageSignCheck, testTag, "11111111111111111111111111111111")
+	require.NoError(t, err)
+
+	// Count the requests to the notary server
+	var requests int64
+	proxy := httputil.NewSingleHostReverseProxy(u)
+	proxy.Transport = &http.Transport{TLSClientConfig: &tls.Config{InsecureSkipVerify: true}}
+	countingSrv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		atomic.AddInt64(&requests, 1)
+		proxy.ServeHTTP(w, req)
+	}))
+	defer countingSrv.Close()
+
+	v := &validator{client: fake.NewSimpleClientset(), whiteList: &WhiteList{}}
+	v.registryPolicyCache = &RegistryPolicyCache{clusterCachedClient: &watcherfake.CachedClient{
+		Cache: map[string]runtime.Object{
+			"policy": &whv1.ClusterRegistrySecurityPolicy{
+				ObjectMeta: metav1.ObjectMeta{Name: "policy"},
+				Spec: whv1.ClusterRegistrySecurityPolicySpec{
+					Registries: []whv1.RegistrySpec{{Registry: u.Host, Notary: countingSrv.URL, SignCheck: true}},
+				},
+			},
+		},
+	}, namespaceCachedClient: &watcherfake.CachedClient{}}
+
+	signedImg := fmt.Sprintf("%s/%s:%s", u.Host, testImageSignCheck, testTag)
+
+	// Requests of a pod
+	valid, reason, err := v.CheckIsValidAndAddDigest(generateTestPod(signedImg, testCheckSign, ""))
+	require.NoError(t, err)
+	require.True(t, valid, reason)
+	podRequests := atomic.SwapInt64(&requests, 0)
+	require.NotZero(t, podRequests)
+
+	// The signature is fetched once for the pods of the same image
+	pods := []*corev1.Pod{
+		generateTestPod(signedImg, testCheckSign, ""),
+		generateTestPod(signedImg, testNoCheckSign, ""),
+		generateTestPod("other.registry/not-matched:v1", testCheckSign, ""),
+		generateTestPod(signedImg, testCheckSign, ""),
+	}
+	results := v.CheckPodsValidAndAddDigest(pods)
+	require.Len(t, results, 4)
+	for _, i := range []int{0, 1, 3} {
+		require.Equal(t, PodValidationResult{Valid: true}, results[i])
+		require.Regexp(t, "@sha256:[0-9a-f]{64}$", pods[i].Spec.Containers[0].Image)
+	}
+	require.False(t, results[2].Valid, "the other pods are validated independently")
+	require.NoError(t, results[2].Err)
+	require.Equal(t, podRequests, atomic.LoadInt64(&requests))
+
+	// The signatures are not shared out of the batch
+	require.Nil(t, v.batchSignatures)
+}
@@ -0,0 +1,121 @@
+package pods
+
+import (
+	"context"
+	"fmt"
+	"sync/atomic"
+
+	admissionv1beta1 "k8s.io/api/admission/v1beta1"
+	authenticationv1 "k8s.io/api/authentication/v1"
+	authorizationv1 "k8s.io/api/authorization/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	logf "sigs.k8s.io/controller-runtime/pkg/log"
+)
+
+// BreakGlassAnnotation is the pod annotation bypassing the validation in emergencies, whose value is the ticket id of the emergency.
+// It's honored only if break-glass is enabled, and the requesting user is authorized to break glass
+const BreakGlassAnnotation = "tmax.io/break-glass"
+
+const (
+	// breakGlassVerb is the verb of registrysecuritypolicies in the pod's namespace, the requesting user should be authorized to, to break glass
+	breakGlassVerb     = "break-glass"
+	breakGlassGroup    = "tmax.io"
+	breakGlassResource = "registrysecuritypolicies"
+)
+
+var (
+	auditLog = logf.Log.WithName("audit")
+
+	// breakGlassAdmissions is the number of the pods admitted by breaking glass
+	breakGlassAdmissions uint64
+)
+
+// breakGlassAuthorizer checks if the users are authorized to break glass, by SubjectAccessReviews
+type breakGlassAuthorizer struct {
+	client kubernetes.Interface
+}
+
+// authorize checks if the user is authorized to break glass in the namespace
+func (b *breakGlassAuthorizer) authorize(user authenticationv1.UserInfo, namespace string) (bool, error) {
+	allowed, err := reviewAccess(b.client, user, &authorizationv1.ResourceAttributes{
+		Namespace: namespace,
+		Verb:      breakGlassVerb,
+		Group:     breakGlassGroup,
+		Resource:  breakGlassResource,
+	})
+	if err != nil {
+		return false, fmt.Errorf("couldn't review the access of user %s to break glass by %s", user.Username, err)
+	}
+	return allowed, nil
+}
+
+// reviewAccess checks if the user is authorized to the resource attributes, by a SubjectAccessReview
+func reviewAccess(client kubernetes.Interface, user authenticationv1.UserInfo, attributes *authorizationv1.ResourceAttributes) (bool, error) {
+	extra := map[string]authorizationv1.ExtraValue{}
+	for k, v := range user.Extra {
+		extra[k] = authorizationv1.ExtraValue(v)
+	}
+	sar := &authorizationv1.SubjectAccessReview{
+		Spec: authorizationv1.SubjectAccessReviewSpec{
+			ResourceAttributes: attributes,
+			User:               user.Username,
+			Groups:             user.Groups,
+			UID:                user.UID,
+			Extra:              extra,
+		},
+	}
+	resp, err := client.AuthorizationV1().SubjectAccessReviews().Create(context.Background(), sar, metav1.CreateOptions{})
+	if err != nil {
+		return false, err
+	}
+	return resp.Status.Allowed, nil
+}
+
+// handleBreakGlass handles the review of the pod annotated to break glass. It returns false if the pod is not annotated or break-glass is disabled,
+// so that the pod is validated as usual. The pods of the authorized users are allowed without validation, and the others are denied.
+// The annotation is evaluated only when it's newly added (i.e., on CREATE, or on the UPDATE adding it). The updates of the pod which already
+// broke glass are validated as usual, so that the other users (e.g., the controllers updating its labels) are not denied by lacking the verb
+func (a *ImageAdmission) handleBreakGlass(review *admissionv1beta1.AdmissionReview, annotations map[string]string) (bool, error) {
+	ticket, exist := annotations[BreakGlassAnnotation]
+	if !exist {
+		return false, nil
+	}
+	oldPod, err := oldPodOf(review.Request)
+	if err != nil {
+		return true, err
+	}
+	if oldPod != nil {
+		if _, broken := oldPod.Annotations[BreakGlassAnnotation]; broken {
+			return false, nil
+		}
+	}
+	user := review.Request.UserInfo
+	if a.breakGlass == nil {
+		plog.Info("Ignoring break-glass annotation, as break-glass is disabled", "name", review.Request.Name, "namespace", review.Request.Namespace, "user", user.Username)
+		return false, nil
+	}
+
+	if ticket == "" {
+		setReviewResponseNotAllowed(review, fmt.Sprintf("Pod is not valid: \n%s annotation requires a ticket id", BreakGlassAnnotation))
+		return true, nil
+	}
+	authorized, err := a.breakGlass.authorize(user, review.Request.Namespace)
+	if err != nil {
+		return true, err
+	}
+	if !authorized {
+		auditLog.Info("Denied break-glass", "ticket", ticket, "user", user.Username, "groups", user.Groups, "name", review.Request.Name, "namespace", review.Request.Namespace, "uid", review.Request.UID)
+		setReviewResponseNotAllowed(review, fmt.Sprintf("Pod is not valid: \nuser %s is not authorized to break glass in %s", user.Username, review.Request.Namespace))
+		return true, nil
+	}
+
+	count := atomic.AddUint64(&breakGlassAdmissions, 1)
+	auditLog.Info("BREAK-GLASS: Pod is allowed without validating images", "ticket", ticket, "user", user.Username, "groups", user.Groups, "name", review.Request.Name, "namespace", review.Request.Namespace, "uid", review.Request.UID, "breakGlassAdmissions", count)
+	review.Response = &admissionv1beta1.AdmissionResponse{
+		Allowed:  true,
+		Result:   &metav1.Status{},
+		Warnings: []string{fmt.Sprintf("Images are not validated by break-glass (ticket %s). It's audit-logged", ticket)},
+	}
+	return true, nil
+}
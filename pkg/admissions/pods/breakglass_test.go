@@ -0,0 +1,130 @@
+package pods
+
+import (
+	"encoding/json"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	admissionv1beta1 "k8s.io/api/admission/v1beta1"
+	authenticationv1 "k8s.io/api/authentication/v1"
+	authorizationv1 "k8s.io/api/authorization/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+// testSubjectAccessReviewClient is a fake client authorizing only the group on-call to break glass in testns
+func testSubjectAccessReviewClient() *fake.Clientset {
+	cli := fake.NewSimpleClientset()
+	cli.PrependReactor("create", "subjectaccessreviews", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		sar := action.(k8stesting.CreateActionImpl).GetObject().(*authorizationv1.SubjectAccessReview)
+		attrs := sar.Spec.ResourceAttributes
+		if attrs == nil || attrs.Verb != breakGlassVerb || attrs.Group != breakGlassGroup || attrs.Resource != breakGlassResource {
+			return true, nil, fmt.Errorf("unexpected resource attributes %v", attrs)
+		}
+		for _, g := range sar.Spec.Groups {
+			sar.Status.Allowed = sar.Status.Allowed || (g == "on-call" && attrs.Namespace == "testns")
+		}
+		return true, sar, nil
+	})
+	return cli
+}
+
+func TestImageAdmission_HandleAdmission_breakGlass(t *testing.T) {
+	tc := map[string]struct {
+		disabled    bool
+		annotations map[string]string
+		user        authenticationv1.UserInfo
+		// oldAnnotations are the annotations of the old pod of an UPDATE. It's a CREATE if nil
+		oldAnnotations map[string]string
+
+		expectedAllowed       bool
+		expectedResultMessage string
+		expectedWarnings      []string
+		// expectedValidated tells the pod is validated as usual, rather than by break-glass
+		expectedValidated bool
+	}{
+		"authorized": {
+			annotations:      map[string]string{BreakGlassAnnotation: "INC-1234"},
+			user:             authenticationv1.UserInfo{Username: "system:serviceaccount:testns:responder", Groups: []string{"system:serviceaccounts", "on-call"}},
+			expectedAllowed:  true,
+			expectedWarnings: []string{"Images are not validated by break-glass (ticket INC-1234). It's audit-logged"},
+		},
+		"unauthorized": {
+			annotations:           map[string]string{BreakGlassAnnotation: "INC-1234"},
+			user:                  authenticationv1.UserInfo{Username: "test-user", Groups: []string{"developers"}},
+			expectedResultMessage: "Pod is not valid: \nuser test-user is not authorized to break glass in testns",
+		},
+		"noTicket": {
+			annotations:           map[string]string{BreakGlassAnnotation: ""},
+			user:                  authenticationv1.UserInfo{Username: "test-user", Groups: []string{"on-call"}},
+			expectedResultMessage: "Pod is not valid: \n" + BreakGlassAnnotation + " annotation requires a ticket id",
+		},
+		"disabled": {
+			disabled:              true,
+			annotations:           map[string]string{BreakGlassAnnotation: "INC-1234"},
+			user:                  authenticationv1.UserInfo{Username: "test-user", Groups: []string{"on-call"}},
+			expectedResultMessage: "Pod is not valid: \nimage 'test-not-signed:test' is not signed",
+		},
+		"updateBroken": {
+			annotations:       map[string]string{BreakGlassAnnotation: "INC-1234"},
+			oldAnnotations:    map[string]string{BreakGlassAnnotation: "INC-1234"},
+			user:              authenticationv1.UserInfo{Username: "system:serviceaccount:kube-system:controller", Groups: []string{"system:serviceaccounts"}},
+			expectedAllowed:   true,
+			expectedValidated: true,
+		},
+		"updateAdding": {
+			annotations:           map[string]string{BreakGlassAnnotation: "INC-1234"},
+			oldAnnotations:        map[string]string{},
+			user:                  authenticationv1.UserInfo{Username: "test-user", Groups: []string{"developers"}},
+			expectedResultMessage: "Pod is not valid: \nuser test-user is not authorized to break glass in testns",
+		},
+		"notAnnotated": {
+			user:                  authenticationv1.UserInfo{Username: "test-user", Groups: []string{"on-call"}},
+			expectedResultMessage: "Pod is not valid: \nimage 'test-not-signed:test' is not signed",
+		},
+	}
+
+	for caseName, c := range tc {
+		t.Run(caseName, func(t *testing.T) {
+			im := &ImageAdmission{validator: &dummyValidator{}, operations: []string{string(admissionv1beta1.Create), string(admissionv1beta1.Update)}}
+			if !c.disabled {
+				im.breakGlass = &breakGlassAuthorizer{client: testSubjectAccessReviewClient()}
+			}
+
+			pod := &corev1.Pod{
+				ObjectMeta: metav1.ObjectMeta{Name: "test", Namespace: "testns", Annotations: c.annotations},
+				Spec:       corev1.PodSpec{Containers: []corev1.Container{{Name: "test-cont", Image: "test-not-signed:test"}}},
+			}
+			raw, err := json.Marshal(pod)
+			require.NoError(t, err)
+			review := &admissionv1beta1.AdmissionReview{
+				Request: &admissionv1beta1.AdmissionRequest{
+					Name:      pod.Name,
+					Namespace: pod.Namespace,
+					Operation: admissionv1beta1.Create,
+					UserInfo:  c.user,
+					Object:    runtime.RawExtension{Raw: raw},
+				},
+			}
+			if c.oldAnnotations != nil {
+				oldPod := pod.DeepCopy()
+				oldPod.Annotations = c.oldAnnotations
+				review.Request.Operation = admissionv1beta1.Update
+				review.Request.OldObject.Raw, err = json.Marshal(oldPod)
+				require.NoError(t, err)
+			}
+
+			require.NoError(t, im.HandleAdmission(review))
+			require.Equal(t, c.expectedAllowed, review.Response.Allowed)
+			require.Equal(t, c.expectedResultMessage, review.Response.Result.Message)
+			require.Equal(t, c.expectedWarnings, review.Response.Warnings)
+			if !c.expectedValidated {
+				require.Nil(t, review.Response.Patch, "break-glass doesn't pin the images")
+			}
+		})
+	}
+}
@@ -0,0 +1,70 @@
+package pods
+
+import (
+	"context"
+	"strings"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	authenticationv1 "k8s.io/api/authentication/v1"
+	authorizationv1 "k8s.io/api/authorization/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+const (
+	// validationServiceVerb is the verb of pods in a namespace, the caller of the validation service should be authorized to,
+	// to validate images in the namespace. The validation reads the namespace's policies and pull secrets, as creating a pod there does
+	validationServiceVerb     = "create"
+	validationServiceResource = "pods"
+)
+
+// callerAuthorizer authenticates the callers of the validation service by their service account (or user) tokens, and authorizes them to the namespaces
+type callerAuthorizer struct {
+	client kubernetes.Interface
+}
+
+// authorize authenticates the caller by the bearer token of the request's metadata by a TokenReview, and checks if it's authorized to create pods in the namespaces.
+// An empty namespace is not checked, as only the cluster policies apply to it. It returns an error of codes.Unauthenticated or codes.PermissionDenied if it's not
+func (a *callerAuthorizer) authorize(ctx context.Context, namespaces ...string) error {
+	token, err := bearerToken(ctx)
+	if err != nil {
+		return err
+	}
+	review, err := a.client.AuthenticationV1().TokenReviews().Create(ctx, &authenticationv1.TokenReview{Spec: authenticationv1.TokenReviewSpec{Token: token}}, metav1.CreateOptions{})
+	if err != nil {
+		return status.Errorf(codes.Unavailable, "couldn't review the token of the caller by %s", err)
+	}
+	if !review.Status.Authenticated {
+		return status.Errorf(codes.Unauthenticated, "caller is not authenticated: %s", review.Status.Error)
+	}
+
+	user := review.Status.User
+	reviewed := map[string]bool{}
+	for _, namespace := range namespaces {
+		if namespace == "" || reviewed[namespace] {
+			continue
+		}
+		reviewed[namespace] = true
+		allowed, err := reviewAccess(a.client, user, &authorizationv1.ResourceAttributes{Namespace: namespace, Verb: validationServiceVerb, Resource: validationServiceResource})
+		if err != nil {
+			return status.Errorf(codes.Unavailable, "couldn't review the access of user %s by %s", user.Username, err)
+		}
+		if !allowed {
+			return status.Errorf(codes.PermissionDenied, "user %s is not authorized to %s %s in %s", user.Username, validationServiceVerb, validationServiceResource, namespace)
+		}
+	}
+	return nil
+}
+
+// bearerToken returns the bearer token of the authorization metadata of the request
+func bearerToken(ctx context.Context) (string, error) {
+	md, _ := metadata.FromIncomingContext(ctx)
+	for _, authorization := range md.Get("authorization") {
+		if token := strings.TrimPrefix(authorization, "Bearer "); token != authorization && token != "" {
+			return token, nil
+		}
+	}
+	return "", status.Error(codes.Unauthenticated, "bearer token is required in the authorization metadata")
+}
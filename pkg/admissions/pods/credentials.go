@@ -0,0 +1,178 @@
+package pods
+
+import (
+	"context"
+	"crypto/sha256"
+	"fmt"
+
+	"github.com/tmax-cloud/image-validating-webhook/internal/utils"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// Credential sources, where the registry credentials are looked up in the order of Options.CredentialSources.
+// The registry is requested anonymously if none of them has a credential for it
+const (
+	// CredentialSourcePodPullSecrets is the imagePullSecrets of the pod
+	CredentialSourcePodPullSecrets = "pod-pull-secrets"
+	// CredentialSourceServiceAccountPullSecrets is the imagePullSecrets of the pod's service account
+	CredentialSourceServiceAccountPullSecrets = "service-account-pull-secrets"
+	// CredentialSourceTokenExchange is the registry token exchanged from the pod's service account token (see CredentialProvider)
+	CredentialSourceTokenExchange = "token-exchange"
+	// CredentialSourceDockerConfig is the cluster-wide docker config file of Options.DockerConfigFile
+	CredentialSourceDockerConfig = "docker-config"
+)
+
+// defaultCredentialSources is the order of the credential sources, if it's not configured
+var defaultCredentialSources = []string{
+	CredentialSourcePodPullSecrets,
+	CredentialSourceServiceAccountPullSecrets,
+	CredentialSourceTokenExchange,
+	CredentialSourceDockerConfig,
+}
+
+// credentialSource returns the basic auth of the host for the pod. It's empty if the source has none for the host
+type credentialSource func(host, namespace, serviceAccount string, pullSecrets []corev1.LocalObjectReference) (string, error)
+
+// isCredentialSource is if the name is one of the credential sources
+func isCredentialSource(name string) bool {
+	for _, source := range defaultCredentialSources {
+		if name == source {
+			return true
+		}
+	}
+	return false
+}
+
+// credentialSources returns the credential sources in the configured order
+func (h *validator) credentialSources() []credentialSource {
+	names := h.opts.CredentialSources
+	if len(names) == 0 {
+		names = defaultCredentialSources
+	}
+
+	var sources []credentialSource
+	for _, name := range names {
+		switch name {
+		case CredentialSourcePodPullSecrets:
+			sources = append(sources, h.getBasicAuthFromPodPullSecrets)
+		case CredentialSourceServiceAccountPullSecrets:
+			sources = append(sources, h.getBasicAuthFromServiceAccountPullSecrets)
+		case CredentialSourceTokenExchange:
+			sources = append(sources, h.getBasicAuthFromTokenExchange)
+		case CredentialSourceDockerConfig:
+			sources = append(sources, func(host, _, _ string, _ []corev1.LocalObjectReference) (string, error) {
+				return h.getBasicAuthFromDockerConfig(host)
+			})
+		}
+	}
+	return sources
+}
+
+// getBasicAuthForHosts gets the basic auth of any of the hosts, from the credential sources in order.
+// Each source is looked up for all the hosts before the next one, so that the precedence of the sources is kept for the aliases.
+// The malformed pull secrets skipped fail it only if none of the sources has a credential, not to request a private image anonymously
+func (h *validator) getBasicAuthForHosts(hosts []string, namespace, serviceAccount string, pullSecrets []corev1.LocalObjectReference) (string, error) {
+	var skipped error
+	for _, source := range h.credentialSources() {
+		for _, host := range hosts {
+			basicAuth, err := source(host, namespace, serviceAccount, pullSecrets)
+			if _, malformed := err.(*malformedPullSecretError); malformed && h.opts.MalformedPullSecretPolicy != MalformedPullSecretPolicyFail {
+				if skipped == nil {
+					skipped = err
+				}
+				continue
+			}
+			if err != nil || basicAuth != "" {
+				return basicAuth, err
+			}
+		}
+	}
+
+	// DO NOT return error - the image may be public
+	return "", skipped
+}
+
+// credentialScope scopes the cached results by the credential they're checked with, so that the results checked with a namespace's private credential
+// are never reused for the pods (e.g., of the other namespaces) without it. The results checked anonymously are in the public scope
+func credentialScope(basicAuth string) string {
+	if basicAuth == "" {
+		return "public"
+	}
+	return fmt.Sprintf("%x", sha256.Sum256([]byte(basicAuth)))
+}
+
+// malformedPullSecretError is the error decoding a pull secret, which is skipped unless the MalformedPullSecretPolicy is Fail
+type malformedPullSecretError struct {
+	namespace string
+	name      string
+	err       error
+}
+
+func (e *malformedPullSecretError) Error() string {
+	return fmt.Sprintf("malformed pull secret %s/%s: %s", e.namespace, e.name, e.err)
+}
+
+// getBasicAuthFromPodPullSecrets gets the basic auth of the registry from the pull secrets in the pod's namespace.
+// The malformed ones are skipped with a warning, returning the first one's error if none of the others has the basic auth
+func (h *validator) getBasicAuthFromPodPullSecrets(host, namespace, _ string, pullSecrets []corev1.LocalObjectReference) (string, error) {
+	var skipped error
+	for _, pullSecret := range pullSecrets {
+		secret, err := h.client.CoreV1().Secrets(namespace).Get(context.Background(), pullSecret.Name, metav1.GetOptions{})
+		if err != nil {
+			return "", fmt.Errorf("couldn't get secret named %s by %s", pullSecret.Name, err)
+		}
+		basicAuth, err := h.getBasicAuthFromSecret(host, secret)
+		if err != nil {
+			err = &malformedPullSecretError{namespace: namespace, name: pullSecret.Name, err: err}
+			if h.opts.MalformedPullSecretPolicy == MalformedPullSecretPolicyFail {
+				return "", err
+			}
+			validatorLog.Info("skipping the malformed pull secret", "namespace", namespace, "secret", pullSecret.Name, "error", err.Error())
+			if skipped == nil {
+				skipped = err
+			}
+			continue
+		}
+		if basicAuth == "" {
+			continue
+		}
+
+		return basicAuth, nil
+	}
+	return "", skipped
+}
+
+// getBasicAuthFromSecret decodes the pull secret, getting the basic auth of the registry from it
+func (h *validator) getBasicAuthFromSecret(host string, secret *corev1.Secret) (string, error) {
+	imagePullSecret, err := utils.NewImagePullSecret(secret)
+	if err != nil {
+		return "", err
+	}
+	return imagePullSecret.GetHostBasicAuth(h.findRegistryServer(host))
+}
+
+// getBasicAuthFromServiceAccountPullSecrets gets the basic auth of the registry from the pull secrets of the pod's service account.
+// The service account which doesn't exist (yet) has no credential
+func (h *validator) getBasicAuthFromServiceAccountPullSecrets(host, namespace, serviceAccount string, _ []corev1.LocalObjectReference) (string, error) {
+	if serviceAccount == "" {
+		serviceAccount = "default"
+	}
+	sa, err := h.client.CoreV1().ServiceAccounts(namespace).Get(context.Background(), serviceAccount, metav1.GetOptions{})
+	if errors.IsNotFound(err) {
+		return "", nil
+	}
+	if err != nil {
+		return "", fmt.Errorf("couldn't get service account %s/%s by %s", namespace, serviceAccount, err)
+	}
+	return h.getBasicAuthFromPodPullSecrets(host, namespace, serviceAccount, sa.ImagePullSecrets)
+}
+
+// getBasicAuthFromTokenExchange gets the registry credential of the pod's service account, if the token exchange is enabled
+func (h *validator) getBasicAuthFromTokenExchange(host, namespace, serviceAccount string, _ []corev1.LocalObjectReference) (string, error) {
+	if h.credentialProvider == nil {
+		return "", nil
+	}
+	return h.credentialProvider.BasicAuth(host, namespace, serviceAccount)
+}
@@ -0,0 +1,231 @@
+package pods
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"github.com/tmax-cloud/image-validating-webhook/internal/utils"
+	whv1 "github.com/tmax-cloud/image-validating-webhook/pkg/type"
+	watcherfake "github.com/tmax-cloud/image-validating-webhook/pkg/watcher/fake"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+// testCredentialProvider provides the same credential for every service account
+type testCredentialProvider struct {
+	basicAuth string
+}
+
+func (p *testCredentialProvider) BasicAuth(_, _, _ string) (string, error) {
+	return p.basicAuth, nil
+}
+
+func testPullSecret(t *testing.T, name, host, auth string) *corev1.Secret {
+	authB, err := json.Marshal(utils.DockerConfigJSON{Auths: map[string]utils.DockerLoginCredential{host: {utils.DockerConfigAuthKey: auth}}})
+	require.NoError(t, err)
+	return &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: testCheckSign},
+		Type:       corev1.SecretTypeDockerConfigJson,
+		Data:       map[string][]byte{corev1.DockerConfigJsonKey: authB},
+	}
+}
+
+func TestValidator_getBasicAuthForRegistry_credentialSources(t *testing.T) {
+	const host = "reg-test:5000"
+	dockerConfig := filepath.Join(t.TempDir(), "config.json")
+	require.NoError(t, ioutil.WriteFile(dockerConfig, []byte(`{"auths":{"reg-test:5000":{"auth":"docker-config"}}}`), 0600))
+
+	tc := map[string]struct {
+		sources        []string
+		podSecret      bool
+		saSecret       bool
+		tokenExchange  bool
+		dockerConfig   bool
+		serviceAccount string
+
+		expectedAuth string
+	}{
+		"podPullSecretsFirst": {
+			podSecret: true, saSecret: true, tokenExchange: true, dockerConfig: true,
+			expectedAuth: "pod-secret",
+		},
+		"serviceAccountPullSecrets": {
+			saSecret: true, tokenExchange: true, dockerConfig: true,
+			expectedAuth: "sa-secret",
+		},
+		"namedServiceAccount": {
+			saSecret: true, serviceAccount: "builder",
+			expectedAuth: "sa-secret",
+		},
+		"tokenExchange": {
+			tokenExchange: true, dockerConfig: true,
+			expectedAuth: "token-exchange",
+		},
+		"dockerConfig": {
+			dockerConfig: true,
+			expectedAuth: "docker-config",
+		},
+		"anonymous": {
+			expectedAuth: "",
+		},
+		"configuredOrder": {
+			sources:   []string{CredentialSourceDockerConfig, CredentialSourcePodPullSecrets},
+			podSecret: true, saSecret: true, tokenExchange: true, dockerConfig: true,
+			expectedAuth: "docker-config",
+		},
+		"sourceLeftOut": {
+			sources:  []string{CredentialSourcePodPullSecrets, CredentialSourceDockerConfig},
+			saSecret: true, tokenExchange: true,
+			expectedAuth: "",
+		},
+	}
+
+	for caseName, c := range tc {
+		t.Run(caseName, func(t *testing.T) {
+			serviceAccount := c.serviceAccount
+			if serviceAccount == "" {
+				serviceAccount = "default"
+			}
+			objs := []runtime.Object{&corev1.ServiceAccount{
+				ObjectMeta: metav1.ObjectMeta{Name: serviceAccount, Namespace: testCheckSign},
+			}}
+			var pullSecrets []corev1.LocalObjectReference
+			if c.podSecret {
+				objs = append(objs, testPullSecret(t, "pod-secret", host, "pod-secret"))
+				pullSecrets = append(pullSecrets, corev1.LocalObjectReference{Name: "pod-secret"})
+			}
+			if c.saSecret {
+				objs = append(objs, testPullSecret(t, "sa-secret", host, "sa-secret"))
+				objs[0].(*corev1.ServiceAccount).ImagePullSecrets = []corev1.LocalObjectReference{{Name: "sa-secret"}}
+			}
+
+			v := &validator{client: fake.NewSimpleClientset(objs...), opts: Options{CredentialSources: c.sources}}
+			if c.tokenExchange {
+				v.credentialProvider = &testCredentialProvider{basicAuth: "token-exchange"}
+			}
+			if c.dockerConfig {
+				v.opts.DockerConfigFile = dockerConfig
+			}
+
+			basicAuth, err := v.getBasicAuthForRegistry(host, testCheckSign, c.serviceAccount, pullSecrets)
+			require.NoError(t, err)
+			require.Equal(t, c.expectedAuth, basicAuth)
+		})
+	}
+}
+
+func TestValidator_getBasicAuthForRegistry_serviceAccountNotFound(t *testing.T) {
+	v := &validator{client: fake.NewSimpleClientset()}
+
+	// The registry is requested anonymously
+	basicAuth, err := v.getBasicAuthForRegistry("reg-test:5000", testCheckSign, "not-exist", nil)
+	require.NoError(t, err)
+	require.Empty(t, basicAuth)
+}
+
+func TestValidator_getBasicAuthForRegistry_malformedPullSecret(t *testing.T) {
+	const host = "reg-test:5000"
+	dockerConfig := filepath.Join(t.TempDir(), "config.json")
+	require.NoError(t, ioutil.WriteFile(dockerConfig, []byte(`{"auths":{"reg-test:5000":{"auth":"docker-config"}}}`), 0600))
+	malformed := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "malformed", Namespace: testCheckSign},
+		Type:       corev1.SecretTypeDockerConfigJson,
+		Data:       map[string][]byte{corev1.DockerConfigJsonKey: []byte("{not json")},
+	}
+
+	tc := map[string]struct {
+		policy       string
+		validSecret  bool
+		dockerConfig bool
+
+		expectedAuth string
+		expectedErr  bool
+	}{
+		"skippedForValidSecret": {
+			validSecret:  true,
+			expectedAuth: "pod-secret",
+		},
+		"skippedForOtherSource": {
+			dockerConfig: true,
+			expectedAuth: "docker-config",
+		},
+		"noOtherCredential": {
+			expectedErr: true,
+		},
+		"fail": {
+			policy:      MalformedPullSecretPolicyFail,
+			validSecret: true,
+			expectedErr: true,
+		},
+	}
+
+	for caseName, c := range tc {
+		t.Run(caseName, func(t *testing.T) {
+			objs := []runtime.Object{malformed}
+			pullSecrets := []corev1.LocalObjectReference{{Name: "malformed"}}
+			if c.validSecret {
+				objs = append(objs, testPullSecret(t, "pod-secret", host, "pod-secret"))
+				pullSecrets = append(pullSecrets, corev1.LocalObjectReference{Name: "pod-secret"})
+			}
+			v := &validator{client: fake.NewSimpleClientset(objs...), opts: Options{MalformedPullSecretPolicy: c.policy}}
+			if c.dockerConfig {
+				v.opts.DockerConfigFile = dockerConfig
+			}
+
+			basicAuth, err := v.getBasicAuthForRegistry(host, testCheckSign, "", pullSecrets)
+			if c.expectedErr {
+				require.Error(t, err)
+				require.Contains(t, err.Error(), "malformed pull secret "+testCheckSign+"/malformed")
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, c.expectedAuth, basicAuth)
+		})
+	}
+}
+
+func TestValidator_CheckIsValidAndAddDigest_credentialIsolation(t *testing.T) {
+	const registry = "registry.test"
+	const otherNamespace = "other-tenant"
+	digest := "sha256:" + strings.Repeat("1", 64)
+	img := registry + "/private:v1@" + digest
+
+	policy := whv1.RegistrySpec{Registry: registry, Notary: "https://notary.test", SignCheck: true}
+	v := testCachedSignatureValidator(policy, img)
+	v.registryPolicyCache.namespaceCachedClient.(*watcherfake.CachedClient).Cache[otherNamespace+"/policy"] = &whv1.RegistrySecurityPolicy{
+		ObjectMeta: metav1.ObjectMeta{Name: "policy", Namespace: otherNamespace},
+		Spec:       whv1.RegistrySecurityPolicySpec{Registries: []whv1.RegistrySpec{policy}},
+	}
+	v.client = fake.NewSimpleClientset(testPullSecret(t, "tenant-secret", "https://"+registry, "tenant-credential"))
+	v.validatedDigests = newValidatedDigestCache(time.Minute)
+	v.deniedImages = newDeniedImageCache(time.Minute)
+	verifier := &stubVerifier{digest: digest}
+	v.verifier = verifier
+
+	// The digest validated with the tenant's credential
+	valid, _, err := v.CheckIsValidAndAddDigest(generateTestPod(img, testCheckSign, "tenant-secret"))
+	require.NoError(t, err)
+	require.True(t, valid)
+	require.Len(t, verifier.verified, 1)
+
+	// isn't reused for the other namespace's pod without the credential
+	verifier.digest, verifier.err = "", &deniedError{reason: "Stub: no access"}
+	valid, reason, err := v.CheckIsValidAndAddDigest(generateTestPod(img, otherNamespace, ""))
+	require.NoError(t, err)
+	require.False(t, valid)
+	require.Equal(t, "Stub: no access", reason)
+	require.Len(t, verifier.verified, 2)
+
+	// and the other namespace's denial isn't reused for the tenant, whose validated digest is still cached
+	valid, _, err = v.CheckIsValidAndAddDigest(generateTestPod(img, testCheckSign, "tenant-secret"))
+	require.NoError(t, err)
+	require.True(t, valid)
+	require.Len(t, verifier.verified, 2)
+}
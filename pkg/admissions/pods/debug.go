@@ -0,0 +1,128 @@
+package pods
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"net"
+	"net/http"
+	"sync/atomic"
+
+	"github.com/tmax-cloud/image-validating-webhook/pkg/notary"
+	"github.com/tmax-cloud/image-validating-webhook/pkg/server"
+	logf "sigs.k8s.io/controller-runtime/pkg/log"
+)
+
+const debugStatePath = "/debug/state"
+
+var (
+	debugLog = logf.Log.WithName("pods/debug.go")
+)
+
+func init() {
+	// Add debug state handler initiator
+	server.AddHandlerInitiator(debugStatePath, []string{http.MethodGet}, NewDebugStateHandler)
+}
+
+// DebugState is the state of the caches loaded in the webhook, for troubleshooting
+type DebugState struct {
+	WhiteList DebugWhiteList `json:"whitelist"`
+	Policies  []loadedPolicy `json:"policies"`
+	Caches    DebugCaches    `json:"caches"`
+	// BreakGlassAdmissions is the number of the pods admitted by breaking glass
+	BreakGlassAdmissions uint64 `json:"breakGlassAdmissions"`
+}
+
+// DebugWhiteList is the loaded whitelist
+type DebugWhiteList struct {
+	Images     []string `json:"images"`
+	Namespaces []string `json:"namespaces"`
+}
+
+// DebugCaches are the statistics of the caches
+type DebugCaches struct {
+	Signatures       DebugCacheStats `json:"signatures"`
+	ValidatedDigests DebugCacheStats `json:"validatedDigests"`
+	// NotaryRepos is the number of the pooled notary repositories
+	NotaryRepos int `json:"notaryRepos"`
+}
+
+// DebugCacheStats is the statistics of a cache
+type DebugCacheStats struct {
+	notary.CacheStats
+	HitRate float64 `json:"hitRate"`
+}
+
+func newDebugCacheStats(stats notary.CacheStats) DebugCacheStats {
+	return DebugCacheStats{CacheStats: stats, HitRate: stats.HitRate()}
+}
+
+// DebugStateHandler serves the DebugState of the validator.
+// It requires the bearer token if it's set, otherwise it's served only to the localhost
+type DebugStateHandler struct {
+	validator *validator
+	token     string
+}
+
+// NewDebugStateHandler initiates a new debug state handler
+func NewDebugStateHandler(cfg *server.HandlerConfig) (http.Handler, error) {
+	v, err := getSharedValidator(cfg)
+	if err != nil {
+		return nil, err
+	}
+	return &DebugStateHandler{validator: v, token: v.opts.DebugToken}, nil
+}
+
+func (d *DebugStateHandler) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if !authorizedDebugRequest(req, d.token) {
+		http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+		return
+	}
+
+	state, err := d.validator.debugState()
+	if err != nil {
+		debugLog.Error(err, "failed to dump the state")
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	b, err := json.Marshal(state)
+	if err != nil {
+		debugLog.Error(err, "")
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if _, err := w.Write(b); err != nil {
+		debugLog.Error(err, "")
+	}
+}
+
+// authorizedDebugRequest checks the bearer token of the request if the token is set, or if the request is from the localhost
+func authorizedDebugRequest(req *http.Request, token string) bool {
+	if token != "" {
+		return subtle.ConstantTimeCompare([]byte(req.Header.Get("Authorization")), []byte("Bearer "+token)) == 1
+	}
+	host, _, err := net.SplitHostPort(req.RemoteAddr)
+	if err != nil {
+		return false
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+// debugState dumps the loaded whitelist and policies, with the statistics of the caches
+func (h *validator) debugState() (*DebugState, error) {
+	policies, err := h.registryPolicyCache.listPolicies()
+	if err != nil {
+		return nil, err
+	}
+
+	state := &DebugState{Policies: policies}
+	state.WhiteList.Images, state.WhiteList.Namespaces = h.whiteList.entries()
+	state.Caches.Signatures = newDebugCacheStats(h.signatureCache.Stats())
+	state.Caches.ValidatedDigests = newDebugCacheStats(h.validatedDigests.stats())
+	if h.repoPool != nil {
+		state.Caches.NotaryRepos = h.repoPool.Len()
+	}
+	state.BreakGlassAdmissions = atomic.LoadUint64(&breakGlassAdmissions)
+	return state, nil
+}
@@ -0,0 +1,117 @@
+package pods
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"github.com/tmax-cloud/image-validating-webhook/pkg/notary"
+	whv1 "github.com/tmax-cloud/image-validating-webhook/pkg/type"
+	watcherfake "github.com/tmax-cloud/image-validating-webhook/pkg/watcher/fake"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+func testDebugValidator(t *testing.T) *validator {
+	v := &validator{whiteList: &WhiteList{}, signatureCache: notary.NewSignatureCache(), validatedDigests: newValidatedDigestCache(time.Minute)}
+	require.NoError(t, v.whiteList.Unmarshal("docker.io/library/nginx:1.21"+delimiter+"test.registry/*", "kube-system"))
+	v.registryPolicyCache = &RegistryPolicyCache{clusterCachedClient: &watcherfake.CachedClient{
+		Cache: map[string]runtime.Object{
+			"/cluster-policy": &whv1.ClusterRegistrySecurityPolicy{
+				ObjectMeta: metav1.ObjectMeta{Name: "cluster-policy"},
+				Spec:       whv1.ClusterRegistrySecurityPolicySpec{Registries: []whv1.RegistrySpec{{Registry: "docker.io", SignCheck: true}}},
+			},
+		},
+	}, namespaceCachedClient: &watcherfake.CachedClient{
+		Cache: map[string]runtime.Object{
+			testCheckSign + "/policy": &whv1.RegistrySecurityPolicy{
+				ObjectMeta: metav1.ObjectMeta{Name: "policy", Namespace: testCheckSign},
+				Spec:       whv1.RegistrySecurityPolicySpec{Registries: []whv1.RegistrySpec{{Registry: "test.registry"}}},
+			},
+		},
+	}}
+
+	// A hit and a miss of each cache
+	v.signatureCache.Set("test.registry/test:v1", "https://notary", &notary.Signature{}, time.Minute)
+	v.signatureCache.Get("test.registry/test:v1", "https://notary")
+	v.signatureCache.Get("test.registry/test:v2", "https://notary")
+	key := validatedDigestKey(&imageRef{host: "test.registry", name: "test", digest: testValidatedDigest}, "", "https://notary", whv1.RegistrySpec{})
+	v.validatedDigests.add(key, time.Time{})
+	v.validatedDigests.get(key)
+	v.validatedDigests.get("other")
+	return v
+}
+
+func TestDebugStateHandler_ServeHTTP(t *testing.T) {
+	tc := map[string]struct {
+		token         string
+		remoteAddr    string
+		authorization string
+
+		expectedCode int
+	}{
+		"localhost": {
+			remoteAddr:   "127.0.0.1:12345",
+			expectedCode: http.StatusOK,
+		},
+		"remoteWithoutToken": {
+			remoteAddr:   "10.0.0.1:12345",
+			expectedCode: http.StatusForbidden,
+		},
+		"token": {
+			token:         "secret",
+			remoteAddr:    "10.0.0.1:12345",
+			authorization: "Bearer secret",
+			expectedCode:  http.StatusOK,
+		},
+		"wrongToken": {
+			token:         "secret",
+			remoteAddr:    "127.0.0.1:12345",
+			authorization: "Bearer wrong",
+			expectedCode:  http.StatusForbidden,
+		},
+	}
+
+	for caseName, c := range tc {
+		t.Run(caseName, func(t *testing.T) {
+			d := &DebugStateHandler{validator: testDebugValidator(t), token: c.token}
+
+			req := httptest.NewRequest(http.MethodGet, debugStatePath, nil)
+			req.RemoteAddr = c.remoteAddr
+			if c.authorization != "" {
+				req.Header.Set("Authorization", c.authorization)
+			}
+			w := httptest.NewRecorder()
+			d.ServeHTTP(w, req)
+			require.Equal(t, c.expectedCode, w.Code)
+		})
+	}
+}
+
+func TestDebugState_schema(t *testing.T) {
+	d := &DebugStateHandler{validator: testDebugValidator(t)}
+	atomic.StoreUint64(&breakGlassAdmissions, 2)
+
+	req := httptest.NewRequest(http.MethodGet, debugStatePath, nil)
+	req.RemoteAddr = "[::1]:12345"
+	w := httptest.NewRecorder()
+	d.ServeHTTP(w, req)
+	require.Equal(t, http.StatusOK, w.Code)
+	require.Equal(t, "application/json", w.Header().Get("Content-Type"))
+	require.JSONEq(t, `{
+  "whitelist": {"images": ["docker.io/library/nginx:1.21", "test.registry/*"], "namespaces": ["kube-system"]},
+  "policies": [
+    {"name": "cluster-policy", "registries": [{"registry": "docker.io", "signCheck": true}]},
+    {"name": "policy", "namespace": "`+testCheckSign+`", "registries": [{"registry": "test.registry", "signCheck": false}]}
+  ],
+  "caches": {
+    "signatures": {"entries": 1, "hits": 1, "misses": 1, "hitRate": 0.5},
+    "validatedDigests": {"entries": 1, "hits": 1, "misses": 1, "hitRate": 0.5},
+    "notaryRepos": 0
+  },
+  "breakGlassAdmissions": 2
+}`, w.Body.String())
+}
@@ -0,0 +1,168 @@
+package pods
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+
+	"github.com/tmax-cloud/image-validating-webhook/pkg/server"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	logf "sigs.k8s.io/controller-runtime/pkg/log"
+)
+
+// DecisionAPIVersion is the version of the Decision schema.
+// Fields may be added to the schema within a version, but never removed or changed
+const DecisionAPIVersion = "decision.tmax.io/v1"
+
+// Gatekeeper's external data provider API
+const (
+	providerAPIVersion   = "externaldata.gatekeeper.sh/v1beta1"
+	providerResponseKind = "ProviderResponse"
+)
+
+var (
+	decisionLog = logf.Log.WithName("pods/decision.go")
+)
+
+func init() {
+	// Add external data provider handler initiator
+	server.AddHandlerInitiator("/provider", []string{http.MethodPost}, NewDecisionProviderHandler)
+}
+
+// Decision is a validation decision of an image, to be consumed by external policy engines (e.g., OPA/Gatekeeper)
+type Decision struct {
+	APIVersion string `json:"apiVersion"`
+	Image      string `json:"image"`
+	Allowed    bool   `json:"allowed"`
+	// Reason is why the image is not allowed
+	Reason string `json:"reason,omitempty"`
+	// Digest is the validated digest of the image, which is to be pinned
+	Digest string `json:"digest,omitempty"`
+	// Signers are the signers of the image's tag
+	Signers []string `json:"signers,omitempty"`
+}
+
+// ProviderRequest is a request of Gatekeeper's external data provider, whose keys are the images
+type ProviderRequest struct {
+	APIVersion string `json:"apiVersion"`
+	Kind       string `json:"kind"`
+	Request    struct {
+		Keys []string `json:"keys"`
+	} `json:"request"`
+}
+
+// ProviderResponse is a response of Gatekeeper's external data provider, whose values are the Decisions of the keys
+type ProviderResponse struct {
+	APIVersion string           `json:"apiVersion"`
+	Kind       string           `json:"kind"`
+	Response   ProviderItemList `json:"response"`
+}
+
+// ProviderItemList is a list of the items of ProviderResponse
+type ProviderItemList struct {
+	Idempotent  bool           `json:"idempotent"`
+	Items       []ProviderItem `json:"items"`
+	SystemError string         `json:"systemError,omitempty"`
+}
+
+// ProviderItem is a Decision of a key(image)
+type ProviderItem struct {
+	Key   string    `json:"key"`
+	Value *Decision `json:"value,omitempty"`
+	Error string    `json:"error,omitempty"`
+}
+
+// DecisionProvider serves the Decisions of the images as Gatekeeper's external data provider
+type DecisionProvider struct {
+	validator Validator
+}
+
+// NewDecisionProviderHandler initiates a new external data provider handler
+func NewDecisionProviderHandler(cfg *server.HandlerConfig) (http.Handler, error) {
+	v, err := getSharedValidator(cfg)
+	if err != nil {
+		return nil, err
+	}
+	return &DecisionProvider{validator: v}, nil
+}
+
+func (p *DecisionProvider) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	resp := &ProviderResponse{APIVersion: providerAPIVersion, Kind: providerResponseKind}
+
+	providerReq := &ProviderRequest{}
+	body, err := ioutil.ReadAll(req.Body)
+	if err == nil {
+		err = json.Unmarshal(body, providerReq)
+	}
+	if err != nil {
+		errMsg := fmt.Sprintf("Couldn't read request by %s", err)
+		decisionLog.Error(err, errMsg)
+		resp.Response.SystemError = errMsg
+		writeProviderResponse(resp, w)
+		return
+	}
+
+	// Decisions are not idempotent, as they change when the policies or the signatures change
+	for _, img := range providerReq.Request.Keys {
+		item := ProviderItem{Key: img}
+		decision, err := decide(p.validator, img, "", "")
+		if err != nil {
+			decisionLog.Error(err, "failed to decide", "image", img)
+			item.Error = err.Error()
+		} else {
+			item.Value = decision
+		}
+		resp.Response.Items = append(resp.Response.Items, item)
+	}
+
+	writeProviderResponse(resp, w)
+}
+
+func writeProviderResponse(resp *ProviderResponse, w http.ResponseWriter) {
+	b, err := json.Marshal(resp)
+	if err != nil {
+		decisionLog.Error(err, "")
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if _, err := w.Write(b); err != nil {
+		decisionLog.Error(err, "")
+	}
+}
+
+// decide validates the image as a container of a pod in the namespace, pulled by the pull secret.
+// The namespace and the pull secret may be empty
+func decide(v Validator, img, namespace, pullSecret string) (*Decision, error) {
+	pod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Namespace: namespace},
+		Spec: corev1.PodSpec{
+			Containers: []corev1.Container{{Name: "decision", Image: img}},
+		},
+	}
+	if pullSecret != "" {
+		pod.Spec.ImagePullSecrets = []corev1.LocalObjectReference{{Name: pullSecret}}
+	}
+	valid, reason, err := v.CheckIsValidAndAddDigest(pod)
+	if err != nil {
+		return nil, err
+	}
+
+	decision := &Decision{APIVersion: DecisionAPIVersion, Image: img, Allowed: valid, Reason: reason}
+	if !valid {
+		return decision, nil
+	}
+
+	ref, err := parseImage(pod.Spec.Containers[0].Image)
+	if err != nil {
+		return nil, err
+	}
+	decision.Digest = ref.digest
+	// The signers are the ones recorded as the image is validated, by the request's pull secret and the namespace's policy
+	if validated, exist := podValidatedImages(pod); exist {
+		decision.Signers = validated.Containers[pod.Spec.Containers[0].Name].Signers
+	}
+	return decision, nil
+}
@@ -0,0 +1,110 @@
+package pods
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/tmax-cloud/image-validating-webhook/pkg/notary"
+	whv1 "github.com/tmax-cloud/image-validating-webhook/pkg/type"
+	corev1 "k8s.io/api/core/v1"
+)
+
+func TestDecisionProvider_ServeHTTP(t *testing.T) {
+	tc := map[string]struct {
+		body string
+
+		expectedItems       []ProviderItem
+		expectedSystemError bool
+	}{
+		"decisions": {
+			body: `{"apiVersion":"externaldata.gatekeeper.sh/v1beta1","kind":"ProviderRequest","request":{"keys":["test-signed:v1","test-not-signed:v1","test-error:v1"]}}`,
+			expectedItems: []ProviderItem{
+				{Key: "test-signed:v1", Value: &Decision{APIVersion: DecisionAPIVersion, Image: "test-signed:v1", Allowed: true, Digest: testPinnedDigest, Signers: []string{"test-signer"}}},
+				{Key: "test-not-signed:v1", Value: &Decision{APIVersion: DecisionAPIVersion, Image: "test-not-signed:v1", Reason: "image 'test-not-signed:v1' is not signed"}},
+				{Key: "test-error:v1", Error: "notary server is unreachable"},
+			},
+		},
+		"malformedRequest": {
+			body:                `{"request":`,
+			expectedSystemError: true,
+		},
+	}
+
+	for name, c := range tc {
+		t.Run(name, func(t *testing.T) {
+			p := &DecisionProvider{validator: &decisionDummyValidator{}}
+
+			w := httptest.NewRecorder()
+			p.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/provider", bytes.NewReader([]byte(c.body))))
+			require.Equal(t, http.StatusOK, w.Code)
+
+			resp := &ProviderResponse{}
+			require.NoError(t, json.Unmarshal(w.Body.Bytes(), resp))
+			require.Equal(t, providerAPIVersion, resp.APIVersion)
+			require.Equal(t, providerResponseKind, resp.Kind)
+			require.Equal(t, c.expectedSystemError, resp.Response.SystemError != "")
+			require.Equal(t, c.expectedItems, resp.Response.Items)
+		})
+	}
+}
+
+func TestDecision_schema(t *testing.T) {
+	b, err := json.Marshal(&Decision{APIVersion: DecisionAPIVersion, Image: "test-signed:v1", Allowed: true, Digest: testPinnedDigest, Signers: []string{"test-signer"}})
+	require.NoError(t, err)
+	require.JSONEq(t, fmt.Sprintf(`{"apiVersion":"decision.tmax.io/v1","image":"test-signed:v1","allowed":true,"digest":"%s","signers":["test-signer"]}`, testPinnedDigest), string(b))
+}
+
+// decisionDummyValidator pins the digests of the signed images, signed by test-signer
+type decisionDummyValidator struct{}
+
+func (d *decisionDummyValidator) CheckIsValidAndAddDigest(pod *corev1.Pod) (bool, string, error) {
+	c := pod.Spec.Containers[0]
+	switch {
+	case strings.HasPrefix(c.Image, "test-error"):
+		return false, "", fmt.Errorf("notary server is unreachable")
+	case strings.HasPrefix(c.Image, "test-not-signed"):
+		return false, fmt.Sprintf("image '%s' is not signed", c.Image), nil
+	}
+	pod.Spec.Containers[0].Image = c.Image + "@" + testPinnedDigest
+	validated := validatedImages{}
+	validated.record(&pod.Spec.Containers[0], ValidatedImage{Signers: []string{"test-signer"}})
+	return true, "", setValidatedImagesAnnotation(pod, validated)
+}
+
+func (d *decisionDummyValidator) CheckPodsValidAndAddDigest(pods []*corev1.Pod) []PodValidationResult {
+	var results []PodValidationResult
+	for _, pod := range pods {
+		valid, reason, err := d.CheckIsValidAndAddDigest(pod)
+		results = append(results, PodValidationResult{Valid: valid, Reason: reason, Err: err})
+	}
+	return results
+}
+
+func TestDecide_signers(t *testing.T) {
+	const registry = "registry.test"
+	img := registry + "/image:v1"
+	digest := strings.Repeat("1", 64)
+
+	v := testCachedSignatureValidator(whv1.RegistrySpec{Registry: registry, Notary: "https://notary.test", SignCheck: true, Signer: []string{"signer-a"}},
+		img, notary.SignedTag{SignedTag: "v1", Digest: digest, Algorithm: "sha256", Signers: []string{"signer-a", "signer-b"}})
+
+	// The signers are the ones of the policy recorded as it's validated in the namespace
+	decision, err := decide(v, img, testCheckSign, "")
+	require.NoError(t, err)
+	require.True(t, decision.Allowed, decision.Reason)
+	require.Equal(t, "sha256:"+digest, decision.Digest)
+	require.Equal(t, []string{"signer-a"}, decision.Signers)
+
+	// The images allowed without their signatures have no signers
+	v.registryPolicyCache.defaultPolicy = DefaultPolicyAllow
+	decision, err = decide(v, img, testNoCheckSign, "")
+	require.NoError(t, err)
+	require.True(t, decision.Allowed)
+	require.Nil(t, decision.Signers)
+}
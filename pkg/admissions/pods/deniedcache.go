@@ -0,0 +1,71 @@
+package pods
+
+import (
+	"strings"
+	"sync"
+	"time"
+
+	whv1 "github.com/tmax-cloud/image-validating-webhook/pkg/type"
+)
+
+// maxDeniedImageTTL is the maximum TTL of the denied images, so that a freshly signed image isn't stuck denied for long
+const maxDeniedImageTTL = time.Minute
+
+// deniedImageCache remembers the reasons of the images denied by their signatures for a few seconds,
+// so that the pods recreated repeatedly (e.g., by a crash-looping controller) are denied without the notary round-trip
+type deniedImageCache struct {
+	lock    sync.Mutex
+	ttl     time.Duration
+	entries map[string]deniedImage
+}
+
+type deniedImage struct {
+	denied   deniedReason
+	expireAt time.Time
+}
+
+// newDeniedImageCache creates a new cache. It returns nil, which caches nothing, if ttl is not positive
+func newDeniedImageCache(ttl time.Duration) *deniedImageCache {
+	if ttl <= 0 {
+		return nil
+	}
+	return &deniedImageCache{ttl: ttl, entries: map[string]deniedImage{}}
+}
+
+// deniedImageKey is a key of the image denied by the notary server by the policy, in the scope of the namespace and the credential it's checked with.
+// The denials are never replayed for the other namespaces, whose policies (e.g., relaxed by the enforcement annotation) may allow the image
+func deniedImageKey(img, namespace, basicAuth, notaryURL string, policy whv1.RegistrySpec) string {
+	return strings.Join([]string{notaryURL, img, namespace, policyHash(policy), credentialScope(basicAuth)}, "|")
+}
+
+// get returns the reason the image is denied for, if it's denied within the ttl
+func (c *deniedImageCache) get(key string) (deniedReason, bool) {
+	if c == nil {
+		return deniedReason{}, false
+	}
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	denied, exist := c.entries[key]
+	if !exist || !time.Now().Before(denied.expireAt) {
+		return deniedReason{}, false
+	}
+	return denied.denied, true
+}
+
+func (c *deniedImageCache) add(key string, denied deniedReason) {
+	if c == nil {
+		return
+	}
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	now := time.Now()
+	// Clean up the expired entries
+	for k, denied := range c.entries {
+		if now.After(denied.expireAt) {
+			delete(c.entries, k)
+		}
+	}
+	c.entries[key] = deniedImage{denied: denied, expireAt: now.Add(c.ttl)}
+}
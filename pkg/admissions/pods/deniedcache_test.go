@@ -0,0 +1,128 @@
+package pods
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	whv1 "github.com/tmax-cloud/image-validating-webhook/pkg/type"
+	watcherfake "github.com/tmax-cloud/image-validating-webhook/pkg/watcher/fake"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestDeniedImageCache(t *testing.T) {
+	policy := whv1.RegistrySpec{Registry: "test.registry", SignCheck: true, Signer: []string{"signer-2", "signer-1"}}
+	key := deniedImageKey("test.registry/test:v1", "ns", "auth", "https://notary", policy)
+
+	c := newDeniedImageCache(time.Minute)
+	_, denied := c.get(key)
+	require.False(t, denied, "empty cache")
+
+	c.add(key, deniedReason{kind: denyReasonNotSigned, subject: "test.registry/test:v1", reason: "denied"})
+	cached, denied := c.get(key)
+	require.True(t, denied)
+	require.Equal(t, deniedReason{kind: denyReasonNotSigned, subject: "test.registry/test:v1", reason: "denied"}, cached)
+	reordered := policy
+	reordered.Signer = []string{"signer-1", "signer-2"}
+	_, denied = c.get(deniedImageKey("test.registry/test:v1", "ns", "auth", "https://notary", reordered))
+	require.True(t, denied, "signers order is ignored")
+	_, denied = c.get(deniedImageKey("test.registry/test:v1", "ns", "other-auth", "https://notary", policy))
+	require.False(t, denied, "other credential")
+	_, denied = c.get(deniedImageKey("test.registry/test:v2", "ns", "auth", "https://notary", policy))
+	require.False(t, denied, "other tag")
+	_, denied = c.get(deniedImageKey("test.registry/test:v1", "other-ns", "auth", "https://notary", policy))
+	require.False(t, denied, "other namespace")
+	optional := policy
+	optional.SignatureOptional = true
+	_, denied = c.get(deniedImageKey("test.registry/test:v1", "ns", "auth", "https://notary", optional))
+	require.False(t, denied, "other policy")
+
+	expired := newDeniedImageCache(time.Nanosecond)
+	expired.add(key, deniedReason{reason: "denied"})
+	time.Sleep(time.Millisecond)
+	_, denied = expired.get(key)
+	require.False(t, denied, "expired")
+
+	disabled := newDeniedImageCache(0)
+	disabled.add(key, deniedReason{reason: "denied"})
+	_, denied = disabled.get(key)
+	require.False(t, denied, "disabled")
+}
+
+func TestValidator_CheckIsValidAndAddDigest_deniedImage(t *testing.T) {
+	const registry = "registry.test"
+	img := registry + "/image:v1"
+	digest := "sha256:" + strings.Repeat("1", 64)
+
+	tc := map[string]struct {
+		ttl time.Duration
+
+		expectedVerified int
+	}{
+		"cacheHit": {
+			ttl:              time.Minute,
+			expectedVerified: 1,
+		},
+		"expired": {
+			ttl:              10 * time.Millisecond,
+			expectedVerified: 2,
+		},
+		"disabled": {
+			expectedVerified: 2,
+		},
+	}
+
+	for caseName, c := range tc {
+		t.Run(caseName, func(t *testing.T) {
+			v := testCachedSignatureValidator(whv1.RegistrySpec{Registry: registry, Notary: "https://notary.test", SignCheck: true}, img)
+			v.deniedImages = newDeniedImageCache(c.ttl)
+			verifier := &stubVerifier{err: &deniedError{reason: "Stub: denied"}}
+			v.verifier = verifier
+
+			valid, reason, err := v.CheckIsValidAndAddDigest(generateTestPod(img, testCheckSign, ""))
+			require.NoError(t, err)
+			require.False(t, valid)
+			require.Equal(t, "Stub: denied", reason)
+
+			time.Sleep(20 * time.Millisecond)
+			valid, reason, err = v.CheckIsValidAndAddDigest(generateTestPod(img, testCheckSign, ""))
+			require.NoError(t, err)
+			require.False(t, valid)
+			require.Equal(t, "Stub: denied", reason)
+			require.Len(t, verifier.verified, c.expectedVerified)
+
+			// The image signed after it's denied is allowed once the denial expires
+			verifier.err, verifier.digest = nil, digest
+			time.Sleep(20 * time.Millisecond)
+			valid, _, err = v.CheckIsValidAndAddDigest(generateTestPod(img, testCheckSign, ""))
+			require.NoError(t, err)
+			require.Equal(t, c.ttl != time.Minute, valid)
+		})
+	}
+}
+
+func TestValidator_CheckIsValidAndAddDigest_deniedImageOtherNamespace(t *testing.T) {
+	const registry = "registry.test"
+	img := registry + "/image:v1"
+	const optionalNamespace = "signature-optional"
+
+	v := testCachedSignatureValidator(whv1.RegistrySpec{Registry: registry, Notary: "https://notary.test", SignCheck: true}, img)
+	v.registryPolicyCache.namespaceCachedClient.(*watcherfake.CachedClient).Cache[optionalNamespace+"/policy"] = &whv1.RegistrySecurityPolicy{
+		ObjectMeta: metav1.ObjectMeta{Name: "policy", Namespace: optionalNamespace},
+		Spec:       whv1.RegistrySecurityPolicySpec{Registries: []whv1.RegistrySpec{{Registry: registry, Notary: "https://notary.test", SignCheck: true, SignatureOptional: true}}},
+	}
+	v.deniedImages = newDeniedImageCache(time.Minute)
+	verifier := &stubVerifier{err: &notSignedError{}}
+	v.verifier = verifier
+
+	valid, _, err := v.CheckIsValidAndAddDigest(generateTestPod(img, testCheckSign, ""))
+	require.NoError(t, err)
+	require.False(t, valid)
+
+	// The denial in the other namespace is not replayed, as its policy allows the image which is not signed
+	valid, _, err = v.CheckIsValidAndAddDigest(generateTestPod(img, optionalNamespace, ""))
+	require.NoError(t, err)
+	require.True(t, valid)
+	require.Len(t, verifier.verified, 2)
+}
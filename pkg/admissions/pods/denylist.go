@@ -0,0 +1,108 @@
+package pods
+
+import (
+	"fmt"
+	"strings"
+
+	godigest "github.com/opencontainers/go-digest"
+	"github.com/tmax-cloud/image-validating-webhook/internal/k8s"
+	"github.com/tmax-cloud/image-validating-webhook/pkg/watcher"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/client-go/rest"
+	ctrl "sigs.k8s.io/controller-runtime"
+)
+
+const (
+	denylistConfigMap = "image-validation-webhook-denylist"
+
+	denylistByDigest = "denied-digests"
+)
+
+var dlog = ctrl.Log.WithName("denylist.go")
+
+// DenyList stores the digests of the compromised or vulnerable images, which are denied regardless of their signatures.
+// It's read from the config map cache on each validation, so its updates (and its deletion) take effect without restarting
+type DenyList struct {
+	cachedClient watcher.CachedClient
+}
+
+func newDenyList(cfg *rest.Config) (*DenyList, error) {
+	watchCli, err := k8s.NewGroupVersionClient(cfg, corev1.SchemeGroupVersion)
+	if err != nil {
+		return nil, err
+	}
+
+	w := watcher.New(registryNamespace, string(corev1.ResourceConfigMaps), &corev1.ConfigMap{}, watchCli, fields.ParseSelectorOrDie(fmt.Sprintf("metadata.name=%s", denylistConfigMap)))
+	dl := &DenyList{cachedClient: watcher.NewCachedClient(w)}
+
+	waitCh := make(chan struct{})
+
+	// Start to watch deny list config map
+	go w.Start(waitCh)
+
+	// Block until it's ready
+	<-waitCh
+
+	return dl, nil
+}
+
+// deniedDigests returns the denied digests, read from the line-separated list of the config map.
+// A line may have a comment after the digest (e.g., sha256:<hex> # CVE-2021-44228). Malformed lines are skipped
+func (d *DenyList) deniedDigests() (map[string]bool, error) {
+	if d == nil {
+		return nil, nil
+	}
+	cms := &corev1.ConfigMapList{}
+	if err := d.cachedClient.List(watcher.Selector{Namespace: registryNamespace}, cms); err != nil {
+		return nil, err
+	}
+
+	digests := map[string]bool{}
+	for _, cm := range cms.Items {
+		for _, line := range parseLineSeparatedList(cm.Data[denylistByDigest]) {
+			entry := strings.TrimSpace(strings.SplitN(line, "#", 2)[0])
+			if entry == "" {
+				continue
+			}
+			if _, err := godigest.Parse(entry); err != nil {
+				dlog.Info("skipping malformed digest of the deny list", "digest", entry, "reason", err.Error())
+				continue
+			}
+			digests[entry] = true
+		}
+	}
+	return digests, nil
+}
+
+// deniedReason returns why the pod is denied if any of its images (pinned to the digests by the validation) has a denied digest.
+// The images without digests are not checked, as they're not resolved (e.g., whitelisted or not signature-checked)
+func (d *DenyList) deniedReason(pod *corev1.Pod) (string, error) {
+	digests, err := d.deniedDigests()
+	if err != nil || len(digests) == 0 {
+		return "", err
+	}
+
+	var reasons []string
+	for _, containers := range [][]corev1.Container{pod.Spec.InitContainers, pod.Spec.Containers} {
+		for _, c := range containers {
+			ref, err := parseImage(c.Image)
+			if err != nil {
+				return "", err
+			}
+			if digests[ref.digest] {
+				reasons = append(reasons, fmt.Sprintf("Image '%s' has the digest %s denied by the deny list", c.Image, ref.digest))
+			}
+		}
+	}
+	return strings.Join(reasons, "\n"), nil
+}
+
+// checkNotDenied checks the pod validated by the signatures against the deny list, after the digests of its images are resolved
+func (h *validator) checkNotDenied(pod *corev1.Pod) (bool, string, error) {
+	reason, err := h.denyList.deniedReason(pod)
+	if err != nil {
+		return false, "", err
+	}
+	return reason == "", reason, nil
+}
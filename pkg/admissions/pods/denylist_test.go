@@ -0,0 +1,71 @@
+package pods
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/tmax-cloud/image-validating-webhook/pkg/notary"
+	whv1 "github.com/tmax-cloud/image-validating-webhook/pkg/type"
+	watcherfake "github.com/tmax-cloud/image-validating-webhook/pkg/watcher/fake"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+func TestValidator_CheckIsValidAndAddDigest_denyList(t *testing.T) {
+	const registry = "registry.test"
+	signed := strings.Repeat("1", 64)
+	other := strings.Repeat("2", 64)
+	whitelisted := "sha256:" + strings.Repeat("3", 64)
+
+	tc := map[string]struct {
+		deniedDigests string
+		image         string
+
+		expectedValid  bool
+		expectedReason string
+	}{
+		"denied": {
+			deniedDigests:  "sha256:" + other + "\nsha256:" + signed + " # CVE-2021-44228",
+			image:          registry + "/image:v1",
+			expectedReason: fmt.Sprintf("Image '%s/image:v1@sha256:%s' has the digest sha256:%s denied by the deny list", registry, signed, signed),
+		},
+		"notDenied": {
+			deniedDigests: "sha256:" + other + "\nmalformed",
+			image:         registry + "/image:v1",
+			expectedValid: true,
+		},
+		"noDenyList": {
+			image:         registry + "/image:v1",
+			expectedValid: true,
+		},
+		"whitelistedDenied": {
+			deniedDigests:  whitelisted,
+			image:          "whitelisted.test/image@" + whitelisted,
+			expectedReason: fmt.Sprintf("Image 'whitelisted.test/image@%s' has the digest %s denied by the deny list", whitelisted, whitelisted),
+		},
+	}
+
+	for caseName, c := range tc {
+		t.Run(caseName, func(t *testing.T) {
+			v := testCachedSignatureValidator(whv1.RegistrySpec{Registry: registry, Notary: "https://notary.test", SignCheck: true}, registry+"/image:v1",
+				notary.SignedTag{SignedTag: "v1", Digest: signed, Algorithm: "sha256", Signers: []string{"Repo Admin"}})
+			require.NoError(t, v.whiteList.UnmarshalImage("whitelisted.test/*"))
+			cache := map[string]runtime.Object{}
+			if c.deniedDigests != "" {
+				cache[registryNamespace+"/"+denylistConfigMap] = &corev1.ConfigMap{
+					ObjectMeta: metav1.ObjectMeta{Name: denylistConfigMap, Namespace: registryNamespace},
+					Data:       map[string]string{denylistByDigest: c.deniedDigests},
+				}
+			}
+			v.denyList = &DenyList{cachedClient: &watcherfake.CachedClient{Cache: cache}}
+
+			valid, reason, err := v.CheckIsValidAndAddDigest(generateTestPod(c.image, testCheckSign, ""))
+			require.NoError(t, err)
+			require.Equal(t, c.expectedValid, valid)
+			require.Equal(t, c.expectedReason, reason)
+		})
+	}
+}
@@ -0,0 +1,103 @@
+package pods
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/tmax-cloud/image-validating-webhook/pkg/notary"
+	whv1 "github.com/tmax-cloud/image-validating-webhook/pkg/type"
+)
+
+// validatedDigestCache remembers the digests validated by their signatures for a while,
+// so that the pods recreated with the pinned images (e.g., by a rolling update) skip the notary round-trip
+type validatedDigestCache struct {
+	lock    sync.RWMutex
+	ttl     time.Duration
+	entries map[string]validatedDigest
+
+	hits   uint64
+	misses uint64
+}
+
+// newValidatedDigestCache creates a new cache. It returns nil, which caches nothing, if ttl is not positive
+func newValidatedDigestCache(ttl time.Duration) *validatedDigestCache {
+	if ttl <= 0 {
+		return nil
+	}
+	return &validatedDigestCache{ttl: ttl, entries: map[string]validatedDigest{}}
+}
+
+type validatedDigest struct {
+	expireAt time.Time
+	// signedAt is when the digest was signed, so that its signature age is still checked without the notary round-trip. It's zero if it's unknown
+	signedAt time.Time
+}
+
+// validatedDigestKey is a key of the digest validated from the notary server by the policy, in the scope of the credential it's validated with
+func validatedDigestKey(ref *imageRef, basicAuth, notaryURL string, policy whv1.RegistrySpec) string {
+	return strings.Join([]string{notaryURL, ref.host + "/" + ref.name + "@" + ref.digest, policyHash(policy), credentialScope(basicAuth)}, "|")
+}
+
+// policyHash scopes the cached results by the whole policy they're checked by, so that the results checked by a policy are never reused
+// for a stricter one (e.g., with more signers, pinned admin keys or required architectures). The order of the signers doesn't matter
+func policyHash(policy whv1.RegistrySpec) string {
+	policy.Signer = append([]string{}, policy.Signer...)
+	sort.Strings(policy.Signer)
+	b, err := json.Marshal(policy)
+	if err != nil {
+		// It never happens, as the spec has only the plain fields. Nothing is shared if it does
+		return fmt.Sprintf("unhashable-%d", time.Now().UnixNano())
+	}
+	return fmt.Sprintf("%x", sha256.Sum256(b))
+}
+
+// get returns when the digest was signed, if it's validated within the ttl
+func (c *validatedDigestCache) get(key string) (time.Time, bool) {
+	if c == nil {
+		return time.Time{}, false
+	}
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+
+	entry, exist := c.entries[key]
+	if !exist || !time.Now().Before(entry.expireAt) {
+		atomic.AddUint64(&c.misses, 1)
+		return time.Time{}, false
+	}
+	atomic.AddUint64(&c.hits, 1)
+	return entry.signedAt, true
+}
+
+func (c *validatedDigestCache) add(key string, signedAt time.Time) {
+	if c == nil {
+		return
+	}
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	now := time.Now()
+	// Clean up the expired entries
+	for k, entry := range c.entries {
+		if now.After(entry.expireAt) {
+			delete(c.entries, k)
+		}
+	}
+	c.entries[key] = validatedDigest{expireAt: now.Add(c.ttl), signedAt: signedAt}
+}
+
+// stats returns the statistics of the cache. It's empty if the cache is disabled
+func (c *validatedDigestCache) stats() notary.CacheStats {
+	if c == nil {
+		return notary.CacheStats{}
+	}
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+
+	return notary.CacheStats{Entries: len(c.entries), Hits: atomic.LoadUint64(&c.hits), Misses: atomic.LoadUint64(&c.misses)}
+}
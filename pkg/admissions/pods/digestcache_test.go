@@ -0,0 +1,156 @@
+package pods
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	whv1 "github.com/tmax-cloud/image-validating-webhook/pkg/type"
+	watcherfake "github.com/tmax-cloud/image-validating-webhook/pkg/watcher/fake"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+const testValidatedDigest = "sha256:1111111111111111111111111111111111111111111111111111111111111111"
+
+func hasValidatedDigest(c *validatedDigestCache, key string) bool {
+	_, hit := c.get(key)
+	return hit
+}
+
+func TestValidatedDigestCache(t *testing.T) {
+	ref := &imageRef{host: "test.registry", name: "test", tag: "v1", digest: testValidatedDigest}
+	policy := whv1.RegistrySpec{Registry: "test.registry", SignCheck: true, Signer: []string{"signer-2", "signer-1"}}
+	key := validatedDigestKey(ref, "", "https://notary", policy)
+
+	c := newValidatedDigestCache(time.Minute)
+	require.False(t, hasValidatedDigest(c, key), "empty cache")
+
+	c.add(key, time.Time{})
+	require.True(t, hasValidatedDigest(c, key))
+	signedAt := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	c.add(key, signedAt)
+	cached, _ := c.get(key)
+	require.Equal(t, signedAt, cached, "signing time")
+	reordered := policy
+	reordered.Signer = []string{"signer-1", "signer-2"}
+	require.True(t, hasValidatedDigest(c, validatedDigestKey(&imageRef{host: "test.registry", name: "test", digest: testValidatedDigest}, "", "https://notary", reordered)), "tag and signers order are ignored")
+	otherSigners := policy
+	otherSigners.Signer = []string{"signer-1"}
+	require.False(t, hasValidatedDigest(c, validatedDigestKey(ref, "", "https://notary", otherSigners)), "other signers")
+	stricter := policy
+	stricter.SignerThreshold = 2
+	require.False(t, hasValidatedDigest(c, validatedDigestKey(ref, "", "https://notary", stricter)), "stricter policy")
+	require.False(t, hasValidatedDigest(c, validatedDigestKey(ref, "", "https://other-notary", policy)), "other notary")
+	require.False(t, hasValidatedDigest(c, validatedDigestKey(ref, "private", "https://notary", policy)), "other credential")
+
+	expired := newValidatedDigestCache(time.Nanosecond)
+	expired.add(key, time.Time{})
+	time.Sleep(time.Millisecond)
+	require.False(t, hasValidatedDigest(expired, key), "expired")
+
+	disabled := newValidatedDigestCache(0)
+	disabled.add(key, time.Time{})
+	require.False(t, hasValidatedDigest(disabled, key), "disabled")
+}
+
+func TestValidator_CheckIsValidAndAddDigest_validatedDigest(t *testing.T) {
+	// Notary server is not reachable, so only the validated digests are valid
+	notaryURL := "https://127.0.0.1:1"
+	v := &validator{client: fake.NewSimpleClientset(), whiteList: &WhiteList{}, validatedDigests: newValidatedDigestCache(time.Minute)}
+	v.registryPolicyCache = &RegistryPolicyCache{clusterCachedClient: &watcherfake.CachedClient{}, namespaceCachedClient: &watcherfake.CachedClient{
+		Cache: map[string]runtime.Object{
+			testCheckSign + "/policy": &whv1.RegistrySecurityPolicy{
+				ObjectMeta: metav1.ObjectMeta{Name: "policy", Namespace: testCheckSign},
+				Spec: whv1.RegistrySecurityPolicySpec{
+					Registries: []whv1.RegistrySpec{{Registry: "test.registry", Notary: notaryURL, SignCheck: true}},
+				},
+			},
+		},
+	}}
+	v.validatedDigests.add(validatedDigestKey(&imageRef{host: "test.registry", name: "test", digest: testValidatedDigest}, "", notaryURL, whv1.RegistrySpec{Registry: "test.registry", Notary: notaryURL, SignCheck: true}), time.Time{})
+
+	pod := generateTestPod("test.registry/test:v1@"+testValidatedDigest, testCheckSign, "")
+	valid, _, err := v.CheckIsValidAndAddDigest(pod)
+	require.NoError(t, err)
+	require.True(t, valid)
+	require.Equal(t, "test.registry/test:v1@"+testValidatedDigest, pod.Spec.Containers[0].Image)
+
+	pod = generateTestPod("test.registry/test:v2", testCheckSign, "")
+	_, _, err = v.CheckIsValidAndAddDigest(pod)
+	require.Error(t, err, "not validated image is checked from notary server")
+}
+
+func TestValidator_CheckIsValidAndAddDigest_validatedDigestStricterPolicy(t *testing.T) {
+	const registry = "registry.test"
+	img := registry + "/image:v1"
+	digest := "sha256:" + strings.Repeat("1", 64)
+	validatedDigests := newValidatedDigestCache(time.Minute)
+
+	// The digest is validated by the policy requiring one of the signers
+	weak := testCachedSignatureValidator(whv1.RegistrySpec{Registry: registry, Notary: "https://notary.test", SignCheck: true, Signer: []string{"alice", "bob"}}, img)
+	weak.validatedDigests = validatedDigests
+	weak.verifier = &stubVerifier{digest: digest, signers: []string{"alice"}}
+	pod := generateTestPod(img, testCheckSign, "")
+	valid, _, err := weak.CheckIsValidAndAddDigest(pod)
+	require.NoError(t, err)
+	require.True(t, valid)
+	pinned := pod.Spec.Containers[0].Image
+
+	// The pinned digest is verified again by the policy requiring both of them, rather than allowed by the validated digest
+	strict := testCachedSignatureValidator(whv1.RegistrySpec{Registry: registry, Notary: "https://notary.test", SignCheck: true, Signer: []string{"alice", "bob"}, SignerThreshold: 2}, img)
+	strict.validatedDigests = validatedDigests
+	verifier := &stubVerifier{err: &deniedError{reason: "Stub: signed by 1 of the signers"}}
+	strict.verifier = verifier
+	valid, reason, err := strict.CheckIsValidAndAddDigest(generateTestPod(pinned, testCheckSign, ""))
+	require.NoError(t, err)
+	require.False(t, valid)
+	require.Equal(t, "Stub: signed by 1 of the signers", reason)
+	require.Equal(t, []string{pinned}, verifier.verified)
+
+	// The same policy still skips the verification
+	weak.verifier = verifier
+	valid, _, err = weak.CheckIsValidAndAddDigest(generateTestPod(pinned, testCheckSign, ""))
+	require.NoError(t, err)
+	require.True(t, valid)
+	require.Len(t, verifier.verified, 1)
+}
+
+func TestValidator_CheckIsValidAndAddDigest_validatedDigestTooOld(t *testing.T) {
+	const registry = "registry.test"
+	img := registry + "/image:v1@" + testValidatedDigest
+	policy := whv1.RegistrySpec{Registry: registry, Notary: "https://notary.test", SignCheck: true, MaxSignatureAge: &metav1.Duration{Duration: 30 * 24 * time.Hour}}
+
+	tc := map[string]struct {
+		signedAt time.Time
+
+		expectedVerified int
+	}{
+		"recent": {
+			signedAt:         time.Now().Add(-24 * time.Hour),
+			expectedVerified: 0,
+		},
+		// The signature got older than the maximum age after it's validated
+		"tooOld": {
+			signedAt:         time.Now().Add(-31 * 24 * time.Hour),
+			expectedVerified: 1,
+		},
+	}
+
+	for caseName, c := range tc {
+		t.Run(caseName, func(t *testing.T) {
+			v := testCachedSignatureValidator(policy, img)
+			v.validatedDigests = newValidatedDigestCache(time.Minute)
+			v.validatedDigests.add(validatedDigestKey(&imageRef{host: registry, name: "image", digest: testValidatedDigest}, "", "https://notary.test", policy), c.signedAt)
+			verifier := &stubVerifier{err: &deniedError{reason: "Stub: too old"}}
+			v.verifier = verifier
+
+			valid, _, err := v.CheckIsValidAndAddDigest(generateTestPod(img, testCheckSign, ""))
+			require.NoError(t, err)
+			require.Equal(t, c.expectedVerified == 0, valid)
+			require.Len(t, verifier.verified, c.expectedVerified)
+		})
+	}
+}
@@ -0,0 +1,111 @@
+package pods
+
+import (
+	"fmt"
+
+	"github.com/tmax-cloud/image-validating-webhook/internal/k8s"
+	whv1 "github.com/tmax-cloud/image-validating-webhook/pkg/type"
+	"github.com/tmax-cloud/image-validating-webhook/pkg/watcher"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/rest"
+	ctrl "sigs.k8s.io/controller-runtime"
+)
+
+const (
+	// EnforceAnnotation is the namespace annotation elevating or relaxing the signature requirement of the namespace's pods
+	EnforceAnnotation = "image-validation.tmax.io/enforce"
+
+	// EnforceStrict requires the signatures of all the images of the namespace, even if their registries' policies don't
+	EnforceStrict = "strict"
+	// EnforcePermissive allows the images which are not signed, as signatureOptional does. It's ignored unless Options.PermissiveEnforcement is set
+	EnforcePermissive = "permissive"
+)
+
+var elog = ctrl.Log.WithName("enforcement.go")
+
+// NamespaceEnforcement stores the enforcements of the namespaces by their EnforceAnnotation, read from the namespace cache
+type NamespaceEnforcement struct {
+	cachedClient watcher.CachedClient
+	// allowPermissive honors EnforcePermissive, which is ignored otherwise
+	allowPermissive bool
+}
+
+func newNamespaceEnforcement(cfg *rest.Config, allowPermissive bool) (*NamespaceEnforcement, error) {
+	watchCli, err := k8s.NewGroupVersionClient(cfg, corev1.SchemeGroupVersion)
+	if err != nil {
+		return nil, err
+	}
+
+	w := watcher.New("", "namespaces", &corev1.Namespace{}, watchCli, fields.Everything())
+	ne := &NamespaceEnforcement{cachedClient: watcher.NewCachedClient(w), allowPermissive: allowPermissive}
+
+	waitCh := make(chan struct{})
+
+	// Start to watch namespaces
+	go w.Start(waitCh)
+
+	// Block until it's ready
+	<-waitCh
+
+	return ne, nil
+}
+
+// enforcement returns the enforcement of the namespace. It's empty if the namespace is not annotated or has an unknown enforcement,
+// or its enforcement is EnforcePermissive which is not allowed
+func (e *NamespaceEnforcement) enforcement(namespace string) string {
+	if e == nil {
+		return ""
+	}
+	ns := &corev1.Namespace{}
+	if err := e.cachedClient.Get(types.NamespacedName{Name: namespace}, ns); err != nil {
+		return ""
+	}
+	switch enforce := ns.Annotations[EnforceAnnotation]; enforce {
+	case "", EnforceStrict:
+		return enforce
+	case EnforcePermissive:
+		if !e.allowPermissive {
+			elog.Info("ignoring permissive enforcement of the namespace, which is not enabled", "namespace", namespace)
+			return ""
+		}
+		return enforce
+	default:
+		elog.Info("ignoring unknown enforcement of the namespace", "namespace", namespace, "enforce", enforce)
+		return ""
+	}
+}
+
+// enforcedPolicy applies the namespace's enforcement to the policy matched for the image's registry.
+// strict checks the signatures of the images allowed without them (i.e., by --default-policy=Allow, signCheck: false or signatureOptional).
+// permissive allows the images which are not signed, pinning the signed ones. The images matching no policy are denied regardless.
+// It returns false if strict can't check the signatures, as the policy configures neither a notary server nor a cosign key
+func (h *validator) enforcedPolicy(policy whv1.RegistrySpec, registry, namespace string) (whv1.RegistrySpec, bool) {
+	switch h.enforcement.enforcement(namespace) {
+	case EnforceStrict:
+		if policy.SignCheck {
+			policy.SignatureOptional = false
+			return policy, true
+		}
+		if policy.Notary == "" && policy.CosignKeyRef == "" {
+			return whv1.RegistrySpec{}, false
+		}
+		if policy.Registry == "" {
+			if registry == "" {
+				registry = "docker.io"
+			}
+			policy.Registry = registry
+		}
+		policy.SignCheck = true
+		policy.SignatureOptional = false
+	case EnforcePermissive:
+		policy.SignatureOptional = true
+	}
+	return policy, true
+}
+
+// strictNotEnforceableReason tells why the image is denied, if the strict enforcement of the namespace can't check its signature
+func strictNotEnforceableReason(image, namespace string) string {
+	return fmt.Sprintf("Image '%s' must be signed by the strict enforcement of namespace '%s', but no registry security policy configures a notary server or a cosign key of its registry", image, namespace)
+}
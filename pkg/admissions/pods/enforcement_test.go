@@ -0,0 +1,143 @@
+package pods
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"github.com/tmax-cloud/image-validating-webhook/pkg/notary"
+	whv1 "github.com/tmax-cloud/image-validating-webhook/pkg/type"
+	watcherfake "github.com/tmax-cloud/image-validating-webhook/pkg/watcher/fake"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+func TestValidator_CheckIsValidAndAddDigest_enforcement(t *testing.T) {
+	const registry = "registry.test"
+	signed := registry + "/signed:v1"
+	notSigned := registry + "/not-signed:v1"
+	digest := strings.Repeat("1", 64)
+
+	tc := map[string]struct {
+		enforce         string
+		allowPermissive bool
+		signCheck       bool
+		noNotary        bool
+		image           string
+
+		expectedValid  bool
+		expectedReason string
+		expectedImage  string
+	}{
+		"notEnforced": {
+			image:         notSigned,
+			expectedValid: true,
+			expectedImage: notSigned,
+		},
+		"strictSigned": {
+			enforce:       EnforceStrict,
+			image:         signed,
+			expectedValid: true,
+			expectedImage: signed + "@sha256:" + digest,
+		},
+		"strictNotSigned": {
+			enforce:        EnforceStrict,
+			image:          notSigned,
+			expectedReason: fmt.Sprintf("Notary: Image '%s' is not signed", notSigned),
+			expectedImage:  notSigned,
+		},
+		"strictNoNotary": {
+			enforce:        EnforceStrict,
+			noNotary:       true,
+			image:          signed,
+			expectedReason: fmt.Sprintf("Notary: Image '%s' must be signed by the strict enforcement of namespace '%s', but no registry security policy configures a notary server", signed, testCheckSign),
+			expectedImage:  signed,
+		},
+		"permissiveNotSigned": {
+			enforce:         EnforcePermissive,
+			allowPermissive: true,
+			signCheck:       true,
+			image:           notSigned,
+			expectedValid:   true,
+			expectedImage:   notSigned,
+		},
+		"permissiveSigned": {
+			enforce:         EnforcePermissive,
+			allowPermissive: true,
+			signCheck:       true,
+			image:           signed,
+			expectedValid:   true,
+			expectedImage:   signed + "@sha256:" + digest,
+		},
+		"permissiveNotEnabled": {
+			enforce:        EnforcePermissive,
+			signCheck:      true,
+			image:          notSigned,
+			expectedReason: fmt.Sprintf("Notary: Image '%s' is not signed", notSigned),
+			expectedImage:  notSigned,
+		},
+		"unknownEnforcement": {
+			enforce:        "lenient",
+			signCheck:      true,
+			image:          notSigned,
+			expectedReason: fmt.Sprintf("Notary: Image '%s' is not signed", notSigned),
+			expectedImage:  notSigned,
+		},
+	}
+
+	for caseName, c := range tc {
+		t.Run(caseName, func(t *testing.T) {
+			policy := whv1.RegistrySpec{Registry: registry, Notary: "https://notary.test", SignCheck: c.signCheck}
+			if c.noNotary {
+				policy.Notary = ""
+			}
+			v := testCachedSignatureValidator(policy, signed,
+				notary.SignedTag{SignedTag: "v1", Digest: digest, Algorithm: "sha256", Signers: []string{"Repo Admin"}})
+			v.signatureCache.Set(notSigned, "https://notary.test", nil, time.Minute)
+			ns := &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: testCheckSign}}
+			if c.enforce != "" {
+				ns.Annotations = map[string]string{EnforceAnnotation: c.enforce}
+			}
+			v.enforcement = &NamespaceEnforcement{cachedClient: &watcherfake.CachedClient{Cache: map[string]runtime.Object{testCheckSign: ns}}, allowPermissive: c.allowPermissive}
+
+			pod := generateTestPod(c.image, testCheckSign, "")
+			valid, reason, err := v.CheckIsValidAndAddDigest(pod)
+			require.NoError(t, err)
+			require.Equal(t, c.expectedValid, valid)
+			require.True(t, strings.HasPrefix(reason, c.expectedReason), reason)
+			require.Equal(t, c.expectedImage, pod.Spec.Containers[0].Image)
+		})
+	}
+}
+
+func TestValidator_enforcedPolicy_noPolicy(t *testing.T) {
+	const registryHost = "registry.test"
+	v := &validator{enforcement: &NamespaceEnforcement{cachedClient: &watcherfake.CachedClient{Cache: map[string]runtime.Object{
+		"production": &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "production", Annotations: map[string]string{EnforceAnnotation: EnforceStrict}}},
+		"staging":    &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "staging"}},
+	}}}}
+
+	// No policy at all allows every image, but the strict enforcement can't check the signatures without a notary server
+	policy, enforceable := v.enforcedPolicy(whv1.RegistrySpec{}, registryHost, "production")
+	require.False(t, enforceable)
+	require.Equal(t, whv1.RegistrySpec{}, policy)
+	policy, enforceable = v.enforcedPolicy(whv1.RegistrySpec{Registry: registryHost}, registryHost, "production")
+	require.False(t, enforceable, "allowed by the default policy")
+	require.Equal(t, whv1.RegistrySpec{}, policy)
+
+	policy, enforceable = v.enforcedPolicy(whv1.RegistrySpec{Registry: registryHost, Notary: "https://notary.test"}, registryHost, "production")
+	require.True(t, enforceable)
+	require.Equal(t, whv1.RegistrySpec{Registry: registryHost, Notary: "https://notary.test", SignCheck: true}, policy)
+
+	for _, namespace := range []string{"staging", "not-found"} {
+		policy, enforceable = v.enforcedPolicy(whv1.RegistrySpec{}, registryHost, namespace)
+		require.True(t, enforceable)
+		require.Equal(t, whv1.RegistrySpec{}, policy)
+	}
+	policy, enforceable = (&validator{}).enforcedPolicy(whv1.RegistrySpec{}, registryHost, "production")
+	require.True(t, enforceable, "no enforcement")
+	require.Equal(t, whv1.RegistrySpec{}, policy)
+}
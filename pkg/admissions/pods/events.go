@@ -0,0 +1,67 @@
+package pods
+
+import (
+	"fmt"
+
+	core "k8s.io/api/core/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/scheme"
+	typedcorev1 "k8s.io/client-go/kubernetes/typed/core/v1"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/client-go/util/flowcontrol"
+)
+
+// ImageValidationDeniedReason is the reason of the events of the pods denied by their images
+const ImageValidationDeniedReason = "ImageValidationDenied"
+
+const (
+	// deniedEventComponent is the source component of the denied events
+	deniedEventComponent = "image-validation-webhook"
+	// deniedEventBurst is the number of the denied events emitted at once, before they're limited by the rate
+	deniedEventBurst = 10
+	// maxDeniedEventMessageSize is the maximum size of the message of a denied event. Longer reasons are truncated
+	maxDeniedEventMessageSize = 1024
+)
+
+// deniedEventRecorder emits the events of the denied pods in their namespaces, so that they're surfaced by kubectl get events,
+// even though the pods are never created. The events are rate-limited, not to flood them when a mass deploy fails
+type deniedEventRecorder struct {
+	recorder record.EventRecorder
+	limiter  flowcontrol.PassiveRateLimiter
+}
+
+// newDeniedEventRecorder creates a new recorder emitting up to rate events per second, which stops when stopCh is closed
+func newDeniedEventRecorder(client kubernetes.Interface, rate float32, stopCh <-chan struct{}) *deniedEventRecorder {
+	broadcaster := record.NewBroadcaster()
+	broadcaster.StartRecordingToSink(&typedcorev1.EventSinkImpl{Interface: client.CoreV1().Events("")})
+	go func() {
+		<-stopCh
+		broadcaster.Shutdown()
+	}()
+	return &deniedEventRecorder{
+		recorder: broadcaster.NewRecorder(scheme.Scheme, core.EventSource{Component: deniedEventComponent}),
+		limiter:  flowcontrol.NewTokenBucketPassiveRateLimiter(rate, deniedEventBurst),
+	}
+}
+
+// record emits the event of the denied pod with the reason. The pod being created may have only its generate name
+func (r *deniedEventRecorder) record(pod *core.Pod, reason string) {
+	if r == nil {
+		return
+	}
+	if !r.limiter.TryAccept() {
+		plog.Info("Dropping the denied event, as the events are rate-limited", "namespace", pod.Namespace, "pod", pod.Name, "generateName", pod.GenerateName)
+		return
+	}
+
+	name := pod.Name
+	if name == "" {
+		name = pod.GenerateName
+	}
+	message := fmt.Sprintf("Pod is denied by its images: %s", reason)
+	if len(message) > maxDeniedEventMessageSize {
+		message = message[:maxDeniedEventMessageSize-3] + "..."
+	}
+	ref := &core.ObjectReference{APIVersion: "v1", Kind: "Pod", Namespace: pod.Namespace, Name: name, UID: pod.UID}
+	r.recorder.Event(ref, core.EventTypeWarning, ImageValidationDeniedReason, message)
+}
@@ -0,0 +1,94 @@
+package pods
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	admissionv1beta1 "k8s.io/api/admission/v1beta1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/client-go/util/flowcontrol"
+)
+
+func TestImageAdmission_HandleAdmission_deniedEvents(t *testing.T) {
+	tc := map[string]struct {
+		image   string
+		limiter flowcontrol.PassiveRateLimiter
+
+		expectedEvents []string
+	}{
+		"denied": {
+			image:          "test-not-signed:test",
+			limiter:        flowcontrol.NewFakeAlwaysRateLimiter(),
+			expectedEvents: []string{"Warning " + ImageValidationDeniedReason + " Pod is denied by its images: image 'test-not-signed:test' is not signed"},
+		},
+		"allowed": {
+			image:   "test-signed:test",
+			limiter: flowcontrol.NewFakeAlwaysRateLimiter(),
+		},
+		"rateLimited": {
+			image:   "test-not-signed:test",
+			limiter: flowcontrol.NewFakeNeverRateLimiter(),
+		},
+	}
+
+	for caseName, c := range tc {
+		t.Run(caseName, func(t *testing.T) {
+			recorder := record.NewFakeRecorder(10)
+			im := &ImageAdmission{validator: &dummyValidator{}, deniedEvents: &deniedEventRecorder{recorder: recorder, limiter: c.limiter}}
+
+			pod := &corev1.Pod{
+				ObjectMeta: metav1.ObjectMeta{GenerateName: "test-", Namespace: "testns"},
+				Spec:       corev1.PodSpec{Containers: []corev1.Container{{Name: "test-cont", Image: c.image}}},
+			}
+			raw, err := json.Marshal(pod)
+			require.NoError(t, err)
+			review := &admissionv1beta1.AdmissionReview{
+				Request: &admissionv1beta1.AdmissionRequest{
+					Namespace: pod.Namespace,
+					Operation: admissionv1beta1.Create,
+					Object:    runtime.RawExtension{Raw: raw},
+				},
+			}
+
+			require.NoError(t, im.HandleAdmission(review))
+			close(recorder.Events)
+			var events []string
+			for e := range recorder.Events {
+				events = append(events, e)
+			}
+			require.Equal(t, c.expectedEvents, events)
+		})
+	}
+}
+
+func TestDeniedEventRecorder_record(t *testing.T) {
+	recorder := record.NewFakeRecorder(20)
+	recorder.IncludeObject = true
+	r := &deniedEventRecorder{recorder: recorder, limiter: flowcontrol.NewTokenBucketPassiveRateLimiter(0.001, deniedEventBurst)}
+
+	// Rate-limited after the burst
+	pod := &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "test-pod", Namespace: "testns"}}
+	for i := 0; i < deniedEventBurst+5; i++ {
+		r.record(pod, "not signed")
+	}
+	require.Len(t, recorder.Events, deniedEventBurst)
+	require.Equal(t, "Warning "+ImageValidationDeniedReason+" Pod is denied by its images: not signed involvedObject{kind=Pod,apiVersion=v1}", <-recorder.Events)
+
+	// The long reason is truncated
+	r.limiter = flowcontrol.NewFakeAlwaysRateLimiter()
+	long := make([]byte, 2*maxDeniedEventMessageSize)
+	for i := range long {
+		long[i] = 'a'
+	}
+	recorder.IncludeObject = false
+	for len(recorder.Events) > 0 {
+		<-recorder.Events
+	}
+	r.record(pod, string(long))
+	e := <-recorder.Events
+	require.Len(t, e, len("Warning "+ImageValidationDeniedReason+" ")+maxDeniedEventMessageSize)
+}
@@ -0,0 +1,95 @@
+package pods
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/tmax-cloud/image-validating-webhook/pkg/image"
+	whv1 "github.com/tmax-cloud/image-validating-webhook/pkg/type"
+	corev1 "k8s.io/api/core/v1"
+)
+
+// manifestDigestReason returns why the image is denied if its tag points to a manifest in the registry other than the signed digest,
+// i.e., the tag is pushed over after it's signed. The images referred by their digests are pulled by the digests, so they're not checked
+func (h *validator) manifestDigestReason(container *corev1.Container, ref *imageRef, basicAuth, signedDigest string, policy whv1.RegistrySpec) (string, error) {
+	if !policy.VerifyManifestDigest || ref.tag == "" || ref.digest != "" {
+		return "", nil
+	}
+
+	img, err := h.imageOptions().NewImage(ref.String(), basicAuth)
+	if err != nil {
+		return "", err
+	}
+	_, digest, err := img.GetManifest()
+	if err != nil {
+		return "", fmt.Errorf("couldn't get the manifest of %s by %s", container.Image, err)
+	}
+	if digest != signedDigest {
+		return fmt.Sprintf("Notary: Image '%s''s tag points to the manifest %s in the registry, which is not the signed digest %s", container.Image, digest, signedDigest), nil
+	}
+	return "", nil
+}
+
+// architecturesReason returns why the image is denied if the signed digest misses any of the required architectures of the policy,
+// so that it's not pinned to a digest which can't run on some of the nodes
+func (h *validator) architecturesReason(container *corev1.Container, ref *imageRef, basicAuth, signedDigest string, policy whv1.RegistrySpec) (string, error) {
+	if len(policy.RequiredArchitectures) == 0 {
+		return "", nil
+	}
+
+	signed := *ref
+	signed.tag, signed.digest = "", signedDigest
+	img, err := h.imageOptions().NewImage(signed.String(), basicAuth)
+	if err != nil {
+		return "", err
+	}
+	manifest, _, err := img.GetManifest()
+	if err != nil {
+		return "", fmt.Errorf("couldn't get the manifest of %s by %s", container.Image, err)
+	}
+	var platforms []image.Platform
+	if manifest.IsList() {
+		for _, m := range manifest.Manifests {
+			if m.Platform != nil {
+				platforms = append(platforms, *m.Platform)
+			}
+		}
+	} else {
+		cfg, _, err := img.GetConfig()
+		if err != nil {
+			return "", fmt.Errorf("couldn't get the config of %s by %s", container.Image, err)
+		}
+		platforms = append(platforms, image.Platform{Architecture: cfg.Architecture, OS: cfg.OS, Variant: cfg.Variant})
+	}
+
+	var missing []string
+	for _, arch := range policy.RequiredArchitectures {
+		if !hasArchitecture(platforms, arch) {
+			missing = append(missing, arch)
+		}
+	}
+	if len(missing) > 0 {
+		return fmt.Sprintf("Notary: Image '%s''s signed digest %s misses the required architectures %s", container.Image, signedDigest, strings.Join(missing, ", ")), nil
+	}
+	return "", nil
+}
+
+// hasArchitecture returns true if any of the platforms is of the architecture, which may have the variant (e.g., arm/v7)
+func hasArchitecture(platforms []image.Platform, arch string) bool {
+	arch, variant, withVariant := strings.Cut(arch, "/")
+	for _, p := range platforms {
+		if p.Architecture == arch && (!withVariant || p.Variant == variant) {
+			return true
+		}
+	}
+	return false
+}
+
+// pinTimeReason returns why the image is denied by the checks of the signed digest against the registry, before the image is pinned to it
+func (h *validator) pinTimeReason(container *corev1.Container, ref *imageRef, basicAuth, signedDigest string, policy whv1.RegistrySpec) (deniedReason, error) {
+	if reason, err := h.manifestDigestReason(container, ref, basicAuth, signedDigest, policy); err != nil || reason != "" {
+		return deniedReason{kind: denyReasonManifestMismatch, subject: container.Image, reason: reason}, err
+	}
+	reason, err := h.architecturesReason(container, ref, basicAuth, signedDigest, policy)
+	return deniedReason{kind: denyReasonMissingArchitectures, subject: container.Image, reason: reason}, err
+}
@@ -0,0 +1,173 @@
+package pods
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/tmax-cloud/image-validating-webhook/pkg/image"
+	whv1 "github.com/tmax-cloud/image-validating-webhook/pkg/type"
+)
+
+func TestValidator_CheckIsValidAndAddDigest_verifyManifestDigest(t *testing.T) {
+	manifest := []byte(`{"schemaVersion":2,"mediaType":"` + image.MediaTypeDockerManifest + `","config":{"digest":"sha256:` + strings.Repeat("2", 64) + `"}}`)
+	manifestDigest := fmt.Sprintf("sha256:%x", sha256.Sum256(manifest))
+	registrySrv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.URL.Path != "/v2/image/manifests/v1" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Type", image.MediaTypeDockerManifest)
+		_, _ = w.Write(manifest)
+	}))
+	defer registrySrv.Close()
+	registry := strings.TrimPrefix(registrySrv.URL, "https://")
+	img := registry + "/image:v1"
+	diverged := "sha256:" + strings.Repeat("1", 64)
+
+	tc := map[string]struct {
+		verifyManifestDigest bool
+		image                string
+		signedDigest         string
+
+		expectedValid  bool
+		expectedReason string
+		expectedErr    bool
+	}{
+		"matching": {
+			verifyManifestDigest: true,
+			image:                img,
+			signedDigest:         manifestDigest,
+			expectedValid:        true,
+		},
+		"diverged": {
+			verifyManifestDigest: true,
+			image:                img,
+			signedDigest:         diverged,
+			expectedReason:       fmt.Sprintf("Notary: Image '%s''s tag points to the manifest %s in the registry, which is not the signed digest %s", img, manifestDigest, diverged),
+		},
+		"divergedNotVerified": {
+			image:         img,
+			signedDigest:  diverged,
+			expectedValid: true,
+		},
+		"referredByDigest": {
+			verifyManifestDigest: true,
+			image:                img + "@" + diverged,
+			signedDigest:         diverged,
+			expectedValid:        true,
+		},
+		"manifestNotFound": {
+			verifyManifestDigest: true,
+			image:                registry + "/other:v1",
+			signedDigest:         diverged,
+			expectedErr:          true,
+		},
+	}
+
+	for caseName, c := range tc {
+		t.Run(caseName, func(t *testing.T) {
+			v := testCachedSignatureValidator(whv1.RegistrySpec{Registry: registry, Notary: "https://notary.test", SignCheck: true, VerifyManifestDigest: c.verifyManifestDigest}, c.image)
+			v.verifier = &stubVerifier{digest: c.signedDigest}
+
+			valid, reason, err := v.CheckIsValidAndAddDigest(generateTestPod(c.image, testCheckSign, ""))
+			if c.expectedErr {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, c.expectedValid, valid)
+			require.Equal(t, c.expectedReason, reason)
+		})
+	}
+}
+
+func TestValidator_CheckIsValidAndAddDigest_requiredArchitectures(t *testing.T) {
+	list := []byte(`{"schemaVersion":2,"mediaType":"` + image.MediaTypeDockerManifestList + `","manifests":[` +
+		`{"digest":"sha256:` + strings.Repeat("3", 64) + `","platform":{"architecture":"amd64","os":"linux"}},` +
+		`{"digest":"sha256:` + strings.Repeat("4", 64) + `","platform":{"architecture":"arm","os":"linux","variant":"v7"}}]}`)
+	listDigest := fmt.Sprintf("sha256:%x", sha256.Sum256(list))
+	configDigest := "sha256:" + strings.Repeat("2", 64)
+	single := []byte(`{"schemaVersion":2,"mediaType":"` + image.MediaTypeDockerManifest + `","config":{"digest":"` + configDigest + `"}}`)
+	singleDigest := fmt.Sprintf("sha256:%x", sha256.Sum256(single))
+	registrySrv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		switch req.URL.Path {
+		case "/v2/image/manifests/" + listDigest:
+			w.Header().Set("Content-Type", image.MediaTypeDockerManifestList)
+			_, _ = w.Write(list)
+		case "/v2/image/manifests/" + singleDigest:
+			w.Header().Set("Content-Type", image.MediaTypeDockerManifest)
+			_, _ = w.Write(single)
+		case "/v2/image/blobs/" + configDigest:
+			_, _ = w.Write([]byte(`{"architecture":"amd64","os":"linux"}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer registrySrv.Close()
+	registry := strings.TrimPrefix(registrySrv.URL, "https://")
+	img := registry + "/image:v1"
+
+	tc := map[string]struct {
+		requiredArchitectures []string
+		signedDigest          string
+
+		expectedValid  bool
+		expectedReason string
+		expectedErr    bool
+	}{
+		"completeList": {
+			requiredArchitectures: []string{"amd64", "arm/v7"},
+			signedDigest:          listDigest,
+			expectedValid:         true,
+		},
+		"incompleteList": {
+			requiredArchitectures: []string{"amd64", "arm64", "arm/v6"},
+			signedDigest:          listDigest,
+			expectedReason:        fmt.Sprintf("Notary: Image '%s''s signed digest %s misses the required architectures arm64, arm/v6", img, listDigest),
+		},
+		"singleManifest": {
+			requiredArchitectures: []string{"amd64"},
+			signedDigest:          singleDigest,
+			expectedValid:         true,
+		},
+		"singleManifestMissing": {
+			requiredArchitectures: []string{"amd64", "arm64"},
+			signedDigest:          singleDigest,
+			expectedReason:        fmt.Sprintf("Notary: Image '%s''s signed digest %s misses the required architectures arm64", img, singleDigest),
+		},
+		"notRequired": {
+			signedDigest:  "sha256:" + strings.Repeat("1", 64),
+			expectedValid: true,
+		},
+		"manifestNotFound": {
+			requiredArchitectures: []string{"amd64"},
+			signedDigest:          "sha256:" + strings.Repeat("1", 64),
+			expectedErr:           true,
+		},
+	}
+
+	for caseName, c := range tc {
+		t.Run(caseName, func(t *testing.T) {
+			v := testCachedSignatureValidator(whv1.RegistrySpec{Registry: registry, Notary: "https://notary.test", SignCheck: true, RequiredArchitectures: c.requiredArchitectures}, img)
+			v.verifier = &stubVerifier{digest: c.signedDigest}
+
+			pod := generateTestPod(img, testCheckSign, "")
+			valid, reason, err := v.CheckIsValidAndAddDigest(pod)
+			if c.expectedErr {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, c.expectedValid, valid)
+			require.Equal(t, c.expectedReason, reason)
+			if c.expectedValid {
+				require.Equal(t, img+"@"+c.signedDigest, pod.Spec.Containers[0].Image)
+			}
+		})
+	}
+}
@@ -0,0 +1,21 @@
+package pods
+
+import (
+	"net/http"
+
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/tmax-cloud/image-validating-webhook/pkg/server"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
+)
+
+const metricsPath = "/metrics"
+
+func init() {
+	// Add metrics handler initiator
+	server.AddHandlerInitiator(metricsPath, []string{http.MethodGet}, NewMetricsHandler)
+}
+
+// NewMetricsHandler initiates a handler serving the metrics (e.g., of the signature cache) in the prometheus format
+func NewMetricsHandler(_ *server.HandlerConfig) (http.Handler, error) {
+	return promhttp.HandlerFor(metrics.Registry, promhttp.HandlerOpts{}), nil
+}
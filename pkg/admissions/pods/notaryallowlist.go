@@ -0,0 +1,42 @@
+package pods
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+// notaryNotAllowedError tells the notary server is not in Options.AllowedNotaryServers
+type notaryNotAllowedError struct {
+	notaryURL string
+}
+
+func (e *notaryNotAllowedError) Error() string {
+	return fmt.Sprintf("notary server %s is not allowed by --allowed-notary-servers", e.notaryURL)
+}
+
+// isNotaryServerAllowed checks if the notary server is in the allowlist of the notary servers. All of them are allowed if the allowlist is empty
+func (h *validator) isNotaryServerAllowed(notaryURL string) bool {
+	if len(h.opts.AllowedNotaryServers) == 0 {
+		return true
+	}
+	normalized := normalizeNotaryURL(notaryURL)
+	for _, allowed := range h.opts.AllowedNotaryServers {
+		if normalizeNotaryURL(allowed) == normalized {
+			return true
+		}
+	}
+	return false
+}
+
+// normalizeNotaryURL lowercases the scheme and the host of the url and trims its trailing slash, so that the urls of the same server are equal
+func normalizeNotaryURL(notaryURL string) string {
+	u, err := url.Parse(notaryURL)
+	if err != nil {
+		return notaryURL
+	}
+	u.Scheme = strings.ToLower(u.Scheme)
+	u.Host = strings.ToLower(u.Host)
+	u.Path = strings.TrimSuffix(u.Path, "/")
+	return u.String()
+}
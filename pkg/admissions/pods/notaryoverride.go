@@ -0,0 +1,40 @@
+package pods
+
+import (
+	"encoding/json"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+// NotaryOverrideAnnotation is the pod annotation overriding the notary servers of its images, e.g., {"<image>": "<notary url>"}.
+// The images are the same form as in the pod's spec. It's honored only in the namespaces allowed by the options
+const NotaryOverrideAnnotation = "tmax.io/notary-override"
+
+// notaryOverrides returns the image-notary server map of the pod's annotation,
+// if the pod's namespace is allowed to override the notary servers
+func (h *validator) notaryOverrides(pod *corev1.Pod) map[string]string {
+	annotation, exist := pod.Annotations[NotaryOverrideAnnotation]
+	if !exist {
+		return nil
+	}
+	if !h.isNotaryOverrideAllowed(pod.Namespace) {
+		validatorLog.Info("ignoring notary override of the namespace which is not allowed", "namespace", pod.Namespace, "pod", pod.Name)
+		return nil
+	}
+
+	overrides := map[string]string{}
+	if err := json.Unmarshal([]byte(annotation), &overrides); err != nil {
+		validatorLog.Error(err, "ignoring malformed notary override", "namespace", pod.Namespace, "pod", pod.Name)
+		return nil
+	}
+	return overrides
+}
+
+func (h *validator) isNotaryOverrideAllowed(namespace string) bool {
+	for _, ns := range h.opts.NotaryOverrideNamespaces {
+		if ns == namespace {
+			return true
+		}
+	}
+	return false
+}
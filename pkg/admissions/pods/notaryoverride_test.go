@@ -0,0 +1,87 @@
+package pods
+
+import (
+	"fmt"
+	"net/url"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	notarytest "github.com/tmax-cloud/image-validating-webhook/pkg/notary/test"
+	whv1 "github.com/tmax-cloud/image-validating-webhook/pkg/type"
+	watcherfake "github.com/tmax-cloud/image-validating-webhook/pkg/watcher/fake"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+const testNotaryOverrideNamespace = "test-notary-override"
+
+func TestValidator_notaryImageValid_notaryOverride(t *testing.T) {
+	testSrv, err := notarytest.New(false)
+	require.NoError(t, err)
+	u, err := url.Parse(testSrv.URL)
+	require.NoError(t, err)
+
+	testDigest := "33333333333333333333333333333333"
+	_, err = testSrv.SignImage(testSrv.URL, u.Host, testImageSignCheck, testTag, testDigest)
+	require.NoError(t, err)
+	img := fmt.Sprintf("%s/%s:%s", u.Host, testImageSignCheck, testTag)
+
+	tc := map[string]struct {
+		namespace  string
+		annotation string
+
+		expectedValid    bool
+		expectedErrOccur bool
+	}{
+		"honored": {
+			namespace:     testNotaryOverrideNamespace,
+			annotation:    fmt.Sprintf(`{"%s":"%s"}`, img, testSrv.URL),
+			expectedValid: true,
+		},
+		"notAllowedNamespace": {
+			namespace:        "test-other",
+			annotation:       fmt.Sprintf(`{"%s":"%s"}`, img, testSrv.URL),
+			expectedErrOccur: true,
+		},
+		"otherImage": {
+			namespace:        testNotaryOverrideNamespace,
+			annotation:       fmt.Sprintf(`{"%s/other:%s":"%s"}`, u.Host, testTag, testSrv.URL),
+			expectedErrOccur: true,
+		},
+		"malformed": {
+			namespace:        testNotaryOverrideNamespace,
+			annotation:       testSrv.URL,
+			expectedErrOccur: true,
+		},
+	}
+
+	for name, c := range tc {
+		t.Run(name, func(t *testing.T) {
+			// The policy's notary server is unreachable
+			v := &validator{client: fake.NewSimpleClientset(), opts: Options{NotaryOverrideNamespaces: []string{testNotaryOverrideNamespace}}, whiteList: &WhiteList{}}
+			v.registryPolicyCache = &RegistryPolicyCache{namespaceCachedClient: &watcherfake.CachedClient{}, clusterCachedClient: &watcherfake.CachedClient{
+				Cache: map[string]runtime.Object{
+					"policy": &whv1.ClusterRegistrySecurityPolicy{
+						ObjectMeta: metav1.ObjectMeta{Name: "policy"},
+						Spec: whv1.ClusterRegistrySecurityPolicySpec{
+							Registries: []whv1.RegistrySpec{{Registry: u.Host, Notary: "https://127.0.0.1:1", SignCheck: true}},
+						},
+					},
+				},
+			}}
+
+			pod := generateTestPod(img, c.namespace, "")
+			pod.Annotations = map[string]string{NotaryOverrideAnnotation: c.annotation}
+
+			valid, _, err := v.notaryImageValid(pod)
+			if c.expectedErrOccur {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, c.expectedValid, valid)
+			require.Equal(t, fmt.Sprintf("%s@sha256:%x", img, testDigest), pod.Spec.Containers[0].Image)
+		})
+	}
+}
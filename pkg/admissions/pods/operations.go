@@ -0,0 +1,158 @@
+package pods
+
+import (
+	"encoding/json"
+	"fmt"
+
+	admissionv1beta1 "k8s.io/api/admission/v1beta1"
+	core "k8s.io/api/core/v1"
+)
+
+// ephemeralContainersSubResource is the subresource of the pods adding ephemeral containers (e.g., kubectl debug), which is an update of the pod
+const ephemeralContainersSubResource = "ephemeralcontainers"
+
+// handlesOperation checks if the requests of the operation are validated. Only CREATE is validated if no operation is set
+func (a *ImageAdmission) handlesOperation(op admissionv1beta1.Operation) bool {
+	if len(a.operations) == 0 {
+		return op == admissionv1beta1.Create
+	}
+	for _, o := range a.operations {
+		if o == string(op) {
+			return true
+		}
+	}
+	return false
+}
+
+// skipsReview checks if the request is allowed without validation, i.e., the operation is not validated or the subresource bears no image.
+// Only the ephemeral containers are validated among the subresources, if UPDATE is validated
+func (a *ImageAdmission) skipsReview(req *admissionv1beta1.AdmissionRequest) bool {
+	if !a.handlesOperation(req.Operation) {
+		return true
+	}
+	return req.SubResource != "" && req.SubResource != ephemeralContainersSubResource
+}
+
+// oldPodOf unmarshals the old pod of the UPDATE request. It returns nil for the other operations
+func oldPodOf(req *admissionv1beta1.AdmissionRequest) (*core.Pod, error) {
+	if req.Operation != admissionv1beta1.Update {
+		return nil, nil
+	}
+	oldPod := &core.Pod{}
+	if err := json.Unmarshal(req.OldObject.Raw, oldPod); err != nil {
+		return nil, fmt.Errorf("unmarshaling old object failed with %s", err)
+	}
+	return oldPod, nil
+}
+
+// checkIsValidForOperation validates the pod of the request by v, returning its image volumes to be pinned.
+// For UPDATE, only the changed containers are validated. The image volumes are not, as the volumes of a pod are immutable
+func (a *ImageAdmission) checkIsValidForOperation(v Validator, req *admissionv1beta1.AdmissionRequest, pod *core.Pod) (bool, string, []imageVolume, error) {
+	oldPod, err := oldPodOf(req)
+	if err != nil {
+		return false, "", nil, err
+	}
+	if oldPod != nil {
+		isValid, reason, err := checkIsValidChangedContainers(v, pod, oldPod)
+		return isValid, reason, nil, err
+	}
+
+	volumes, err := podImageVolumes(req.Object.Raw)
+	if err != nil {
+		return false, "", nil, fmt.Errorf("unmarshaling image volumes failed with %s", err)
+	}
+	isValid, reason, err := checkIsValidWithImageVolumes(v, pod, volumes)
+	return isValid, reason, volumes, err
+}
+
+// checkIsValidChangedContainers validates the images of the containers (including the init containers and the ephemeral containers)
+// added or changed from the old pod, pinning their digests. The unchanged ones are validated when they're created or changed,
+// so updating a pinned pod (e.g., its labels) isn't denied again by the signatures changed since then
+func checkIsValidChangedContainers(v Validator, pod, oldPod *core.Pod) (bool, string, error) {
+	oldImages := containerImages(oldPod)
+	changed := func(containers []core.Container) []core.Container {
+		var result []core.Container
+		for _, c := range containers {
+			if img, exist := oldImages[c.Name]; !exist || img != c.Image {
+				result = append(result, c)
+			}
+		}
+		return result
+	}
+
+	validating := pod.DeepCopy()
+	validating.Spec.InitContainers = changed(pod.Spec.InitContainers)
+	validating.Spec.Containers = changed(pod.Spec.Containers)
+	validating.Spec.EphemeralContainers = nil
+	for _, ec := range pod.Spec.EphemeralContainers {
+		validating.Spec.Containers = append(validating.Spec.Containers, changed([]core.Container{core.Container(ec.EphemeralContainerCommon)})...)
+	}
+	if len(validating.Spec.InitContainers) == 0 && len(validating.Spec.Containers) == 0 {
+		return true, "", nil
+	}
+
+	isValid, reason, err := v.CheckIsValidAndAddDigest(validating)
+	if err != nil || !isValid {
+		return isValid, reason, err
+	}
+
+	// Pin the validated images of the pod
+	pinned := containerImages(validating)
+	for _, containers := range [][]core.Container{pod.Spec.InitContainers, pod.Spec.Containers} {
+		for i := range containers {
+			if img, exist := pinned[containers[i].Name]; exist {
+				containers[i].Image = img
+			}
+		}
+	}
+	for i := range pod.Spec.EphemeralContainers {
+		if img, exist := pinned[pod.Spec.EphemeralContainers[i].Name]; exist {
+			pod.Spec.EphemeralContainers[i].Image = img
+		}
+	}
+
+	return true, "", mergeValidatedImagesAnnotation(pod, validating, oldPod)
+}
+
+// containerImages returns the images of all the containers of the pod, keyed by the container names, which are unique in a pod
+func containerImages(pod *core.Pod) map[string]string {
+	images := map[string]string{}
+	for _, containers := range [][]core.Container{pod.Spec.InitContainers, pod.Spec.Containers} {
+		for _, c := range containers {
+			images[c.Name] = c.Image
+		}
+	}
+	for _, ec := range pod.Spec.EphemeralContainers {
+		images[ec.Name] = ec.Image
+	}
+	return images
+}
+
+// mergeValidatedImagesAnnotation sets ValidatedImagesAnnotation of the pod to the images validated now, keeping the old pod's ones of the containers validated before
+func mergeValidatedImagesAnnotation(pod, validating, oldPod *core.Pod) error {
+	annotation, exist := validating.Annotations[ValidatedImagesAnnotation]
+	if !exist || annotation == pod.Annotations[ValidatedImagesAnnotation] {
+		return nil
+	}
+
+	validated := validatedImages{}
+	if old, exist := oldPod.Annotations[ValidatedImagesAnnotation]; exist {
+		oldValidated := &ValidatedImages{}
+		if err := json.Unmarshal([]byte(old), oldValidated); err == nil {
+			images := containerImages(pod)
+			for name, img := range oldValidated.Containers {
+				if _, exist := images[name]; exist {
+					validated[name] = img
+				}
+			}
+		}
+	}
+	newValidated := &ValidatedImages{}
+	if err := json.Unmarshal([]byte(annotation), newValidated); err != nil {
+		return err
+	}
+	for name, img := range newValidated.Containers {
+		validated[name] = img
+	}
+	return setValidatedImagesAnnotation(pod, validated)
+}
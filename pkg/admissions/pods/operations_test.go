@@ -0,0 +1,156 @@
+package pods
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	admissionv1beta1 "k8s.io/api/admission/v1beta1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+func TestImageAdmission_HandleAdmission_operations(t *testing.T) {
+	pinned := "test-not-signed:v1@" + testPinnedDigest
+	testPod := func(image string, ephemeralImages ...string) *corev1.Pod {
+		pod := &corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Name: "test", Namespace: "testns"},
+			Spec:       corev1.PodSpec{Containers: []corev1.Container{{Name: "test-cont", Image: image}}},
+		}
+		for _, img := range ephemeralImages {
+			pod.Spec.EphemeralContainers = append(pod.Spec.EphemeralContainers, corev1.EphemeralContainer{
+				EphemeralContainerCommon: corev1.EphemeralContainerCommon{Name: "debugger", Image: img},
+			})
+		}
+		return pod
+	}
+
+	tc := map[string]struct {
+		operations  []string
+		operation   admissionv1beta1.Operation
+		subResource string
+		pod         *corev1.Pod
+		oldPod      *corev1.Pod
+
+		expectedAllowed       bool
+		expectedResultMessage string
+		expectedPatch         []patchOperation
+	}{
+		"create": {
+			operation:       admissionv1beta1.Create,
+			pod:             testPod("test-signed:v1"),
+			expectedAllowed: true,
+			expectedPatch:   []patchOperation{{Op: "replace", Path: "/spec/containers", Value: []interface{}{map[string]interface{}{"name": "test-cont", "image": "test-signed:v1@" + testPinnedDigest, "resources": map[string]interface{}{}}}}},
+		},
+		"createNotSigned": {
+			operation:             admissionv1beta1.Create,
+			pod:                   testPod("test-not-signed:v1"),
+			expectedResultMessage: "Pod is not valid: \nimage 'test-not-signed:v1' is not signed",
+		},
+		"updateNotEnabled": {
+			operation:       admissionv1beta1.Update,
+			pod:             testPod("test-not-signed:v2"),
+			oldPod:          testPod(pinned),
+			expectedAllowed: true,
+		},
+		"updateUnchanged": {
+			operations:      []string{"CREATE", "UPDATE"},
+			operation:       admissionv1beta1.Update,
+			pod:             testPod(pinned),
+			oldPod:          testPod(pinned),
+			expectedAllowed: true,
+			expectedPatch:   []patchOperation{{Op: "replace", Path: "/spec/containers", Value: []interface{}{map[string]interface{}{"name": "test-cont", "image": pinned, "resources": map[string]interface{}{}}}}},
+		},
+		"updateChanged": {
+			operations:      []string{"UPDATE"},
+			operation:       admissionv1beta1.Update,
+			pod:             testPod("test-signed:v2"),
+			oldPod:          testPod(pinned),
+			expectedAllowed: true,
+			expectedPatch:   []patchOperation{{Op: "replace", Path: "/spec/containers", Value: []interface{}{map[string]interface{}{"name": "test-cont", "image": "test-signed:v2@" + testPinnedDigest, "resources": map[string]interface{}{}}}}},
+		},
+		"updateChangedNotSigned": {
+			operations:            []string{"UPDATE"},
+			operation:             admissionv1beta1.Update,
+			pod:                   testPod("test-not-signed:v2"),
+			oldPod:                testPod(pinned),
+			expectedResultMessage: "Pod is not valid: \nimage 'test-not-signed:v2' is not signed",
+		},
+		"ephemeralContainer": {
+			operations:      []string{"UPDATE"},
+			operation:       admissionv1beta1.Update,
+			subResource:     ephemeralContainersSubResource,
+			pod:             testPod(pinned, "test-signed-debugger:v1"),
+			oldPod:          testPod(pinned),
+			expectedAllowed: true,
+			expectedPatch: []patchOperation{
+				{Op: "replace", Path: "/spec/containers", Value: []interface{}{map[string]interface{}{"name": "test-cont", "image": pinned, "resources": map[string]interface{}{}}}},
+				{Op: "replace", Path: "/spec/ephemeralContainers", Value: []interface{}{map[string]interface{}{"name": "debugger", "image": "test-signed-debugger:v1@" + testPinnedDigest, "resources": map[string]interface{}{}}}},
+			},
+		},
+		"ephemeralContainerNotSigned": {
+			operations:            []string{"UPDATE"},
+			operation:             admissionv1beta1.Update,
+			subResource:           ephemeralContainersSubResource,
+			pod:                   testPod(pinned, "test-not-signed-debugger:v1"),
+			oldPod:                testPod(pinned),
+			expectedResultMessage: "Pod is not valid: \nimage 'test-not-signed-debugger:v1' is not signed",
+		},
+	}
+
+	for caseName, c := range tc {
+		t.Run(caseName, func(t *testing.T) {
+			im := &ImageAdmission{validator: &pinningValidator{}, operations: c.operations}
+
+			raw, err := json.Marshal(c.pod)
+			require.NoError(t, err)
+			review := &admissionv1beta1.AdmissionReview{
+				Request: &admissionv1beta1.AdmissionRequest{
+					Name:        c.pod.Name,
+					Namespace:   c.pod.Namespace,
+					Operation:   c.operation,
+					SubResource: c.subResource,
+					Object:      runtime.RawExtension{Raw: raw},
+				},
+			}
+			if c.oldPod != nil {
+				review.Request.OldObject.Raw, err = json.Marshal(c.oldPod)
+				require.NoError(t, err)
+			}
+
+			require.NoError(t, im.HandleAdmission(review))
+			require.Equal(t, c.expectedAllowed, review.Response.Allowed)
+			require.Equal(t, c.expectedResultMessage, review.Response.Result.Message)
+
+			var patch []patchOperation
+			if review.Response.Patch != nil {
+				require.NoError(t, json.Unmarshal(review.Response.Patch, &patch))
+			}
+			require.Equal(t, c.expectedPatch, patch)
+		})
+	}
+}
+
+func TestMergeValidatedImagesAnnotation(t *testing.T) {
+	oldPod := &corev1.Pod{}
+	require.NoError(t, setValidatedImagesAnnotation(oldPod, validatedImages{
+		"kept":    {Digest: "sha256:1", Signers: []string{"a"}},
+		"changed": {Digest: "sha256:2", Signers: []string{"a"}},
+		"removed": {Digest: "sha256:3", Signers: []string{"a"}},
+	}))
+	pod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Annotations: map[string]string{ValidatedImagesAnnotation: oldPod.Annotations[ValidatedImagesAnnotation]}},
+		Spec:       corev1.PodSpec{Containers: []corev1.Container{{Name: "kept"}, {Name: "changed"}}},
+	}
+	validating := pod.DeepCopy()
+	require.NoError(t, setValidatedImagesAnnotation(validating, validatedImages{"changed": {Digest: "sha256:4", Signers: []string{"b"}}}))
+
+	require.NoError(t, mergeValidatedImagesAnnotation(pod, validating, oldPod))
+	validated := &ValidatedImages{}
+	require.NoError(t, json.Unmarshal([]byte(pod.Annotations[ValidatedImagesAnnotation]), validated))
+	require.Equal(t, map[string]ValidatedImage{
+		"kept":    {Digest: "sha256:1", Signers: []string{"a"}},
+		"changed": {Digest: "sha256:4", Signers: []string{"b"}},
+	}, validated.Containers)
+}
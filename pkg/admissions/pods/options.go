@@ -0,0 +1,422 @@
+package pods
+
+import (
+	"flag"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	admissionv1beta1 "k8s.io/api/admission/v1beta1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	"k8s.io/apimachinery/pkg/labels"
+)
+
+// Error policies, deciding the response when an internal error occurs while validating
+const (
+	// ErrorPolicyDeny denies the pod explicitly
+	ErrorPolicyDeny = "Deny"
+	// ErrorPolicyAllow allows the pod explicitly, with a warning
+	ErrorPolicyAllow = "Allow"
+	// ErrorPolicyFailurePolicy responds with an error status, so that the webhook configuration's failurePolicy decides
+	ErrorPolicyFailurePolicy = "FailurePolicy"
+)
+
+// Default policies, deciding how the images of the registries matching no registry security policy are validated
+const (
+	// DefaultPolicyDeny denies the images
+	DefaultPolicyDeny = "Deny"
+	// DefaultPolicyAllow allows the images without checking their signatures
+	DefaultPolicyAllow = "Allow"
+	// DefaultPolicyRequireSignature allows the images signed by anyone, checking them by the default notary server
+	DefaultPolicyRequireSignature = "RequireSignature"
+)
+
+// Pull-never policies, deciding how the images of the containers whose imagePullPolicy is Never are validated in the policies checking signatures.
+// Such images are not pulled, but run from the nodes' local content
+const (
+	// PullNeverPolicyValidate validates the images by their signatures as the others. The pinned digest should be on the node
+	PullNeverPolicyValidate = "Validate"
+	// PullNeverPolicyAllow allows the images without checking their signatures, trusting the nodes' local content
+	PullNeverPolicyAllow = "Allow"
+	// PullNeverPolicyDeny denies the images
+	PullNeverPolicyDeny = "Deny"
+)
+
+// Policy precedences, deciding which of the cluster and the namespace registry security policies are evaluated first
+// if a registry matches both equally specifically
+const (
+	// PolicyPrecedenceClusterFirst evaluates the cluster policies first, so that the namespaces can only tighten them
+	PolicyPrecedenceClusterFirst = "ClusterFirst"
+	// PolicyPrecedenceNamespaceFirst evaluates the namespace policies first, so that the namespaces can override the cluster policies
+	PolicyPrecedenceNamespaceFirst = "NamespaceFirst"
+)
+
+// Empty signer policies, deciding how the images are validated by the signatures if the registry's policy has no signer
+const (
+	// EmptySignerPolicyAllowIfSigned allows the images released by any signer (i.e., signed into targets or targets/releases)
+	EmptySignerPolicyAllowIfSigned = "AllowIfSigned"
+	// EmptySignerPolicyDeny denies the images, so that the signers must be configured explicitly
+	EmptySignerPolicyDeny = "Deny"
+)
+
+// Malformed pull secret policies, deciding how the pull secrets which couldn't be decoded are handled
+const (
+	// MalformedPullSecretPolicySkip skips them with a warning, trying the other secrets and credential sources.
+	// The validation fails only if none of them has a credential
+	MalformedPullSecretPolicySkip = "Skip"
+	// MalformedPullSecretPolicyFail fails the validation
+	MalformedPullSecretPolicyFail = "Fail"
+)
+
+// Pin formats, deciding the reference format of the images pinned to their digests
+const (
+	// PinFormatTagDigest keeps the human-readable tag with the digest (e.g., repo/app:v1@sha256:<digest>)
+	PinFormatTagDigest = "TagDigest"
+	// PinFormatDigest drops the tag (e.g., repo/app@sha256:<digest>)
+	PinFormatDigest = "Digest"
+)
+
+// Options are the configurable options of the pods admission handler
+type Options struct {
+	// DisableDefaultNotary makes the registries without notary server fail, instead of falling back to docker hub's notary server
+	DisableDefaultNotary bool
+	// NotarySocket is a unix domain socket of the local notary proxy. If it's set, all notary servers are requested through it
+	NotarySocket string
+	// AllowedNotaryServers are the notary servers (e.g., https://notary.example.com) the images may be checked by. All notary servers are allowed if it's empty
+	AllowedNotaryServers []string
+	// NotaryHostAliases are the IPs the notary hosts are resolved to (e.g., notary.internal to 10.0.0.10), for the hosts which don't resolve by DNS
+	NotaryHostAliases map[string]string
+	// NotaryOverrideNamespaces are the namespaces whose pods may override the notary servers of their images by NotaryOverrideAnnotation
+	NotaryOverrideNamespaces []string
+
+	// ImageRewrites are the prefixes of the image references rewritten to the others (e.g., docker.io/ to mirror.internal/) before validation.
+	// The images are validated and pinned by the rewritten references, so that the trust data is fetched from the mirror's notary server
+	ImageRewrites map[string]string
+
+	// InsecureRegistries are the registries served over plain http (e.g., registry.local:5000), whose images are requested by http
+	InsecureRegistries []string
+
+	// DockerConfigFile is a docker config file (e.g., a mounted ~/.docker/config.json), whose auths are used for the registries
+	// the pull secrets of the pods have no credential for
+	DockerConfigFile string
+
+	// CredentialSources are the sources of the registry credentials, looked up in order (see CredentialSourcePodPullSecrets and the others).
+	// The registries none of them has a credential for are requested anonymously. Empty means defaultCredentialSources
+	CredentialSources []string
+	// MalformedPullSecretPolicy decides how the pull secrets which couldn't be decoded are handled. One of Skip, Fail. Empty means Skip
+	MalformedPullSecretPolicy string
+
+	// TokenExchangeEndpoints are the token endpoints of the registry hosts, where the tokens of the pods' service accounts
+	// are exchanged for the registry tokens if the pods have no pull secret for them
+	TokenExchangeEndpoints map[string]string
+	// TokenExchangeAudience is the audience of the service account tokens to be exchanged. The registry host is used if it's empty
+	TokenExchangeAudience string
+	// TokenExchangeUsername is the username of the exchanged registry tokens
+	TokenExchangeUsername string
+
+	// CacheWarmImages are the frequently deployed images, whose signatures are fetched periodically to the cache
+	CacheWarmImages []string
+	// CacheWarmInterval is the interval of fetching the signatures of CacheWarmImages
+	CacheWarmInterval time.Duration
+	// SignatureCacheTTL is how long the signatures fetched anonymously for the pods are kept in the signature cache,
+	// which is shared with the cache warm-up. 0 caches only the signatures of CacheWarmImages
+	SignatureCacheTTL time.Duration
+
+	// NotaryRepoTTL is how long a notary repository (with its token and TUF cache) is reused across the requests of the same image repository.
+	// 0 creates a new one for each request
+	NotaryRepoTTL time.Duration
+
+	// NotaryCacheMaxSize is the maximum total size in bytes of the TUF caches of the notary repositories.
+	// The least recently used caches are evicted if it's exceeded. 0 means no limit
+	NotaryCacheMaxSize int64
+	// MaxTrustMetadataSize is the maximum size in bytes of a response of the notary servers (e.g., the TUF metadata).
+	// The larger ones fail the validation, not to exhaust the memory by a malicious server. 0 means no limit
+	MaxTrustMetadataSize int64
+
+	// ValidatedDigestTTL is how long the digests validated by their signatures are trusted without the notary round-trip
+	ValidatedDigestTTL time.Duration
+	// DeniedImageTTL is how long the images denied by their signatures are denied again without the notary round-trip. It's at most maxDeniedImageTTL
+	DeniedImageTTL time.Duration
+	// SignatureRotationWindow is how long the prior digest of a re-signed tag is allowed, after it's last seen signed.
+	// 0 allows only the current digest
+	SignatureRotationWindow time.Duration
+
+	// PodSelector selects the pods to validate. The other pods are allowed without validation. nil selects everything
+	PodSelector labels.Selector
+
+	// MaxContainers is the maximum number of containers (including init containers and image volumes) of a pod to validate.
+	// Pods with more containers are denied, before requesting the notary servers for each of them. 0 means no limit
+	MaxContainers int
+
+	// DefaultPolicy decides how the images of the registries matching no registry security policy are validated.
+	// One of Deny, Allow, RequireSignature. Empty means Deny
+	DefaultPolicy string
+
+	// PolicyPrecedence decides which of the cluster and the namespace policies are evaluated first. One of ClusterFirst, NamespaceFirst. Empty means ClusterFirst
+	PolicyPrecedence string
+
+	// ErrorPolicy decides the response when an internal error occurs while validating. One of Deny, Allow, FailurePolicy
+	ErrorPolicy string
+
+	// PullNeverPolicy decides how the images of the containers whose imagePullPolicy is Never are validated.
+	// One of Validate, Allow, Deny. Empty means Validate
+	PullNeverPolicy string
+
+	// EmptySignerPolicy decides how the images are validated by the signatures if the registry's policy has no signer.
+	// One of AllowIfSigned, Deny. Empty means AllowIfSigned
+	EmptySignerPolicy string
+
+	// PinFormat decides the reference format of the images pinned to their digests. One of TagDigest, Digest. Empty means TagDigest
+	PinFormat string
+
+	// Operations are the operations of the pod requests validated. CREATE and UPDATE are supported. Empty means CREATE only.
+	// For UPDATE, only the containers whose images are changed (including the ephemeral containers added) are validated
+	Operations []string
+
+	// ValidationLatencyAnnotations enables ValidationLatencyAnnotation and ValidationCacheHitAnnotation of the allowed pods
+	ValidationLatencyAnnotations bool
+
+	// DenyMessageVerbosity decides what the messages of the denied pods tell. One of Reason, Hint. Empty means Reason
+	DenyMessageVerbosity string
+
+	// DeniedEvents enables the events of the denied pods in their namespaces, whose reason is ImageValidationDeniedReason
+	DeniedEvents bool
+	// DeniedEventRate is the maximum number of the denied events emitted per second
+	DeniedEventRate float32
+
+	// PermissiveEnforcement honors EnforcePermissive of the namespaces, which relaxes the cluster policies. It's ignored by default
+	PermissiveEnforcement bool
+
+	// BreakGlass enables BreakGlassAnnotation, allowing the pods of the authorized users without validation in emergencies
+	BreakGlass bool
+
+	// RecentDecisions is how many of the last decisions are kept in memory to be served by the debug endpoint. 0 keeps none
+	RecentDecisions int
+
+	// DebugToken is a bearer token of the debug endpoints. If it's empty, the debug endpoints are served only to the localhost
+	DebugToken string
+}
+
+var options Options
+
+// BindFlags binds the pods admission handler's options to the flag set
+func BindFlags(fs *flag.FlagSet) {
+	fs.BoolVar(&options.DisableDefaultNotary, "disable-default-notary", false, "Deny the images of the registries without notary server, instead of checking them from docker hub's notary server")
+	fs.StringVar(&options.NotarySocket, "notary-socket", "", "Unix domain socket of the local notary proxy. If it's set, all notary servers are requested through the socket")
+	fs.Func("allowed-notary-servers", "Comma-separated notary servers (e.g., https://notary.example.com) the images may be checked by. Images of the other notary servers (by the RegistrySecurityPolicies or the overrides) are denied. All are allowed if it's not set", func(s string) error {
+		options.AllowedNotaryServers = splitList(s)
+		return nil
+	})
+	fs.Func("notary-host-aliases", "Comma-separated IPs of the notary hosts, in the form of <host>=<ip> (e.g., notary.internal=10.0.0.10), as /etc/hosts entries. The notary servers are dialed by the IPs of their hosts, which don't need to resolve by DNS", func(s string) error {
+		aliases := map[string]string{}
+		for _, alias := range splitList(s) {
+			kv := strings.SplitN(alias, "=", 2)
+			if len(kv) != 2 || kv[0] == "" || net.ParseIP(kv[1]) == nil {
+				return fmt.Errorf("notary host alias %s is not in the form of <host>=<ip>", alias)
+			}
+			aliases[kv[0]] = kv[1]
+		}
+		options.NotaryHostAliases = aliases
+		return nil
+	})
+	fs.Func("notary-override-namespaces", "Comma-separated namespaces whose pods may override the notary servers of their images by the "+NotaryOverrideAnnotation+" annotation", func(s string) error {
+		options.NotaryOverrideNamespaces = splitList(s)
+		return nil
+	})
+	fs.Func("image-rewrites", "Comma-separated prefix rewrites of the images before validation, in the form of <prefix>=<replacement> (e.g., docker.io/=mirror.internal/). The longest matching prefix is rewritten", func(s string) error {
+		rewrites := map[string]string{}
+		for _, rule := range splitList(s) {
+			kv := strings.SplitN(rule, "=", 2)
+			if len(kv) != 2 || kv[0] == "" || kv[1] == "" {
+				return fmt.Errorf("image rewrite %s is not in the form of <prefix>=<replacement>", rule)
+			}
+			rewrites[kv[0]] = kv[1]
+		}
+		options.ImageRewrites = rewrites
+		return nil
+	})
+	fs.Func("insecure-registries", "Comma-separated registries served over plain http, with their ports if they're not the default ones (e.g., registry.local:5000). The others are requested by https", func(s string) error {
+		options.InsecureRegistries = splitList(s)
+		return nil
+	})
+	fs.StringVar(&options.DockerConfigFile, "docker-config", "", "Docker config file (e.g., /root/.docker/config.json) whose auths are used for the registries the pull secrets of the pods have no credential for. credHelpers are not supported")
+	fs.Func("credential-sources", "Comma-separated sources of the registry credentials, looked up in order: "+strings.Join(defaultCredentialSources, ",")+"(default). The registries none of them has a credential for are requested anonymously", func(s string) error {
+		sources := splitList(s)
+		for _, source := range sources {
+			if !isCredentialSource(source) {
+				return fmt.Errorf("unknown credential source %s", source)
+			}
+		}
+		options.CredentialSources = sources
+		return nil
+	})
+	fs.Func("malformed-pull-secret-policy", "How the pull secrets which couldn't be decoded are handled: Skip(default, skipped with a warning unless no other credential is found) or Fail", func(s string) error {
+		switch s {
+		case MalformedPullSecretPolicySkip, MalformedPullSecretPolicyFail:
+			options.MalformedPullSecretPolicy = s
+			return nil
+		}
+		return fmt.Errorf("unknown malformed pull secret policy %s", s)
+	})
+	fs.Func("token-exchange-endpoints", "Comma-separated token endpoints of the registries, in the form of <registry>=<token endpoint url>. The tokens of the pods' service accounts are exchanged there for the registry tokens (RFC 8693), if the pods have no pull secret for the registries", func(s string) error {
+		endpoints := map[string]string{}
+		for _, e := range splitList(s) {
+			kv := strings.SplitN(e, "=", 2)
+			if len(kv) != 2 || kv[0] == "" || kv[1] == "" {
+				return fmt.Errorf("token exchange endpoint %s is not in the form of <registry>=<token endpoint url>", e)
+			}
+			endpoints[kv[0]] = kv[1]
+		}
+		options.TokenExchangeEndpoints = endpoints
+		return nil
+	})
+	fs.StringVar(&options.TokenExchangeAudience, "token-exchange-audience", "", "Audience of the service account tokens exchanged for the registry tokens. The registry host is used by default")
+	fs.StringVar(&options.TokenExchangeUsername, "token-exchange-username", "<token>", "Username of the registry tokens exchanged from the service account tokens")
+	fs.Func("cache-warm-images", "Comma-separated images whose signatures are fetched periodically to the cache. They should be in the same form as in the pods' spec", func(s string) error {
+		options.CacheWarmImages = splitList(s)
+		return nil
+	})
+	fs.DurationVar(&options.CacheWarmInterval, "cache-warm-interval", 5*time.Minute, "Interval of fetching the signatures of the cache-warm-images")
+	fs.DurationVar(&options.SignatureCacheTTL, "signature-cache-ttl", 0, "How long the signatures fetched without credentials for the pods are kept in the signature cache shared with the cache warm-up. 0 caches only the signatures of the cache-warm-images")
+	fs.Func("error-policy", "Response when an internal error occurs while validating: Deny(default), Allow or FailurePolicy(defer to the webhook configuration's failurePolicy)", func(s string) error {
+		switch s {
+		case ErrorPolicyDeny, ErrorPolicyAllow, ErrorPolicyFailurePolicy:
+			options.ErrorPolicy = s
+			return nil
+		}
+		return fmt.Errorf("unknown error policy %s", s)
+	})
+	fs.Func("default-policy", "How the images of the registries matching no registry security policy are validated: Deny(default), Allow or RequireSignature(signed by anyone)", func(s string) error {
+		switch s {
+		case DefaultPolicyDeny, DefaultPolicyAllow, DefaultPolicyRequireSignature:
+			options.DefaultPolicy = s
+			return nil
+		}
+		return fmt.Errorf("unknown default policy %s", s)
+	})
+	fs.Func("policy-precedence", "Which of the cluster and the namespace registry security policies are evaluated first, if a registry matches both: ClusterFirst(default), whose matching policy the namespace policies can only tighten, or NamespaceFirst", func(s string) error {
+		switch s {
+		case PolicyPrecedenceClusterFirst, PolicyPrecedenceNamespaceFirst:
+			options.PolicyPrecedence = s
+			return nil
+		}
+		return fmt.Errorf("unknown policy precedence %s", s)
+	})
+	fs.Func("pull-never-policy", "How the images of the containers whose imagePullPolicy is Never are validated, if their registries' policies check signatures: Validate(default, by their signatures), Allow(trusting the nodes' local content) or Deny", func(s string) error {
+		switch s {
+		case PullNeverPolicyValidate, PullNeverPolicyAllow, PullNeverPolicyDeny:
+			options.PullNeverPolicy = s
+			return nil
+		}
+		return fmt.Errorf("unknown pull-never policy %s", s)
+	})
+	fs.Func("empty-signer-policy", "How the images are validated by the signatures if the registry's policy has no signer: AllowIfSigned(default, released by any signer) or Deny", func(s string) error {
+		switch s {
+		case EmptySignerPolicyAllowIfSigned, EmptySignerPolicyDeny:
+			options.EmptySignerPolicy = s
+			return nil
+		}
+		return fmt.Errorf("unknown empty signer policy %s", s)
+	})
+	fs.Func("deny-message-verbosity", "What the messages of the denied pods tell: Reason(default, why the images are denied) or Hint(with the hints to fix the common failures)", func(s string) error {
+		switch s {
+		case DenyMessageVerbosityReason, DenyMessageVerbosityHint:
+			options.DenyMessageVerbosity = s
+			return nil
+		}
+		return fmt.Errorf("unknown deny message verbosity %s", s)
+	})
+	fs.Func("pin-format", "Reference format of the images pinned to their digests: TagDigest(default, e.g., repo/app:v1@sha256:<digest>) or Digest(e.g., repo/app@sha256:<digest>)", func(s string) error {
+		switch s {
+		case PinFormatTagDigest, PinFormatDigest:
+			options.PinFormat = s
+			return nil
+		}
+		return fmt.Errorf("unknown pin format %s", s)
+	})
+	fs.Func("operations", "Comma-separated operations of the pod requests to validate: CREATE(default) and UPDATE. For UPDATE, only the changed images are validated. The webhook configuration's rules should include them", func(s string) error {
+		ops := splitList(s)
+		for _, op := range ops {
+			switch op {
+			case string(admissionv1beta1.Create), string(admissionv1beta1.Update):
+			default:
+				return fmt.Errorf("unsupported operation %s", op)
+			}
+		}
+		options.Operations = ops
+		return nil
+	})
+	fs.Func("pod-selector", "Label selector of the pods to validate (e.g., app!=debug). The other pods are allowed without validation. Selects everything by default", func(s string) error {
+		selector, err := labels.Parse(s)
+		if err != nil {
+			return err
+		}
+		options.PodSelector = selector
+		return nil
+	})
+	fs.IntVar(&options.MaxContainers, "max-containers", 100, "Maximum number of containers (including init containers and image volumes) of a pod to validate. Pods with more containers are denied. 0 means no limit")
+	fs.DurationVar(&options.NotaryRepoTTL, "notary-repo-ttl", time.Minute, "How long a notary repository (with its token and TUF cache) is reused across the requests of the same image repository. 0 creates a new one for each request")
+	options.NotaryCacheMaxSize = 256 * 1024 * 1024
+	fs.Func("notary-cache-max-size", "Maximum total size of the TUF caches of the notary repositories (e.g., 256Mi, default). The least recently used caches are evicted if it's exceeded. 0 means no limit", func(s string) error {
+		q, err := resource.ParseQuantity(s)
+		if err != nil {
+			return err
+		}
+		options.NotaryCacheMaxSize = q.Value()
+		return nil
+	})
+	options.MaxTrustMetadataSize = 16 * 1024 * 1024
+	fs.Func("max-trust-metadata-size", "Maximum size of a response of the notary servers, e.g., the TUF metadata (e.g., 16Mi, default). The larger ones fail the validation. 0 means no limit", func(s string) error {
+		q, err := resource.ParseQuantity(s)
+		if err != nil {
+			return err
+		}
+		options.MaxTrustMetadataSize = q.Value()
+		return nil
+	})
+	fs.DurationVar(&options.ValidatedDigestTTL, "validated-digest-ttl", 10*time.Minute, "How long the validated digests are trusted without checking their signatures again. 0 disables it")
+	options.DeniedImageTTL = 5 * time.Second
+	fs.Func("denied-image-ttl", fmt.Sprintf("How long the images denied by their signatures are denied again without checking their signatures, up to %s (default 5s). 0 disables it", maxDeniedImageTTL), func(s string) error {
+		ttl, err := time.ParseDuration(s)
+		if err != nil {
+			return err
+		}
+		if ttl > maxDeniedImageTTL {
+			return fmt.Errorf("denied image ttl %s is longer than %s", ttl, maxDeniedImageTTL)
+		}
+		options.DeniedImageTTL = ttl
+		return nil
+	})
+	fs.DurationVar(&options.SignatureRotationWindow, "signature-rotation-window", 0, "How long the images pinned to the prior digest of a re-signed tag are allowed, after the digest is last seen signed. 0(default) allows only the current digest")
+	fs.BoolVar(&options.ValidationLatencyAnnotations, "enable-validation-latency-annotations", false, "Annotate the allowed pods with the total time their images are validated in ("+ValidationLatencyAnnotation+") and if any of them is validated from the caches ("+ValidationCacheHitAnnotation+")")
+	fs.BoolVar(&options.DeniedEvents, "enable-denied-events", false, "Emit a "+ImageValidationDeniedReason+" event in the namespace of each pod denied by its images, so that kubectl get events tells why the pod is not created")
+	options.DeniedEventRate = 1
+	fs.Func("denied-event-rate", "Maximum number of the denied events emitted per second(default 1), after a burst of 10. The others are dropped, not to flood the events when a mass deploy fails", func(s string) error {
+		rate, err := strconv.ParseFloat(s, 32)
+		if err != nil {
+			return err
+		}
+		if rate <= 0 {
+			return fmt.Errorf("denied event rate %s is not positive", s)
+		}
+		options.DeniedEventRate = float32(rate)
+		return nil
+	})
+	fs.BoolVar(&options.PermissiveEnforcement, "enable-permissive-enforcement", false, "Honor the "+EnforceAnnotation+"="+EnforcePermissive+" annotation of the namespaces, which allows their images which are not signed even if the cluster policies require signatures")
+	fs.BoolVar(&options.BreakGlass, "enable-break-glass", false, "Allow the pods annotated with "+BreakGlassAnnotation+"=<ticket id> without validation, if the requesting users are authorized to the "+breakGlassVerb+" verb of "+breakGlassResource+"."+breakGlassGroup+" in the pods' namespaces. They're audit-logged")
+	fs.IntVar(&options.RecentDecisions, "recent-decisions", 100, "How many of the last admission decisions are kept in memory, to be served at "+recentDecisionsPath+". 0 keeps none")
+	fs.StringVar(&options.DebugToken, "debug-token", "", "Bearer token of the "+debugStatePath+" and "+recentDecisionsPath+" endpoints. If it's empty, the endpoints are served only to the localhost")
+}
+
+// splitList splits the comma-separated list, omitting empty entries
+func splitList(s string) []string {
+	var list []string
+	for _, e := range strings.Split(s, ",") {
+		if e = strings.TrimSpace(e); e != "" {
+			list = append(list, e)
+		}
+	}
+	return list
+}
@@ -1,14 +1,21 @@
 package pods
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io/ioutil"
+	"mime"
+	"net"
 	"net/http"
+	"time"
 
 	logf "sigs.k8s.io/controller-runtime/pkg/log"
 
+	"github.com/theupdateframework/notary/storage"
 	"github.com/tmax-cloud/image-validating-webhook/pkg/server"
+	"github.com/tmax-cloud/image-validating-webhook/pkg/trust"
 	"k8s.io/client-go/kubernetes/scheme"
 
 	admissionv1beta1 "k8s.io/api/admission/v1beta1"
@@ -18,6 +25,9 @@ import (
 
 const (
 	registryNamespace = "registry-system"
+
+	// notaryCacheCleanInterval is the interval of bounding the TUF caches to Options.NotaryCacheMaxSize
+	notaryCacheCleanInterval = time.Minute
 )
 
 var (
@@ -27,29 +37,89 @@ var (
 func init() {
 	// Add validating-mutating-admission handler initiator
 	server.AddHandlerInitiator("/validate", []string{http.MethodPost}, NewPodsAdmissionHandler)
+	// Add validation service initiator, served if the server has a gRPC address
+	server.AddGRPCServiceInitiator(RegisterValidationService)
 }
 
 // ImageAdmission is ...
 type ImageAdmission struct {
-	validator Validator
+	validator   Validator
+	errorPolicy string
+	// breakGlass authorizes the users breaking glass by BreakGlassAnnotation. nil if break-glass is disabled
+	breakGlass *breakGlassAuthorizer
+	// operations are the operations of the requests validated. Only CREATE is validated if empty
+	operations []string
+	// deniedEvents emits the events of the denied pods. nil if it's disabled
+	deniedEvents *deniedEventRecorder
+	// denyMessageVerbosity decides if the deny messages have the remediation hints
+	denyMessageVerbosity string
+}
+
+// sharedValidatorKey is the key of the validator shared by the handlers of a handler config
+type sharedValidatorKey struct{}
+
+// getSharedValidator returns the validator shared by the handlers of the handler config. It's built once with the clients of the config,
+// not to load the config and watch the policies for each handler
+func getSharedValidator(cfg *server.HandlerConfig) (*validator, error) {
+	v, err := cfg.Shared(sharedValidatorKey{}, func() (interface{}, error) {
+		return newValidator(cfg.RestCfg, cfg.ClientSet, cfg.RestClient)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return v.(*validator), nil
 }
 
 // NewPodsAdmissionHandler initiates a new image validation admission handler
 func NewPodsAdmissionHandler(cfg *server.HandlerConfig) (http.Handler, error) {
-	v, err := newValidator(cfg.RestCfg, cfg.ClientSet, cfg.RestClient)
+	v, err := getSharedValidator(cfg)
 	if err != nil {
 		return nil, err
 	}
 
-	return &ImageAdmission{validator: v}, nil
+	// Warm up the signature cache of the frequently deployed images
+	if len(v.opts.CacheWarmImages) > 0 {
+		go newSignatureCacheWarmer(v, v.opts.CacheWarmImages, v.opts.CacheWarmInterval).Start(cfg.StopCh)
+	}
+
+	// Bound the TUF caches, not to fill the ephemeral storage
+	if v.opts.NotaryCacheMaxSize > 0 {
+		go trust.NewCacheCleaner(trust.DefaultCachePath, v.opts.NotaryCacheMaxSize, v.repoPool).Start(notaryCacheCleanInterval, cfg.StopCh)
+	}
+
+	a := &ImageAdmission{validator: v, errorPolicy: v.opts.ErrorPolicy, operations: v.opts.Operations, denyMessageVerbosity: v.opts.DenyMessageVerbosity}
+	if v.opts.BreakGlass {
+		a.breakGlass = &breakGlassAuthorizer{client: v.client}
+	}
+	if v.opts.DeniedEvents {
+		a.deniedEvents = newDeniedEventRecorder(v.client, v.opts.DeniedEventRate, cfg.StopCh)
+	}
+	return a, nil
 }
 
 func (a *ImageAdmission) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	// Only the admission requests are processed, not the others (e.g., health checkers, scanners)
+	if req.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		writeErrorResponse(w, http.StatusMethodNotAllowed, fmt.Sprintf("Method %s is not allowed", req.Method))
+		return
+	}
+	if !isJSONContentType(req) {
+		writeErrorResponse(w, http.StatusUnsupportedMediaType, fmt.Sprintf("Content type %s is not supported. It should be application/json", req.Header.Get("Content-Type")))
+		return
+	}
+
 	body, err := ioutil.ReadAll(req.Body)
 	if err != nil {
 		errMsg := fmt.Sprintf("Couldn't read request by %s", err)
 		plog.Error(err, errMsg)
-		http.Error(w, errMsg, http.StatusBadRequest)
+		writeErrorResponse(w, http.StatusBadRequest, errMsg)
+		return
+	}
+	if len(body) == 0 {
+		errMsg := "Request body is empty"
+		plog.Error(errors.New(errMsg), errMsg)
+		writeErrorResponse(w, http.StatusBadRequest, errMsg)
 		return
 	}
 
@@ -59,10 +129,13 @@ func (a *ImageAdmission) ServeHTTP(w http.ResponseWriter, req *http.Request) {
 	if _, _, err = scheme.Codecs.UniversalDeserializer().Decode(body, nil, review); err != nil {
 		errMsg := fmt.Sprintf("Couldn't decode request by %s", err)
 		plog.Error(err, errMsg)
-		setReviewResponseNotAllowed(review, errMsg)
-		if err := writeReviewResponse(review, w); err != nil {
-			plog.Error(err, "")
-		}
+		writeErrorResponse(w, http.StatusBadRequest, errMsg)
+		return
+	}
+	if review.Request == nil {
+		errMsg := "AdmissionReview has no request"
+		plog.Error(errors.New(errMsg), errMsg)
+		writeErrorResponse(w, http.StatusBadRequest, errMsg)
 		return
 	}
 
@@ -70,7 +143,16 @@ func (a *ImageAdmission) ServeHTTP(w http.ResponseWriter, req *http.Request) {
 	if err := a.HandleAdmission(review); err != nil {
 		errMsg := fmt.Sprintf("Couldn't handle admission request by %s", err)
 		plog.Error(err, errMsg)
-		setReviewResponseNotAllowed(review, errMsg)
+		switch a.errorPolicy {
+		case ErrorPolicyFailurePolicy:
+			// The webhook configuration's failurePolicy decides
+			writeErrorResponse(w, http.StatusInternalServerError, errMsg)
+			return
+		case ErrorPolicyAllow:
+			setReviewResponseAllowedOnError(review, errMsg)
+		default:
+			setReviewResponseNotAllowedOnError(review, errMsg, err)
+		}
 		if err := writeReviewResponse(review, w); err != nil {
 			plog.Error(err, "")
 		}
@@ -83,8 +165,31 @@ func (a *ImageAdmission) ServeHTTP(w http.ResponseWriter, req *http.Request) {
 	}
 }
 
+// isJSONContentType checks if the content type of the request is application/json, which the API server sends.
+// The request without the content type is let through (logging it), in case the API server omits it
+func isJSONContentType(req *http.Request) bool {
+	contentType := req.Header.Get("Content-Type")
+	if contentType == "" {
+		plog.Info("Request has no content type, decoding it as application/json")
+		return true
+	}
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	return err == nil && mediaType == "application/json"
+}
+
 // HandleAdmission is ...
 func (a *ImageAdmission) HandleAdmission(review *admissionv1beta1.AdmissionReview) error {
+	// Only the configured operations are validated. Subresources (e.g., pods/exec, pods/status) don't bear images, except the ephemeral containers.
+	// They're allowed, not to break them
+	if a.skipsReview(review.Request) {
+		plog.Info("Skipping review of pod", "operation", review.Request.Operation, "subResource", review.Request.SubResource, "name", review.Request.Name, "namespace", review.Request.Namespace)
+		review.Response = &admissionv1beta1.AdmissionResponse{
+			Allowed: true,
+			Result:  &metav1.Status{},
+		}
+		return nil
+	}
+
 	pod := &core.Pod{}
 	if err := json.Unmarshal(review.Request.Object.Raw, pod); err != nil {
 		errMsg := fmt.Sprintf("unmarshaling request failed with %s", err)
@@ -94,11 +199,20 @@ func (a *ImageAdmission) HandleAdmission(review *admissionv1beta1.AdmissionRevie
 	}
 	pod.Namespace = review.Request.Namespace
 
+	// Emergency escape hatch, bypassing the validation
+	if handled, err := a.handleBreakGlass(review, pod.Annotations); handled || err != nil {
+		if err != nil {
+			plog.Error(err, "failed to handle break-glass")
+		}
+		return err
+	}
+
 	infoMsg := fmt.Sprintf("Start to handle review of pod %s(%s) in %s", pod.Name, pod.GenerateName, pod.Namespace)
 	plog.Info(infoMsg)
 
 	// Validate image signers
-	isValid, invalidReason, err := a.validator.CheckIsValidAndAddDigest(pod)
+	v, hints := recordingDenyHints(a.validator)
+	isValid, invalidReason, volumes, err := a.checkIsValidForOperation(v, review.Request, pod)
 	if err != nil {
 		errMsg := fmt.Sprintf("Error while validating images by %s", err)
 		plog.Error(err, errMsg)
@@ -106,7 +220,7 @@ func (a *ImageAdmission) HandleAdmission(review *admissionv1beta1.AdmissionRevie
 		return err
 	} else if isValid {
 		plog.Info("Pod is valid")
-		patch, err := createPatch(pod)
+		patch, err := createPatch(pod, volumes)
 		if err != nil {
 			errMsg := fmt.Sprintf("Couldn't make patched pod by %s", err)
 			plog.Error(err, errMsg)
@@ -114,16 +228,20 @@ func (a *ImageAdmission) HandleAdmission(review *admissionv1beta1.AdmissionRevie
 			return err
 		}
 
-		patchType := admissionv1beta1.PatchTypeJSONPatch
 		review.Response = &admissionv1beta1.AdmissionResponse{
-			Allowed:   true,
-			Result:    &metav1.Status{},
-			Patch:     patch,
-			PatchType: &patchType,
+			Allowed:  true,
+			Result:   &metav1.Status{},
+			Warnings: admissionWarnings(pod),
+		}
+		if patch != nil {
+			patchType := admissionv1beta1.PatchTypeJSONPatch
+			review.Response.Patch = patch
+			review.Response.PatchType = &patchType
 		}
 	} else {
 		plog.Info("Pod is invalid")
-		setReviewResponseNotAllowed(review, fmt.Sprintf("Pod is not valid: \n%s", invalidReason))
+		setReviewResponseNotAllowed(review, denyMessage(invalidReason, hints, a.denyMessageVerbosity))
+		a.deniedEvents.record(pod, invalidReason)
 	}
 
 	return nil
@@ -138,6 +256,39 @@ func setReviewResponseNotAllowed(review *admissionv1beta1.AdmissionReview, messa
 	}
 }
 
+// setReviewResponseNotAllowedOnError denies the pod, distinguishing the transient errors (e.g., the notary server is unavailable),
+// which are likely to be resolved by retrying, from the permanent ones by the status code and the reason
+func setReviewResponseNotAllowedOnError(review *admissionv1beta1.AdmissionReview, message string, err error) {
+	if !isTransientError(err) {
+		setReviewResponseNotAllowed(review, message)
+		return
+	}
+	review.Response = &admissionv1beta1.AdmissionResponse{
+		Allowed: false,
+		Result: &metav1.Status{
+			Code:    http.StatusServiceUnavailable,
+			Reason:  metav1.StatusReasonServiceUnavailable,
+			Message: fmt.Sprintf("Temporarily unable to validate images, please retry: %s", message),
+		},
+	}
+}
+
+// isTransientError checks if the error is from the network or the unavailable notary server
+func isTransientError(err error) bool {
+	var netErr net.Error
+	var unavailable storage.ErrServerUnavailable
+	var offline storage.ErrOffline
+	return errors.As(err, &netErr) || errors.As(err, &unavailable) || errors.As(err, &offline) || errors.Is(err, context.DeadlineExceeded)
+}
+
+func setReviewResponseAllowedOnError(review *admissionv1beta1.AdmissionReview, message string) {
+	review.Response = &admissionv1beta1.AdmissionResponse{
+		Allowed:  true,
+		Result:   &metav1.Status{},
+		Warnings: []string{fmt.Sprintf("Images are not validated: %s", message)},
+	}
+}
+
 func writeReviewResponse(review *admissionv1beta1.AdmissionReview, w http.ResponseWriter) error {
 	responseInBytes, err := json.Marshal(review)
 	if err != nil {
@@ -149,23 +300,47 @@ func writeReviewResponse(review *admissionv1beta1.AdmissionReview, w http.Respon
 	return nil
 }
 
+// errorResponse is the body responded for the requests which are not handled as admission reviews (e.g., malformed ones),
+// so that the misconfigured callers can tell why
+type errorResponse struct {
+	Error string `json:"error"`
+}
+
+// writeErrorResponse responds the error message in a JSON body with the status code
+func writeErrorResponse(w http.ResponseWriter, status int, errMsg string) {
+	b, err := json.Marshal(&errorResponse{Error: errMsg})
+	if err != nil {
+		plog.Error(err, "")
+		http.Error(w, errMsg, status)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(status)
+	if _, err := w.Write(b); err != nil {
+		plog.Error(err, "")
+	}
+}
+
 type patchOperation struct {
 	Op    string      `json:"op"`
 	Path  string      `json:"path"`
 	Value interface{} `json:"value,omitempty"`
 }
 
-func createPatch(patchPod *core.Pod) ([]byte, error) {
+func createPatch(patchPod *core.Pod, volumes []imageVolume) ([]byte, error) {
 	if patchPod == nil {
 		return nil, fmt.Errorf("couldn't create patch")
 	}
 
-	patch := []patchOperation{
-		{
+	// A pod may have no containers to patch, e.g., only the init containers (or none, pathologically)
+	var patch []patchOperation
+	if len(patchPod.Spec.Containers) > 0 {
+		patch = append(patch, patchOperation{
 			Op:    "replace",
 			Path:  "/spec/containers",
 			Value: patchPod.Spec.Containers,
-		},
+		})
 	}
 
 	if len(patchPod.Spec.InitContainers) > 0 {
@@ -176,5 +351,37 @@ func createPatch(patchPod *core.Pod) ([]byte, error) {
 		})
 	}
 
+	if len(patchPod.Spec.EphemeralContainers) > 0 {
+		patch = append(patch, patchOperation{
+			Op:    "replace",
+			Path:  "/spec/ephemeralContainers",
+			Value: patchPod.Spec.EphemeralContainers,
+		})
+	}
+
+	patch = append(patch, imageVolumePatches(volumes)...)
+
+	// Adding the annotations replaces the existing ones, keeping the others as they are in the pod
+	if hasValidationAnnotations(patchPod) {
+		patch = append(patch, patchOperation{
+			Op:    "add",
+			Path:  "/metadata/annotations",
+			Value: patchPod.Annotations,
+		})
+	}
+
+	if len(patch) == 0 {
+		return nil, nil
+	}
 	return json.Marshal(&patch)
 }
+
+// hasValidationAnnotations checks if the pod has any of the annotations set by the validation
+func hasValidationAnnotations(pod *core.Pod) bool {
+	for _, key := range []string{ValidatedImagesAnnotation, ValidationLatencyAnnotation} {
+		if _, exist := pod.Annotations[key]; exist {
+			return true
+		}
+	}
+	return false
+}
@@ -1,12 +1,24 @@
 package pods
 
 import (
+	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
 	"strings"
 	"testing"
+	"testing/iotest"
+	"time"
 
 	"github.com/stretchr/testify/require"
+	"github.com/theupdateframework/notary/storage"
+	"github.com/tmax-cloud/image-validating-webhook/pkg/server"
 	admissionv1beta1 "k8s.io/api/admission/v1beta1"
 	authenticationv1 "k8s.io/api/authentication/v1"
 	corev1 "k8s.io/api/core/v1"
@@ -14,6 +26,7 @@ import (
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes/fake"
 )
 
 type imageAdmissionHandlerTestCase struct {
@@ -103,3 +116,408 @@ func (d *dummyValidator) CheckIsValidAndAddDigest(pod *corev1.Pod) (bool, string
 
 	return true, "", nil
 }
+
+func TestImageAdmission_HandleAdmission_imageVolume(t *testing.T) {
+	tc := map[string]struct {
+		volumes string
+
+		expectedAllowed bool
+		expectedPatch   []patchOperation
+	}{
+		"signedImageVolume": {
+			volumes:         `[{"name":"empty","emptyDir":{}},{"name":"artifact","image":{"reference":"test-signed-artifact:v1","pullPolicy":"IfNotPresent"}}]`,
+			expectedAllowed: true,
+			expectedPatch:   []patchOperation{{Op: "replace", Path: "/spec/volumes/1/image/reference", Value: "test-signed-artifact:v1@" + testPinnedDigest}},
+		},
+		"notSignedImageVolume": {
+			volumes:         `[{"name":"artifact","image":{"reference":"test-not-signed-artifact:v1"}}]`,
+			expectedAllowed: false,
+		},
+		"noImageVolume": {
+			volumes:         `[{"name":"empty","emptyDir":{}}]`,
+			expectedAllowed: true,
+		},
+	}
+
+	for name, c := range tc {
+		t.Run(name, func(t *testing.T) {
+			im := &ImageAdmission{validator: &pinningValidator{}}
+			raw := fmt.Sprintf(`{"apiVersion":"v1","kind":"Pod","metadata":{"name":"test-pod","namespace":"test-ns"},"spec":{"containers":[{"name":"test-cont","image":"test-signed:v1"}],"volumes":%s}}`, c.volumes)
+
+			review := &admissionv1beta1.AdmissionReview{
+				Request: &admissionv1beta1.AdmissionRequest{
+					UID:       types.UID("test-uid"),
+					Namespace: "test-ns",
+					Operation: admissionv1beta1.Create,
+					Object:    runtime.RawExtension{Raw: []byte(raw)},
+				},
+			}
+
+			require.NoError(t, im.HandleAdmission(review))
+			require.Equal(t, c.expectedAllowed, review.Response.Allowed)
+			if !c.expectedAllowed {
+				return
+			}
+
+			var patch []patchOperation
+			require.NoError(t, json.Unmarshal(review.Response.Patch, &patch))
+			require.Len(t, patch, 1+len(c.expectedPatch))
+			require.Equal(t, "/spec/containers", patch[0].Path)
+			require.Len(t, patch[0].Value, 1, "image volumes are not patched as containers")
+			if len(c.expectedPatch) > 0 {
+				require.Equal(t, c.expectedPatch, patch[1:])
+			}
+		})
+	}
+}
+
+func TestImageAdmission_HandleAdmission_noContainers(t *testing.T) {
+	tc := map[string]struct {
+		spec string
+
+		expectedPatchPaths []string
+	}{
+		"noContainers": {
+			spec: `{}`,
+		},
+		"emptyContainers": {
+			spec: `{"containers":[]}`,
+		},
+		"initContainersOnly": {
+			spec:               `{"initContainers":[{"name":"init-cont","image":"test-signed:v1"}]}`,
+			expectedPatchPaths: []string{"/spec/initContainers"},
+		},
+	}
+
+	for name, c := range tc {
+		t.Run(name, func(t *testing.T) {
+			im := &ImageAdmission{validator: &dummyValidator{}}
+			raw := fmt.Sprintf(`{"apiVersion":"v1","kind":"Pod","metadata":{"name":"test-pod","namespace":"test-ns"},"spec":%s}`, c.spec)
+
+			review := &admissionv1beta1.AdmissionReview{
+				Request: &admissionv1beta1.AdmissionRequest{
+					UID:       types.UID("test-uid"),
+					Namespace: "test-ns",
+					Operation: admissionv1beta1.Create,
+					Object:    runtime.RawExtension{Raw: []byte(raw)},
+				},
+			}
+
+			require.NoError(t, im.HandleAdmission(review))
+			require.True(t, review.Response.Allowed)
+			if len(c.expectedPatchPaths) == 0 {
+				require.Nil(t, review.Response.Patch)
+				require.Nil(t, review.Response.PatchType)
+				return
+			}
+
+			var patch []patchOperation
+			require.NoError(t, json.Unmarshal(review.Response.Patch, &patch))
+			var paths []string
+			for _, p := range patch {
+				paths = append(paths, p.Path)
+			}
+			require.Equal(t, c.expectedPatchPaths, paths)
+		})
+	}
+}
+
+const testPinnedDigest = "sha256:1111111111111111111111111111111111111111111111111111111111111111"
+
+// pinningValidator pins the digests of the signed images
+type pinningValidator struct{}
+
+func (p *pinningValidator) CheckIsValidAndAddDigest(pod *corev1.Pod) (bool, string, error) {
+	for i, c := range pod.Spec.Containers {
+		if strings.HasPrefix(c.Image, "test-not-signed") {
+			return false, fmt.Sprintf("image '%s' is not signed", c.Image), nil
+		}
+		pod.Spec.Containers[i].Image = c.Image + "@" + testPinnedDigest
+	}
+	return true, "", nil
+}
+
+func TestImageAdmission_HandleAdmission_subResource(t *testing.T) {
+	im := &ImageAdmission{validator: &dummyValidator{}}
+
+	execOptions := &corev1.PodExecOptions{
+		TypeMeta:  metav1.TypeMeta{APIVersion: "v1", Kind: "PodExecOptions"},
+		Container: "test-cont",
+		Command:   []string{"sh"},
+	}
+	raw, err := json.Marshal(execOptions)
+	require.NoError(t, err)
+
+	review := &admissionv1beta1.AdmissionReview{
+		Request: &admissionv1beta1.AdmissionRequest{
+			UID:         types.UID("test-uid"),
+			Kind:        metav1.GroupVersionKind{Group: "", Version: "v1", Kind: "PodExecOptions"},
+			Resource:    metav1.GroupVersionResource{Group: "", Version: "v1", Resource: "pods"},
+			SubResource: "exec",
+			Name:        "test-pod",
+			Namespace:   "test-ns",
+			Operation:   admissionv1beta1.Connect,
+			Object:      runtime.RawExtension{Raw: raw},
+		},
+	}
+
+	require.NoError(t, im.HandleAdmission(review))
+	require.True(t, review.Response.Allowed)
+	require.Nil(t, review.Response.Patch)
+}
+
+func TestImageAdmission_ServeHTTP_errorPolicy(t *testing.T) {
+	tc := map[string]struct {
+		errorPolicy string
+		err         error
+		transient   bool
+
+		expectedStatus     int
+		expectedAllowed    bool
+		expectedResultCode int32
+	}{
+		"default": {
+			expectedStatus:  http.StatusOK,
+			expectedAllowed: false,
+		},
+		"deny": {
+			errorPolicy:     ErrorPolicyDeny,
+			expectedStatus:  http.StatusOK,
+			expectedAllowed: false,
+		},
+		"allow": {
+			errorPolicy:     ErrorPolicyAllow,
+			expectedStatus:  http.StatusOK,
+			expectedAllowed: true,
+		},
+		"failurePolicy": {
+			errorPolicy:    ErrorPolicyFailurePolicy,
+			expectedStatus: http.StatusInternalServerError,
+		},
+		"transient": {
+			err:                errors.New("dial tcp: i/o timeout"),
+			transient:          true,
+			expectedStatus:     http.StatusOK,
+			expectedAllowed:    false,
+			expectedResultCode: http.StatusServiceUnavailable,
+		},
+	}
+
+	for name, c := range tc {
+		t.Run(name, func(t *testing.T) {
+			err := c.err
+			if c.transient {
+				err = &net.OpError{Op: "dial", Net: "tcp", Err: err}
+			}
+			im := &ImageAdmission{validator: &errorValidator{err: err}, errorPolicy: c.errorPolicy}
+			raw := `{"apiVersion":"v1","kind":"Pod","metadata":{"name":"test-pod","namespace":"test-ns"},"spec":{"containers":[{"name":"test-cont","image":"test-signed:v1"}]}}`
+
+			body, err := json.Marshal(&admissionv1beta1.AdmissionReview{
+				TypeMeta: metav1.TypeMeta{APIVersion: admissionv1beta1.SchemeGroupVersion.String(), Kind: "AdmissionReview"},
+				Request: &admissionv1beta1.AdmissionRequest{
+					UID:       types.UID("test-uid"),
+					Namespace: "test-ns",
+					Operation: admissionv1beta1.Create,
+					Object:    runtime.RawExtension{Raw: []byte(raw)},
+				},
+			})
+			require.NoError(t, err)
+
+			req := httptest.NewRequest(http.MethodPost, "/validate", bytes.NewReader(body))
+			req.Header.Set("Content-Type", "application/json")
+			w := httptest.NewRecorder()
+			im.ServeHTTP(w, req)
+			require.Equal(t, c.expectedStatus, w.Code)
+			if c.expectedStatus != http.StatusOK {
+				resp := &errorResponse{}
+				require.NoError(t, json.Unmarshal(w.Body.Bytes(), resp))
+				require.Contains(t, resp.Error, "Couldn't handle admission request")
+				return
+			}
+
+			review := &admissionv1beta1.AdmissionReview{}
+			require.NoError(t, json.Unmarshal(w.Body.Bytes(), review))
+			require.Equal(t, c.expectedAllowed, review.Response.Allowed)
+			require.Equal(t, c.expectedResultCode, review.Response.Result.Code)
+			if c.expectedAllowed {
+				require.Len(t, review.Response.Warnings, 1)
+			}
+		})
+	}
+}
+
+// errorValidator fails to validate any pod, with err or a permanent error if it's nil
+type errorValidator struct {
+	err error
+}
+
+func (e *errorValidator) CheckIsValidAndAddDigest(_ *corev1.Pod) (bool, string, error) {
+	if e.err != nil {
+		return false, "", e.err
+	}
+	return false, "", fmt.Errorf("image is not in right form")
+}
+
+func TestImageAdmission_ServeHTTP_notAdmissionRequest(t *testing.T) {
+	tc := map[string]struct {
+		method      string
+		contentType string
+		body        string
+
+		unreadable bool
+
+		expectedStatus int
+		expectedError  string
+	}{
+		"get": {
+			method:         http.MethodGet,
+			expectedStatus: http.StatusMethodNotAllowed,
+			expectedError:  "Method GET is not allowed",
+		},
+		"notJSON": {
+			method:         http.MethodPost,
+			contentType:    "text/plain",
+			body:           "hello",
+			expectedStatus: http.StatusUnsupportedMediaType,
+			expectedError:  "Content type text/plain is not supported. It should be application/json",
+		},
+		"jsonPrefixed": {
+			method:         http.MethodPost,
+			contentType:    "application/jsonp",
+			body:           "hello",
+			expectedStatus: http.StatusUnsupportedMediaType,
+			expectedError:  "Content type application/jsonp is not supported. It should be application/json",
+		},
+		"jsonWithCharset": {
+			method:         http.MethodPost,
+			contentType:    "application/json; charset=utf-8",
+			body:           `{"apiVersion":"admission.k8s.io/v1beta1","kind":"AdmissionReview"}`,
+			expectedStatus: http.StatusBadRequest,
+			expectedError:  "AdmissionReview has no request",
+		},
+		"noContentType": {
+			method:         http.MethodPost,
+			body:           `{"apiVersion":"admission.k8s.io/v1beta1","kind":"AdmissionReview"}`,
+			expectedStatus: http.StatusBadRequest,
+			expectedError:  "AdmissionReview has no request",
+		},
+		"unreadableBody": {
+			method:         http.MethodPost,
+			contentType:    "application/json",
+			unreadable:     true,
+			expectedStatus: http.StatusBadRequest,
+			expectedError:  "Couldn't read request by connection reset",
+		},
+		"emptyBody": {
+			method:         http.MethodPost,
+			contentType:    "application/json",
+			expectedStatus: http.StatusBadRequest,
+			expectedError:  "Request body is empty",
+		},
+		"malformedBody": {
+			method:         http.MethodPost,
+			contentType:    "application/json",
+			body:           `{"kind":`,
+			expectedStatus: http.StatusBadRequest,
+			expectedError:  "Couldn't decode request by ",
+		},
+		"noRequest": {
+			method:         http.MethodPost,
+			contentType:    "application/json",
+			body:           `{"apiVersion":"admission.k8s.io/v1beta1","kind":"AdmissionReview"}`,
+			expectedStatus: http.StatusBadRequest,
+			expectedError:  "AdmissionReview has no request",
+		},
+	}
+
+	for name, c := range tc {
+		t.Run(name, func(t *testing.T) {
+			im := &ImageAdmission{validator: &dummyValidator{}}
+
+			var body io.Reader = strings.NewReader(c.body)
+			if c.unreadable {
+				body = iotest.ErrReader(errors.New("connection reset"))
+			}
+			req := httptest.NewRequest(c.method, "/validate", body)
+			if c.contentType != "" {
+				req.Header.Set("Content-Type", c.contentType)
+			}
+			w := httptest.NewRecorder()
+			im.ServeHTTP(w, req)
+			require.Equal(t, c.expectedStatus, w.Code)
+			require.NotContains(t, w.Body.String(), `"response"`, "no admission review is written")
+
+			require.Equal(t, "application/json", w.Header().Get("Content-Type"))
+			resp := &errorResponse{}
+			require.NoError(t, json.Unmarshal(w.Body.Bytes(), resp))
+			require.True(t, strings.HasPrefix(resp.Error, c.expectedError), resp.Error)
+		})
+	}
+}
+
+func TestIsTransientError(t *testing.T) {
+	tc := map[string]struct {
+		err error
+
+		expectedTransient bool
+	}{
+		"network": {
+			err:               &url.Error{Op: "Get", URL: "https://notary", Err: &net.OpError{Op: "dial", Net: "tcp", Err: errors.New("connection refused")}},
+			expectedTransient: true,
+		},
+		"notaryUnavailable": {
+			err:               storage.ErrServerUnavailable{},
+			expectedTransient: true,
+		},
+		"notaryOffline": {
+			err:               storage.ErrOffline{},
+			expectedTransient: true,
+		},
+		"deadline": {
+			err:               fmt.Errorf("fetching signature: %w", context.DeadlineExceeded),
+			expectedTransient: true,
+		},
+		"permanent": {
+			err: errors.New("image is not in right form"),
+		},
+	}
+
+	for name, c := range tc {
+		t.Run(name, func(t *testing.T) {
+			require.Equal(t, c.expectedTransient, isTransientError(c.err))
+		})
+	}
+}
+
+func TestNewHandlers_sharedValidator(t *testing.T) {
+	cli := fake.NewSimpleClientset()
+	cfg := &server.HandlerConfig{ClientSet: cli}
+
+	// Build the shared validator with the injected client, without watching the policies
+	_, err := cfg.Shared(sharedValidatorKey{}, func() (interface{}, error) {
+		return &validator{client: cfg.ClientSet, deniedImages: newDeniedImageCache(time.Minute)}, nil
+	})
+	require.NoError(t, err)
+
+	admission, err := NewPodsAdmissionHandler(cfg)
+	require.NoError(t, err)
+	provider, err := NewDecisionProviderHandler(cfg)
+	require.NoError(t, err)
+
+	v, ok := admission.(*ImageAdmission).validator.(*validator)
+	require.True(t, ok)
+	require.Same(t, v, provider.(*DecisionProvider).validator, "handlers share the validator")
+	require.Same(t, cli, v.client, "validator uses the injected client")
+
+	// The handlers of another config share another validator, with its own caches
+	otherCfg := &server.HandlerConfig{ClientSet: fake.NewSimpleClientset()}
+	_, err = otherCfg.Shared(sharedValidatorKey{}, func() (interface{}, error) {
+		return &validator{client: otherCfg.ClientSet, deniedImages: newDeniedImageCache(time.Minute)}, nil
+	})
+	require.NoError(t, err)
+	otherAdmission, err := NewPodsAdmissionHandler(otherCfg)
+	require.NoError(t, err)
+	other := otherAdmission.(*ImageAdmission).validator.(*validator)
+	require.NotSame(t, v, other)
+	require.NotSame(t, v.deniedImages, other.deniedImages, "configs don't share the caches")
+}
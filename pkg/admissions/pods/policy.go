@@ -2,6 +2,8 @@ package pods
 
 import (
 	"fmt"
+	"sort"
+	"strings"
 
 	"github.com/tmax-cloud/image-validating-webhook/internal/k8s"
 	whv1 "github.com/tmax-cloud/image-validating-webhook/pkg/type"
@@ -17,13 +19,18 @@ type RegistryPolicyCache struct {
 
 	clusterCachedClient   watcher.CachedClient
 	namespaceCachedClient watcher.CachedClient
+
+	// defaultPolicy is one of the default policies, for the registries matching no policy
+	defaultPolicy string
+	// precedence is one of the policy precedences, deciding which of the cluster and the namespace policies are evaluated first
+	precedence string
 }
 
 var (
 	policylog = logf.Log.WithName("policy.go")
 )
 
-func newRegistryPolicyCache(cfg *rest.Config, restClient rest.Interface) (*RegistryPolicyCache, error) {
+func newRegistryPolicyCache(cfg *rest.Config, restClient rest.Interface, defaultPolicy, precedence string) (*RegistryPolicyCache, error) {
 	// Create watcher client for whv1
 	watchCli, err := k8s.NewGroupVersionClient(cfg, whv1.GroupVersion)
 	if err != nil {
@@ -38,6 +45,8 @@ func newRegistryPolicyCache(cfg *rest.Config, restClient rest.Interface) (*Regis
 		restClient:            restClient,
 		clusterCachedClient:   watcher.NewCachedClient(cw),
 		namespaceCachedClient: watcher.NewCachedClient(nw),
+		defaultPolicy:         defaultPolicy,
+		precedence:            precedence,
 	}
 
 	waitChCluster := make(chan struct{})
@@ -54,42 +63,274 @@ func newRegistryPolicyCache(cfg *rest.Config, restClient rest.Interface) (*Regis
 	return p, nil
 }
 
-func (c *RegistryPolicyCache) doesMatchPolicy(registry string, namespace string) (bool, whv1.RegistrySpec) {
+// doesMatchPolicy finds the registry's policy. It returns an error if the policies cannot be listed,
+// which is distinguished from the registry not matching any policy. If it matches no policy (including when there's no policy at all),
+// the default policy decides. See matchesHost for how the registries match,
+// and selectPolicySpec for which one is selected if the registry matches many of them. Only the cluster policies are matched if the namespace is empty
+func (c *RegistryPolicyCache) doesMatchPolicy(registry string, namespace string) (bool, whv1.RegistrySpec, error) {
 	clusterObjs := &whv1.ClusterRegistrySecurityPolicyList{}
 	namespaceObjs := &whv1.RegistrySecurityPolicyList{}
 
 	if err := c.clusterCachedClient.List(watcher.Selector{Namespace: ""}, clusterObjs); err != nil {
-		policylog.Error(err, "")
-		return false, whv1.RegistrySpec{}
+		policylog.Error(err, "couldn't list cluster registry security policies")
+		return false, whv1.RegistrySpec{}, fmt.Errorf("couldn't list cluster registry security policies by %s", err)
 	}
-	if err := c.namespaceCachedClient.List(watcher.Selector{Namespace: namespace}, namespaceObjs); err != nil {
-		policylog.Error(err, "")
-		return false, whv1.RegistrySpec{}
+	// An empty namespace selects the policies of all the namespaces, which no namespace should be validated by
+	if namespace != "" {
+		if err := c.namespaceCachedClient.List(watcher.Selector{Namespace: namespace}, namespaceObjs); err != nil {
+			policylog.Error(err, "couldn't list registry security policies", "namespace", namespace)
+			return false, whv1.RegistrySpec{}, fmt.Errorf("couldn't list registry security policies by %s", err)
+		}
 	}
 
 	if registry == "" {
 		registry = "docker.io"
 	}
 
-	if len(clusterObjs.Items) == 0 && len(namespaceObjs.Items) == 0 {
-		return true, whv1.RegistrySpec{}
+	if spec, matched := c.selectPolicySpec(clusterObjs, namespaceObjs, registry); matched {
+		return true, spec, nil
+	}
+	policylog.Info("no matching registry security policy", "registry", registry, "namespace", namespace, "defaultPolicy", c.defaultPolicy)
+
+	return c.defaultRegistrySpec(registry)
+}
+
+// doesMatchClusterPolicy finds the registry's policy only among the cluster policies, for the images validated outside of any namespace
+// (e.g., warming up the signature cache). See doesMatchPolicy
+func (c *RegistryPolicyCache) doesMatchClusterPolicy(registry string) (bool, whv1.RegistrySpec, error) {
+	return c.doesMatchPolicy(registry, "")
+}
+
+// selectPolicySpec selects the spec of the policies matching the registry.
+// By PolicyPrecedenceClusterFirst, the most specific cluster spec wins however specific the namespace specs are,
+// and the matching namespace spec can only tighten it (see tightenRegistrySpec). The namespace specs are selected only if no cluster spec matches.
+// By PolicyPrecedenceNamespaceFirst, the most specific spec of all wins, the namespace specs coming first among the equally specific ones
+func (c *RegistryPolicyCache) selectPolicySpec(clusterObjs *whv1.ClusterRegistrySecurityPolicyList, namespaceObjs *whv1.RegistrySecurityPolicyList, registry string) (whv1.RegistrySpec, bool) {
+	clusterSpecs, namespaceSpecs := orderedRegistrySpecs(clusterObjs, namespaceObjs)
+	if c.precedence == PolicyPrecedenceNamespaceFirst {
+		return selectRegistrySpec(append(namespaceSpecs, clusterSpecs...), registry)
+	}
+
+	clusterSpec, clusterMatched := selectRegistrySpec(clusterSpecs, registry)
+	namespaceSpec, namespaceMatched := selectRegistrySpec(namespaceSpecs, registry)
+	switch {
+	case clusterMatched && namespaceMatched:
+		return tightenRegistrySpec(clusterSpec, namespaceSpec), true
+	case clusterMatched:
+		return clusterSpec, true
 	}
+	return namespaceSpec, namespaceMatched
+}
+
+// orderedRegistrySpecs returns the registry specs of the cluster and the namespace policies, each in the order of the policies' names.
+// The registries of a policy are in their order in the policy. The proxy auth secrets of the namespace policies are dropped,
+// not to let the namespaces use the secrets of registry-system namespace
+func orderedRegistrySpecs(clusterObjs *whv1.ClusterRegistrySecurityPolicyList, namespaceObjs *whv1.RegistrySecurityPolicyList) ([]whv1.RegistrySpec, []whv1.RegistrySpec) {
+	sort.Slice(clusterObjs.Items, func(i, j int) bool { return clusterObjs.Items[i].Name < clusterObjs.Items[j].Name })
+	sort.Slice(namespaceObjs.Items, func(i, j int) bool { return namespaceObjs.Items[i].Name < namespaceObjs.Items[j].Name })
+
+	var clusterSpecs, namespaceSpecs []whv1.RegistrySpec
 	for i := range clusterObjs.Items {
-		for j := range clusterObjs.Items[i].Spec.Registries {
-			if clusterObjs.Items[i].Spec.Registries[j].Registry == registry {
-				return true, clusterObjs.Items[i].Spec.Registries[j]
-			}
-		}
+		clusterSpecs = append(clusterSpecs, clusterObjs.Items[i].Spec.Registries...)
 	}
 	for i := range namespaceObjs.Items {
-		for j := range namespaceObjs.Items[i].Spec.Registries {
-			if namespaceObjs.Items[i].Spec.Registries[j].Registry == registry {
-				return true, namespaceObjs.Items[i].Spec.Registries[j]
+		for _, spec := range namespaceObjs.Items[i].Spec.Registries {
+			if spec.ProxyAuthSecret != "" {
+				policylog.Info("ignoring proxy auth secret of the namespace policy, which is honored only by the cluster policies",
+					"namespace", namespaceObjs.Items[i].Namespace, "policy", namespaceObjs.Items[i].Name, "registry", spec.Registry)
+				spec.ProxyAuthSecret = ""
 			}
+			namespaceSpecs = append(namespaceSpecs, spec)
+		}
+	}
+	return clusterSpecs, namespaceSpecs
+}
+
+// tightenRegistrySpec returns the cluster spec tightened by the namespace spec matching the same registry.
+// The namespace spec can turn on the checks the cluster spec doesn't require, raise the signer threshold, shorten the maximum signature age,
+// and restrict the signers and the admin keys if the cluster spec doesn't. It can't loosen or replace anything the cluster spec sets
+func tightenRegistrySpec(cluster, namespace whv1.RegistrySpec) whv1.RegistrySpec {
+	tightened := *cluster.DeepCopy()
+	if !cluster.SignCheck && namespace.SignCheck {
+		tightened.SignCheck = true
+		if tightened.Notary == "" {
+			tightened.Notary = namespace.Notary
+		}
+	}
+	tightened.SignatureOptional = cluster.SignatureOptional && namespace.SignatureOptional
+	tightened.VerifyManifestDigest = cluster.VerifyManifestDigest || namespace.VerifyManifestDigest
+	tightened.RequireAuthenticatedPull = cluster.RequireAuthenticatedPull || namespace.RequireAuthenticatedPull
+	if namespace.SignerThreshold > tightened.SignerThreshold {
+		tightened.SignerThreshold = namespace.SignerThreshold
+	}
+	if namespace.MaxSignatureAge != nil && (tightened.MaxSignatureAge == nil || namespace.MaxSignatureAge.Duration < tightened.MaxSignatureAge.Duration) {
+		tightened.MaxSignatureAge = namespace.MaxSignatureAge.DeepCopy()
+	}
+	if len(tightened.Signer) == 0 {
+		tightened.Signer = append([]string(nil), namespace.Signer...)
+	}
+	if len(tightened.AdminKeys) == 0 {
+		tightened.AdminKeys = append([]string(nil), namespace.AdminKeys...)
+	}
+	for _, arch := range namespace.RequiredArchitectures {
+		if !containsString(tightened.RequiredArchitectures, arch) {
+			tightened.RequiredArchitectures = append(tightened.RequiredArchitectures, arch)
+		}
+	}
+	return tightened
+}
+
+// containsString checks if the list contains the string
+func containsString(list []string, str string) bool {
+	for _, s := range list {
+		if s == str {
+			return true
+		}
+	}
+	return false
+}
+
+// selectRegistrySpec selects the spec matching the registry, the first match in the evaluation order winning among the equally specific ones.
+// An exact match is the most specific, and the longer wildcard patterns are more specific than the shorter ones (e.g., *.a.example.com than *.example.com)
+func selectRegistrySpec(specs []whv1.RegistrySpec, registry string) (whv1.RegistrySpec, bool) {
+	for i := range specs {
+		if matchesRegistry(specs[i], registry, false) {
+			return specs[i], true
+		}
+	}
+
+	selected, longest := -1, 0
+	for i := range specs {
+		if l := len(matchedWildcard(specs[i], registry)); l > longest {
+			selected, longest = i, l
+		}
+	}
+	if selected < 0 {
+		return whv1.RegistrySpec{}, false
+	}
+	return specs[selected], true
+}
+
+// defaultRegistrySpec returns the registry spec of the default policy for the registry matching no policy
+func (c *RegistryPolicyCache) defaultRegistrySpec(registry string) (bool, whv1.RegistrySpec, error) {
+	switch c.defaultPolicy {
+	case DefaultPolicyAllow:
+		return true, whv1.RegistrySpec{Registry: registry}, nil
+	case DefaultPolicyRequireSignature:
+		return true, whv1.RegistrySpec{Registry: registry, SignCheck: true}, nil
+	}
+	return false, whv1.RegistrySpec{}, nil
+}
+
+// matchesRegistry checks if the registry matches the spec's registry or one of its aliases, by matchesHost.
+// Only the wildcard patterns are matched if wildcard is true, otherwise only the others are
+func matchesRegistry(spec whv1.RegistrySpec, registry string, wildcard bool) bool {
+	for _, pattern := range append([]string{spec.Registry}, spec.Aliases...) {
+		if isWildcardHost(pattern) == wildcard && matchesHost(pattern, registry) {
+			return true
+		}
+	}
+	return false
+}
+
+// matchedWildcard returns the longest wildcard pattern of the spec's registry and its aliases matching the registry, or empty if none matches
+func matchedWildcard(spec whv1.RegistrySpec, registry string) string {
+	var matched string
+	for _, pattern := range append([]string{spec.Registry}, spec.Aliases...) {
+		if isWildcardHost(pattern) && matchesHost(pattern, registry) && len(pattern) > len(matched) {
+			matched = pattern
 		}
 	}
-	err := fmt.Errorf("no matching registry security policy")
-	policylog.Error(err, "")
+	return matched
+}
+
+// matchesHost checks if the host matches the registry pattern of a policy. Hosts are case-insensitive.
+//   - registry.example.com matches only registry.example.com, not its subdomains or the other ports (e.g., registry.example.com:5000)
+//   - *.example.com matches the subdomains of example.com (e.g., a.example.com, a.b.example.com), not example.com itself
+//   - *.example.com:5000 matches the subdomains of example.com at port 5000
+//
+// An empty pattern matches nothing, and a wildcard other than the leftmost label is not supported
+func matchesHost(pattern, host string) bool {
+	if pattern == "" || host == "" {
+		return false
+	}
+	pattern, host = strings.ToLower(pattern), strings.ToLower(host)
+	if !isWildcardHost(pattern) {
+		return pattern == host
+	}
+
+	suffix := strings.TrimPrefix(pattern, "*")
+	patternHost, patternPort := splitHostPort(suffix)
+	hostname, port := splitHostPort(host)
+	return port == patternPort && len(hostname) > len(patternHost) && strings.HasSuffix(hostname, patternHost)
+}
+
+// isWildcardHost checks if the registry pattern matches the subdomains, e.g., *.example.com
+func isWildcardHost(pattern string) bool {
+	return strings.HasPrefix(pattern, "*.")
+}
+
+// splitHostPort splits the host into the hostname and the port with its colon (e.g., :5000), which is empty if there's no port
+func splitHostPort(host string) (string, string) {
+	if i := strings.LastIndex(host, ":"); i >= 0 {
+		return host[:i], host[i:]
+	}
+	return host, ""
+}
+
+// listRegistries lists all the registries from the cluster/namespace registry security policies
+func (c *RegistryPolicyCache) listRegistries() ([]whv1.RegistrySpec, error) {
+	clusterObjs := &whv1.ClusterRegistrySecurityPolicyList{}
+	namespaceObjs := &whv1.RegistrySecurityPolicyList{}
+
+	if err := c.clusterCachedClient.List(watcher.Selector{}, clusterObjs); err != nil {
+		return nil, err
+	}
+	if err := c.namespaceCachedClient.List(watcher.Selector{}, namespaceObjs); err != nil {
+		return nil, err
+	}
+
+	var registries []whv1.RegistrySpec
+	for _, p := range clusterObjs.Items {
+		registries = append(registries, p.Spec.Registries...)
+	}
+	for _, p := range namespaceObjs.Items {
+		registries = append(registries, p.Spec.Registries...)
+	}
+	return registries, nil
+}
+
+// loadedPolicy is a cluster/namespace registry security policy loaded in the cache
+type loadedPolicy struct {
+	Name       string              `json:"name"`
+	Namespace  string              `json:"namespace,omitempty"`
+	Registries []whv1.RegistrySpec `json:"registries"`
+}
+
+// listPolicies lists the cluster/namespace registry security policies, sorted by their namespaces and names
+func (c *RegistryPolicyCache) listPolicies() ([]loadedPolicy, error) {
+	clusterObjs := &whv1.ClusterRegistrySecurityPolicyList{}
+	namespaceObjs := &whv1.RegistrySecurityPolicyList{}
+
+	if err := c.clusterCachedClient.List(watcher.Selector{}, clusterObjs); err != nil {
+		return nil, err
+	}
+	if err := c.namespaceCachedClient.List(watcher.Selector{}, namespaceObjs); err != nil {
+		return nil, err
+	}
 
-	return false, whv1.RegistrySpec{}
+	policies := []loadedPolicy{}
+	for _, p := range clusterObjs.Items {
+		policies = append(policies, loadedPolicy{Name: p.Name, Registries: p.Spec.Registries})
+	}
+	for _, p := range namespaceObjs.Items {
+		policies = append(policies, loadedPolicy{Name: p.Name, Namespace: p.Namespace, Registries: p.Spec.Registries})
+	}
+	sort.Slice(policies, func(i, j int) bool {
+		if policies[i].Namespace != policies[j].Namespace {
+			return policies[i].Namespace < policies[j].Namespace
+		}
+		return policies[i].Name < policies[j].Name
+	})
+	return policies, nil
 }
@@ -2,15 +2,18 @@ package pods
 
 import (
 	"bytes"
+	"fmt"
 	"io/ioutil"
 	"net/http"
 	"testing"
 
 	"github.com/stretchr/testify/require"
 	whv1 "github.com/tmax-cloud/image-validating-webhook/pkg/type"
+	"github.com/tmax-cloud/image-validating-webhook/pkg/watcher"
 	"github.com/tmax-cloud/image-validating-webhook/pkg/watcher/fake"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/client-go/kubernetes/scheme"
 	restfake "k8s.io/client-go/rest/fake"
 )
@@ -51,6 +54,57 @@ func TestRegistryPolicyCache_doesMatchPolicy(t *testing.T) {
 				SignCheck: false,
 			},
 		},
+		"alias": {
+			registry:      "registry.internal",
+			namespace:     testCheckSign,
+			expectedValid: true,
+			expectedPolicy: whv1.RegistrySpec{
+				Registry:  "registry.example.com",
+				Aliases:   []string{"registry.internal", "registry.example.com:443"},
+				Notary:    "https://notary.example.com",
+				SignCheck: true,
+			},
+		},
+		"anotherAlias": {
+			registry:      "registry.example.com:443",
+			namespace:     testCheckSign,
+			expectedValid: true,
+			expectedPolicy: whv1.RegistrySpec{
+				Registry:  "registry.example.com",
+				Aliases:   []string{"registry.internal", "registry.example.com:443"},
+				Notary:    "https://notary.example.com",
+				SignCheck: true,
+			},
+		},
+		"caseInsensitive": {
+			registry:      "REGISTRY.internal",
+			namespace:     testCheckSign,
+			expectedValid: true,
+			expectedPolicy: whv1.RegistrySpec{
+				Registry:  "registry.example.com",
+				Aliases:   []string{"registry.internal", "registry.example.com:443"},
+				Notary:    "https://notary.example.com",
+				SignCheck: true,
+			},
+		},
+		"wildcard": {
+			registry:       "harbor.example.com",
+			namespace:      testCheckSign,
+			expectedValid:  true,
+			expectedPolicy: whv1.RegistrySpec{Registry: "*.example.com", SignCheck: true},
+		},
+		"wildcardOtherPort": {
+			registry:       "harbor.example.com:5000",
+			namespace:      testCheckSign,
+			expectedValid:  false,
+			expectedPolicy: whv1.RegistrySpec{},
+		},
+		"wildcardNotSubdomain": {
+			registry:       "example.com",
+			namespace:      testCheckSign,
+			expectedValid:  false,
+			expectedPolicy: whv1.RegistrySpec{},
+		},
 	}
 
 	cache := RegistryPolicyCache{restClient: testPolicyRestClient(), clusterCachedClient: &fake.CachedClient{
@@ -66,6 +120,11 @@ func TestRegistryPolicyCache_doesMatchPolicy(t *testing.T) {
 							Notary:    "",
 							SignCheck: false,
 						},
+						// registry.internal matches the alias of the namespace policy, as this doesn't match it
+						{
+							Registry:  "*.example.com",
+							SignCheck: true,
+						},
 					},
 				},
 			},
@@ -84,6 +143,12 @@ func TestRegistryPolicyCache_doesMatchPolicy(t *testing.T) {
 							Notary:    "",
 							SignCheck: false,
 						},
+						{
+							Registry:  "registry.example.com",
+							Aliases:   []string{"registry.internal", "registry.example.com:443"},
+							Notary:    "https://notary.example.com",
+							SignCheck: true,
+						},
 					},
 				},
 			},
@@ -92,13 +157,277 @@ func TestRegistryPolicyCache_doesMatchPolicy(t *testing.T) {
 
 	for name, c := range tc {
 		t.Run(name, func(t *testing.T) {
-			valid, policy := cache.doesMatchPolicy(c.registry, c.namespace)
+			valid, policy, err := cache.doesMatchPolicy(c.registry, c.namespace)
+			require.NoError(t, err)
 			require.Equal(t, c.expectedValid, valid)
 			require.Equal(t, c.expectedPolicy, policy)
 		})
 	}
 }
 
+func TestMatchesHost(t *testing.T) {
+	tc := map[string]struct {
+		pattern string
+		host    string
+
+		expectedMatch bool
+	}{
+		"exact":                  {pattern: "registry.example.com", host: "registry.example.com", expectedMatch: true},
+		"exactCaseInsensitive":   {pattern: "Registry.Example.com", host: "registry.example.COM", expectedMatch: true},
+		"exactWithPort":          {pattern: "registry.example.com:5000", host: "registry.example.com:5000", expectedMatch: true},
+		"exactOtherPort":         {pattern: "registry.example.com", host: "registry.example.com:5000"},
+		"exactSubdomain":         {pattern: "example.com", host: "registry.example.com"},
+		"exactSuffix":            {pattern: "example.com", host: "myexample.com"},
+		"wildcard":               {pattern: "*.example.com", host: "registry.example.com", expectedMatch: true},
+		"wildcardDeepSubdomain":  {pattern: "*.example.com", host: "a.registry.example.com", expectedMatch: true},
+		"wildcardWithPort":       {pattern: "*.example.com:5000", host: "registry.example.com:5000", expectedMatch: true},
+		"wildcardOtherPort":      {pattern: "*.example.com", host: "registry.example.com:5000"},
+		"wildcardNotSubdomain":   {pattern: "*.example.com", host: "example.com"},
+		"wildcardSuffix":         {pattern: "*.example.com", host: "registry.myexample.com"},
+		"wildcardNotLeftmost":    {pattern: "registry-*.example.com", host: "registry-1.example.com"},
+		"empty":                  {pattern: "", host: "registry.example.com"},
+		"emptyHost":              {pattern: "*.example.com", host: ""},
+		"wildcardOtherDomain":    {pattern: "*.example.com", host: "registry.example.org"},
+		"wildcardPortNoHostPort": {pattern: "*.example.com:5000", host: "registry.example.com"},
+	}
+
+	for caseName, c := range tc {
+		t.Run(caseName, func(t *testing.T) {
+			require.Equal(t, c.expectedMatch, matchesHost(c.pattern, c.host))
+		})
+	}
+}
+
+func TestRegistryPolicyCache_doesMatchPolicy_defaultPolicy(t *testing.T) {
+	tc := map[string]struct {
+		defaultPolicy string
+
+		expectedValid  bool
+		expectedPolicy whv1.RegistrySpec
+	}{
+		"unset": {
+			expectedValid: false,
+		},
+		"deny": {
+			defaultPolicy: DefaultPolicyDeny,
+			expectedValid: false,
+		},
+		"allow": {
+			defaultPolicy:  DefaultPolicyAllow,
+			expectedValid:  true,
+			expectedPolicy: whv1.RegistrySpec{Registry: "no-match-registry"},
+		},
+		"requireSignature": {
+			defaultPolicy:  DefaultPolicyRequireSignature,
+			expectedValid:  true,
+			expectedPolicy: whv1.RegistrySpec{Registry: "no-match-registry", SignCheck: true},
+		},
+	}
+
+	for name, c := range tc {
+		t.Run(name, func(t *testing.T) {
+			cache := RegistryPolicyCache{restClient: testPolicyRestClient(), defaultPolicy: c.defaultPolicy, clusterCachedClient: &fake.CachedClient{}, namespaceCachedClient: &fake.CachedClient{
+				Cache: map[string]runtime.Object{
+					testCheckSign + "/policy": &whv1.RegistrySecurityPolicy{
+						ObjectMeta: metav1.ObjectMeta{Name: "policy", Namespace: testCheckSign},
+						Spec:       whv1.RegistrySecurityPolicySpec{Registries: []whv1.RegistrySpec{{Registry: "testRegistry1", SignCheck: true}}},
+					},
+				},
+			}}
+
+			valid, policy, err := cache.doesMatchPolicy("no-match-registry", testCheckSign)
+			require.NoError(t, err)
+			require.Equal(t, c.expectedValid, valid)
+			require.Equal(t, c.expectedPolicy, policy)
+
+			// The namespace with no policy at all is decided by the default policy as well
+			valid, policy, err = cache.doesMatchPolicy("no-match-registry", testNoCheckSign)
+			require.NoError(t, err)
+			require.Equal(t, c.expectedValid, valid)
+			require.Equal(t, c.expectedPolicy, policy)
+
+			// The matching policy is not affected
+			valid, policy, err = cache.doesMatchPolicy("testRegistry1", testCheckSign)
+			require.NoError(t, err)
+			require.True(t, valid)
+			require.Equal(t, whv1.RegistrySpec{Registry: "testRegistry1", SignCheck: true}, policy)
+		})
+	}
+}
+
+func TestRegistryPolicyCache_doesMatchPolicy_evaluationOrder(t *testing.T) {
+	clusterPolicy := func(name string, specs ...whv1.RegistrySpec) runtime.Object {
+		return &whv1.ClusterRegistrySecurityPolicy{ObjectMeta: metav1.ObjectMeta{Name: name}, Spec: whv1.ClusterRegistrySecurityPolicySpec{Registries: specs}}
+	}
+	clusterCachedClient := &fake.CachedClient{Cache: map[string]runtime.Object{
+		"/policy-b": clusterPolicy("policy-b", whv1.RegistrySpec{Registry: "registry.test", Notary: "https://cluster-b"}),
+		"/policy-a": clusterPolicy("policy-a", whv1.RegistrySpec{Registry: "registry.test", Notary: "https://cluster-a"}),
+		"/policy-c": clusterPolicy("policy-c", whv1.RegistrySpec{Registry: "*.example.com", Notary: "https://cluster-wildcard"}),
+	}}
+	namespaceCachedClient := &fake.CachedClient{Cache: map[string]runtime.Object{
+		testCheckSign + "/policy": &whv1.RegistrySecurityPolicy{
+			ObjectMeta: metav1.ObjectMeta{Name: "policy", Namespace: testCheckSign},
+			Spec: whv1.RegistrySecurityPolicySpec{Registries: []whv1.RegistrySpec{
+				{Registry: "*.team.example.com", Notary: "https://namespace-wildcard"},
+				{Registry: "registry.test", Notary: "https://namespace"},
+			}},
+		},
+	}}
+
+	tc := map[string]struct {
+		precedence string
+		registry   string
+
+		expectedNotary string
+	}{
+		"clusterFirstByName": {
+			registry:       "registry.test",
+			expectedNotary: "https://cluster-a",
+		},
+		"namespaceFirst": {
+			precedence:     PolicyPrecedenceNamespaceFirst,
+			registry:       "registry.test",
+			expectedNotary: "https://namespace",
+		},
+		"clusterWildcardOverNamespaceWildcard": {
+			registry:       "harbor.team.example.com",
+			expectedNotary: "https://cluster-wildcard",
+		},
+		"mostSpecificWildcard": {
+			precedence:     PolicyPrecedenceNamespaceFirst,
+			registry:       "harbor.team.example.com",
+			expectedNotary: "https://namespace-wildcard",
+		},
+		"lessSpecificWildcard": {
+			precedence:     PolicyPrecedenceNamespaceFirst,
+			registry:       "harbor.example.com",
+			expectedNotary: "https://cluster-wildcard",
+		},
+	}
+
+	for name, c := range tc {
+		t.Run(name, func(t *testing.T) {
+			cache := RegistryPolicyCache{restClient: testPolicyRestClient(), precedence: c.precedence, clusterCachedClient: clusterCachedClient, namespaceCachedClient: namespaceCachedClient}
+			// The same spec is selected every time, regardless of the order the policies are listed
+			for i := 0; i < 20; i++ {
+				valid, policy, err := cache.doesMatchPolicy(c.registry, testCheckSign)
+				require.NoError(t, err)
+				require.True(t, valid)
+				require.Equal(t, c.expectedNotary, policy.Notary)
+			}
+		})
+	}
+}
+
+func TestRegistryPolicyCache_doesMatchPolicy_namespaceTightens(t *testing.T) {
+	clusterCachedClient := &fake.CachedClient{Cache: map[string]runtime.Object{
+		"/policy": &whv1.ClusterRegistrySecurityPolicy{
+			ObjectMeta: metav1.ObjectMeta{Name: "policy"},
+			Spec: whv1.ClusterRegistrySecurityPolicySpec{Registries: []whv1.RegistrySpec{
+				{Registry: "*.corp.com", Notary: "https://notary.corp.com", SignCheck: true},
+				{Registry: "public.example.com"},
+			}},
+		},
+	}}
+	namespaceCachedClient := &fake.CachedClient{Cache: map[string]runtime.Object{
+		testCheckSign + "/policy": &whv1.RegistrySecurityPolicy{
+			ObjectMeta: metav1.ObjectMeta{Name: "policy", Namespace: testCheckSign},
+			Spec: whv1.RegistrySecurityPolicySpec{Registries: []whv1.RegistrySpec{
+				{Registry: "registry.corp.com", Notary: "https://notary.tenant", SignCheck: false, Signer: []string{"tenant"}},
+				{Registry: "public.example.com", Notary: "https://notary.tenant", SignCheck: true, SignerThreshold: 2},
+			}},
+		},
+	}}
+
+	tc := map[string]struct {
+		precedence string
+		registry   string
+
+		expectedPolicy whv1.RegistrySpec
+	}{
+		"clusterWildcardOverNamespaceExact": {
+			registry:       "registry.corp.com",
+			expectedPolicy: whv1.RegistrySpec{Registry: "*.corp.com", Notary: "https://notary.corp.com", SignCheck: true, Signer: []string{"tenant"}},
+		},
+		"namespaceTurnsOnSignCheck": {
+			registry:       "public.example.com",
+			expectedPolicy: whv1.RegistrySpec{Registry: "public.example.com", Notary: "https://notary.tenant", SignCheck: true, SignerThreshold: 2},
+		},
+		"namespaceFirst": {
+			precedence:     PolicyPrecedenceNamespaceFirst,
+			registry:       "registry.corp.com",
+			expectedPolicy: whv1.RegistrySpec{Registry: "registry.corp.com", Notary: "https://notary.tenant", SignCheck: false, Signer: []string{"tenant"}},
+		},
+	}
+
+	for name, c := range tc {
+		t.Run(name, func(t *testing.T) {
+			cache := RegistryPolicyCache{restClient: testPolicyRestClient(), precedence: c.precedence, clusterCachedClient: clusterCachedClient, namespaceCachedClient: namespaceCachedClient}
+			valid, policy, err := cache.doesMatchPolicy(c.registry, testCheckSign)
+			require.NoError(t, err)
+			require.True(t, valid)
+			require.Equal(t, c.expectedPolicy, policy)
+		})
+	}
+}
+
+func TestRegistryPolicyCache_doesMatchPolicy_proxyAuthSecret(t *testing.T) {
+	cache := RegistryPolicyCache{restClient: testPolicyRestClient(), clusterCachedClient: &fake.CachedClient{Cache: map[string]runtime.Object{
+		"/policy": &whv1.ClusterRegistrySecurityPolicy{
+			ObjectMeta: metav1.ObjectMeta{Name: "policy"},
+			Spec:       whv1.ClusterRegistrySecurityPolicySpec{Registries: []whv1.RegistrySpec{{Registry: "cluster.test", SignCheck: true, ProxyAuthSecret: "proxy-auth"}}},
+		},
+	}}, namespaceCachedClient: &fake.CachedClient{Cache: map[string]runtime.Object{
+		testCheckSign + "/policy": &whv1.RegistrySecurityPolicy{
+			ObjectMeta: metav1.ObjectMeta{Name: "policy", Namespace: testCheckSign},
+			Spec:       whv1.RegistrySecurityPolicySpec{Registries: []whv1.RegistrySpec{{Registry: "namespace.test", SignCheck: true, ProxyAuthSecret: "proxy-auth"}}},
+		},
+	}}}
+
+	_, policy, err := cache.doesMatchPolicy("cluster.test", testCheckSign)
+	require.NoError(t, err)
+	require.Equal(t, "proxy-auth", policy.ProxyAuthSecret)
+
+	// The namespaces can't use the secrets of registry-system namespace
+	_, policy, err = cache.doesMatchPolicy("namespace.test", testCheckSign)
+	require.NoError(t, err)
+	require.Equal(t, whv1.RegistrySpec{Registry: "namespace.test", SignCheck: true}, policy)
+}
+
+func TestRegistryPolicyCache_doesMatchPolicy_noNamespace(t *testing.T) {
+	cache := RegistryPolicyCache{restClient: testPolicyRestClient(), clusterCachedClient: &fake.CachedClient{}, namespaceCachedClient: &fake.CachedClient{Cache: map[string]runtime.Object{
+		testCheckSign + "/policy": &whv1.RegistrySecurityPolicy{
+			ObjectMeta: metav1.ObjectMeta{Name: "policy", Namespace: testCheckSign},
+			Spec:       whv1.RegistrySecurityPolicySpec{Registries: []whv1.RegistrySpec{{Registry: "registry.test"}}},
+		},
+	}}}
+
+	// The policies of the namespaces are not matched, so the default policy (i.e., Deny) decides as if there's no policy at all
+	valid, policy, err := cache.doesMatchPolicy("registry.test", "")
+	require.NoError(t, err)
+	require.False(t, valid)
+	require.Equal(t, whv1.RegistrySpec{}, policy)
+}
+
+func TestRegistryPolicyCache_doesMatchPolicy_listFailed(t *testing.T) {
+	cache := RegistryPolicyCache{restClient: testPolicyRestClient(), clusterCachedClient: &failingCachedClient{}, namespaceCachedClient: &fake.CachedClient{}}
+
+	valid, _, err := cache.doesMatchPolicy("testRegistry1", testCheckSign)
+	require.Error(t, err)
+	require.False(t, valid)
+}
+
+// failingCachedClient is a watcher.CachedClient which always fails
+type failingCachedClient struct{}
+
+func (c *failingCachedClient) Get(_ types.NamespacedName, _ runtime.Object) error {
+	return fmt.Errorf("connection refused")
+}
+
+func (c *failingCachedClient) List(_ watcher.Selector, _ runtime.Object) error {
+	return fmt.Errorf("connection refused")
+}
+
 func testPolicyRestClient() *restfake.RESTClient {
 	_ = whv1.AddToScheme(scheme.Scheme)
 	return &restfake.RESTClient{
@@ -0,0 +1,88 @@
+package pods
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"strings"
+
+	cosigns "github.com/tmax-cloud/image-validating-webhook/pkg/cosign"
+	whv1 "github.com/tmax-cloud/image-validating-webhook/pkg/type"
+	"github.com/tmax-cloud/image-validating-webhook/pkg/watcher"
+)
+
+// reportPolicyProblems checks all the registry security policies loaded at startup, logging the malformed ones.
+// Misconfigured policies are still used, but they are caught at boot rather than by confusing admission results
+func (h *validator) reportPolicyProblems() {
+	problems, err := h.checkPolicies()
+	if err != nil {
+		policylog.Error(err, "couldn't check registry security policies")
+		return
+	}
+	for _, p := range problems {
+		policylog.Error(fmt.Errorf("%s", p), "malformed registry security policy")
+	}
+	if len(problems) == 0 {
+		policylog.Info("all registry security policies are well-formed")
+	}
+}
+
+// checkPolicies checks the registries of all the cluster/namespace registry security policies,
+// returning the problems prefixed with the policy and the registry
+func (h *validator) checkPolicies() ([]string, error) {
+	clusterObjs := &whv1.ClusterRegistrySecurityPolicyList{}
+	namespaceObjs := &whv1.RegistrySecurityPolicyList{}
+
+	if err := h.registryPolicyCache.clusterCachedClient.List(watcher.Selector{}, clusterObjs); err != nil {
+		return nil, err
+	}
+	if err := h.registryPolicyCache.namespaceCachedClient.List(watcher.Selector{}, namespaceObjs); err != nil {
+		return nil, err
+	}
+
+	var problems []string
+	for _, p := range clusterObjs.Items {
+		for _, spec := range p.Spec.Registries {
+			for _, problem := range h.checkRegistrySpec(spec) {
+				problems = append(problems, fmt.Sprintf("ClusterRegistrySecurityPolicy %s, registry '%s': %s", p.Name, spec.Registry, problem))
+			}
+		}
+	}
+	for _, p := range namespaceObjs.Items {
+		for _, spec := range p.Spec.Registries {
+			for _, problem := range h.checkRegistrySpec(spec) {
+				problems = append(problems, fmt.Sprintf("RegistrySecurityPolicy %s/%s, registry '%s': %s", p.Namespace, p.Name, spec.Registry, problem))
+			}
+		}
+	}
+	return problems, nil
+}
+
+// checkRegistrySpec checks the registry spec is well-formed and the cosign key it refers to exists
+func (h *validator) checkRegistrySpec(spec whv1.RegistrySpec) []string {
+	var problems []string
+	if spec.Registry == "" {
+		problems = append(problems, "registry is empty")
+	}
+	for _, pattern := range append([]string{spec.Registry}, spec.Aliases...) {
+		if strings.Contains(strings.TrimPrefix(pattern, "*."), "*") {
+			problems = append(problems, fmt.Sprintf("registry '%s' has a wildcard other than the leftmost label (e.g., *.example.com)", pattern))
+		}
+	}
+	if spec.Notary != "" {
+		if u, err := url.Parse(spec.Notary); err != nil || u.Scheme == "" || u.Host == "" {
+			problems = append(problems, fmt.Sprintf("notary server '%s' is not a valid URL", spec.Notary))
+		}
+	} else if spec.SignCheck && h.opts.DisableDefaultNotary && h.opts.NotarySocket == "" {
+		problems = append(problems, "there is no notary server, and falling back to docker hub's notary server is disabled")
+	}
+	if spec.SignerThreshold > len(spec.Signer) {
+		problems = append(problems, fmt.Sprintf("signerThreshold %d exceeds the number of the signers %d", spec.SignerThreshold, len(spec.Signer)))
+	}
+	if spec.CosignKeyRef != "" {
+		if _, err := cosigns.GetKeyPairSecret(context.TODO(), h.client, spec.CosignKeyRef); err != nil {
+			problems = append(problems, fmt.Sprintf("cosign key '%s' is not found: %s", spec.CosignKeyRef, err))
+		}
+	}
+	return problems
+}
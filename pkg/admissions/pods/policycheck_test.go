@@ -0,0 +1,53 @@
+package pods
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	whv1 "github.com/tmax-cloud/image-validating-webhook/pkg/type"
+	watcherfake "github.com/tmax-cloud/image-validating-webhook/pkg/watcher/fake"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestValidator_checkPolicies(t *testing.T) {
+	cli := fake.NewSimpleClientset(&corev1.Secret{ObjectMeta: metav1.ObjectMeta{Name: "cosign-key", Namespace: "cosign"}})
+
+	v := &validator{client: cli, opts: Options{DisableDefaultNotary: true}}
+	v.registryPolicyCache = &RegistryPolicyCache{
+		clusterCachedClient: &watcherfake.CachedClient{
+			Cache: map[string]runtime.Object{
+				"/cluster-policy": &whv1.ClusterRegistrySecurityPolicy{
+					ObjectMeta: metav1.ObjectMeta{Name: "cluster-policy"},
+					Spec: whv1.ClusterRegistrySecurityPolicySpec{Registries: []whv1.RegistrySpec{
+						{Registry: "registry.test", Notary: "https://notary.test", SignCheck: true, Signer: []string{"signer-a"}, CosignKeyRef: "k8s://cosign/cosign-key"},
+						{Registry: "", Notary: "notary.test"},
+						{Registry: "registry-*.test", Aliases: []string{"*.registry.internal"}, Notary: "https://notary.test"},
+					}},
+				},
+			},
+		},
+		namespaceCachedClient: &watcherfake.CachedClient{
+			Cache: map[string]runtime.Object{
+				testCheckSign + "/policy": &whv1.RegistrySecurityPolicy{
+					ObjectMeta: metav1.ObjectMeta{Name: "policy", Namespace: testCheckSign},
+					Spec: whv1.RegistrySecurityPolicySpec{Registries: []whv1.RegistrySpec{
+						{Registry: "registry.test", SignCheck: true, Signer: []string{"signer-a"}, SignerThreshold: 2, CosignKeyRef: "k8s://cosign/not-exist"},
+					}},
+				},
+			},
+		},
+	}
+
+	problems, err := v.checkPolicies()
+	require.NoError(t, err)
+	require.Len(t, problems, 6)
+	require.Equal(t, "ClusterRegistrySecurityPolicy cluster-policy, registry '': registry is empty", problems[0])
+	require.Equal(t, "ClusterRegistrySecurityPolicy cluster-policy, registry '': notary server 'notary.test' is not a valid URL", problems[1])
+	require.Equal(t, "ClusterRegistrySecurityPolicy cluster-policy, registry 'registry-*.test': registry 'registry-*.test' has a wildcard other than the leftmost label (e.g., *.example.com)", problems[2])
+	require.Equal(t, "RegistrySecurityPolicy "+testCheckSign+"/policy, registry 'registry.test': there is no notary server, and falling back to docker hub's notary server is disabled", problems[3])
+	require.Equal(t, "RegistrySecurityPolicy "+testCheckSign+"/policy, registry 'registry.test': signerThreshold 2 exceeds the number of the signers 1", problems[4])
+	require.Contains(t, problems[5], "cosign key 'k8s://cosign/not-exist' is not found")
+}
@@ -0,0 +1,57 @@
+package pods
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/tmax-cloud/image-validating-webhook/internal/k8s"
+	"github.com/tmax-cloud/image-validating-webhook/pkg/watcher"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/rest"
+)
+
+// proxyAuthSecretKey is the key of the proxy auth secret of a registry, whose value is the Proxy-Authorization header
+const proxyAuthSecretKey = "proxy-authorization"
+
+// ProxyAuthSecrets stores the proxy auth secrets of registry-system namespace, read from the secret cache
+type ProxyAuthSecrets struct {
+	cachedClient watcher.CachedClient
+}
+
+func newProxyAuthSecrets(cfg *rest.Config) (*ProxyAuthSecrets, error) {
+	watchCli, err := k8s.NewGroupVersionClient(cfg, corev1.SchemeGroupVersion)
+	if err != nil {
+		return nil, err
+	}
+
+	w := watcher.New(registryNamespace, string(corev1.ResourceSecrets), &corev1.Secret{}, watchCli, fields.Everything())
+	ps := &ProxyAuthSecrets{cachedClient: watcher.NewCachedClient(w)}
+
+	waitCh := make(chan struct{})
+
+	// Start to watch secrets of registry-system namespace
+	go w.Start(waitCh)
+
+	// Block until it's ready
+	<-waitCh
+
+	return ps, nil
+}
+
+// authorization returns the Proxy-Authorization header of the proxy auth secret
+func (p *ProxyAuthSecrets) authorization(name string) (string, error) {
+	if p == nil {
+		return "", fmt.Errorf("couldn't get proxy auth secret named %s, as the proxy auth secrets are not watched", name)
+	}
+	secret := &corev1.Secret{}
+	if err := p.cachedClient.Get(types.NamespacedName{Namespace: registryNamespace, Name: name}, secret); err != nil {
+		return "", fmt.Errorf("couldn't get proxy auth secret named %s by %s", name, err)
+	}
+	authorization, exist := secret.Data[proxyAuthSecretKey]
+	if !exist {
+		return "", fmt.Errorf("proxy auth secret named %s has no %s", name, proxyAuthSecretKey)
+	}
+	return strings.TrimSpace(string(authorization)), nil
+}
@@ -0,0 +1,28 @@
+package pods
+
+import (
+	"fmt"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+// decidePullNever decides the container whose imagePullPolicy is Never by the pull-never policy, if the image is to be checked by its signature.
+// It returns false as not decided if the image is to be validated as usual
+func (h *validator) decidePullNever(container *corev1.Container) (bool, bool) {
+	if container.ImagePullPolicy != corev1.PullNever {
+		return false, false
+	}
+	switch h.opts.PullNeverPolicy {
+	case PullNeverPolicyAllow:
+		validatorLog.Info("allowing the image which is not pulled, trusting the node's local content", "image", container.Image)
+		return true, true
+	case PullNeverPolicyDeny:
+		return true, false
+	}
+	return false, false
+}
+
+// pullNeverReason returns why the image which is not pulled is denied by the pull-never policy Deny
+func pullNeverReason(image string) string {
+	return fmt.Sprintf("Image '%s' is not pulled (imagePullPolicy Never), so it's denied in the registry which requires signatures", image)
+}
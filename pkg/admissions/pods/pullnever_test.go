@@ -0,0 +1,102 @@
+package pods
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"github.com/tmax-cloud/image-validating-webhook/pkg/notary"
+	whv1 "github.com/tmax-cloud/image-validating-webhook/pkg/type"
+	corev1 "k8s.io/api/core/v1"
+)
+
+func TestValidator_CheckIsValidAndAddDigest_pullNever(t *testing.T) {
+	const registry = "registry.test"
+	digest := strings.Repeat("1", 64)
+
+	tc := map[string]struct {
+		pullNeverPolicy string
+		image           string
+		pullPolicy      corev1.PullPolicy
+		signCheck       bool
+		cosignKeyRef    string
+
+		expectedValid  bool
+		expectedReason string
+		expectedImage  string
+	}{
+		"validate": {
+			pullNeverPolicy: PullNeverPolicyValidate,
+			image:           registry + "/image:signed",
+			pullPolicy:      corev1.PullNever,
+			signCheck:       true,
+			expectedValid:   true,
+			expectedImage:   registry + "/image:signed@sha256:" + digest,
+		},
+		"validateNotSigned": {
+			image:          registry + "/image:not-signed",
+			pullPolicy:     corev1.PullNever,
+			signCheck:      true,
+			expectedReason: fmt.Sprintf("Notary: Image '%s/image:not-signed' is not signed", registry),
+		},
+		"allow": {
+			pullNeverPolicy: PullNeverPolicyAllow,
+			image:           registry + "/image:not-signed",
+			pullPolicy:      corev1.PullNever,
+			signCheck:       true,
+			expectedValid:   true,
+		},
+		"allowPulled": {
+			pullNeverPolicy: PullNeverPolicyAllow,
+			image:           registry + "/image:not-signed",
+			pullPolicy:      corev1.PullIfNotPresent,
+			signCheck:       true,
+			expectedReason:  fmt.Sprintf("Notary: Image '%s/image:not-signed' is not signed", registry),
+		},
+		"deny": {
+			pullNeverPolicy: PullNeverPolicyDeny,
+			image:           registry + "/image:signed",
+			pullPolicy:      corev1.PullNever,
+			signCheck:       true,
+			expectedReason:  fmt.Sprintf("Notary: Image '%s/image:signed' is not pulled (imagePullPolicy Never), so it's denied in the registry which requires signatures", registry),
+		},
+		"denyCosign": {
+			pullNeverPolicy: PullNeverPolicyDeny,
+			image:           registry + "/image:signed",
+			pullPolicy:      corev1.PullNever,
+			signCheck:       true,
+			cosignKeyRef:    "cosign-key",
+			expectedReason: fmt.Sprintf("Notary: Image '%s/image:signed' is not pulled (imagePullPolicy Never), so it's denied in the registry which requires signatures\n", registry) +
+				fmt.Sprintf("Cosign: Image '%s/image:signed' is not pulled (imagePullPolicy Never), so it's denied in the registry which requires signatures", registry),
+		},
+		"denyWithoutSignCheck": {
+			pullNeverPolicy: PullNeverPolicyDeny,
+			image:           registry + "/image:not-signed",
+			pullPolicy:      corev1.PullNever,
+			expectedValid:   true,
+		},
+	}
+
+	for caseName, c := range tc {
+		t.Run(caseName, func(t *testing.T) {
+			v := testCachedSignatureValidator(whv1.RegistrySpec{Registry: registry, Notary: "https://notary.test", SignCheck: c.signCheck, CosignKeyRef: c.cosignKeyRef}, registry+"/image:signed",
+				notary.SignedTag{SignedTag: "signed", Digest: digest, Algorithm: "sha256", Signers: []string{"Repo Admin"}})
+			v.signatureCache.Set(registry+"/image:not-signed", "https://notary.test", nil, time.Minute)
+			v.opts.PullNeverPolicy = c.pullNeverPolicy
+
+			pod := generateTestPod(c.image, testCheckSign, "")
+			pod.Spec.Containers[0].ImagePullPolicy = c.pullPolicy
+			valid, reason, err := v.CheckIsValidAndAddDigest(pod)
+			require.NoError(t, err)
+			require.Equal(t, c.expectedValid, valid)
+			require.Equal(t, c.expectedReason, reason)
+			expectedImage := c.expectedImage
+			if expectedImage == "" {
+				expectedImage = c.image
+			}
+			require.Equal(t, expectedImage, pod.Spec.Containers[0].Image)
+		})
+	}
+}
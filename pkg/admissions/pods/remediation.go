@@ -0,0 +1,117 @@
+package pods
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Deny message verbosities, deciding what the messages of the denied pods tell
+const (
+	// DenyMessageVerbosityReason tells only why the images are denied
+	DenyMessageVerbosityReason = "Reason"
+	// DenyMessageVerbosityHint tells how to fix the common failures, as well as why the images are denied
+	DenyMessageVerbosityHint = "Hint"
+)
+
+// denyReason is the kind of the common failures, which have the remediation hints
+type denyReason int
+
+const (
+	denyReasonUnknown denyReason = iota
+	denyReasonNotSigned
+	denyReasonSignerMismatch
+	denyReasonNoPolicy
+	denyReasonDigestMismatch
+	denyReasonSignerThreshold
+	denyReasonNoCredential
+	denyReasonCosignNotSigned
+	denyReasonNoSigner
+	denyReasonAdminKeyMismatch
+	denyReasonMalformedTrustData
+	denyReasonSignatureTooOld
+	denyReasonNotReleased
+	denyReasonManifestMismatch
+	denyReasonMissingArchitectures
+	denyReasonLabeledDigestMismatch
+)
+
+// remediationHints are the hints of the common failures, formatted with the subjects of their reasons
+var remediationHints = map[denyReason]string{
+	denyReasonNotSigned:       "Sign the image by `docker trust sign %s`",
+	denyReasonSignerMismatch:  "Sign the image %s by one of the signers of the RegistrySecurityPolicy, or add its signer to the signer of the RegistrySecurityPolicy",
+	denyReasonNoPolicy:        "Create a RegistrySecurityPolicy for the registry of the image %s, or add the image to the whitelist",
+	denyReasonDigestMismatch:  "Refer to the image %s by its signed tag, or sign its digest by `docker trust sign`",
+	denyReasonSignerThreshold: "Sign the image by more of the signers of the RegistrySecurityPolicy, e.g., `docker trust sign %s` by each of them",
+	denyReasonNoCredential:    "Add an imagePullSecret of the registry %s to the pod or its service account",
+	denyReasonCosignNotSigned: "Sign the image by `cosign sign %s` with the key pair of the cosignKeyRef of the RegistrySecurityPolicy",
+	denyReasonNoSigner:        "Add the signers of the image %s to the signer of the RegistrySecurityPolicy",
+	// The subject is the observed administrative key IDs
+	denyReasonAdminKeyMismatch:      "Add the administrative key IDs %s to the adminKeys of the RegistrySecurityPolicy, if the repository is trusted",
+	denyReasonMalformedTrustData:    "Re-sign the image by `docker trust sign %s`, as its trust data is malformed",
+	denyReasonSignatureTooOld:       "Re-sign the image by `docker trust sign %s`, or raise the maxSignatureAge of the RegistrySecurityPolicy",
+	denyReasonNotReleased:           "Sign the image by `docker trust sign %s` into targets/releases, or add the signers' role to the releaseRoles of the RegistrySecurityPolicy",
+	denyReasonManifestMismatch:      "Push the signed manifest to the tag of the image %s again, or sign the pushed manifest by `docker trust sign`",
+	denyReasonMissingArchitectures:  "Sign the manifest list of the image %s, which has all of the required architectures of the RegistrySecurityPolicy",
+	denyReasonLabeledDigestMismatch: "Refer to the image %s by its tag, or by the digest of its manifest which has the trusted labels",
+}
+
+// deniedReason is why an image is denied, with the kind of its failure.
+// The subject is what the remediation hint is about, i.e., the image or the registry host
+type deniedReason struct {
+	kind    denyReason
+	subject string
+	reason  string
+}
+
+// hint returns how to fix the failure, or empty if it's not a common one
+func (r deniedReason) hint() string {
+	hint, exist := remediationHints[r.kind]
+	if !exist {
+		return ""
+	}
+	return fmt.Sprintf(hint, r.subject)
+}
+
+// denyHints are the remediation hints of the denied images by their reasons, recorded as they're denied
+type denyHints map[string]string
+
+// record records the hint of the denied reason, returning its reason. Nil records nothing
+func (h denyHints) record(denied deniedReason) string {
+	if hint := denied.hint(); h != nil && denied.reason != "" && hint != "" {
+		h[denied.reason] = hint
+	}
+	return denied.reason
+}
+
+// deny returns the reason of the denied image, recording its remediation hint if the validator records them
+func (h *validator) deny(kind denyReason, subject, reason string) string {
+	return h.denyHints.record(deniedReason{kind: kind, subject: subject, reason: reason})
+}
+
+// recordingDenyHints returns the validator recording the remediation hints of the images it denies, and the recorded hints.
+// The validators other than the webhook's own record none
+func recordingDenyHints(v Validator) (Validator, denyHints) {
+	hints := denyHints{}
+	h, ok := v.(*validator)
+	if !ok {
+		return v, hints
+	}
+	recording := *h
+	recording.denyHints = hints
+	return &recording, hints
+}
+
+// denyMessage returns the message of the pod denied by the reasons (one per line), adding their remediation hints if the verbosity is Hint
+func denyMessage(reasons string, hints denyHints, verbosity string) string {
+	if verbosity != DenyMessageVerbosityHint {
+		return fmt.Sprintf("Pod is not valid: \n%s", reasons)
+	}
+	var lines []string
+	for _, reason := range strings.Split(reasons, "\n") {
+		lines = append(lines, reason)
+		if hint, exist := hints[reason]; exist {
+			lines = append(lines, "Hint: "+hint)
+		}
+	}
+	return fmt.Sprintf("Pod is not valid: \n%s", strings.Join(lines, "\n"))
+}
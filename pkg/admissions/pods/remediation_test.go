@@ -0,0 +1,183 @@
+package pods
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	whv1 "github.com/tmax-cloud/image-validating-webhook/pkg/type"
+)
+
+func TestDeniedReason_hint(t *testing.T) {
+	const img = "registry.test/image:v1"
+
+	tc := map[string]struct {
+		denied deniedReason
+
+		expectedHint string
+	}{
+		"notSigned": {
+			denied:       deniedReason{kind: denyReasonNotSigned, subject: img},
+			expectedHint: "Sign the image by `docker trust sign registry.test/image:v1`",
+		},
+		"signerMismatch": {
+			denied:       deniedReason{kind: denyReasonSignerMismatch, subject: img},
+			expectedHint: "Sign the image registry.test/image:v1 by one of the signers of the RegistrySecurityPolicy, or add its signer to the signer of the RegistrySecurityPolicy",
+		},
+		"noPolicy": {
+			denied:       deniedReason{kind: denyReasonNoPolicy, subject: img},
+			expectedHint: "Create a RegistrySecurityPolicy for the registry of the image registry.test/image:v1, or add the image to the whitelist",
+		},
+		"digestMismatch": {
+			denied:       deniedReason{kind: denyReasonDigestMismatch, subject: img},
+			expectedHint: "Refer to the image registry.test/image:v1 by its signed tag, or sign its digest by `docker trust sign`",
+		},
+		"signerThreshold": {
+			denied:       deniedReason{kind: denyReasonSignerThreshold, subject: img},
+			expectedHint: "Sign the image by more of the signers of the RegistrySecurityPolicy, e.g., `docker trust sign registry.test/image:v1` by each of them",
+		},
+		"noCredential": {
+			denied:       deniedReason{kind: denyReasonNoCredential, subject: "registry.test"},
+			expectedHint: "Add an imagePullSecret of the registry registry.test to the pod or its service account",
+		},
+		"cosignNotSigned": {
+			denied:       deniedReason{kind: denyReasonCosignNotSigned, subject: img},
+			expectedHint: "Sign the image by `cosign sign registry.test/image:v1` with the key pair of the cosignKeyRef of the RegistrySecurityPolicy",
+		},
+		"adminKeyMismatch": {
+			denied:       deniedReason{kind: denyReasonAdminKeyMismatch, subject: "abc, def"},
+			expectedHint: "Add the administrative key IDs abc, def to the adminKeys of the RegistrySecurityPolicy, if the repository is trusted",
+		},
+		"signatureTooOld": {
+			denied:       deniedReason{kind: denyReasonSignatureTooOld, subject: img},
+			expectedHint: "Re-sign the image by `docker trust sign registry.test/image:v1`, or raise the maxSignatureAge of the RegistrySecurityPolicy",
+		},
+		"notReleased": {
+			denied:       deniedReason{kind: denyReasonNotReleased, subject: img},
+			expectedHint: "Sign the image by `docker trust sign registry.test/image:v1` into targets/releases, or add the signers' role to the releaseRoles of the RegistrySecurityPolicy",
+		},
+		"unknown": {
+			denied: deniedReason{subject: img},
+		},
+	}
+
+	for caseName, c := range tc {
+		t.Run(caseName, func(t *testing.T) {
+			require.Equal(t, c.expectedHint, c.denied.hint())
+		})
+	}
+}
+
+func TestDenyMessage(t *testing.T) {
+	reasons := "Notary: Image 'registry.test/image:v1' is not signed\nImage 'registry.test/other:v1' has the digest sha256:1234 denied by the deny list"
+	hints := denyHints{"Notary: Image 'registry.test/image:v1' is not signed": "Sign the image by `docker trust sign registry.test/image:v1`"}
+
+	tc := map[string]struct {
+		verbosity string
+
+		expectedMessage string
+	}{
+		"default": {
+			expectedMessage: "Pod is not valid: \n" + reasons,
+		},
+		"reason": {
+			verbosity:       DenyMessageVerbosityReason,
+			expectedMessage: "Pod is not valid: \n" + reasons,
+		},
+		"hint": {
+			verbosity: DenyMessageVerbosityHint,
+			expectedMessage: "Pod is not valid: \nNotary: Image 'registry.test/image:v1' is not signed\nHint: Sign the image by `docker trust sign registry.test/image:v1`\n" +
+				"Image 'registry.test/other:v1' has the digest sha256:1234 denied by the deny list",
+		},
+	}
+
+	for caseName, c := range tc {
+		t.Run(caseName, func(t *testing.T) {
+			require.Equal(t, c.expectedMessage, denyMessage(reasons, hints, c.verbosity))
+		})
+	}
+}
+
+func TestValidator_CheckIsValidAndAddDigest_denyHints(t *testing.T) {
+	const registry = "registry.test"
+	img := registry + "/image:v1"
+	otherImg := "other.test/image:v1"
+
+	tc := map[string]struct {
+		img      string
+		verifier *stubVerifier
+
+		expectedHints denyHints
+	}{
+		"notSigned": {
+			img:           img,
+			verifier:      &stubVerifier{err: &notSignedError{}},
+			expectedHints: denyHints{"Notary: Image '" + img + "' is not signed": "Sign the image by `docker trust sign " + img + "`"},
+		},
+		"signerMismatch": {
+			img:      img,
+			verifier: &stubVerifier{err: &notSignedError{signed: true}},
+			expectedHints: denyHints{"Notary: Image '" + img + "'s signer is invalid": "Sign the image " + img +
+				" by one of the signers of the RegistrySecurityPolicy, or add its signer to the signer of the RegistrySecurityPolicy"},
+		},
+		"signerThreshold": {
+			img:      img,
+			verifier: &stubVerifier{err: &deniedError{reason: "Stub: signed by 1 of the signers", kind: denyReasonSignerThreshold}},
+			expectedHints: denyHints{"Stub: signed by 1 of the signers": "Sign the image by more of the signers of the RegistrySecurityPolicy, e.g., `docker trust sign " + img +
+				"` by each of them"},
+		},
+		"adminKeyMismatch": {
+			img:           img,
+			verifier:      &stubVerifier{err: &deniedError{reason: "Stub: admin keys", kind: denyReasonAdminKeyMismatch, subject: "abc, def"}},
+			expectedHints: denyHints{"Stub: admin keys": "Add the administrative key IDs abc, def to the adminKeys of the RegistrySecurityPolicy, if the repository is trusted"},
+		},
+		"unknown": {
+			img:           img,
+			verifier:      &stubVerifier{err: &deniedError{reason: "Stub: denied"}},
+			expectedHints: denyHints{},
+		},
+		"noPolicyNotaryAndCosign": {
+			img: otherImg,
+			expectedHints: denyHints{
+				"Notary: Image '" + otherImg + "' does not meet registry security policy. Please check the RegistrySecurityPolicy": "Create a RegistrySecurityPolicy for the registry of the image " + otherImg + ", or add the image to the whitelist",
+				"Cosign: Image '" + otherImg + "' does not meet registry security policy. Please check the RegistrySecurityPolicy": "Create a RegistrySecurityPolicy for the registry of the image " + otherImg + ", or add the image to the whitelist",
+			},
+		},
+	}
+
+	for caseName, c := range tc {
+		t.Run(caseName, func(t *testing.T) {
+			v := testCachedSignatureValidator(whv1.RegistrySpec{Registry: registry, Notary: "https://notary.test", SignCheck: true}, img)
+			if c.verifier != nil {
+				v.verifier = c.verifier
+			}
+
+			recording, hints := recordingDenyHints(v)
+			valid, _, err := recording.CheckIsValidAndAddDigest(generateTestPod(c.img, testCheckSign, ""))
+			require.NoError(t, err)
+			require.False(t, valid)
+			require.Equal(t, c.expectedHints, hints)
+			require.Nil(t, v.denyHints, "the shared validator records none")
+		})
+	}
+}
+
+func TestValidator_CheckIsValidAndAddDigest_deniedImageHint(t *testing.T) {
+	const registry = "registry.test"
+	img := registry + "/image:v1"
+
+	v := testCachedSignatureValidator(whv1.RegistrySpec{Registry: registry, Notary: "https://notary.test", SignCheck: true}, img)
+	v.deniedImages = newDeniedImageCache(time.Minute)
+	verifier := &stubVerifier{err: &notSignedError{}}
+	v.verifier = verifier
+
+	// The denial replayed from the cache has the hint of the denial verified
+	for i := 0; i < 2; i++ {
+		recording, hints := recordingDenyHints(v)
+		valid, reason, err := recording.CheckIsValidAndAddDigest(generateTestPod(img, testCheckSign, ""))
+		require.NoError(t, err)
+		require.False(t, valid)
+		require.Equal(t, "Sign the image by `docker trust sign "+img+"`", hints[reason])
+	}
+	require.Len(t, verifier.verified, 1)
+}
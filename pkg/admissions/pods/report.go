@@ -0,0 +1,232 @@
+package pods
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/tmax-cloud/image-validating-webhook/pkg/server"
+	corev1 "k8s.io/api/core/v1"
+	utilyaml "k8s.io/apimachinery/pkg/util/yaml"
+)
+
+// Report formats
+const (
+	// ReportFormatJSON is the ValidationReport as it is
+	ReportFormatJSON = "json"
+	// ReportFormatSARIF is the SARIF 2.1.0 log, whose results are the pods not passed
+	ReportFormatSARIF = "sarif"
+)
+
+const (
+	sarifVersion = "2.1.0"
+	sarifSchema  = "https://json.schemastore.org/sarif-2.1.0.json"
+	sarifRuleID  = "image-validation"
+)
+
+// ValidationReport is the report of the pods validated without admitting them (e.g., in CI), for the dashboards
+type ValidationReport struct {
+	Pods []PodReport `json:"pods"`
+	// Passed is the number of the pods passed
+	Passed int `json:"passed"`
+	// Failed is the number of the pods not passed, including the ones failed to be validated
+	Failed int `json:"failed"`
+}
+
+// PodReport is the validation result of a pod
+type PodReport struct {
+	Namespace string `json:"namespace"`
+	Name      string `json:"name"`
+	Passed    bool   `json:"passed"`
+	// Reason is why the pod is not passed
+	Reason string `json:"reason,omitempty"`
+	// Error is the internal error occurred while validating the pod
+	Error  string        `json:"error,omitempty"`
+	Images []ImageReport `json:"images"`
+}
+
+// ImageReport is the validated digest and the signers of a container's image. They're empty if it's not validated by its signature
+type ImageReport struct {
+	Container string   `json:"container"`
+	Image     string   `json:"image"`
+	Digest    string   `json:"digest,omitempty"`
+	Signers   []string `json:"signers,omitempty"`
+}
+
+// RunReport validates the pods as the webhook does, without admitting them, and reports the results
+func RunReport(cfg *server.HandlerConfig, pods []*corev1.Pod) (*ValidationReport, error) {
+	v, err := newValidator(cfg.RestCfg, cfg.ClientSet, cfg.RestClient)
+	if err != nil {
+		return nil, err
+	}
+	return newValidationReport(v, pods), nil
+}
+
+// newValidationReport validates the pods in a batch, building the report from their results.
+// The images are reported as they're requested, before they're pinned to the digests
+func newValidationReport(v batchValidator, pods []*corev1.Pod) *ValidationReport {
+	images := make([][]corev1.Container, len(pods))
+	for i, pod := range pods {
+		images[i] = append(append([]corev1.Container{}, pod.Spec.InitContainers...), pod.Spec.Containers...)
+	}
+	results := v.CheckPodsValidAndAddDigest(pods)
+
+	report := &ValidationReport{Pods: []PodReport{}}
+	for i, pod := range pods {
+		r := PodReport{Namespace: pod.Namespace, Name: pod.Name, Passed: results[i].Valid && results[i].Err == nil, Reason: results[i].Reason, Images: []ImageReport{}}
+		if results[i].Err != nil {
+			r.Error = results[i].Err.Error()
+		}
+		validated := &ValidatedImages{}
+		if annotation, exist := pod.Annotations[ValidatedImagesAnnotation]; exist {
+			_ = json.Unmarshal([]byte(annotation), validated)
+		}
+		for _, c := range images[i] {
+			img := ImageReport{Container: c.Name, Image: c.Image}
+			if v, exist := validated.Containers[c.Name]; exist {
+				img.Digest, img.Signers = v.Digest, v.Signers
+			}
+			r.Images = append(r.Images, img)
+		}
+
+		if r.Passed {
+			report.Passed++
+		} else {
+			report.Failed++
+		}
+		report.Pods = append(report.Pods, r)
+	}
+	return report
+}
+
+// WriteReport writes the report in the format, which is one of json and sarif
+func WriteReport(w io.Writer, report *ValidationReport, format string) error {
+	var out interface{}
+	switch format {
+	case ReportFormatJSON:
+		out = report
+	case ReportFormatSARIF:
+		out = newSARIFLog(report)
+	default:
+		return fmt.Errorf("unknown report format %s", format)
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(out)
+}
+
+// sarifLog is the subset of SARIF 2.1.0 log, which is enough to be shown by the code scanning dashboards
+type sarifLog struct {
+	Version string     `json:"version"`
+	Schema  string     `json:"$schema"`
+	Runs    []sarifRun `json:"runs"`
+}
+
+type sarifRun struct {
+	Tool    sarifTool     `json:"tool"`
+	Results []sarifResult `json:"results"`
+}
+
+type sarifTool struct {
+	Driver sarifDriver `json:"driver"`
+}
+
+type sarifDriver struct {
+	Name  string      `json:"name"`
+	Rules []sarifRule `json:"rules"`
+}
+
+type sarifRule struct {
+	ID               string       `json:"id"`
+	ShortDescription sarifMessage `json:"shortDescription"`
+}
+
+type sarifResult struct {
+	RuleID    string          `json:"ruleId"`
+	Level     string          `json:"level"`
+	Message   sarifMessage    `json:"message"`
+	Locations []sarifLocation `json:"locations"`
+}
+
+type sarifMessage struct {
+	Text string `json:"text"`
+}
+
+type sarifLocation struct {
+	LogicalLocations []sarifLogicalLocation `json:"logicalLocations"`
+}
+
+type sarifLogicalLocation struct {
+	FullyQualifiedName string `json:"fullyQualifiedName"`
+	Kind               string `json:"kind"`
+}
+
+// newSARIFLog converts the report to a SARIF log, whose results are the pods not passed, located by <namespace>/<name>
+func newSARIFLog(report *ValidationReport) *sarifLog {
+	run := sarifRun{
+		Tool: sarifTool{Driver: sarifDriver{
+			Name:  "image-validating-webhook",
+			Rules: []sarifRule{{ID: sarifRuleID, ShortDescription: sarifMessage{Text: "Images should be signed by the signers of the registry security policies"}}},
+		}},
+		Results: []sarifResult{},
+	}
+	for _, pod := range report.Pods {
+		if pod.Passed {
+			continue
+		}
+		message := pod.Reason
+		if pod.Error != "" {
+			message = pod.Error
+		}
+		run.Results = append(run.Results, sarifResult{
+			RuleID:    sarifRuleID,
+			Level:     "error",
+			Message:   sarifMessage{Text: message},
+			Locations: []sarifLocation{{LogicalLocations: []sarifLogicalLocation{{FullyQualifiedName: pod.Namespace + "/" + pod.Name, Kind: "pod"}}}},
+		})
+	}
+	return &sarifLog{Version: sarifVersion, Schema: sarifSchema, Runs: []sarifRun{run}}
+}
+
+// ReadPodManifests reads the pods of the manifest files, which may have multiple documents (in YAML or JSON).
+// The documents of the other kinds are skipped, and the pods without namespaces are in the namespace
+func ReadPodManifests(paths []string, namespace string) ([]*corev1.Pod, error) {
+	var pods []*corev1.Pod
+	for _, path := range paths {
+		f, err := os.Open(path)
+		if err != nil {
+			return nil, err
+		}
+		read, err := readPodManifest(f, namespace)
+		_ = f.Close()
+		if err != nil {
+			return nil, fmt.Errorf("couldn't read %s by %s", path, err)
+		}
+		pods = append(pods, read...)
+	}
+	return pods, nil
+}
+
+func readPodManifest(r io.Reader, namespace string) ([]*corev1.Pod, error) {
+	var pods []*corev1.Pod
+	decoder := utilyaml.NewYAMLOrJSONDecoder(r, 4096)
+	for {
+		pod := &corev1.Pod{}
+		err := decoder.Decode(pod)
+		if errors.Is(err, io.EOF) {
+			return pods, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		if pod.Kind != "Pod" {
+			continue
+		}
+		if pod.Namespace == "" {
+			pod.Namespace = namespace
+		}
+		pods = append(pods, pod)
+	}
+}
@@ -0,0 +1,114 @@
+package pods
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"github.com/tmax-cloud/image-validating-webhook/pkg/notary"
+	whv1 "github.com/tmax-cloud/image-validating-webhook/pkg/type"
+	corev1 "k8s.io/api/core/v1"
+)
+
+func testValidationReport() *ValidationReport {
+	const registry = "registry.test"
+	v := testCachedSignatureValidator(whv1.RegistrySpec{Registry: registry, Notary: "https://notary.test", SignCheck: true}, registry+"/image:v1",
+		notary.SignedTag{SignedTag: "v1", Digest: strings.Repeat("1", 64), Algorithm: "sha256", Signers: []string{"Repo Admin"}})
+	v.signatureCache.Set(registry+"/image:v2", "https://notary.test", &notary.Signature{Name: registry + "/image:v2"}, time.Minute)
+
+	signed := generateTestPod(registry+"/image:v1", testCheckSign, "")
+	signed.Name = "signed"
+	unsigned := generateTestPod(registry+"/image:v2", testCheckSign, "")
+	unsigned.Name = "unsigned"
+	return newValidationReport(v, []*corev1.Pod{signed, unsigned})
+}
+
+func TestWriteReport_json(t *testing.T) {
+	buf := &bytes.Buffer{}
+	require.NoError(t, WriteReport(buf, testValidationReport(), ReportFormatJSON))
+
+	var report map[string]interface{}
+	require.NoError(t, json.Unmarshal(buf.Bytes(), &report))
+	require.Equal(t, float64(1), report["passed"])
+	require.Equal(t, float64(1), report["failed"])
+
+	pods := report["pods"].([]interface{})
+	require.Len(t, pods, 2)
+	signed := pods[0].(map[string]interface{})
+	require.Equal(t, testCheckSign, signed["namespace"])
+	require.Equal(t, "signed", signed["name"])
+	require.Equal(t, true, signed["passed"])
+	require.NotContains(t, signed, "reason")
+	require.Equal(t, []interface{}{map[string]interface{}{
+		"container": "test-cont",
+		"image":     "registry.test/image:v1",
+		"digest":    "sha256:" + strings.Repeat("1", 64),
+		"signers":   []interface{}{"Repo Admin"},
+	}}, signed["images"])
+
+	unsigned := pods[1].(map[string]interface{})
+	require.Equal(t, "unsigned", unsigned["name"])
+	require.Equal(t, false, unsigned["passed"])
+	require.NotEmpty(t, unsigned["reason"])
+	require.Equal(t, []interface{}{map[string]interface{}{
+		"container": "test-cont",
+		"image":     "registry.test/image:v2",
+	}}, unsigned["images"])
+}
+
+func TestWriteReport_sarif(t *testing.T) {
+	report := testValidationReport()
+	buf := &bytes.Buffer{}
+	require.NoError(t, WriteReport(buf, report, ReportFormatSARIF))
+
+	log := &sarifLog{}
+	require.NoError(t, json.Unmarshal(buf.Bytes(), log))
+	require.Equal(t, "2.1.0", log.Version)
+	require.Len(t, log.Runs, 1)
+	require.Equal(t, "image-validating-webhook", log.Runs[0].Tool.Driver.Name)
+	require.Equal(t, []sarifResult{{
+		RuleID:    sarifRuleID,
+		Level:     "error",
+		Message:   sarifMessage{Text: report.Pods[1].Reason},
+		Locations: []sarifLocation{{LogicalLocations: []sarifLogicalLocation{{FullyQualifiedName: testCheckSign + "/unsigned", Kind: "pod"}}}},
+	}}, log.Runs[0].Results)
+
+	require.Error(t, WriteReport(buf, report, "xml"))
+}
+
+func TestReadPodManifests(t *testing.T) {
+	manifest := `apiVersion: v1
+kind: Pod
+metadata:
+  name: pod-a
+spec:
+  containers:
+  - name: app
+    image: registry.test/image:v1
+---
+apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: config
+---
+apiVersion: v1
+kind: Pod
+metadata:
+  name: pod-b
+  namespace: other
+spec:
+  containers:
+  - name: app
+    image: registry.test/image:v2
+`
+	pods, err := readPodManifest(strings.NewReader(manifest), "default")
+	require.NoError(t, err)
+	require.Len(t, pods, 2)
+	require.Equal(t, "default", pods[0].Namespace)
+	require.Equal(t, "pod-a", pods[0].Name)
+	require.Equal(t, "other", pods[1].Namespace)
+	require.Equal(t, "registry.test/image:v2", pods[1].Spec.Containers[0].Image)
+}
@@ -0,0 +1,47 @@
+package pods
+
+import (
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+// rewriteImages rewrites the images of the pod's init containers and containers by the image rewrites,
+// before they are validated and pinned
+func (h *validator) rewriteImages(pod *corev1.Pod) {
+	if len(h.opts.ImageRewrites) == 0 {
+		return
+	}
+	for _, containers := range [][]corev1.Container{pod.Spec.InitContainers, pod.Spec.Containers} {
+		for i := range containers {
+			if rewritten := h.rewriteImage(containers[i].Image); rewritten != containers[i].Image {
+				validatorLog.Info("rewriting image", "namespace", pod.Namespace, "pod", pod.Name, "image", containers[i].Image, "rewritten", rewritten)
+				containers[i].Image = rewritten
+			}
+		}
+	}
+}
+
+// rewriteImage replaces the longest prefix of the image matching the image rewrites (e.g., docker.io/ to mirror.internal/).
+// Images without a registry are matched as docker.io/<image>
+func (h *validator) rewriteImage(img string) string {
+	ref, err := parseImage(img)
+	if err != nil {
+		return img
+	}
+	full := img
+	if ref.host == "" {
+		full = "docker.io/" + img
+	}
+
+	from := ""
+	for prefix := range h.opts.ImageRewrites {
+		if strings.HasPrefix(full, prefix) && len(prefix) > len(from) {
+			from = prefix
+		}
+	}
+	if from == "" {
+		return img
+	}
+	return h.opts.ImageRewrites[from] + strings.TrimPrefix(full, from)
+}
@@ -0,0 +1,81 @@
+package pods
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	whv1 "github.com/tmax-cloud/image-validating-webhook/pkg/type"
+	watcherfake "github.com/tmax-cloud/image-validating-webhook/pkg/watcher/fake"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestValidator_rewriteImage(t *testing.T) {
+	v := &validator{opts: Options{ImageRewrites: map[string]string{
+		"docker.io/":         "mirror.internal/docker.io/",
+		"docker.io/library/": "mirror.internal/library/",
+		"quay.io/":           "mirror.internal/quay.io/",
+	}}}
+
+	tc := map[string]struct {
+		image string
+
+		expectedImage string
+	}{
+		"prefix": {
+			image:         "quay.io/test/image:v1",
+			expectedImage: "mirror.internal/quay.io/test/image:v1",
+		},
+		"longestPrefix": {
+			image:         "docker.io/library/nginx:1.21",
+			expectedImage: "mirror.internal/library/nginx:1.21",
+		},
+		"noRegistry": {
+			image:         "tmax/image:v1",
+			expectedImage: "mirror.internal/docker.io/tmax/image:v1",
+		},
+		"digest": {
+			image:         "quay.io/test/image@sha256:1111",
+			expectedImage: "mirror.internal/quay.io/test/image@sha256:1111",
+		},
+		"notMatched": {
+			image:         "registry.internal/test/image:v1",
+			expectedImage: "registry.internal/test/image:v1",
+		},
+	}
+
+	for name, c := range tc {
+		t.Run(name, func(t *testing.T) {
+			require.Equal(t, c.expectedImage, v.rewriteImage(c.image))
+		})
+	}
+}
+
+func TestValidator_CheckIsValidAndAddDigest_imageRewrites(t *testing.T) {
+	v := &validator{client: fake.NewSimpleClientset(), whiteList: &WhiteList{}, opts: Options{ImageRewrites: map[string]string{"docker.io/": "mirror.internal/"}}}
+	// Only the mirror is allowed
+	v.registryPolicyCache = &RegistryPolicyCache{clusterCachedClient: &watcherfake.CachedClient{}, namespaceCachedClient: &watcherfake.CachedClient{
+		Cache: map[string]runtime.Object{
+			testCheckSign + "/policy": &whv1.RegistrySecurityPolicy{
+				ObjectMeta: metav1.ObjectMeta{Name: "policy", Namespace: testCheckSign},
+				Spec:       whv1.RegistrySecurityPolicySpec{Registries: []whv1.RegistrySpec{{Registry: "mirror.internal", SignCheck: false}}},
+			},
+		},
+	}}
+
+	pod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: "test-pod", Namespace: testCheckSign},
+		Spec: corev1.PodSpec{
+			InitContainers: []corev1.Container{{Name: "init", Image: "docker.io/library/busybox:1.35"}},
+			Containers:     []corev1.Container{{Name: "app", Image: "nginx:1.21"}},
+		},
+	}
+
+	valid, reason, err := v.CheckIsValidAndAddDigest(pod)
+	require.NoError(t, err)
+	require.True(t, valid, reason)
+	require.Equal(t, "mirror.internal/library/busybox:1.35", pod.Spec.InitContainers[0].Image)
+	require.Equal(t, "mirror.internal/nginx:1.21", pod.Spec.Containers[0].Image)
+}
@@ -0,0 +1,88 @@
+package pods
+
+import (
+	"strings"
+	"sync"
+	"time"
+
+	whv1 "github.com/tmax-cloud/image-validating-webhook/pkg/type"
+)
+
+// rotatedDigestCache remembers the digests signed for the tags recently. When a tag is re-signed to another digest,
+// the pods pinned to the prior digest (e.g., in-flight deployments) are allowed within the rotation window
+type rotatedDigestCache struct {
+	lock   sync.Mutex
+	window time.Duration
+	// signedAt is when each digest is last seen signed, keyed by the tag and the digest
+	signedAt map[string]map[string]time.Time
+}
+
+// newRotatedDigestCache creates a new cache. It returns nil, which allows only the current digests, if window is not positive
+func newRotatedDigestCache(window time.Duration) *rotatedDigestCache {
+	if window <= 0 {
+		return nil
+	}
+	return &rotatedDigestCache{window: window, signedAt: map[string]map[string]time.Time{}}
+}
+
+// rotatedDigestKey is a key of the tag signed in the notary server by the policy, in the scope of the credential it's checked with.
+// The digests seen signed by a policy are never allowed by a stricter one (e.g., with a higher signer threshold or pinned admin keys)
+func rotatedDigestKey(ref *imageRef, basicAuth, notaryURL string, policy whv1.RegistrySpec) string {
+	return strings.Join([]string{notaryURL, ref.host + "/" + ref.name + ":" + ref.tag, policyHash(policy), credentialScope(basicAuth)}, "|")
+}
+
+// observe records the digest is signed for the tag now
+func (c *rotatedDigestCache) observe(key, digest string) {
+	if c == nil {
+		return
+	}
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	now := time.Now()
+	// Clean up the digests out of the window
+	for k, digests := range c.signedAt {
+		for d, signedAt := range digests {
+			if now.Sub(signedAt) > c.window {
+				delete(digests, d)
+			}
+		}
+		if len(digests) == 0 {
+			delete(c.signedAt, k)
+		}
+	}
+	if c.signedAt[key] == nil {
+		c.signedAt[key] = map[string]time.Time{}
+	}
+	c.signedAt[key][digest] = now
+}
+
+// wasSigned checks if the digest was signed for the tag within the rotation window
+func (c *rotatedDigestCache) wasSigned(key, digest string) bool {
+	if c == nil {
+		return false
+	}
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	signedAt, exist := c.signedAt[key][digest]
+	return exist && time.Since(signedAt) <= c.window
+}
+
+// pinnedDigest returns the digest the image is pinned to, which is the signed digest of the tag, or the user-specified one if it was signed
+// within the rotation window. It's false if the user-specified digest is neither
+func (h *validator) pinnedDigest(ref *imageRef, basicAuth, notaryURL string, policy whv1.RegistrySpec, signed string) (string, bool) {
+	if ref.tag == "" {
+		return signed, ref.digest == "" || ref.digest == signed
+	}
+	key := rotatedDigestKey(ref, basicAuth, notaryURL, policy)
+	h.rotatedDigests.observe(key, signed)
+	if ref.digest == "" || ref.digest == signed {
+		return signed, true
+	}
+	if !h.rotatedDigests.wasSigned(key, ref.digest) {
+		return "", false
+	}
+	validatorLog.Info("allowing the digest signed before the tag is re-signed", "image", ref.String(), "signedDigest", signed, "window", h.rotatedDigests.window)
+	return ref.digest, true
+}
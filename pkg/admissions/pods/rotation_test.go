@@ -0,0 +1,141 @@
+package pods
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"github.com/tmax-cloud/image-validating-webhook/pkg/notary"
+	whv1 "github.com/tmax-cloud/image-validating-webhook/pkg/type"
+)
+
+func TestValidator_CheckIsValidAndAddDigest_rotation(t *testing.T) {
+	const registry = "registry.test"
+	const notaryURL = "https://notary.test"
+	img := registry + "/image:v1"
+	prior, current, other := strings.Repeat("1", 64), strings.Repeat("2", 64), strings.Repeat("3", 64)
+
+	tc := map[string]struct {
+		window    time.Duration
+		elapsed   time.Duration
+		digest    string
+		notSigned bool
+
+		expectedValid  bool
+		expectedReason string
+	}{
+		"prior": {
+			window:        time.Hour,
+			digest:        prior,
+			expectedValid: true,
+		},
+		"current": {
+			window:        time.Hour,
+			digest:        current,
+			expectedValid: true,
+		},
+		"neverSigned": {
+			window:         time.Hour,
+			digest:         other,
+			expectedReason: fmt.Sprintf("Notary: Image '%s@sha256:%s''s digest is different from the signed digest", img, other),
+		},
+		"priorOutOfWindow": {
+			window:         time.Hour,
+			elapsed:        2 * time.Hour,
+			digest:         prior,
+			expectedReason: fmt.Sprintf("Notary: Image '%s@sha256:%s''s digest is different from the signed digest", img, prior),
+		},
+		"priorWithoutWindow": {
+			digest:         prior,
+			expectedReason: fmt.Sprintf("Notary: Image '%s@sha256:%s''s digest is different from the signed digest", img, prior),
+		},
+	}
+
+	for caseName, c := range tc {
+		t.Run(caseName, func(t *testing.T) {
+			policy := whv1.RegistrySpec{Registry: registry, Notary: notaryURL, SignCheck: true}
+			v := testCachedSignatureValidator(policy, img)
+			v.rotatedDigests = newRotatedDigestCache(c.window)
+			sign := func(digest string) {
+				sig := &notary.Signature{Name: img, SignedTags: []notary.SignedTag{{SignedTag: "v1", Digest: digest, Algorithm: "sha256", Signers: []string{"Repo Admin"}}}}
+				for _, ref := range []string{img, img + "@sha256:" + prior, img + "@sha256:" + current, img + "@sha256:" + other} {
+					v.signatureCache.Set(ref, notaryURL, sig, time.Minute)
+				}
+			}
+
+			// Deployed before the tag is re-signed
+			sign(prior)
+			pod := generateTestPod(img, testCheckSign, "")
+			valid, reason, err := v.CheckIsValidAndAddDigest(pod)
+			require.NoError(t, err)
+			require.True(t, valid, reason)
+			require.Equal(t, img+"@sha256:"+prior, pod.Spec.Containers[0].Image)
+			if c.elapsed > 0 {
+				key := rotatedDigestKey(&imageRef{host: registry, name: "image", tag: "v1"}, "", notaryURL, policy)
+				v.rotatedDigests.signedAt[key]["sha256:"+prior] = time.Now().Add(-c.elapsed)
+			}
+
+			// Re-signed to the current digest
+			sign(current)
+			pinned := img + "@sha256:" + c.digest
+			pod = generateTestPod(pinned, testCheckSign, "")
+			valid, reason, err = v.CheckIsValidAndAddDigest(pod)
+			require.NoError(t, err)
+			require.Equal(t, c.expectedValid, valid)
+			require.Equal(t, c.expectedReason, reason)
+			require.Equal(t, pinned, pod.Spec.Containers[0].Image)
+		})
+	}
+}
+
+func TestValidator_CheckIsValidAndAddDigest_rotationNotCached(t *testing.T) {
+	const registry = "registry.test"
+	const notaryURL = "https://notary.test"
+	img := registry + "/image:v1"
+	prior, current := strings.Repeat("1", 64), strings.Repeat("2", 64)
+
+	policy := whv1.RegistrySpec{Registry: registry, Notary: notaryURL, SignCheck: true}
+	v := testCachedSignatureValidator(policy, img)
+	v.rotatedDigests = newRotatedDigestCache(time.Minute)
+	sign := func(digest string) {
+		sig := &notary.Signature{Name: img, SignedTags: []notary.SignedTag{{SignedTag: "v1", Digest: digest, Algorithm: "sha256", Signers: []string{"Repo Admin"}}}}
+		for _, ref := range []string{img, img + "@sha256:" + prior} {
+			v.signatureCache.Set(ref, notaryURL, sig, time.Minute)
+		}
+	}
+	sign(prior)
+	valid, reason, err := v.CheckIsValidAndAddDigest(generateTestPod(img, testCheckSign, ""))
+	require.NoError(t, err)
+	require.True(t, valid, reason)
+
+	// The prior digest is allowed within the rotation window, but not cached as validated
+	v.validatedDigests = newValidatedDigestCache(time.Hour)
+	sign(current)
+	pinned := img + "@sha256:" + prior
+	valid, reason, err = v.CheckIsValidAndAddDigest(generateTestPod(pinned, testCheckSign, ""))
+	require.NoError(t, err)
+	require.True(t, valid, reason)
+
+	// It's denied once the window closes, even though the validated digests are kept longer
+	key := rotatedDigestKey(&imageRef{host: registry, name: "image", tag: "v1"}, "", notaryURL, policy)
+	v.rotatedDigests.signedAt[key]["sha256:"+prior] = time.Now().Add(-2 * time.Minute)
+	valid, reason, err = v.CheckIsValidAndAddDigest(generateTestPod(pinned, testCheckSign, ""))
+	require.NoError(t, err)
+	require.False(t, valid)
+	require.Equal(t, fmt.Sprintf("Notary: Image '%s''s digest is different from the signed digest", pinned), reason)
+}
+
+func TestRotatedDigestKey_policy(t *testing.T) {
+	ref := &imageRef{host: "registry.test", name: "image", tag: "v1"}
+	policy := whv1.RegistrySpec{Registry: "registry.test", SignCheck: true, Signer: []string{"signer-b", "signer-a"}}
+
+	reordered := policy
+	reordered.Signer = []string{"signer-a", "signer-b"}
+	require.Equal(t, rotatedDigestKey(ref, "", "https://notary.test", policy), rotatedDigestKey(ref, "", "https://notary.test", reordered))
+
+	stricter := policy
+	stricter.SignerThreshold = 2
+	require.NotEqual(t, rotatedDigestKey(ref, "", "https://notary.test", policy), rotatedDigestKey(ref, "", "https://notary.test", stricter))
+}
@@ -0,0 +1,123 @@
+package pods
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/tmax-cloud/image-validating-webhook/pkg/server"
+	"github.com/tmax-cloud/image-validating-webhook/pkg/trust"
+	whv1 "github.com/tmax-cloud/image-validating-webhook/pkg/type"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+// SelfTestResult is a result of a single self-test check
+type SelfTestResult struct {
+	Name string
+	Err  error
+}
+
+// Passed returns true if the check succeeded
+func (r *SelfTestResult) Passed() bool {
+	return r.Err == nil
+}
+
+// RunSelfTest checks if the webhook is able to reach the api server, read the whitelist and the policies,
+// and reach the notary servers. If testImage is not empty, it is validated in the testNamespace as well
+func RunSelfTest(cfg *server.HandlerConfig, testImage, testNamespace string) []SelfTestResult {
+	var results []SelfTestResult
+	check := func(name string, err error) bool {
+		results = append(results, SelfTestResult{Name: name, Err: err})
+		return err == nil
+	}
+
+	_, err := cfg.ClientSet.Discovery().ServerVersion()
+	if !check("Reach API server", err) {
+		return results
+	}
+
+	if !check("Read whitelist", readWhiteList(cfg.ClientSet)) {
+		return results
+	}
+
+	v, err := newValidator(cfg.RestCfg, cfg.ClientSet, cfg.RestClient)
+	if !check("Initialize validator", err) {
+		return results
+	}
+
+	registries, err := v.registryPolicyCache.listRegistries()
+	if !check("Read registry security policies", err) {
+		return results
+	}
+
+	for _, notaryURL := range notaryServers(registries) {
+		check(fmt.Sprintf("Reach notary server %s", notaryURL), trust.Ping(notaryURL, v.opts.NotaryHostAliases))
+	}
+
+	if testImage != "" {
+		check(fmt.Sprintf("Validate image %s", testImage), validateTestImage(v, testImage, testNamespace))
+	}
+
+	return results
+}
+
+// readWhiteList reads and parses the whitelist config map, without updating it
+func readWhiteList(clientSet kubernetes.Interface) error {
+	cm, err := clientSet.CoreV1().ConfigMaps(registryNamespace).Get(context.Background(), whitelistConfigMap, metav1.GetOptions{})
+	if err != nil {
+		return err
+	}
+
+	wl := &WhiteList{}
+	if img, exist := cm.Data[whitelistByImage]; exist {
+		if err := wl.UnmarshalImage(img); err != nil {
+			return err
+		}
+	} else if err := wl.UnmarshalLegacyImage(cm.Data[whitelistByImageLegacy]); err != nil {
+		return err
+	}
+
+	if _, exist := cm.Data[whitelistByNamespace]; !exist {
+		return wl.UnmarshalLegacyNamespace(cm.Data[whitelistByNamespaceLegacy])
+	}
+	return nil
+}
+
+// notaryServers returns the distinct notary servers of the registries to be sign-checked
+func notaryServers(registries []whv1.RegistrySpec) []string {
+	var servers []string
+	found := map[string]bool{}
+	for _, reg := range registries {
+		if !reg.SignCheck {
+			continue
+		}
+		notaryURL := reg.Notary
+		if notaryURL == "" {
+			notaryURL = trust.DefaultNotaryServer
+		}
+		if found[notaryURL] {
+			continue
+		}
+		found[notaryURL] = true
+		servers = append(servers, notaryURL)
+	}
+	return servers
+}
+
+func validateTestImage(v *validator, testImage, testNamespace string) error {
+	pod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: "selftest", Namespace: testNamespace},
+		Spec: corev1.PodSpec{
+			Containers: []corev1.Container{{Name: "selftest", Image: testImage}},
+		},
+	}
+	isValid, reason, err := v.CheckIsValidAndAddDigest(pod)
+	if err != nil {
+		return err
+	}
+	if !isValid {
+		return fmt.Errorf("image is not valid: %s", reason)
+	}
+	return nil
+}
@@ -0,0 +1,62 @@
+package pods
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/tmax-cloud/image-validating-webhook/pkg/trust"
+	whv1 "github.com/tmax-cloud/image-validating-webhook/pkg/type"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestReadWhiteList(t *testing.T) {
+	tc := map[string]struct {
+		data map[string]string
+
+		expectedErrOccur bool
+	}{
+		"normal": {
+			data: map[string]string{whitelistByImage: "test-image", whitelistByNamespace: "test-ns"},
+		},
+		"legacy": {
+			data: map[string]string{whitelistByImageLegacy: `["test-image"]`, whitelistByNamespaceLegacy: `["test-ns"]`},
+		},
+		"malformedLegacy": {
+			data:             map[string]string{whitelistByImageLegacy: `test-image`, whitelistByNamespace: "test-ns"},
+			expectedErrOccur: true,
+		},
+	}
+
+	for name, c := range tc {
+		t.Run(name, func(t *testing.T) {
+			cli := fake.NewSimpleClientset()
+			_, err := cli.CoreV1().ConfigMaps(registryNamespace).Create(context.Background(), &corev1.ConfigMap{
+				ObjectMeta: metav1.ObjectMeta{Name: whitelistConfigMap, Namespace: registryNamespace},
+				Data:       c.data,
+			}, metav1.CreateOptions{})
+			require.NoError(t, err)
+
+			err = readWhiteList(cli)
+			if c.expectedErrOccur {
+				require.Error(t, err)
+			} else {
+				require.NoError(t, err)
+			}
+		})
+	}
+
+	require.Error(t, readWhiteList(fake.NewSimpleClientset()), "no config map")
+}
+
+func TestNotaryServers(t *testing.T) {
+	servers := notaryServers([]whv1.RegistrySpec{
+		{Registry: "reg-1", Notary: "https://notary-1", SignCheck: true},
+		{Registry: "reg-2", Notary: "https://notary-1", SignCheck: true},
+		{Registry: "reg-3", Notary: "https://notary-2", SignCheck: false},
+		{Registry: "reg-4", SignCheck: true},
+	})
+	require.Equal(t, []string{"https://notary-1", trust.DefaultNotaryServer}, servers)
+}
@@ -0,0 +1,56 @@
+package pods
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	whv1 "github.com/tmax-cloud/image-validating-webhook/pkg/type"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+type signedAtKey struct{}
+
+// withSignedAt returns the context recording when the verified image was signed, and the recorded time
+func withSignedAt(ctx context.Context) (context.Context, *time.Time) {
+	signedAt := new(time.Time)
+	return context.WithValue(ctx, signedAtKey{}, signedAt), signedAt
+}
+
+// recordSignedAt records when the verified image was signed, if the context is recording it
+func recordSignedAt(ctx context.Context, signedAt time.Time) {
+	if recorded, ok := ctx.Value(signedAtKey{}).(*time.Time); ok {
+		*recorded = signedAt
+	}
+}
+
+// signatureAge returns the signing time of the validated image, and if it's older than the signature age warning of the policy.
+// The signing time is nil if it's unknown
+func signatureAge(signedAt time.Time, policy whv1.RegistrySpec) (*metav1.Time, bool) {
+	if signedAt.IsZero() {
+		return nil, false
+	}
+	aging := policy.SignatureAgeWarning != nil && time.Since(signedAt) > policy.SignatureAgeWarning.Duration
+	return &metav1.Time{Time: signedAt}, aging
+}
+
+// signatureAgeWarnings returns the warnings of the containers whose images are signed earlier than the signature age warnings of their policies,
+// nudging them to be re-signed before they're denied by the maximum signature ages
+func signatureAgeWarnings(pod *corev1.Pod) []string {
+	validated, exist := podValidatedImages(pod)
+	if !exist {
+		return nil
+	}
+
+	var warnings []string
+	for _, containers := range [][]corev1.Container{pod.Spec.InitContainers, pod.Spec.Containers} {
+		for _, c := range containers {
+			if img, exist := validated.Containers[c.Name]; exist && img.SignatureAging && img.SignedAt != nil {
+				warnings = append(warnings, fmt.Sprintf("Image '%s' was signed at %s, which is older than the signature age warning of the registry security policy. Please re-sign it before it's denied",
+					c.Image, img.SignedAt.UTC().Format(time.RFC3339)))
+			}
+		}
+	}
+	return warnings
+}
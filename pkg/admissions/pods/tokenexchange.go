@@ -0,0 +1,161 @@
+package pods
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	authenticationv1 "k8s.io/api/authentication/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+const (
+	tokenExchangeGrantType = "urn:ietf:params:oauth:grant-type:token-exchange"
+	jwtTokenType           = "urn:ietf:params:oauth:token-type:jwt"
+
+	// serviceAccountTokenExpiry is the expiry of the service account tokens requested to be exchanged
+	serviceAccountTokenExpiry = 10 * time.Minute
+	// registryTokenExpiryMargin is how early the exchanged registry tokens are renewed before they expire
+	registryTokenExpiryMargin = time.Minute
+)
+
+// CredentialProvider provides the registry credential of a pod's service account,
+// for the pods pulling images by workload identity rather than pull secrets
+type CredentialProvider interface {
+	// BasicAuth returns the base64 encoded <username>:<password> of the registry for the service account. It's empty if there is none
+	BasicAuth(host, namespace, serviceAccount string) (string, error)
+}
+
+// tokenExchangeProvider exchanges a token of the pod's service account for a registry token at the registry's token endpoint,
+// by OAuth 2.0 token exchange (RFC 8693). The registry should trust the cluster's service account issuer (OIDC)
+type tokenExchangeProvider struct {
+	client     kubernetes.Interface
+	httpClient *http.Client
+
+	// endpoints are the token endpoints of the registry hosts
+	endpoints map[string]string
+	// audience is the audience of the service account tokens. The registry host is used if it's empty
+	audience string
+	// username is the username of the exchanged token, which is the password
+	username string
+
+	lock   sync.Mutex
+	tokens map[string]exchangedToken
+}
+
+type exchangedToken struct {
+	basicAuth string
+	expires   time.Time
+}
+
+// tokenExchangeResponse is the response of the token endpoint
+type tokenExchangeResponse struct {
+	AccessToken string `json:"access_token"`
+	ExpiresIn   int    `json:"expires_in"`
+}
+
+func newTokenExchangeProvider(client kubernetes.Interface, endpoints map[string]string, audience, username string) *tokenExchangeProvider {
+	return &tokenExchangeProvider{
+		client:     client,
+		httpClient: &http.Client{Timeout: 30 * time.Second},
+		endpoints:  endpoints,
+		audience:   audience,
+		username:   username,
+		tokens:     map[string]exchangedToken{},
+	}
+}
+
+// BasicAuth exchanges the service account's token for the registry token, if the registry has a token endpoint.
+// The registry tokens are cached until they expire
+func (p *tokenExchangeProvider) BasicAuth(host, namespace, serviceAccount string) (string, error) {
+	endpoint, exist := p.endpoints[host]
+	if !exist {
+		return "", nil
+	}
+	if serviceAccount == "" {
+		serviceAccount = "default"
+	}
+
+	key := strings.Join([]string{host, namespace, serviceAccount}, "/")
+	p.lock.Lock()
+	cached, exist := p.tokens[key]
+	p.lock.Unlock()
+	if exist && time.Now().Before(cached.expires) {
+		return cached.basicAuth, nil
+	}
+
+	audience := p.audience
+	if audience == "" {
+		audience = host
+	}
+	saToken, err := p.requestServiceAccountToken(namespace, serviceAccount, audience)
+	if err != nil {
+		return "", fmt.Errorf("couldn't request a token of service account %s/%s by %s", namespace, serviceAccount, err)
+	}
+	resp, err := p.exchange(endpoint, saToken, audience)
+	if err != nil {
+		return "", fmt.Errorf("couldn't exchange the token of service account %s/%s at %s by %s", namespace, serviceAccount, endpoint, err)
+	}
+
+	token := exchangedToken{basicAuth: base64.StdEncoding.EncodeToString([]byte(p.username + ":" + resp.AccessToken))}
+	if resp.ExpiresIn > 0 {
+		token.expires = time.Now().Add(time.Duration(resp.ExpiresIn)*time.Second - registryTokenExpiryMargin)
+	}
+	p.lock.Lock()
+	p.tokens[key] = token
+	p.lock.Unlock()
+	return token.basicAuth, nil
+}
+
+// requestServiceAccountToken requests a short-lived token of the service account for the audience, as the one projected to the pod
+func (p *tokenExchangeProvider) requestServiceAccountToken(namespace, serviceAccount, audience string) (string, error) {
+	expiry := int64(serviceAccountTokenExpiry.Seconds())
+	tr, err := p.client.CoreV1().ServiceAccounts(namespace).CreateToken(context.Background(), serviceAccount, &authenticationv1.TokenRequest{
+		Spec: authenticationv1.TokenRequestSpec{Audiences: []string{audience}, ExpirationSeconds: &expiry},
+	}, metav1.CreateOptions{})
+	if err != nil {
+		return "", err
+	}
+	return tr.Status.Token, nil
+}
+
+// exchange exchanges the service account token for the registry token at the endpoint
+func (p *tokenExchangeProvider) exchange(endpoint, saToken, audience string) (*tokenExchangeResponse, error) {
+	form := url.Values{
+		"grant_type":         {tokenExchangeGrantType},
+		"subject_token":      {saToken},
+		"subject_token_type": {jwtTokenType},
+		"audience":           {audience},
+	}
+	resp, err := p.httpClient.PostForm(endpoint, form)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		_ = resp.Body.Close()
+	}()
+	b, err := io.ReadAll(io.LimitReader(resp.Body, 1024*1024))
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("token endpoint responded %d: %s", resp.StatusCode, string(b))
+	}
+
+	tokenResp := &tokenExchangeResponse{}
+	if err := json.Unmarshal(b, tokenResp); err != nil {
+		return nil, err
+	}
+	if tokenResp.AccessToken == "" {
+		return nil, fmt.Errorf("token endpoint responded no access token")
+	}
+	return tokenResp, nil
+}
@@ -0,0 +1,82 @@
+package pods
+
+import (
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	authenticationv1 "k8s.io/api/authentication/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+// testTokenRequestClient is a fake client issuing the service account tokens named <namespace>/<service account>/<audience>
+func testTokenRequestClient() *fake.Clientset {
+	cli := fake.NewSimpleClientset()
+	cli.PrependReactor("create", "serviceaccounts", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		create := action.(k8stesting.CreateActionImpl)
+		if create.GetSubresource() != "token" {
+			return false, nil, nil
+		}
+		tr := create.GetObject().(*authenticationv1.TokenRequest)
+		tr.Status.Token = fmt.Sprintf("%s/%s/%s", create.GetNamespace(), create.Name, tr.Spec.Audiences[0])
+		return true, tr, nil
+	})
+	return cli
+}
+
+func TestTokenExchangeProvider_BasicAuth(t *testing.T) {
+	exchanged := 0
+	tokenSrv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		require.NoError(t, req.ParseForm())
+		require.Equal(t, tokenExchangeGrantType, req.PostForm.Get("grant_type"))
+		require.Equal(t, jwtTokenType, req.PostForm.Get("subject_token_type"))
+		if req.PostForm.Get("subject_token") != testCheckSign+"/builder/registry.test" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		exchanged++
+		_, _ = w.Write([]byte(`{"access_token":"registry-token","issued_token_type":"urn:ietf:params:oauth:token-type:access_token","token_type":"Bearer","expires_in":3600}`))
+	}))
+	defer tokenSrv.Close()
+
+	p := newTokenExchangeProvider(testTokenRequestClient(), map[string]string{"registry.test": tokenSrv.URL}, "", "<token>")
+
+	// Exchanged once, and cached
+	for i := 0; i < 2; i++ {
+		basicAuth, err := p.BasicAuth("registry.test", testCheckSign, "builder")
+		require.NoError(t, err)
+		require.Equal(t, base64.StdEncoding.EncodeToString([]byte("<token>:registry-token")), basicAuth)
+	}
+	require.Equal(t, 1, exchanged)
+
+	// Registries without token endpoints
+	basicAuth, err := p.BasicAuth("other.test", testCheckSign, "builder")
+	require.NoError(t, err)
+	require.Empty(t, basicAuth)
+
+	// The registry doesn't trust the service account
+	_, err = p.BasicAuth("registry.test", testCheckSign, "")
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "service account "+testCheckSign+"/default")
+}
+
+func TestValidator_getBasicAuthForRegistry_tokenExchange(t *testing.T) {
+	tokenSrv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		_, _ = w.Write([]byte(`{"access_token":"registry-token","expires_in":3600}`))
+	}))
+	defer tokenSrv.Close()
+
+	cli := testTokenRequestClient()
+	v := &validator{client: cli, credentialProvider: newTokenExchangeProvider(cli, map[string]string{"registry.test": tokenSrv.URL}, "", "<token>")}
+
+	// Pods without pull secrets use their service accounts
+	basicAuth, err := v.getBasicAuthForRegistry("registry.test", testCheckSign, "builder", []corev1.LocalObjectReference{})
+	require.NoError(t, err)
+	require.Equal(t, base64.StdEncoding.EncodeToString([]byte("<token>:registry-token")), basicAuth)
+}
@@ -0,0 +1,138 @@
+package pods
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// ValidatedImagesAnnotation is the pod annotation telling the pinned digest and the signers of each container's image,
+// so that downstream tooling can parse a single annotation. Its value is a ValidatedImages
+const ValidatedImagesAnnotation = "tmax.io/validated-images"
+
+// maxValidatedImagesAnnotationSize is the maximum size of ValidatedImagesAnnotation.
+// It's well within the total size limit of the annotations(256KiB), leaving the room for the others
+const maxValidatedImagesAnnotationSize = 32 * 1024
+
+// ValidatedImages is the value of ValidatedImagesAnnotation
+type ValidatedImages struct {
+	// Containers are the validated images, keyed by the container names
+	Containers map[string]ValidatedImage `json:"containers"`
+	// Truncated is the number of containers omitted, not to exceed the size limit of the annotation
+	Truncated int `json:"truncated,omitempty"`
+}
+
+// ValidatedImage is the pinned digest and the signers of a container's image
+type ValidatedImage struct {
+	Digest  string   `json:"digest"`
+	Signers []string `json:"signers,omitempty"`
+	// Notary is the notary server URL the signature is checked against
+	Notary string `json:"notary,omitempty"`
+	// NotaryFallback tells the registry has no notary server, so the signature is checked against docker hub's notary server
+	NotaryFallback bool `json:"notaryFallback,omitempty"`
+	// SignedAt is when the image was signed, if it's known
+	SignedAt *metav1.Time `json:"signedAt,omitempty"`
+	// SignatureAging tells the signature is older than the signature age warning of the policy
+	SignatureAging bool `json:"signatureAging,omitempty"`
+
+	// cacheHit tells it's validated from the caches, without the notary round-trip
+	cacheHit bool
+}
+
+// validatedImages collects the validated images of a pod, keyed by the container names. A nil one collects nothing
+type validatedImages map[string]ValidatedImage
+
+// record records the pinned digest of the container's image, with its signers and notary server in image. Images without digests are not recorded
+func (v validatedImages) record(container *corev1.Container, image ValidatedImage) {
+	if v == nil {
+		return
+	}
+	ref, err := parseImage(container.Image)
+	if err != nil || ref.digest == "" {
+		return
+	}
+	image.Digest = ref.digest
+	v[container.Name] = image
+}
+
+// setValidatedImagesAnnotation sets ValidatedImagesAnnotation of the pod.
+// If it exceeds the size limit, the containers are omitted from the last one in the order of their names
+func setValidatedImagesAnnotation(pod *corev1.Pod, validated validatedImages) error {
+	if len(validated) == 0 {
+		return nil
+	}
+
+	names := make([]string, 0, len(validated))
+	for name := range validated {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	// Leave the room for the enclosing object and the truncated count
+	size := len(`{"containers":{},"truncated":}`) + 20
+	annotation := ValidatedImages{Containers: map[string]ValidatedImage{}}
+	for i, name := range names {
+		entry, err := json.Marshal(map[string]ValidatedImage{name: validated[name]})
+		if err != nil {
+			return err
+		}
+		// Without the enclosing braces, with a separating comma
+		size += len(entry) - 1
+		if size > maxValidatedImagesAnnotationSize {
+			annotation.Truncated = len(names) - i
+			validatorLog.Info("truncated validated images annotation", "namespace", pod.Namespace, "pod", pod.Name, "omitted", annotation.Truncated)
+			break
+		}
+		annotation.Containers[name] = validated[name]
+	}
+
+	b, err := json.Marshal(annotation)
+	if err != nil {
+		return err
+	}
+	if pod.Annotations == nil {
+		pod.Annotations = map[string]string{}
+	}
+	pod.Annotations[ValidatedImagesAnnotation] = string(b)
+	return nil
+}
+
+// podValidatedImages returns the validated images of the pod in its ValidatedImagesAnnotation, if it has the annotation
+func podValidatedImages(pod *corev1.Pod) (*ValidatedImages, bool) {
+	annotation, exist := pod.Annotations[ValidatedImagesAnnotation]
+	if !exist {
+		return nil, false
+	}
+	validated := &ValidatedImages{}
+	if err := json.Unmarshal([]byte(annotation), validated); err != nil {
+		return nil, false
+	}
+	return validated, true
+}
+
+// admissionWarnings returns the warnings of the allowed pod, about its validated images
+func admissionWarnings(pod *corev1.Pod) []string {
+	return append(notaryFallbackWarnings(pod), signatureAgeWarnings(pod)...)
+}
+
+// notaryFallbackWarnings returns the warnings of the containers whose images are checked against docker hub's notary server,
+// as their registries have no notary server. It's likely a misconfiguration for a private registry
+func notaryFallbackWarnings(pod *corev1.Pod) []string {
+	validated, exist := podValidatedImages(pod)
+	if !exist {
+		return nil
+	}
+
+	var warnings []string
+	for _, containers := range [][]corev1.Container{pod.Spec.InitContainers, pod.Spec.Containers} {
+		for _, c := range containers {
+			if img, exist := validated.Containers[c.Name]; exist && img.NotaryFallback {
+				warnings = append(warnings, fmt.Sprintf("Image '%s' is checked against the notary server %s, as its registry has no notary server in the registry security policy", c.Image, img.Notary))
+			}
+		}
+	}
+	return warnings
+}
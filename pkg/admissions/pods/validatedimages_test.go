@@ -0,0 +1,125 @@
+package pods
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"github.com/tmax-cloud/image-validating-webhook/pkg/notary"
+	"github.com/tmax-cloud/image-validating-webhook/pkg/trust"
+	whv1 "github.com/tmax-cloud/image-validating-webhook/pkg/type"
+	admissionv1beta1 "k8s.io/api/admission/v1beta1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+func TestValidator_notaryImageValid_validatedImagesAnnotation(t *testing.T) {
+	const registry = "registry.test"
+	img := registry + "/image:v1"
+	digest := strings.Repeat("1", 64)
+
+	v := testCachedSignatureValidator(whv1.RegistrySpec{Registry: registry, Notary: "https://notary.test", SignCheck: true, Signer: []string{"signer-a"}},
+		img, notary.SignedTag{SignedTag: "v1", Digest: digest, Algorithm: "sha256", Signers: []string{"signer-a", "signer-b"}})
+	v.whiteList = &WhiteList{byImages: []imageRef{{name: testImageWhitelisted}}}
+
+	pod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: "test-pod", Namespace: testCheckSign, Annotations: map[string]string{"other": "annotation"}},
+		Spec: corev1.PodSpec{
+			InitContainers: []corev1.Container{{Name: "init", Image: img}},
+			Containers:     []corev1.Container{{Name: "app", Image: img}, {Name: "whitelisted", Image: testImageWhitelisted}},
+		},
+	}
+
+	valid, _, err := v.notaryImageValid(pod)
+	require.NoError(t, err)
+	require.True(t, valid)
+
+	annotation := ValidatedImages{}
+	require.NoError(t, json.Unmarshal([]byte(pod.Annotations[ValidatedImagesAnnotation]), &annotation))
+	expected := ValidatedImage{Digest: "sha256:" + digest, Signers: []string{"signer-a"}, Notary: "https://notary.test"}
+	require.Equal(t, ValidatedImages{Containers: map[string]ValidatedImage{"init": expected, "app": expected}}, annotation)
+	require.Equal(t, "annotation", pod.Annotations["other"])
+}
+
+func TestSetValidatedImagesAnnotation_truncated(t *testing.T) {
+	validated := validatedImages{}
+	for i := 0; i < 1000; i++ {
+		validated[fmt.Sprintf("container-%04d", i)] = ValidatedImage{Digest: "sha256:" + strings.Repeat("1", 64), Signers: []string{"signer-a"}}
+	}
+
+	pod := &corev1.Pod{}
+	require.NoError(t, setValidatedImagesAnnotation(pod, validated))
+	require.LessOrEqual(t, len(pod.Annotations[ValidatedImagesAnnotation]), maxValidatedImagesAnnotationSize)
+
+	annotation := ValidatedImages{}
+	require.NoError(t, json.Unmarshal([]byte(pod.Annotations[ValidatedImagesAnnotation]), &annotation))
+	require.Equal(t, 1000, len(annotation.Containers)+annotation.Truncated)
+	require.Greater(t, annotation.Truncated, 0)
+	// The containers are omitted from the last one
+	require.Contains(t, annotation.Containers, "container-0000")
+	require.NotContains(t, annotation.Containers, "container-0999")
+}
+
+func TestCreatePatch_validatedImagesAnnotation(t *testing.T) {
+	pod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Annotations: map[string]string{ValidatedImagesAnnotation: `{"containers":{}}`}},
+		Spec:       corev1.PodSpec{Containers: []corev1.Container{{Name: "test-cont", Image: "test-signed:v1"}}},
+	}
+
+	b, err := createPatch(pod, nil)
+	require.NoError(t, err)
+
+	var patch []patchOperation
+	require.NoError(t, json.Unmarshal(b, &patch))
+	require.Len(t, patch, 2)
+	require.Equal(t, "add", patch[1].Op)
+	require.Equal(t, "/metadata/annotations", patch[1].Path)
+	require.Equal(t, map[string]interface{}{ValidatedImagesAnnotation: `{"containers":{}}`}, patch[1].Value)
+}
+
+func TestImageAdmission_HandleAdmission_notaryFallbackWarning(t *testing.T) {
+	const registry = "registry.test"
+	img := registry + "/image:v1"
+	signedTag := notary.SignedTag{SignedTag: "v1", Digest: strings.Repeat("1", 64), Algorithm: "sha256", Signers: []string{"Repo Admin"}}
+
+	tc := map[string]struct {
+		notary string
+
+		expectedWarnings []string
+	}{
+		"notary": {
+			notary: "https://notary.test",
+		},
+		"fallback": {
+			expectedWarnings: []string{fmt.Sprintf("Image '%s@sha256:%s' is checked against the notary server %s, as its registry has no notary server in the registry security policy", img, signedTag.Digest, trust.DefaultNotaryServer)},
+		},
+	}
+
+	for caseName, c := range tc {
+		t.Run(caseName, func(t *testing.T) {
+			v := testCachedSignatureValidator(whv1.RegistrySpec{Registry: registry, Notary: c.notary, SignCheck: true}, img, signedTag)
+			v.signatureCache.Set(img, trust.DefaultNotaryServer, &notary.Signature{Name: img, SignedTags: []notary.SignedTag{signedTag}}, time.Minute)
+			im := &ImageAdmission{validator: v}
+
+			pod := generateTestPod(img, testCheckSign, "")
+			raw, err := json.Marshal(pod)
+			require.NoError(t, err)
+			review := &admissionv1beta1.AdmissionReview{
+				Request: &admissionv1beta1.AdmissionRequest{
+					Name:      pod.Name,
+					Namespace: pod.Namespace,
+					Operation: admissionv1beta1.Create,
+					Object:    runtime.RawExtension{Raw: raw},
+				},
+			}
+
+			require.NoError(t, im.HandleAdmission(review))
+			require.True(t, review.Response.Allowed, review.Response.Result.Message)
+			require.Equal(t, c.expectedWarnings, review.Response.Warnings)
+		})
+	}
+}
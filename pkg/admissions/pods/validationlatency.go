@@ -0,0 +1,70 @@
+package pods
+
+import (
+	"context"
+	"strconv"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+// Annotations of the validation latency, set to the allowed pods if Options.ValidationLatencyAnnotations is enabled
+const (
+	// ValidationLatencyAnnotation is the total time the pod's images are validated in (e.g., 1.234s)
+	ValidationLatencyAnnotation = "tmax.io/validation-latency"
+	// ValidationCacheHitAnnotation tells if any of the pod's images is validated from the caches (the signature cache or the validated digest cache),
+	// without the notary round-trip. It's true or false
+	ValidationCacheHitAnnotation = "tmax.io/validation-cache-hit"
+)
+
+type cacheHitKey struct{}
+
+// withCacheHit returns the context recording if the signature is read from the signature cache, and the recorded result
+func withCacheHit(ctx context.Context) (context.Context, *bool) {
+	hit := new(bool)
+	return context.WithValue(ctx, cacheHitKey{}, hit), hit
+}
+
+// recordCacheHit records the signature is read from the signature cache, if the context is recording it
+func recordCacheHit(ctx context.Context) {
+	if hit, ok := ctx.Value(cacheHitKey{}).(*bool); ok {
+		*hit = true
+	}
+}
+
+// cacheHit returns if any of the images is validated from the caches
+func (v validatedImages) cacheHit() bool {
+	for _, img := range v {
+		if img.cacheHit {
+			return true
+		}
+	}
+	return false
+}
+
+// setValidationCacheHitAnnotation sets ValidationCacheHitAnnotation of the pod validated by the signatures, if it's enabled
+func (h *validator) setValidationCacheHitAnnotation(pod *corev1.Pod, validated validatedImages) {
+	if !h.opts.ValidationLatencyAnnotations {
+		return
+	}
+	setAnnotation(pod, ValidationCacheHitAnnotation, strconv.FormatBool(validated.cacheHit()))
+}
+
+// setValidationLatencyAnnotation sets ValidationLatencyAnnotation of the allowed pod, if it's enabled.
+// The pods not validated by the signatures (e.g., whitelisted) have no cache hit
+func (h *validator) setValidationLatencyAnnotation(pod *corev1.Pod, latency time.Duration) {
+	if !h.opts.ValidationLatencyAnnotations {
+		return
+	}
+	setAnnotation(pod, ValidationLatencyAnnotation, latency.Round(time.Millisecond).String())
+	if _, exist := pod.Annotations[ValidationCacheHitAnnotation]; !exist {
+		setAnnotation(pod, ValidationCacheHitAnnotation, strconv.FormatBool(false))
+	}
+}
+
+func setAnnotation(pod *corev1.Pod, key, value string) {
+	if pod.Annotations == nil {
+		pod.Annotations = map[string]string{}
+	}
+	pod.Annotations[key] = value
+}
@@ -0,0 +1,84 @@
+package pods
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"github.com/tmax-cloud/image-validating-webhook/pkg/notary"
+	whv1 "github.com/tmax-cloud/image-validating-webhook/pkg/type"
+)
+
+func TestValidator_CheckIsValidAndAddDigest_validationLatencyAnnotations(t *testing.T) {
+	const registry = "registry.test"
+	img := registry + "/image:v1"
+	digest := "sha256:" + strings.Repeat("1", 64)
+
+	tc := map[string]struct {
+		disabled     bool
+		image        string
+		namespace    string
+		signedDigest string
+		validated    bool
+
+		expectedCacheHit string
+	}{
+		"signatureCacheHit": {
+			image:            img,
+			namespace:        testCheckSign,
+			expectedCacheHit: "true",
+		},
+		"validatedDigestCacheHit": {
+			image:            img + "@" + digest,
+			namespace:        testCheckSign,
+			validated:        true,
+			expectedCacheHit: "true",
+		},
+		"notValidatedBySignature": {
+			image:            img,
+			namespace:        "whitelisted-ns",
+			expectedCacheHit: "false",
+		},
+		"disabled": {
+			disabled:  true,
+			image:     img,
+			namespace: testCheckSign,
+		},
+	}
+
+	for caseName, c := range tc {
+		t.Run(caseName, func(t *testing.T) {
+			v := testCachedSignatureValidator(whv1.RegistrySpec{Registry: registry, Notary: "https://notary.test", SignCheck: true}, img,
+				notary.SignedTag{SignedTag: "v1", Digest: strings.Repeat("1", 64), Algorithm: "sha256", Signers: []string{"Repo Admin"}})
+			v.opts.ValidationLatencyAnnotations = !c.disabled
+			v.validatedDigests = newValidatedDigestCache(time.Minute)
+			require.NoError(t, v.whiteList.Unmarshal("", "whitelisted-ns"))
+			if c.validated {
+				v.validatedDigests.add(validatedDigestKey(&imageRef{host: registry, name: "image", digest: digest}, "", "https://notary.test", whv1.RegistrySpec{Registry: registry, Notary: "https://notary.test", SignCheck: true}), time.Time{})
+			}
+
+			pod := generateTestPod(c.image, c.namespace, "")
+			valid, _, err := v.CheckIsValidAndAddDigest(pod)
+			require.NoError(t, err)
+			require.True(t, valid)
+
+			if c.disabled {
+				require.NotContains(t, pod.Annotations, ValidationLatencyAnnotation)
+				require.NotContains(t, pod.Annotations, ValidationCacheHitAnnotation)
+				return
+			}
+			_, err = time.ParseDuration(pod.Annotations[ValidationLatencyAnnotation])
+			require.NoError(t, err)
+			require.Equal(t, c.expectedCacheHit, pod.Annotations[ValidationCacheHitAnnotation])
+
+			// The annotations are patched, even if the pod has no validated images
+			b, err := createPatch(pod, nil)
+			require.NoError(t, err)
+			var patch []patchOperation
+			require.NoError(t, json.Unmarshal(b, &patch))
+			require.Equal(t, "/metadata/annotations", patch[len(patch)-1].Path)
+		})
+	}
+}
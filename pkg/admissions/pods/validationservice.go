@@ -0,0 +1,116 @@
+package pods
+
+import (
+	"context"
+	"encoding/json"
+
+	"github.com/tmax-cloud/image-validating-webhook/pkg/admissions/pods/validationpb"
+	"github.com/tmax-cloud/image-validating-webhook/pkg/server"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	corev1 "k8s.io/api/core/v1"
+)
+
+// serviceValidator validates the images and the pods in a batch
+type serviceValidator interface {
+	Validator
+	batchValidator
+}
+
+// ValidationService validates images for the callers other than the API server (e.g., CI pipelines),
+// in the same way as the pods are validated by the admission handler. It implements validationpb.ValidationServiceServer
+type ValidationService struct {
+	validationpb.UnimplementedValidationServiceServer
+
+	validator serviceValidator
+	// authorizer authenticates the callers and authorizes them to the namespaces they validate images in
+	authorizer *callerAuthorizer
+}
+
+// NewValidationService initiates a new validation service, sharing the validator and its caches with the handlers
+func NewValidationService(cfg *server.HandlerConfig) (*ValidationService, error) {
+	v, err := getSharedValidator(cfg)
+	if err != nil {
+		return nil, err
+	}
+	return &ValidationService{validator: v, authorizer: &callerAuthorizer{client: cfg.ClientSet}}, nil
+}
+
+// RegisterValidationService registers a new validation service to the gRPC server
+func RegisterValidationService(cfg *server.HandlerConfig, srv *grpc.Server) error {
+	s, err := NewValidationService(cfg)
+	if err != nil {
+		return err
+	}
+	validationpb.RegisterValidationServiceServer(srv, s)
+	return nil
+}
+
+// ValidateImage validates the image, as a container of a pod in the request's namespace.
+// The caller should be authorized to create pods in the namespace
+func (s *ValidationService) ValidateImage(ctx context.Context, req *validationpb.ValidateImageRequest) (*validationpb.ValidateImageResponse, error) {
+	if req.Image == "" {
+		return nil, status.Error(codes.InvalidArgument, "image is required")
+	}
+	if req.PullSecret != "" && req.Namespace == "" {
+		return nil, status.Errorf(codes.InvalidArgument, "namespace of the pull secret %s is required", req.PullSecret)
+	}
+	if err := s.authorizer.authorize(ctx, req.Namespace); err != nil {
+		return nil, err
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, status.FromContextError(err).Err()
+	}
+
+	decision, err := decide(s.validator, req.Image, req.Namespace, req.PullSecret)
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	return &validationpb.ValidateImageResponse{
+		Allowed: decision.Allowed,
+		Reason:  decision.Reason,
+		Digest:  decision.Digest,
+		Signers: decision.Signers,
+	}, nil
+}
+
+// ValidatePods validates the JSON encoded pods in a batch, sharing the signatures fetched across them. An error of a pod is its result's error.
+// The caller should be authorized to create pods in all the pods' namespaces
+func (s *ValidationService) ValidatePods(ctx context.Context, req *validationpb.ValidatePodsRequest) (*validationpb.ValidatePodsResponse, error) {
+	pods := make([]*corev1.Pod, len(req.Pods))
+	namespaces := make([]string, len(req.Pods))
+	for i, raw := range req.Pods {
+		pod := &corev1.Pod{}
+		if err := json.Unmarshal(raw, pod); err != nil {
+			return nil, status.Errorf(codes.InvalidArgument, "pod %d is not a valid pod by %s", i, err)
+		}
+		if pod.Namespace == "" {
+			return nil, status.Errorf(codes.InvalidArgument, "namespace of pod %d is required", i)
+		}
+		pods[i], namespaces[i] = pod, pod.Namespace
+	}
+	if err := s.authorizer.authorize(ctx, namespaces...); err != nil {
+		return nil, err
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, status.FromContextError(err).Err()
+	}
+
+	resp := &validationpb.ValidatePodsResponse{}
+	for i, result := range s.validator.CheckPodsValidAndAddDigest(pods) {
+		podResult := &validationpb.ValidatePodResult{Allowed: result.Valid, Reason: result.Reason}
+		if result.Err != nil {
+			podResult.Error = result.Err.Error()
+		} else if result.Valid {
+			for _, containers := range [][]corev1.Container{pods[i].Spec.InitContainers, pods[i].Spec.Containers} {
+				for _, c := range containers {
+					podResult.Images = append(podResult.Images, c.Image)
+				}
+			}
+			podResult.Warnings = admissionWarnings(pods[i])
+		}
+		resp.Results = append(resp.Results, podResult)
+	}
+	return resp, nil
+}
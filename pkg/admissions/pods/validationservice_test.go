@@ -0,0 +1,182 @@
+package pods
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/tmax-cloud/image-validating-webhook/pkg/admissions/pods/validationpb"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+	"google.golang.org/protobuf/proto"
+	authenticationv1 "k8s.io/api/authentication/v1"
+	authorizationv1 "k8s.io/api/authorization/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+const testCallerToken = "test-caller-token"
+
+// testCallerAuthorizer authenticates testCallerToken as user ci, which is authorized to create pods only in testCheckSign
+func testCallerAuthorizer() *callerAuthorizer {
+	cli := fake.NewSimpleClientset()
+	cli.PrependReactor("create", "tokenreviews", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		review := action.(k8stesting.CreateActionImpl).GetObject().(*authenticationv1.TokenReview)
+		if review.Spec.Token == testCallerToken {
+			review.Status = authenticationv1.TokenReviewStatus{Authenticated: true, User: authenticationv1.UserInfo{Username: "ci", Groups: []string{"pipelines"}}}
+		}
+		return true, review, nil
+	})
+	cli.PrependReactor("create", "subjectaccessreviews", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		sar := action.(k8stesting.CreateActionImpl).GetObject().(*authorizationv1.SubjectAccessReview)
+		attrs := sar.Spec.ResourceAttributes
+		sar.Status.Allowed = sar.Spec.User == "ci" && attrs.Verb == validationServiceVerb && attrs.Resource == validationServiceResource && attrs.Namespace == testCheckSign
+		return true, sar, nil
+	})
+	return &callerAuthorizer{client: cli}
+}
+
+func testCallerContext(token string) context.Context {
+	if token == "" {
+		return context.Background()
+	}
+	return metadata.NewIncomingContext(context.Background(), metadata.Pairs("authorization", "Bearer "+token))
+}
+
+func TestValidationService_ValidateImage(t *testing.T) {
+	tc := map[string]struct {
+		req   *validationpb.ValidateImageRequest
+		token string
+
+		expectedResp *validationpb.ValidateImageResponse
+		expectedCode codes.Code
+	}{
+		"signed": {
+			req:          &validationpb.ValidateImageRequest{Image: "test-signed:v1", Namespace: testCheckSign, PullSecret: testSecretDcj},
+			token:        testCallerToken,
+			expectedResp: &validationpb.ValidateImageResponse{Allowed: true, Digest: testPinnedDigest, Signers: []string{"test-signer"}},
+		},
+		"notSigned": {
+			req:          &validationpb.ValidateImageRequest{Image: "test-not-signed:v1"},
+			token:        testCallerToken,
+			expectedResp: &validationpb.ValidateImageResponse{Reason: "image 'test-not-signed:v1' is not signed"},
+		},
+		"error": {
+			req:          &validationpb.ValidateImageRequest{Image: "test-error:v1"},
+			token:        testCallerToken,
+			expectedCode: codes.Internal,
+		},
+		"noImage": {
+			req:          &validationpb.ValidateImageRequest{},
+			token:        testCallerToken,
+			expectedCode: codes.InvalidArgument,
+		},
+		"pullSecretWithoutNamespace": {
+			req:          &validationpb.ValidateImageRequest{Image: "test-signed:v1", PullSecret: testSecretDcj},
+			token:        testCallerToken,
+			expectedCode: codes.InvalidArgument,
+		},
+		"noToken": {
+			req:          &validationpb.ValidateImageRequest{Image: "test-signed:v1"},
+			expectedCode: codes.Unauthenticated,
+		},
+		"invalidToken": {
+			req:          &validationpb.ValidateImageRequest{Image: "test-signed:v1"},
+			token:        "invalid-token",
+			expectedCode: codes.Unauthenticated,
+		},
+		"notAuthorizedNamespace": {
+			req:          &validationpb.ValidateImageRequest{Image: "test-signed:v1", Namespace: testNoCheckSign, PullSecret: testSecretDcj},
+			token:        testCallerToken,
+			expectedCode: codes.PermissionDenied,
+		},
+	}
+
+	for name, c := range tc {
+		t.Run(name, func(t *testing.T) {
+			s := &ValidationService{validator: &decisionDummyValidator{}, authorizer: testCallerAuthorizer()}
+			resp, err := s.ValidateImage(testCallerContext(c.token), c.req)
+			if c.expectedCode != codes.OK {
+				require.Equal(t, c.expectedCode, status.Code(err), err)
+				return
+			}
+			require.NoError(t, err)
+			require.True(t, proto.Equal(c.expectedResp, resp), resp.String())
+		})
+	}
+}
+
+func TestValidationService_ValidatePods(t *testing.T) {
+	s := &ValidationService{validator: &decisionDummyValidator{}, authorizer: testCallerAuthorizer()}
+	ctx := testCallerContext(testCallerToken)
+
+	resp, err := s.ValidatePods(ctx, &validationpb.ValidatePodsRequest{Pods: testEncodedPods(t,
+		generateTestPod("test-signed:v1", testCheckSign, ""),
+		generateTestPod("test-not-signed:v1", testCheckSign, ""),
+		generateTestPod("test-error:v1", testCheckSign, ""),
+	)})
+	require.NoError(t, err)
+	expected := []*validationpb.ValidatePodResult{
+		{Allowed: true, Images: []string{"test-signed:v1@" + testPinnedDigest}},
+		{Reason: "image 'test-not-signed:v1' is not signed"},
+		{Error: "notary server is unreachable"},
+	}
+	require.Len(t, resp.Results, len(expected))
+	for i := range expected {
+		require.True(t, proto.Equal(expected[i], resp.Results[i]), resp.Results[i].String())
+	}
+
+	_, err = s.ValidatePods(ctx, &validationpb.ValidatePodsRequest{Pods: [][]byte{[]byte("not a pod")}})
+	require.Equal(t, codes.InvalidArgument, status.Code(err))
+	_, err = s.ValidatePods(ctx, &validationpb.ValidatePodsRequest{Pods: testEncodedPods(t, generateTestPod("test-signed:v1", "", ""))})
+	require.Equal(t, codes.InvalidArgument, status.Code(err), "no namespace")
+	// Every namespace of the pods should be authorized
+	_, err = s.ValidatePods(ctx, &validationpb.ValidatePodsRequest{Pods: testEncodedPods(t,
+		generateTestPod("test-signed:v1", testCheckSign, ""),
+		generateTestPod("test-signed:v1", testNoCheckSign, ""),
+	)})
+	require.Equal(t, codes.PermissionDenied, status.Code(err))
+	_, err = s.ValidatePods(context.Background(), &validationpb.ValidatePodsRequest{Pods: testEncodedPods(t, generateTestPod("test-signed:v1", testCheckSign, ""))})
+	require.Equal(t, codes.Unauthenticated, status.Code(err))
+}
+
+func TestRegisterValidationService_grpc(t *testing.T) {
+	lis := bufconn.Listen(1024 * 1024)
+	srv := grpc.NewServer()
+	validationpb.RegisterValidationServiceServer(srv, &ValidationService{validator: &decisionDummyValidator{}, authorizer: testCallerAuthorizer()})
+	go func() { _ = srv.Serve(lis) }()
+	defer srv.Stop()
+
+	conn, err := grpc.Dial("bufnet", grpc.WithContextDialer(func(context.Context, string) (net.Conn, error) { return lis.Dial() }),
+		grpc.WithTransportCredentials(insecure.NewCredentials()))
+	require.NoError(t, err)
+	defer func() { _ = conn.Close() }()
+	cli := validationpb.NewValidationServiceClient(conn)
+
+	ctx := metadata.AppendToOutgoingContext(context.Background(), "authorization", "Bearer "+testCallerToken)
+	resp, err := cli.ValidateImage(ctx, &validationpb.ValidateImageRequest{Image: "test-signed:v1", Namespace: testCheckSign})
+	require.NoError(t, err)
+	require.True(t, resp.Allowed)
+	require.Equal(t, testPinnedDigest, resp.Digest)
+
+	_, err = cli.ValidateImage(context.Background(), &validationpb.ValidateImageRequest{Image: "test-signed:v1", Namespace: testCheckSign})
+	require.Equal(t, codes.Unauthenticated, status.Code(err))
+}
+
+func testEncodedPods(t *testing.T, pods ...*corev1.Pod) [][]byte {
+	var encoded [][]byte
+	for _, pod := range pods {
+		b, err := json.Marshal(pod)
+		require.NoError(t, err)
+		encoded = append(encoded, b)
+	}
+	return encoded
+}
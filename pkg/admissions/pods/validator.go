@@ -2,16 +2,22 @@ package pods
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"strings"
+	"time"
 
+	"github.com/docker/distribution/reference"
 	"github.com/google/go-containerregistry/pkg/name"
+	godigest "github.com/opencontainers/go-digest"
 	"github.com/tmax-cloud/image-validating-webhook/internal/utils"
 	cosigns "github.com/tmax-cloud/image-validating-webhook/pkg/cosign"
+	"github.com/tmax-cloud/image-validating-webhook/pkg/image"
 	"github.com/tmax-cloud/image-validating-webhook/pkg/notary"
+	"github.com/tmax-cloud/image-validating-webhook/pkg/trust"
 	whv1 "github.com/tmax-cloud/image-validating-webhook/pkg/type"
 	corev1 "k8s.io/api/core/v1"
-	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/kubernetes/scheme"
 	"k8s.io/client-go/rest"
@@ -39,20 +45,63 @@ type Validator interface {
 // validator handles overall process to check signs
 type validator struct {
 	client kubernetes.Interface
+	opts   Options
 
 	registryPolicyCache *RegistryPolicyCache
 	whiteList           *WhiteList
+	// denyList is the digests denied regardless of the signatures. nil denies none
+	denyList *DenyList
+	// enforcement elevates or relaxes the signature requirement of the namespaces. nil changes none
+	enforcement *NamespaceEnforcement
+	// proxyAuthSecrets are the proxy auth secrets referred by the cluster policies
+	proxyAuthSecrets *ProxyAuthSecrets
+	// recentDecisions are the last decisions of the pods. nil records none
+	recentDecisions  *recentDecisions
+	signatureCache   *notary.SignatureCache
+	validatedDigests *validatedDigestCache
+	// deniedImages are the images denied by their signatures recently. nil if it's disabled
+	deniedImages *deniedImageCache
+	// rotatedDigests are the digests signed for the tags recently. nil if the rotation window is disabled
+	rotatedDigests *rotatedDigestCache
+	// repoPool reuses the notary repositories across the requests. nil if it's disabled
+	repoPool *trust.RepoPool
+	// verifier verifies the signatures of the images. The notary verifier is used if it's nil
+	verifier Verifier
+	// credentialProvider provides the registry credentials of the pods' service accounts. nil if it's disabled
+	credentialProvider CredentialProvider
+	// batchSignatures are the signatures fetched in the batch of CheckPodsValidAndAddDigest. nil if it's not in a batch
+	batchSignatures *signatureBatch
+	// denyHints records the remediation hints of the denied images. nil records none
+	denyHints denyHints
 }
 
 func newValidator(cfg *rest.Config, clientSet kubernetes.Interface, restClient rest.Interface) (*validator, error) {
 	v := &validator{
-		client: clientSet,
+		client:         clientSet,
+		opts:           options,
+		signatureCache: notary.NewSignatureCache(),
+	}
+	if len(v.opts.AllowedNotaryServers) == 0 {
+		validatorLog.Info("all notary servers are allowed, as --allowed-notary-servers is not set. Set it to allow only the trusted ones")
+	}
+	if v.opts.EmptySignerPolicy != EmptySignerPolicyDeny {
+		validatorLog.Info("images released by any signer are allowed in the registries whose policies have no signer. Set --empty-signer-policy=Deny to deny them")
+	}
+	v.validatedDigests = newValidatedDigestCache(v.opts.ValidatedDigestTTL)
+	v.deniedImages = newDeniedImageCache(v.opts.DeniedImageTTL)
+	v.rotatedDigests = newRotatedDigestCache(v.opts.SignatureRotationWindow)
+	v.recentDecisions = newRecentDecisions(v.opts.RecentDecisions)
+	if v.opts.NotaryRepoTTL > 0 {
+		v.repoPool = trust.NewRepoPool(trust.DefaultCachePath, v.opts.NotaryRepoTTL)
+	}
+	if len(v.opts.TokenExchangeEndpoints) > 0 {
+		v.credentialProvider = newTokenExchangeProvider(clientSet, v.opts.TokenExchangeEndpoints, v.opts.TokenExchangeAudience, v.opts.TokenExchangeUsername)
 	}
 
 	var err error
 
 	// Initiate RegistryPolicy cache
-	v.registryPolicyCache, err = newRegistryPolicyCache(cfg, restClient)
+	v.registryPolicyCache, err = newRegistryPolicyCache(cfg, restClient, v.opts.DefaultPolicy, v.opts.PolicyPrecedence)
 	if err != nil {
 		return nil, err
 	}
@@ -63,16 +112,67 @@ func newValidator(cfg *rest.Config, clientSet kubernetes.Interface, restClient r
 		return nil, err
 	}
 
+	// Initiate DenyList cache
+	v.denyList, err = newDenyList(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	// Initiate namespace enforcement cache
+	v.enforcement, err = newNamespaceEnforcement(cfg, v.opts.PermissiveEnforcement)
+	if err != nil {
+		return nil, err
+	}
+
+	// Initiate proxy auth secret cache
+	v.proxyAuthSecrets, err = newProxyAuthSecrets(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	// Report the misconfigured policies at startup, rather than at admission time
+	v.reportPolicyProblems()
+
 	return v, nil
 }
 
-// CheckIsValidAndAddDigest checks if images of initContainers and containers are valid
+// CheckIsValidAndAddDigest checks if images of initContainers and containers are valid, recording the decision
 func (h *validator) CheckIsValidAndAddDigest(pod *corev1.Pod) (bool, string, error) {
+	start := time.Now()
+	images := podImages(pod)
+	valid, reason, err := h.checkIsValidAndAddDigest(pod)
+	if valid && err == nil {
+		h.setValidationLatencyAnnotation(pod, time.Since(start))
+	}
+	h.recordDecision(pod, images, valid, reason, err)
+	return valid, reason, err
+}
+
+func (h *validator) checkIsValidAndAddDigest(pod *corev1.Pod) (bool, string, error) {
 	// Check namespace whitelist
 	if h.whiteList.IsNamespaceWhiteListed(pod.Namespace) {
 		return true, "", nil
 	}
 
+	// Skip the pods not selected
+	if h.opts.PodSelector != nil && !h.opts.PodSelector.Matches(labels.Set(pod.Labels)) {
+		validatorLog.Info("skipping validation of the pod not selected", "namespace", pod.Namespace, "pod", pod.Name)
+		return true, "", nil
+	}
+
+	// Deny the pods with too many containers, not to request the notary servers for each of them
+	if numContainers := len(pod.Spec.InitContainers) + len(pod.Spec.Containers); h.opts.MaxContainers > 0 && numContainers > h.opts.MaxContainers {
+		return false, fmt.Sprintf("Pod has %d containers, which exceeds the maximum %d to validate", numContainers, h.opts.MaxContainers), nil
+	}
+
+	// Validate and pin the images rewritten to the mirrors
+	h.rewriteImages(pod)
+
+	// Deny the malformed image references with the clear reasons, rather than failing to validate them
+	if reason := invalidReferencesReason(pod); reason != "" {
+		return false, reason, nil
+	}
+
 	// TODO: Check both Notary and Cosign Signature
 	var reasonRes []string
 	// Image validating with notary
@@ -80,7 +180,7 @@ func (h *validator) CheckIsValidAndAddDigest(pod *corev1.Pod) (bool, string, err
 		return false, "", err
 		// if image valid with notary, return true
 	} else if isValid {
-		return true, "", nil
+		return h.checkNotDenied(pod)
 		// if image invalid, reason append reason array
 	} else {
 		if reason != "" {
@@ -92,7 +192,7 @@ func (h *validator) CheckIsValidAndAddDigest(pod *corev1.Pod) (bool, string, err
 		return false, "", err
 		// if image valid with cosign, return true
 	} else if isValid {
-		return true, "", nil
+		return h.checkNotDenied(pod)
 		// if image invalid, reason append reason array
 	} else {
 		if reason != "" {
@@ -104,189 +204,653 @@ func (h *validator) CheckIsValidAndAddDigest(pod *corev1.Pod) (bool, string, err
 	return false, reasons, nil
 }
 
+// invalidReferencesReason returns why the pod is denied if any of its images is not a valid image reference,
+// by the docker reference grammar as well as the webhook's parser, which is lenient to some malformed ones (e.g., registry.test/image::v1).
+// The internationalized hosts are checked in their punycode forms, as the docker reference grammar allows only the ASCII ones
+func invalidReferencesReason(pod *corev1.Pod) string {
+	var reasons []string
+	for _, containers := range [][]corev1.Container{pod.Spec.InitContainers, pod.Spec.Containers} {
+		for _, c := range containers {
+			ascii, err := asciiHostReference(c.Image)
+			if err == nil {
+				_, err = reference.ParseNormalizedNamed(ascii)
+			}
+			if err == nil {
+				_, err = parseImage(c.Image)
+			}
+			if err != nil {
+				reasons = append(reasons, fmt.Sprintf("Image '%s' is not a valid image reference: %s", c.Image, err))
+			}
+		}
+	}
+	return strings.Join(reasons, "\n")
+}
+
+// asciiHostReference returns the image reference whose host is converted to its punycode form by normalizeHost.
+// The image is returned as it is if it has no host
+func asciiHostReference(img string) (string, error) {
+	i := strings.Index(img, "/")
+	if i < 0 {
+		return img, nil
+	}
+	host, err := normalizeHost(img[:i])
+	if err != nil {
+		return "", err
+	}
+	return host + img[i:], nil
+}
+
 // notaryImageValid check if image is valid(signing) that using notary(DCT)
 func (h *validator) notaryImageValid(pod *corev1.Pod) (bool, string, error) {
-	// Check initContainers
-	if isValid, reason, err := h.addDigestWhenImageValid(pod.Spec.InitContainers, pod.Namespace, pod.Spec.ImagePullSecrets); err != nil {
-		return false, "", err
-	} else if !isValid {
-		return false, reason, nil
+	overrides := h.notaryOverrides(pod)
+	validated := validatedImages{}
+	isValid, reason, err := validateContainers(pod, func(container *corev1.Container, namespace string, pullSecrets []corev1.LocalObjectReference) (bool, string, error) {
+		return h.addDigestWhenImageValid(container, namespace, pod.Spec.ServiceAccountName, pullSecrets, overrides, validated)
+	})
+	if err != nil || !isValid {
+		return isValid, reason, err
 	}
-	// Check containers
-	if isValid, reason, err := h.addDigestWhenImageValid(pod.Spec.Containers, pod.Namespace, pod.Spec.ImagePullSecrets); err != nil {
+	if err := setValidatedImagesAnnotation(pod, validated); err != nil {
 		return false, "", err
-	} else if !isValid {
-		return false, reason, nil
 	}
-
+	h.setValidationCacheHitAnnotation(pod, validated)
 	return true, "", nil
 }
 
 // cosignImageValid check if image is valid(signing) that using cosign
 func (h *validator) cosignImageValid(pod *corev1.Pod) (bool, string, error) {
-	// Check initContainers
-	if isValid, reason, err := h.addDigestWhenImageValidCosign(pod.Spec.InitContainers, pod.Namespace, pod.Spec.ImagePullSecrets); err != nil {
-		return false, "", err
-	} else if !isValid {
-		return false, reason, nil
+	return validateContainers(pod, h.addDigestWhenImageValidCosign)
+}
+
+// containerValidateFunc validates a container, adding digest to its image if it's needed
+type containerValidateFunc func(container *corev1.Container, namespace string, pullSecrets []corev1.LocalObjectReference) (bool, string, error)
+
+// validateContainers validates all the initContainers and containers of the pod.
+// Reasons of every invalid container are combined, so that users can fix them at once
+func validateContainers(pod *corev1.Pod, validate containerValidateFunc) (bool, string, error) {
+	var reasons []string
+	for _, containers := range [][]corev1.Container{pod.Spec.InitContainers, pod.Spec.Containers} {
+		for i := range containers {
+			isValid, reason, err := validate(&containers[i], pod.Namespace, pod.Spec.ImagePullSecrets)
+			if err != nil {
+				return false, "", err
+			}
+			if !isValid {
+				reasons = append(reasons, reason)
+			}
+		}
 	}
-	// Check containers
-	if isValid, reason, err := h.addDigestWhenImageValidCosign(pod.Spec.Containers, pod.Namespace, pod.Spec.ImagePullSecrets); err != nil {
-		return false, "", err
-	} else if !isValid {
-		return false, reason, nil
+	if len(reasons) > 0 {
+		return false, strings.Join(reasons, "\n"), nil
 	}
 	return true, "", nil
 }
 
-func (h *validator) addDigestWhenImageValidCosign(containers []corev1.Container, namespace string, pullSecrets []corev1.LocalObjectReference) (bool, string, error) {
-	for _, container := range containers {
-		// Check if it`s whitelisted
-		if h.whiteList.IsImageWhiteListed(container.Image) {
-			continue
-		}
+func (h *validator) addDigestWhenImageValidCosign(container *corev1.Container, namespace string, pullSecrets []corev1.LocalObjectReference) (bool, string, error) {
+	// Check if it`s whitelisted
+	if h.whiteList.IsImageWhiteListed(container.Image) {
+		return true, "", nil
+	}
 
-		ref, err := parseImage(container.Image)
-		if err != nil {
-			return false, "", err
-		}
+	ref, err := parseImage(container.Image)
+	if err != nil {
+		return false, "", err
+	}
 
-		// Check if it meets registry security policy
-		if valid, policy := h.registryPolicyCache.doesMatchPolicy(ref.host, namespace); valid && policy.Registry == "" {
+	// Check if it meets registry security policy
+	valid, policy, err := h.registryPolicyCache.doesMatchPolicy(ref.host, namespace)
+	if err != nil {
+		return false, "", err
+	}
+	if valid {
+		var enforceable bool
+		if policy, enforceable = h.enforcedPolicy(policy, ref.host, namespace); !enforceable {
+			return false, "Cosign: " + strictNotEnforceableReason(container.Image, namespace), nil
+		}
+		if !policy.SignCheck {
 			return true, "", nil
-		} else if valid {
-			if !policy.SignCheck {
-				return true, "", nil
-			}
-			// Get Cosign Key pair from secret object
-			secret, err := cosigns.GetKeyPairSecret(context.TODO(), h.client, policy.CosignKeyRef)
-			if err != nil {
-				validatorLog.Error(err, "")
-				return false, "", err
-			}
-			// Get Public Key from Secret
-			keys, err := cosigns.GetPublicKey(secret.Data)
-			if err != nil {
-				validatorLog.Error(err, "")
-				return false, "", err
-			}
-			// Valid Image
-			imgRef, err := name.ParseReference(container.Image)
-			if err != nil {
-				validatorLog.Error(err, "")
-				return false, "", err
+		}
+		if decided, allowed := h.decidePullNever(container); decided {
+			// Without a cosign key, it's checked only by notary, whose reason is responded
+			if allowed || policy.CosignKeyRef == "" {
+				return allowed, "", nil
 			}
-			// If the image signature is not valid, an error is raised
-			sig, err := cosigns.Valid(context.TODO(), imgRef, policy.Signer, keys)
-			if err != nil {
-				// if signer annotation is incorrect, Signer is Invalid
-				if strings.Contains(err.Error(), "missing or incorrect annotation") {
-					return false, fmt.Sprintf("Cosign: Image '%s's signer is invalid", container.Image), nil
-				}
-				return false, fmt.Sprintf("Cosign: Image '%s' is invalid", container.Image), nil
+			return false, "Cosign: " + pullNeverReason(container.Image), nil
+		}
+		return h.validateByCosign(container, ref, policy)
+	}
+	// Does NOT match registry security policy
+	return false, h.deny(denyReasonNoPolicy, container.Image, fmt.Sprintf("Cosign: Image '%s' does not meet registry security policy. Please check the RegistrySecurityPolicy", container.Image)), nil
+}
 
-			}
+// validateByCosign validates the image by its cosign signatures. The signatures attached by the OCI referrers API are verified first,
+// pinning the verified digest, falling back to the signatures of the tag convention (<digest>.sig) if there are none
+func (h *validator) validateByCosign(container *corev1.Container, ref *imageRef, policy whv1.RegistrySpec) (bool, string, error) {
+	// Without a cosign key (e.g., the default policy), it's checked only by notary, whose reason is responded
+	if policy.CosignKeyRef == "" {
+		return false, "", nil
+	}
+	// Get Cosign Key pair from secret object
+	secret, err := cosigns.GetKeyPairSecret(context.TODO(), h.client, policy.CosignKeyRef)
+	if err != nil {
+		validatorLog.Error(err, "")
+		return false, "", err
+	}
+	// Get Public Key from Secret
+	keys, err := cosigns.GetPublicKey(secret.Data)
+	if err != nil {
+		validatorLog.Error(err, "")
+		return false, "", err
+	}
+	// Valid Image
+	imgRef, err := name.ParseReference(container.Image)
+	if err != nil {
+		validatorLog.Error(err, "")
+		return false, "", err
+	}
 
-			if sig == nil {
-				return false, fmt.Sprintf("Cosign: Image '%s' signature is empty", container.Image), nil
-			}
+	// Signatures attached by the referrers API (e.g., cosign v2 with --registry-referrers-mode=oci-1-1)
+	digest, err := cosigns.ValidReferrers(context.TODO(), imgRef, policy.Signer, keys)
+	if err == nil {
+		h.pinDigest(container, ref, digest)
+		return true, "", nil
+	}
+	if !errors.Is(err, cosigns.ErrNoReferrers) {
+		validatorLog.Info("couldn't verify the signature referrers, falling back to the signature tag", "image", container.Image, "reason", err.Error())
+	}
 
-			return true, "", nil
+	// If the image signature is not valid, an error is raised
+	sig, err := cosigns.Valid(context.TODO(), imgRef, policy.Signer, keys)
+	if err != nil {
+		// if signer annotation is incorrect, Signer is Invalid
+		if strings.Contains(err.Error(), "missing or incorrect annotation") {
+			return false, h.deny(denyReasonSignerMismatch, container.Image, fmt.Sprintf("Cosign: Image '%s's signer is invalid", container.Image)), nil
 		}
-		// Does NOT match registry security policy
-		return false, fmt.Sprintf("Cosign: Image '%s' does not meet registry security policy. Please check the RegistrySecurityPolicy", container.Image), nil
+		return false, h.deny(denyReasonCosignNotSigned, container.Image, fmt.Sprintf("Cosign: Image '%s' is invalid", container.Image)), nil
+
+	}
+
+	if sig == nil {
+		return false, h.deny(denyReasonCosignNotSigned, container.Image, fmt.Sprintf("Cosign: Image '%s' signature is empty", container.Image)), nil
 	}
+
 	return true, "", nil
 }
 
-func (h *validator) addDigestWhenImageValid(containers []corev1.Container, namespace string, pullSecrets []corev1.LocalObjectReference) (bool, string, error) {
-	for i, container := range containers {
-		// Check if it's whitelisted
-		if h.whiteList.IsImageWhiteListed(container.Image) {
-			continue
-		}
+// addDigestWhenImageValid validates the container's image by notary, using the notary server overridden for the image if there is.
+// The images validated by their signatures are recorded to validated
+func (h *validator) addDigestWhenImageValid(container *corev1.Container, namespace, serviceAccount string, pullSecrets []corev1.LocalObjectReference, notaryOverrides map[string]string, validated validatedImages) (bool, string, error) {
+	// Check if it's whitelisted
+	if h.whiteList.IsImageWhiteListed(container.Image) {
+		return true, "", nil
+	}
 
-		ref, err := parseImage(container.Image)
-		if err != nil {
-			return false, "", err
-		}
+	ref, err := parseImage(container.Image)
+	if err != nil {
+		return false, "", err
+	}
 
-		// Get registry basic auth
-		basicAuth, err := h.getBasicAuthForRegistry(ref.host, namespace, pullSecrets)
-		if err != nil {
-			return false, "", err
+	// Check if it meets registry security policy
+	valid, policy, err := h.registryPolicyCache.doesMatchPolicy(ref.host, namespace)
+	if err != nil {
+		return false, "", err
+	}
+	if valid {
+		var enforceable bool
+		if policy, enforceable = h.enforcedPolicy(policy, ref.host, namespace); !enforceable {
+			return false, "Notary: " + strictNotEnforceableReason(container.Image, namespace), nil
 		}
+	}
+
+	// Get registry basic auth
+	basicAuth, err := h.getBasicAuthForPolicy(ref.host, policy, namespace, serviceAccount, pullSecrets)
+	if err != nil {
+		return false, "", err
+	}
 
-		// Check if it meets registry security policy
-		if valid, policy := h.registryPolicyCache.doesMatchPolicy(ref.host, namespace); valid && policy.Registry == "" {
+	if valid {
+		if !policy.SignCheck {
 			return true, "", nil
-		} else if valid {
-			if !policy.SignCheck {
+		}
+		if decided, allowed := h.decidePullNever(container); decided {
+			if allowed {
 				return true, "", nil
 			}
-			// Get trust info of the image
-			sig, err := notary.FetchSignature(container.Image, basicAuth, policy.Notary)
-			if err != nil {
-				validatorLog.Error(err, "")
-				return false, "", err
-			}
-			// sig is nil if it's not signed
-			if sig == nil {
-				return false, fmt.Sprintf("Notary: Image '%s' is invalid", container.Image), nil
-			}
+			return false, "Notary: " + pullNeverReason(container.Image), nil
+		}
+		if notaryURL, exist := notaryOverrides[container.Image]; exist {
+			validatorLog.Info("overriding notary server", "image", container.Image, "notary", notaryURL)
+			policy.Notary = notaryURL
+		}
+		if reason := unauthenticatedPullReason(container, ref, basicAuth, policy); reason != "" {
+			return false, h.deny(denyReasonNoCredential, ref.host, reason), nil
+		}
+		return h.validateBySignature(container, ref, namespace, basicAuth, policy, validated)
+	}
+	// Does NOT match registry security policy
+	return false, h.deny(denyReasonNoPolicy, container.Image, fmt.Sprintf("Notary: Image '%s' does not meet registry security policy. Please check the RegistrySecurityPolicy", container.Image)), nil
+}
 
-			// If signer is different from signer policy, return false & invalid
-			isMatchSigner := sig.MatchSigner(policy.Signer)
-			if !isMatchSigner {
-				return false, fmt.Sprintf("Notary: Image '%s's signer is invalid", container.Image), nil
-			}
+// unauthenticatedPullReason returns why the image is denied if the registry requires authenticated pulls but there is no credential for it,
+// rather than checking it anonymously
+func unauthenticatedPullReason(container *corev1.Container, ref *imageRef, basicAuth string, policy whv1.RegistrySpec) string {
+	if !policy.RequireAuthenticatedPull || basicAuth != "" {
+		return ""
+	}
+	return fmt.Sprintf("Notary: Image '%s' has no credential for the registry %s, which requires authenticated pulls. Please check the pull secrets of the pod", container.Image, ref.host)
+}
 
-			digest := sig.GetDigest(ref.tag)
+// validateBySignature validates the image by its notary signature, pinning the signed digest
+func (h *validator) validateBySignature(container *corev1.Container, ref *imageRef, namespace, basicAuth string, policy whv1.RegistrySpec, validated validatedImages) (bool, string, error) {
+	notaryURL, err := h.notaryServer(policy)
+	if reason := notaryServerReason(container.Image, err); reason != "" {
+		return false, reason, nil
+	}
+	if err != nil {
+		return false, "", err
+	}
+	checked := ValidatedImage{Notary: notaryURL, NotaryFallback: policy.Notary == "" && h.opts.NotarySocket == ""}
+	validatorLog.Info("checking signature", "image", container.Image, "notary", notaryURL, "fallback", checked.NotaryFallback)
 
-			// If digest is different from user-specified one, return error
-			if ref.digest != "" && ref.digest != digest {
-				return false, fmt.Sprintf("Notary: Image '%s''s digest is different from the signed digest", container.Image), nil
-			}
+	// Skip the notary round-trip for the digest validated recently (e.g., pods recreated by a rolling update)
+	if h.isValidatedDigest(container, ref, basicAuth, notaryURL, policy, checked, validated) {
+		return true, "", nil
+	}
 
-			ref.digest = digest
-			containers[i].Image = ref.String()
+	// Deny the image denied recently (e.g., pods recreated by a crash-looping controller) without the notary round-trip
+	deniedKey := deniedImageKey(container.Image, namespace, basicAuth, notaryURL, policy)
+	if denied, exist := h.deniedImages.get(deniedKey); exist {
+		return false, h.denyHints.record(denied), nil
+	}
+	valid, denied, err := h.verifySignature(container, ref, basicAuth, notaryURL, policy, checked, validated)
+	if !valid && denied.reason != "" && err == nil {
+		h.deniedImages.add(deniedKey, denied)
+	}
+	return valid, h.denyHints.record(denied), err
+}
 
-			return true, "", nil
+// verifySignature verifies the signature of the image with the resolved notary server, pinning the signed digest.
+// It returns why the image is denied if it's not valid
+func (h *validator) verifySignature(container *corev1.Container, ref *imageRef, basicAuth, notaryURL string, policy whv1.RegistrySpec, checked ValidatedImage, validated validatedImages) (bool, deniedReason, error) {
+	verifying := policy
+	verifying.Notary = notaryURL
+	ctx, cacheHit := withCacheHit(context.TODO())
+	ctx, signedAt := withSignedAt(ctx)
+	digest, signers, err := h.signatureVerifier().Verify(ctx, container.Image, basicAuth, verifying)
+	var denied *deniedError
+	var notSigned *notSignedError
+	switch {
+	case errors.As(err, &denied):
+		subject := denied.subject
+		if subject == "" {
+			subject = container.Image
 		}
-		// Does NOT match registry security policy
-		return false, fmt.Sprintf("Notary: Image '%s' does not meet registry security policy. Please check the RegistrySecurityPolicy", container.Image), nil
+		return false, deniedReason{kind: denied.kind, subject: subject, reason: denied.reason}, nil
+	case errors.As(err, &notSigned):
+		return h.validateWithoutReleasedSignature(container, ref, basicAuth, policy, notSigned)
+	case err != nil:
+		validatorLog.Error(err, "")
+		return false, deniedReason{}, err
 	}
-	return true, "", nil
+
+	// If digest is different from user-specified one, return error unless it's signed before the tag is re-signed
+	pinned, ok := h.pinnedDigest(ref, basicAuth, notaryURL, policy, digest)
+	if !ok {
+		return false, deniedReason{kind: denyReasonDigestMismatch, subject: container.Image, reason: fmt.Sprintf("Notary: Image '%s''s digest is different from the signed digest", container.Image)}, nil
+	}
+	if denied, err := h.pinTimeReason(container, ref, basicAuth, pinned, policy); err != nil || denied.reason != "" {
+		return false, denied, err
+	}
+
+	h.pinDigest(container, ref, pinned)
+	// The prior digest allowed only within the rotation window is not cached, not to be allowed after the window closes
+	if pinned == digest {
+		h.validatedDigests.add(validatedDigestKey(ref, basicAuth, notaryURL, policy), *signedAt)
+	}
+	checked.Signers = signers
+	checked.cacheHit = *cacheHit
+	checked.SignedAt, checked.SignatureAging = signatureAge(*signedAt, policy)
+	validated.record(container, checked)
+
+	return true, deniedReason{}, nil
 }
 
-func (h *validator) getBasicAuthForRegistry(host, namespace string, pullSecrets []corev1.LocalObjectReference) (string, error) {
-	for _, pullSecret := range pullSecrets {
-		secret, err := h.client.CoreV1().Secrets(namespace).Get(context.Background(), pullSecret.Name, metav1.GetOptions{})
-		if err != nil {
-			return "", fmt.Errorf("couldn't get secret named %s by %s", pullSecret.Name, err)
+// isValidatedDigest checks if the image's digest is validated by the policy recently, recording it with its signature age as it's validated
+func (h *validator) isValidatedDigest(container *corev1.Container, ref *imageRef, basicAuth, notaryURL string, policy whv1.RegistrySpec, checked ValidatedImage, validated validatedImages) bool {
+	if ref.digest == "" {
+		return false
+	}
+	signedAt, hit := h.validatedDigests.get(validatedDigestKey(ref, basicAuth, notaryURL, policy))
+	// The signature which gets older than the maximum age after it's validated is verified again, to be denied
+	if !hit || isSignatureTooOld(signedAt, policy) {
+		return false
+	}
+	checked.cacheHit = true
+	checked.SignedAt, checked.SignatureAging = signatureAge(signedAt, policy)
+	validated.record(container, checked)
+	return true
+}
+
+// signedAtOf returns when the image was signed, estimated from the expiry of the role which signed the tag (see SignatureExpiry of the policy),
+// so it's the time the role was last signed. It's zero if it's unknown
+func signedAtOf(sig *notary.Signature, ref *imageRef, policy whv1.RegistrySpec) time.Time {
+	if policy.SignatureExpiry == nil {
+		return sig.GetSignedAt(ref.tag)
+	}
+	return sig.GetSignedAtByExpiry(ref.tag, policy.SignatureExpiry.Duration)
+}
+
+// isSignatureTooOld checks if the signature signed at the time is older than the maximum age of the policy
+func isSignatureTooOld(signedAt time.Time, policy whv1.RegistrySpec) bool {
+	return policy.MaxSignatureAge != nil && time.Since(signedAt) > policy.MaxSignatureAge.Duration
+}
+
+// signatureAgeReason returns why the image is denied if its signature is older than the maximum age of the policy
+func signatureAgeReason(image string, ref *imageRef, sig *notary.Signature, policy whv1.RegistrySpec) string {
+	if policy.MaxSignatureAge == nil {
+		return ""
+	}
+	signedAt := signedAtOf(sig, ref, policy)
+	if signedAt.IsZero() {
+		return fmt.Sprintf("Notary: Image '%s''s signing time is unknown, but the maximum signature age is %s", image, policy.MaxSignatureAge.Duration)
+	}
+	if isSignatureTooOld(signedAt, policy) {
+		return fmt.Sprintf("Notary: Image '%s' was signed at %s, which is older than the maximum signature age %s. Please re-sign it",
+			image, signedAt.UTC().Format(time.RFC3339), policy.MaxSignatureAge.Duration)
+	}
+	return ""
+}
+
+// matchedSigners returns the signers who are the policy signers.
+// All the signers are returned if none of them is, as the image is allowed by the repository admin
+func matchedSigners(signers, policySigners []string) []string {
+	var matched []string
+	for _, signer := range signers {
+		for _, sgr := range policySigners {
+			if signer == sgr {
+				matched = append(matched, signer)
+				break
+			}
 		}
-		imagePullSecret, err := utils.NewImagePullSecret(secret)
-		if err != nil {
-			return "", err
+	}
+	if len(matched) == 0 {
+		return signers
+	}
+	return matched
+}
+
+// isWellFormedDigest checks if the digest is a non-zero digest with its algorithm (e.g., sha256:<64 hex chars>)
+func isWellFormedDigest(d string) bool {
+	parsed, err := godigest.Parse(d)
+	if err != nil {
+		return false
+	}
+	return strings.Trim(parsed.Encoded(), "0") != ""
+}
+
+// pinDigest pins the container's image to the digest, in the reference format of the pin format.
+// The tag is dropped for PinFormatDigest, but kept in ref
+func (h *validator) pinDigest(container *corev1.Container, ref *imageRef, digest string) {
+	ref.digest = digest
+	pinned := *ref
+	if h.opts.PinFormat == PinFormatDigest {
+		pinned.tag = ""
+	}
+	container.Image = pinned.String()
+}
+
+// clientProfile returns the timeout and the retries of the requests to the notary server of the registry
+func clientProfile(policy whv1.RegistrySpec) trust.ClientProfile {
+	profile := trust.ClientProfile{Retries: policy.Retries}
+	if policy.Timeout != nil {
+		profile.Timeout = policy.Timeout.Duration
+	}
+	if policy.ReadTimeout != nil {
+		profile.ReadTimeout = policy.ReadTimeout.Duration
+	}
+	if policy.RetryBackoff != nil {
+		profile.Backoff = policy.RetryBackoff.Duration
+	}
+	return profile
+}
+
+// notaryClientProfile returns the client profile of the registry's notary server, authenticating to the forward proxy by its proxy auth secret.
+// The proxy auth secret is only of the cluster policies, as the namespace ones are dropped by orderedRegistrySpecs.
+// The responses larger than the maximum trust metadata size fail
+func (h *validator) notaryClientProfile(policy whv1.RegistrySpec) (trust.ClientProfile, error) {
+	profile := clientProfile(policy)
+	profile.MaxResponseSize = h.opts.MaxTrustMetadataSize
+	profile.HostAliases = h.opts.NotaryHostAliases
+	if policy.ProxyAuthSecret == "" {
+		return profile, nil
+	}
+	authorization, err := h.proxyAuthSecrets.authorization(policy.ProxyAuthSecret)
+	if err != nil {
+		return profile, err
+	}
+	profile.ProxyAuthorization = authorization
+	return profile, nil
+}
+
+// noNotaryServerError tells the registry has no notary server, and falling back to docker hub's notary server is disabled
+type noNotaryServerError struct {
+	registry string
+}
+
+func (e *noNotaryServerError) Error() string {
+	return fmt.Sprintf("registry %s has no notary server and falling back to %s is disabled", e.registry, trust.DefaultNotaryServer)
+}
+
+// notaryServerReason returns why the image is denied if its notary server can't be resolved by a misconfiguration of the policy, rather than a failure.
+// It's denied regardless of the error policy, as the notary server may be the attacker's, or there's none to check the signature against
+func notaryServerReason(image string, err error) string {
+	var notAllowed *notaryNotAllowedError
+	if errors.As(err, &notAllowed) {
+		return fmt.Sprintf("Notary: Image '%s' is checked by the notary server %s, which is not allowed. Please check the RegistrySecurityPolicy", image, notAllowed.notaryURL)
+	}
+	var noNotary *noNotaryServerError
+	if errors.As(err, &noNotary) {
+		return fmt.Sprintf("Notary: Image '%s''s registry %s has no notary server configured, and falling back to %s is disabled. Please set the notary of the RegistrySecurityPolicy",
+			image, noNotary.registry, trust.DefaultNotaryServer)
+	}
+	return ""
+}
+
+// notaryServer returns the notary server of the registry, or the local notary proxy if it's configured.
+// If the registry has no notary server, docker hub's notary server is used unless the fallback is disabled, in which case a *noNotaryServerError is returned.
+// A notary server not in the allowlist is not returned, but a *notaryNotAllowedError
+func (h *validator) notaryServer(policy whv1.RegistrySpec) (string, error) {
+	if h.opts.NotarySocket != "" {
+		return trust.UnixSocketScheme + h.opts.NotarySocket, nil
+	}
+	notaryURL := policy.Notary
+	if notaryURL == "" {
+		if h.opts.DisableDefaultNotary {
+			return "", &noNotaryServerError{registry: policy.Registry}
 		}
-		basicAuth, err := imagePullSecret.GetHostBasicAuth(h.findRegistryServer(host))
-		if err != nil {
-			return "", err
+		validatorLog.Info("registry has no notary server, falling back to docker hub's notary server", "registry", policy.Registry, "notary", trust.DefaultNotaryServer)
+		notaryURL = trust.DefaultNotaryServer
+	}
+	if !h.isNotaryServerAllowed(notaryURL) {
+		return "", &notaryNotAllowedError{notaryURL: notaryURL}
+	}
+	return notaryURL, nil
+}
+
+// releaseRolesOf returns the release roles of the image's repository, i.e., the policy's release roles and the repository's own ones
+func releaseRolesOf(ref *imageRef, policy whv1.RegistrySpec) []string {
+	repoRoles := policy.RepositoryReleaseRoles[ref.name]
+	if len(repoRoles) == 0 {
+		return policy.ReleaseRoles
+	}
+	return append(append([]string{}, policy.ReleaseRoles...), repoRoles...)
+}
+
+// fetchSignature fetches the signature of the image, from the signature cache if it's warmed up.
+// The cache is not used for the custom release roles, as the cached signatures are of the default ones.
+// In a batch, the signature fetched for a pod is reused for the others. The notary server is requested by the client profile of the policy
+func (h *validator) fetchSignature(ctx context.Context, ref *imageRef, basicAuth, notaryURL string, policy whv1.RegistrySpec) (*notary.Signature, error) {
+	releaseRoles := releaseRolesOf(ref, policy)
+	if h.signatureCache != nil && len(releaseRoles) == 0 {
+		if sig, exist := h.signatureCache.Get(ref.String(), notaryURL); exist {
+			recordCacheHit(ctx)
+			return sig, nil
+		}
+	}
+	key := signatureBatchKey(ref, basicAuth, notaryURL, releaseRoles)
+	if sig, exist := h.batchSignatures.get(key); exist {
+		return sig, nil
+	}
+	img, err := ref.toImage(h.imageOptions(), basicAuth)
+	if err != nil {
+		return nil, err
+	}
+	profile, err := h.notaryClientProfile(policy)
+	if err != nil {
+		return nil, err
+	}
+	sig, err := notary.FetchProfiledSignature(h.repoPool, img, notaryURL, profile, releaseRoles...)
+	if err != nil {
+		return nil, err
+	}
+	h.batchSignatures.set(key, sig)
+	h.cacheSignature(ref, basicAuth, notaryURL, releaseRoles, sig)
+	return sig, nil
+}
+
+// cacheSignature keeps the signature in the signature cache shared with the cache warm-up, so the other pods (and the warm-up) reuse it.
+// Only the signatures fetched without credentials and of the default release roles are kept, as the warm-up does,
+// not to share a signature with the pods which have no access to it. The images which are not signed are not kept, to allow them once they're signed
+func (h *validator) cacheSignature(ref *imageRef, basicAuth, notaryURL string, releaseRoles []string, sig *notary.Signature) {
+	if h.signatureCache == nil || h.opts.SignatureCacheTTL <= 0 || basicAuth != "" || len(releaseRoles) > 0 || sig == nil {
+		return
+	}
+	h.signatureCache.Set(ref.String(), notaryURL, sig, h.opts.SignatureCacheTTL)
+}
+
+// validateWithoutReleasedSignature validates the image whose tag is not signed by the signers, telling why if it's signed but not released
+// (e.g., signed only into the delegation roles, not into targets, targets/releases nor the release roles of the policy)
+func (h *validator) validateWithoutReleasedSignature(container *corev1.Container, ref *imageRef, basicAuth string, policy whv1.RegistrySpec, notSigned *notSignedError) (bool, deniedReason, error) {
+	valid, denied, err := h.validateWithoutSignature(container, ref, basicAuth, policy, notSigned.signed)
+	if err != nil || valid || notSigned.reason == "" {
+		return valid, denied, err
+	}
+	return false, deniedReason{kind: denyReasonNotReleased, subject: container.Image, reason: notSigned.reason}, nil
+}
+
+// validateWithoutSignature validates the image which is not signed (or signed by an invalid signer).
+// The image signed by an invalid signer is denied, and the one which has no trust data at all is validated by its trusted labels.
+// If the signature is optional, the image which is not signed is allowed without pinning its digest
+func (h *validator) validateWithoutSignature(container *corev1.Container, ref *imageRef, basicAuth string, policy whv1.RegistrySpec, signed bool) (bool, deniedReason, error) {
+	// The labels, which anyone who can push to the registry can set, never override the signers rejected by the policy
+	if signed {
+		return false, deniedReason{kind: denyReasonSignerMismatch, subject: container.Image, reason: fmt.Sprintf("Notary: Image '%s's signer is invalid", container.Image)}, nil
+	}
+	trusted, digest, err := hasTrustedLabels(h.imageOptions(), container.Image, basicAuth, policy.TrustedLabels)
+	if err != nil {
+		validatorLog.Error(err, "")
+		return false, deniedReason{}, err
+	}
+	if !trusted {
+		if policy.SignatureOptional {
+			validatorLog.Info("image is not signed, but allowed as the signature is optional", "image", container.Image)
+			return true, deniedReason{}, nil
 		}
-		if basicAuth == "" {
-			continue
+		// The repository or the tag has no trust data
+		return false, deniedReason{kind: denyReasonNotSigned, subject: container.Image, reason: fmt.Sprintf("Notary: Image '%s' is not signed", container.Image)}, nil
+	}
+
+	// If digest is different from user-specified one, return error
+	if ref.digest != "" && ref.digest != digest {
+		return false, deniedReason{kind: denyReasonLabeledDigestMismatch, subject: container.Image, reason: fmt.Sprintf("Notary: Image '%s''s digest is different from the labeled digest", container.Image)}, nil
+	}
+
+	h.pinDigest(container, ref, digest)
+
+	return true, deniedReason{}, nil
+}
+
+// hasTrustedLabels checks if the image config has all of the trusted labels.
+// Labels are not signed, so they are only as trustworthy as the registry's push permission.
+// It returns the digest of the image's manifest if the labels match
+func hasTrustedLabels(opts image.Options, imageURI, basicAuth string, trustedLabels map[string]string) (bool, string, error) {
+	if len(trustedLabels) == 0 {
+		return false, "", nil
+	}
+
+	img, err := opts.NewImage(imageURI, basicAuth)
+	if err != nil {
+		return false, "", err
+	}
+	cfg, digest, err := img.GetConfig()
+	if err != nil {
+		return false, "", err
+	}
+
+	for key, val := range trustedLabels {
+		if label, exist := cfg.Config.Labels[key]; !exist || label != val {
+			return false, "", nil
 		}
+	}
+	return true, digest, nil
+}
 
-		return basicAuth, nil
+// getBasicAuthForPolicy gets the basic auth for the host, or for the registry and the aliases of its policy.
+// The wildcard registry and aliases are not hosts, so their credentials are not looked up
+func (h *validator) getBasicAuthForPolicy(host string, policy whv1.RegistrySpec, namespace, serviceAccount string, pullSecrets []corev1.LocalObjectReference) (string, error) {
+	hosts := []string{host}
+	for _, hst := range append([]string{policy.Registry}, policy.Aliases...) {
+		if hst != "" && hst != host && !isWildcardHost(hst) {
+			hosts = append(hosts, hst)
+		}
 	}
+	return h.getBasicAuthForHosts(hosts, namespace, serviceAccount, pullSecrets)
+}
 
-	// DO NOT return error - the image may be public
+// getBasicAuthFromDockerConfig gets the basic auth of the registry from the docker config file.
+// The file is read every time, as it may be a mounted secret which is updated
+func (h *validator) getBasicAuthFromDockerConfig(host string) (string, error) {
+	if h.opts.DockerConfigFile == "" {
+		return "", nil
+	}
+	cfg, err := utils.ReadDockerConfigFile(h.opts.DockerConfigFile)
+	if err != nil {
+		return "", fmt.Errorf("couldn't read docker config file by %s", err)
+	}
+	server := h.findRegistryServer(host)
+	basicAuth, err := cfg.GetHostBasicAuth(server)
+	if err != nil || basicAuth != "" {
+		return basicAuth, err
+	}
+	if helper := cfg.GetHostCredHelper(server); helper != "" {
+		validatorLog.Info("credential helpers of the docker config file are not supported, regarding the registry as public", "registry", host, "credHelper", helper)
+	}
 	return "", nil
 }
 
-func (h *validator) findRegistryServer(registry string) string {
-	if registry == "docker.io" {
-		return "https://registry-1.docker.io"
+// canonicalRef returns the image referred by the registry of the policy, instead of its alias.
+// The image of a wildcard registry (e.g., *.example.com) is referred by its own host
+func canonicalRef(ref *imageRef, policy whv1.RegistrySpec) *imageRef {
+	if policy.Registry == "" || isWildcardHost(policy.Registry) || matchesHost(policy.Registry, ref.host) {
+		return ref
 	}
-	return "https://" + registry
+	canonical := *ref
+	canonical.host = policy.Registry
+	return &canonical
+}
+
+// getBasicAuthForRegistry gets the basic auth of the registry from the credential sources in order
+// (by default, the pull secrets of the pod and of its service account, the token exchange and the docker config file)
+func (h *validator) getBasicAuthForRegistry(host, namespace, serviceAccount string, pullSecrets []corev1.LocalObjectReference) (string, error) {
+	return h.getBasicAuthForHosts([]string{host}, namespace, serviceAccount, pullSecrets)
+}
+
+// findRegistryServer returns the registry server of the image host, derived in the same way as the image client does
+func (h *validator) findRegistryServer(registry string) string {
+	return h.imageOptions().ServerURLForHost(registry)
+}
+
+// imageOptions returns the options of the image clients, requesting the insecure registries over http
+func (h *validator) imageOptions() image.Options {
+	return image.Options{InsecureRegistries: h.opts.InsecureRegistries}
 }
@@ -4,23 +4,30 @@ import (
 	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io/ioutil"
 	"net/http"
+	"net/http/httptest"
 	"net/url"
 	"os"
+	"path/filepath"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/sirupsen/logrus"
 	"github.com/stretchr/testify/require"
 	"github.com/tmax-cloud/image-validating-webhook/internal/k8s"
 	"github.com/tmax-cloud/image-validating-webhook/internal/utils"
+	"github.com/tmax-cloud/image-validating-webhook/pkg/notary"
 	notarytest "github.com/tmax-cloud/image-validating-webhook/pkg/notary/test"
+	"github.com/tmax-cloud/image-validating-webhook/pkg/trust"
 	whv1 "github.com/tmax-cloud/image-validating-webhook/pkg/type"
 	watcherfake "github.com/tmax-cloud/image-validating-webhook/pkg/watcher/fake"
 	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/kubernetes/fake"
@@ -32,25 +39,31 @@ import (
 )
 
 const (
-	testNoCheckSign = "testNoCheckSign"
-	testCheckSign   = "testCheckSign"
+	testNoCheckSign       = "testNoCheckSign"
+	testCheckSign         = "testCheckSign"
+	testSignatureOptional = "testSignatureOptional"
+	testRegistryAlias     = "testRegistryAlias"
 
 	testTag              = "test"
 	testImageNotSigned   = "image-not-signed"
 	testImageSignCheck   = "image-sign-check"
 	testImageNoSignCheck = "image-no-sign-check"
 	testImageWhitelisted = "image-whitelisted"
+	testImageScratch     = "image-scratch"
 
 	testSecretDcj = "test-dcj"
 )
 
 type handlerTestCase struct {
-	namespace  string
+	namespace string
+	// host is the registry host of the image, which is the notary mock-up server if it's empty
+	host       string
 	image      string
 	pullSecret string
 
 	expectedValid    bool
 	expectedReason   string
+	expectedDigest   string
 	expectedErrOccur bool
 	expectedErrMsg   string
 }
@@ -82,11 +95,15 @@ func TestHandler_IsValid(t *testing.T) {
 	require.NoError(t, createTestSecret(testCli, testSrv.URL))
 
 	// Sign some images
-	testDummyDigest := "111111111111111111111111111111"
+	testDummyDigest := "11111111111111111111111111111111"
 	_, err = testSrv.SignImage(testSrv.URL, u.Host, testImageNoSignCheck, testTag, testDummyDigest)
 	require.NoError(t, err)
 	_, err = testSrv.SignImage(testSrv.URL, u.Host, testImageSignCheck, testTag, testDummyDigest)
 	require.NoError(t, err)
+	// Image built from scratch, which has no layers and no config blob
+	testScratchDigest := "22222222222222222222222222222222"
+	_, err = testSrv.SignImage(testSrv.URL, u.Host, testImageScratch, testTag, testScratchDigest)
+	require.NoError(t, err)
 
 	tc := map[string]handlerTestCase{
 		"whitelisted": {
@@ -120,13 +137,60 @@ func TestHandler_IsValid(t *testing.T) {
 			expectedErrOccur: false,
 			expectedErrMsg:   "",
 		},
+		"signatureOptionalNotSigned": {
+			namespace:        testSignatureOptional,
+			image:            fmt.Sprintf("%s:%s", testImageNotSigned, testTag),
+			pullSecret:       testSecretDcj,
+			expectedValid:    true,
+			expectedErrOccur: false,
+			expectedErrMsg:   "",
+		},
+		"signatureOptionalSigned": {
+			namespace:        testSignatureOptional,
+			image:            fmt.Sprintf("%s:%s", testImageSignCheck, testTag),
+			pullSecret:       testSecretDcj,
+			expectedValid:    true,
+			expectedErrOccur: false,
+			expectedErrMsg:   "",
+		},
+		"registryAlias": {
+			namespace:        testRegistryAlias,
+			host:             "localhost:" + u.Port(),
+			image:            fmt.Sprintf("%s:%s", testImageSignCheck, testTag),
+			pullSecret:       testSecretDcj,
+			expectedValid:    true,
+			expectedErrOccur: false,
+			expectedErrMsg:   "",
+		},
+		"registryAnotherAlias": {
+			namespace:        testRegistryAlias,
+			host:             "registry.internal:" + u.Port(),
+			image:            fmt.Sprintf("%s:%s", testImageSignCheck, testTag),
+			pullSecret:       testSecretDcj,
+			expectedValid:    true,
+			expectedErrOccur: false,
+			expectedErrMsg:   "",
+		},
+		"scratch": {
+			namespace:        testCheckSign,
+			image:            fmt.Sprintf("%s:%s", testImageScratch, testTag),
+			pullSecret:       testSecretDcj,
+			expectedValid:    true,
+			expectedDigest:   fmt.Sprintf("sha256:%x", testScratchDigest),
+			expectedErrOccur: false,
+			expectedErrMsg:   "",
+		},
 	}
 
 	validator := testValidator(testCli, testRestCli)
 
 	for name, c := range tc {
 		t.Run(name, func(t *testing.T) {
-			imgURI := fmt.Sprintf("%s/%s", u.Host, c.image)
+			host := c.host
+			if host == "" {
+				host = u.Host
+			}
+			imgURI := fmt.Sprintf("%s/%s", host, c.image)
 
 			pod := generateTestPod(imgURI, c.namespace, c.pullSecret)
 			valid, reason, err := validator.CheckIsValidAndAddDigest(pod)
@@ -142,8 +206,10 @@ func TestHandler_IsValid(t *testing.T) {
 					// Whitelisted image does not get digest
 					if !strings.Contains(pod.Spec.Containers[0].Image, testImageWhitelisted) {
 						ref, _ := parseImage(imgURI)
-						if !strings.Contains(pod.Spec.Containers[0].Image, testImageNoSignCheck) {
-							ref.digest = fmt.Sprintf("%x", testDummyDigest)
+						if c.expectedDigest != "" {
+							ref.digest = c.expectedDigest
+						} else if !strings.Contains(pod.Spec.Containers[0].Image, testImageNoSignCheck) && !strings.Contains(pod.Spec.Containers[0].Image, testImageNotSigned) {
+							ref.digest = fmt.Sprintf("sha256:%x", testDummyDigest)
 						}
 						require.Equal(t, ref.String(), pod.Spec.Containers[0].Image, "image digest")
 					}
@@ -153,6 +219,901 @@ func TestHandler_IsValid(t *testing.T) {
 	}
 }
 
+func TestValidator_CheckIsValidAndAddDigest_multipleReasons(t *testing.T) {
+	v := testValidator(fake.NewSimpleClientset(), nil)
+
+	pod := generateTestPod("not-allowed.registry/image-1:test", testCheckSign, "")
+	pod.Spec.InitContainers = []corev1.Container{{Name: "init-cont", Image: "not-allowed.registry/image-init:test"}}
+	pod.Spec.Containers = append(pod.Spec.Containers, corev1.Container{Name: "test-cont-2", Image: "not-allowed.registry/image-2:test"})
+
+	valid, reason, err := v.CheckIsValidAndAddDigest(pod)
+	require.NoError(t, err)
+	require.False(t, valid)
+
+	var expectedReasons []string
+	for _, prefix := range []string{"Notary", "Cosign"} {
+		for _, img := range []string{"image-init", "image-1", "image-2"} {
+			expectedReasons = append(expectedReasons, fmt.Sprintf("%s: Image 'not-allowed.registry/%s:test' does not meet registry security policy. Please check the RegistrySecurityPolicy", prefix, img))
+		}
+	}
+	require.Equal(t, strings.Join(expectedReasons, "\n"), reason)
+}
+
+func TestValidator_CheckIsValidAndAddDigest_maxContainers(t *testing.T) {
+	v := testValidator(fake.NewSimpleClientset(), nil)
+	v.opts.MaxContainers = 2
+
+	pod := generateTestPod("not-allowed.registry/image-1:test", testCheckSign, "")
+	pod.Spec.InitContainers = []corev1.Container{{Name: "init-cont", Image: "not-allowed.registry/image-init:test"}}
+	pod.Spec.Containers = append(pod.Spec.Containers, corev1.Container{Name: "test-cont-2", Image: "not-allowed.registry/image-2:test"})
+
+	valid, reason, err := v.CheckIsValidAndAddDigest(pod)
+	require.NoError(t, err)
+	require.False(t, valid)
+	require.Equal(t, "Pod has 3 containers, which exceeds the maximum 2 to validate", reason)
+}
+
+func TestValidator_CheckIsValidAndAddDigest_noContainers(t *testing.T) {
+	const registry = "registry.test"
+	digest := strings.Repeat("1", 64)
+
+	tc := map[string]struct {
+		initImages []string
+
+		expectedValid  bool
+		expectedReason string
+		expectedImages []string
+	}{
+		"noContainers": {
+			expectedValid: true,
+		},
+		"initContainersOnly": {
+			initImages:     []string{registry + "/image:v1"},
+			expectedValid:  true,
+			expectedImages: []string{registry + "/image:v1@sha256:" + digest},
+		},
+		"initContainersOnlyNotSigned": {
+			initImages:     []string{"not-allowed.registry/image:v1"},
+			expectedReason: "Notary: Image 'not-allowed.registry/image:v1' does not meet registry security policy. Please check the RegistrySecurityPolicy\nCosign: Image 'not-allowed.registry/image:v1' does not meet registry security policy. Please check the RegistrySecurityPolicy",
+			expectedImages: []string{"not-allowed.registry/image:v1"},
+		},
+	}
+
+	for caseName, c := range tc {
+		t.Run(caseName, func(t *testing.T) {
+			v := testCachedSignatureValidator(whv1.RegistrySpec{Registry: registry, Notary: "https://notary.test", SignCheck: true}, registry+"/image:v1",
+				notary.SignedTag{SignedTag: "v1", Digest: digest, Algorithm: "sha256", Signers: []string{"Repo Admin"}})
+
+			pod := generateTestPod("", testCheckSign, "")
+			pod.Spec.Containers = nil
+			for i, img := range c.initImages {
+				pod.Spec.InitContainers = append(pod.Spec.InitContainers, corev1.Container{Name: fmt.Sprintf("init-cont-%d", i), Image: img})
+			}
+
+			var valid bool
+			var reason string
+			var err error
+			require.NotPanics(t, func() { valid, reason, err = v.CheckIsValidAndAddDigest(pod) })
+			require.NoError(t, err)
+			require.Equal(t, c.expectedValid, valid)
+			require.Equal(t, c.expectedReason, reason)
+			require.Empty(t, pod.Spec.Containers)
+
+			var images []string
+			for _, ic := range pod.Spec.InitContainers {
+				images = append(images, ic.Image)
+			}
+			require.Equal(t, c.expectedImages, images)
+		})
+	}
+}
+
+func TestValidator_CheckIsValidAndAddDigest_invalidReference(t *testing.T) {
+	tc := map[string]struct {
+		image string
+
+		expectedReason string
+	}{
+		"empty": {
+			image:          "",
+			expectedReason: "Image '' is not a valid image reference: ",
+		},
+		"malformedDigest": {
+			image:          "registry.test/image:v1@sha256:zzz",
+			expectedReason: "Image 'registry.test/image:v1@sha256:zzz' is not a valid image reference: ",
+		},
+		"doubleColon": {
+			image:          "registry.test/image::v1",
+			expectedReason: "Image 'registry.test/image::v1' is not a valid image reference: ",
+		},
+		"noName": {
+			image:          "registry.test/",
+			expectedReason: "Image 'registry.test/' is not a valid image reference: ",
+		},
+		"whitespace": {
+			image:          "registry.test/image name:v1",
+			expectedReason: "Image 'registry.test/image name:v1' is not a valid image reference: ",
+		},
+	}
+
+	for caseName, c := range tc {
+		t.Run(caseName, func(t *testing.T) {
+			v := testValidator(fake.NewSimpleClientset(), nil)
+
+			valid, reason, err := v.CheckIsValidAndAddDigest(generateTestPod(c.image, testCheckSign, ""))
+			require.NoError(t, err)
+			require.False(t, valid)
+			require.True(t, strings.HasPrefix(reason, c.expectedReason), reason)
+			require.NotContains(t, reason, "\n")
+		})
+	}
+}
+
+func TestValidator_CheckIsValidAndAddDigest_internationalizedHost(t *testing.T) {
+	const punycode = "xn--bcher-kva.example/alpine:v1"
+	digest := strings.Repeat("1", 64)
+	v := testCachedSignatureValidator(whv1.RegistrySpec{Registry: "xn--bcher-kva.example", Notary: "https://notary.test", SignCheck: true}, punycode,
+		notary.SignedTag{SignedTag: "v1", Digest: digest, Algorithm: "sha256", Signers: []string{"Repo Admin"}})
+
+	pod := generateTestPod("bücher.example/alpine:v1", testCheckSign, "")
+	valid, reason, err := v.CheckIsValidAndAddDigest(pod)
+	require.NoError(t, err)
+	require.True(t, valid, reason)
+	require.Equal(t, punycode+"@sha256:"+digest, pod.Spec.Containers[0].Image)
+
+	// An invalid internationalized host is still denied
+	valid, reason, err = v.CheckIsValidAndAddDigest(generateTestPod("bü_cher.example/alpine:v1", testCheckSign, ""))
+	require.NoError(t, err)
+	require.False(t, valid)
+	require.True(t, strings.HasPrefix(reason, "Image 'bü_cher.example/alpine:v1' is not a valid image reference: "), reason)
+}
+
+func TestValidator_CheckIsValidAndAddDigest_podSelector(t *testing.T) {
+	selector, err := labels.Parse("app!=debug")
+	require.NoError(t, err)
+
+	tc := map[string]struct {
+		labels map[string]string
+
+		expectedValid bool
+	}{
+		"notSelected": {
+			labels:        map[string]string{"app": "debug"},
+			expectedValid: true,
+		},
+		"selected": {
+			labels:        map[string]string{"app": "test"},
+			expectedValid: false,
+		},
+		"noLabels": {
+			expectedValid: false,
+		},
+	}
+
+	for name, c := range tc {
+		t.Run(name, func(t *testing.T) {
+			v := testValidator(fake.NewSimpleClientset(), nil)
+			v.opts.PodSelector = selector
+
+			pod := generateTestPod("not-allowed.registry/image-1:test", testCheckSign, "")
+			pod.Labels = c.labels
+
+			valid, _, err := v.CheckIsValidAndAddDigest(pod)
+			require.NoError(t, err)
+			require.Equal(t, c.expectedValid, valid)
+		})
+	}
+}
+
+func TestValidator_addDigestWhenImageValid_signerThreshold(t *testing.T) {
+	const (
+		registry  = "registry.test"
+		notaryURL = "https://notary.test"
+	)
+	img := registry + "/image:v1"
+
+	tc := map[string]struct {
+		threshold int
+
+		expectedValid  bool
+		expectedReason string
+	}{
+		"noThreshold": {
+			expectedValid: true,
+		},
+		"thresholdMet": {
+			threshold:     2,
+			expectedValid: true,
+		},
+		"thresholdNotMet": {
+			threshold:      3,
+			expectedValid:  false,
+			expectedReason: fmt.Sprintf("Notary: Image '%s' is signed by 2 of the signers, but 3 are required", img),
+		},
+	}
+
+	for name, c := range tc {
+		t.Run(name, func(t *testing.T) {
+			// The image is signed by two of the signers
+			v := testCachedSignatureValidator(whv1.RegistrySpec{Registry: registry, Notary: notaryURL, SignCheck: true, Signer: []string{"signer-a", "signer-b", "signer-c"}, SignerThreshold: c.threshold},
+				img, notary.SignedTag{SignedTag: "v1", Digest: strings.Repeat("1", 64), Algorithm: "sha256", Signers: []string{"signer-a", "signer-b"}})
+
+			container := &corev1.Container{Name: "test-cont", Image: img}
+			valid, reason, err := v.addDigestWhenImageValid(container, testCheckSign, "", nil, nil, nil)
+			require.NoError(t, err)
+			require.Equal(t, c.expectedValid, valid)
+			require.Equal(t, c.expectedReason, reason)
+			if valid {
+				require.Equal(t, img+"@sha256:"+strings.Repeat("1", 64), container.Image)
+			}
+		})
+	}
+}
+
+func TestValidator_addDigestWhenImageValid_signerNames(t *testing.T) {
+	const (
+		registry  = "registry.test"
+		notaryURL = "https://notary.test"
+	)
+	img := registry + "/image:v1"
+
+	tc := map[string]struct {
+		signerNames map[string]string
+
+		expectedValid  bool
+		expectedReason string
+	}{
+		"notMapped": {
+			expectedReason: fmt.Sprintf("Notary: Image '%s's signer is invalid", img),
+		},
+		"mapped": {
+			signerNames:   map[string]string{"targets/ci-bot": "ci@example.com", "release-bot": "release@example.com"},
+			expectedValid: true,
+		},
+		"mappedThresholdNotMet": {
+			signerNames:    map[string]string{"targets/ci-bot": "ci@example.com", "release-bot": "ci@example.com"},
+			expectedReason: fmt.Sprintf("Notary: Image '%s' is signed by 1 of the signers, but 2 are required", img),
+		},
+	}
+
+	for name, c := range tc {
+		t.Run(name, func(t *testing.T) {
+			// The delegation roles are named differently from the signers of the policy
+			v := testCachedSignatureValidator(whv1.RegistrySpec{Registry: registry, Notary: notaryURL, SignCheck: true, Signer: []string{"ci@example.com", "release@example.com"}, SignerThreshold: 2, SignerNames: c.signerNames},
+				img, notary.SignedTag{SignedTag: "v1", Digest: strings.Repeat("1", 64), Algorithm: "sha256", Signers: []string{"ci-bot", "release-bot"}})
+
+			container := &corev1.Container{Name: "test-cont", Image: img}
+			valid, reason, err := v.addDigestWhenImageValid(container, testCheckSign, "", nil, nil, nil)
+			require.NoError(t, err)
+			require.Equal(t, c.expectedValid, valid)
+			require.Equal(t, c.expectedReason, reason)
+		})
+	}
+}
+
+func TestValidator_addDigestWhenImageValid_adminKeys(t *testing.T) {
+	const (
+		registry  = "registry.test"
+		notaryURL = "https://notary.test"
+	)
+	img := registry + "/image:v1"
+
+	tc := map[string]struct {
+		adminKeys []string
+
+		expectedValid  bool
+		expectedReason string
+	}{
+		"notPinned": {
+			expectedValid: true,
+		},
+		"rootKeyPinned": {
+			adminKeys:     []string{"other", "root-1"},
+			expectedValid: true,
+		},
+		"repositoryKeyPinned": {
+			adminKeys:     []string{"targets-1"},
+			expectedValid: true,
+		},
+		"mismatched": {
+			adminKeys:      []string{"other"},
+			expectedReason: fmt.Sprintf("Notary: Image '%s' is signed in the repository whose administrative keys (Root: root-1; Repository: targets-1, targets-2) are not pinned by the adminKeys of the RegistrySecurityPolicy", img),
+		},
+	}
+
+	for name, c := range tc {
+		t.Run(name, func(t *testing.T) {
+			v := testCachedSignatureValidator(whv1.RegistrySpec{Registry: registry, Notary: notaryURL, SignCheck: true, AdminKeys: c.adminKeys}, img)
+			v.signatureCache.Set(img, notaryURL, &notary.Signature{
+				Name:               img,
+				SignedTags:         []notary.SignedTag{{SignedTag: "v1", Digest: strings.Repeat("1", 64), Algorithm: "sha256", Signers: []string{"Repo Admin"}}},
+				AdministrativeKeys: []notary.AdminRole{{Name: trust.RootAdminRole, Keys: []notary.AdminKey{{ID: "root-1"}}}, {Name: trust.RepositoryAdminRole, Keys: []notary.AdminKey{{ID: "targets-1"}, {ID: "targets-2"}}}},
+			}, time.Minute)
+
+			container := &corev1.Container{Name: "test-cont", Image: img}
+			valid, reason, err := v.addDigestWhenImageValid(container, testCheckSign, "", nil, nil, nil)
+			require.NoError(t, err)
+			require.Equal(t, c.expectedValid, valid)
+			require.Equal(t, c.expectedReason, reason)
+		})
+	}
+}
+
+func TestValidator_addDigestWhenImageValid_malformedDigest(t *testing.T) {
+	const (
+		registry  = "registry.test"
+		notaryURL = "https://notary.test"
+	)
+	img := registry + "/image:v1"
+
+	tc := map[string]struct {
+		digest    string
+		algorithm string
+
+		expectedValid bool
+	}{
+		"sha256": {
+			digest:        strings.Repeat("1", 64),
+			algorithm:     "sha256",
+			expectedValid: true,
+		},
+		"sha512": {
+			digest:        strings.Repeat("1", 128),
+			algorithm:     "sha512",
+			expectedValid: true,
+		},
+		"empty": {
+			digest:    "",
+			algorithm: "sha256",
+		},
+		"short": {
+			digest:    "1111",
+			algorithm: "sha256",
+		},
+		"zero": {
+			digest:    strings.Repeat("0", 64),
+			algorithm: "sha256",
+		},
+		"notHex": {
+			digest:    strings.Repeat("z", 64),
+			algorithm: "sha256",
+		},
+	}
+
+	for name, c := range tc {
+		t.Run(name, func(t *testing.T) {
+			v := testCachedSignatureValidator(whv1.RegistrySpec{Registry: registry, Notary: notaryURL, SignCheck: true},
+				img, notary.SignedTag{SignedTag: "v1", Digest: c.digest, Algorithm: c.algorithm, Signers: []string{"Repo Admin"}})
+
+			container := &corev1.Container{Name: "test-cont", Image: img}
+			valid, reason, err := v.addDigestWhenImageValid(container, testCheckSign, "", nil, nil, nil)
+			require.NoError(t, err)
+			require.Equal(t, c.expectedValid, valid)
+			if !valid {
+				require.Contains(t, reason, "malformed trust data")
+				require.Equal(t, img, container.Image, "not pinned")
+			}
+		})
+	}
+}
+
+func TestValidator_addDigestWhenImageValid_maxSignatureAge(t *testing.T) {
+	const registry = "registry.test"
+	img := registry + "/image:v1"
+	digest := strings.Repeat("1", 64)
+
+	tc := map[string]struct {
+		maxSignatureAge *metav1.Duration
+		signatureExpiry *metav1.Duration
+		signedAt        time.Time
+		expires         time.Time
+
+		expectedValid  bool
+		expectedReason string
+	}{
+		"noMaxAge": {
+			signedAt:      time.Now().Add(-365 * 24 * time.Hour),
+			expectedValid: true,
+		},
+		"recent": {
+			maxSignatureAge: &metav1.Duration{Duration: 30 * 24 * time.Hour},
+			signedAt:        time.Now().Add(-24 * time.Hour),
+			expectedValid:   true,
+		},
+		"old": {
+			maxSignatureAge: &metav1.Duration{Duration: 30 * 24 * time.Hour},
+			signedAt:        time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC),
+			expectedReason:  fmt.Sprintf("Notary: Image '%s' was signed at 2020-01-01T00:00:00Z, which is older than the maximum signature age 720h0m0s. Please re-sign it", img),
+		},
+		"unknown": {
+			maxSignatureAge: &metav1.Duration{Duration: 30 * 24 * time.Hour},
+			expectedReason:  fmt.Sprintf("Notary: Image '%s''s signing time is unknown, but the maximum signature age is 720h0m0s", img),
+		},
+		"customExpiry": {
+			maxSignatureAge: &metav1.Duration{Duration: 30 * 24 * time.Hour},
+			signatureExpiry: &metav1.Duration{Duration: 90 * 24 * time.Hour},
+			// Recent by the default expiry, but signed at 2020-01-01 by the expiry of the policy
+			signedAt:       time.Now().Add(-24 * time.Hour),
+			expires:        time.Date(2020, 3, 31, 0, 0, 0, 0, time.UTC),
+			expectedReason: fmt.Sprintf("Notary: Image '%s' was signed at 2020-01-01T00:00:00Z, which is older than the maximum signature age 720h0m0s. Please re-sign it", img),
+		},
+	}
+
+	for name, c := range tc {
+		t.Run(name, func(t *testing.T) {
+			v := testCachedSignatureValidator(whv1.RegistrySpec{Registry: registry, Notary: "https://notary.test", SignCheck: true, MaxSignatureAge: c.maxSignatureAge, SignatureExpiry: c.signatureExpiry},
+				img, notary.SignedTag{SignedTag: "v1", Digest: digest, Algorithm: "sha256", Signers: []string{"Repo Admin"}, SignedAt: c.signedAt, Expires: c.expires})
+
+			container := &corev1.Container{Name: "test-cont", Image: img}
+			valid, reason, err := v.addDigestWhenImageValid(container, testCheckSign, "", nil, nil, nil)
+			require.NoError(t, err)
+			require.Equal(t, c.expectedValid, valid)
+			require.Equal(t, c.expectedReason, reason)
+			if !valid {
+				require.Equal(t, img, container.Image, "not pinned")
+			}
+		})
+	}
+}
+
+func TestValidator_CheckIsValidAndAddDigest_signatureAgeWarning(t *testing.T) {
+	const registry = "registry.test"
+	img := registry + "/image:v1"
+	digest := strings.Repeat("1", 64)
+	signedAt := time.Now().Add(-25 * 24 * time.Hour).Truncate(time.Second)
+
+	tc := map[string]struct {
+		signatureAgeWarning *metav1.Duration
+
+		expectedAging bool
+	}{
+		"noWarningAge": {},
+		"recent": {
+			signatureAgeWarning: &metav1.Duration{Duration: 30 * 24 * time.Hour},
+		},
+		"aging": {
+			signatureAgeWarning: &metav1.Duration{Duration: 20 * 24 * time.Hour},
+			expectedAging:       true,
+		},
+	}
+
+	for name, c := range tc {
+		t.Run(name, func(t *testing.T) {
+			v := testCachedSignatureValidator(whv1.RegistrySpec{Registry: registry, Notary: "https://notary.test", SignCheck: true,
+				MaxSignatureAge: &metav1.Duration{Duration: 30 * 24 * time.Hour}, SignatureAgeWarning: c.signatureAgeWarning},
+				img, notary.SignedTag{SignedTag: "v1", Digest: digest, Algorithm: "sha256", Signers: []string{"Repo Admin"}, SignedAt: signedAt})
+			v.validatedDigests = newValidatedDigestCache(time.Minute)
+
+			// The aging image is warned, but allowed as it's not older than the maximum signature age.
+			// The pod recreated with the pinned image is warned as well, though it's allowed by the validated digest
+			pod := generateTestPod(img, testCheckSign, "")
+			for i := 0; i < 2; i++ {
+				valid, _, err := v.CheckIsValidAndAddDigest(pod)
+				require.NoError(t, err)
+				require.True(t, valid)
+
+				validated, exist := podValidatedImages(pod)
+				require.True(t, exist)
+				require.Equal(t, c.expectedAging, validated.Containers["test-cont"].SignatureAging)
+				require.True(t, signedAt.Equal(validated.Containers["test-cont"].SignedAt.Time))
+				if !c.expectedAging {
+					require.Empty(t, admissionWarnings(pod))
+				} else {
+					require.Equal(t, []string{fmt.Sprintf("Image '%s@sha256:%s' was signed at %s, which is older than the signature age warning of the registry security policy. Please re-sign it before it's denied",
+						img, digest, signedAt.UTC().Format(time.RFC3339))}, admissionWarnings(pod))
+				}
+
+				pod = generateTestPod(pod.Spec.Containers[0].Image, testCheckSign, "")
+			}
+			require.Equal(t, uint64(1), v.validatedDigests.stats().Hits)
+		})
+	}
+}
+
+func TestValidator_CheckIsValidAndAddDigest_defaultPolicy(t *testing.T) {
+	digest := strings.Repeat("1", 64)
+	signedImg := "unmatched.test/signed:v1"
+	notSignedImg := "unmatched.test/not-signed:v1"
+
+	tc := map[string]struct {
+		defaultPolicy string
+		image         string
+
+		expectedValid  bool
+		expectedReason string
+		expectedImage  string
+	}{
+		"deny": {
+			defaultPolicy:  DefaultPolicyDeny,
+			image:          signedImg,
+			expectedReason: fmt.Sprintf("Notary: Image '%s' does not meet registry security policy. Please check the RegistrySecurityPolicy\nCosign: Image '%s' does not meet registry security policy. Please check the RegistrySecurityPolicy", signedImg, signedImg),
+			expectedImage:  signedImg,
+		},
+		"allow": {
+			defaultPolicy: DefaultPolicyAllow,
+			image:         notSignedImg,
+			expectedValid: true,
+			expectedImage: notSignedImg,
+		},
+		"requireSignatureSigned": {
+			defaultPolicy: DefaultPolicyRequireSignature,
+			image:         signedImg,
+			expectedValid: true,
+			expectedImage: signedImg + "@sha256:" + digest,
+		},
+		"requireSignatureNotSigned": {
+			defaultPolicy:  DefaultPolicyRequireSignature,
+			image:          notSignedImg,
+			expectedReason: fmt.Sprintf("Notary: Image '%s' is not signed", notSignedImg),
+			expectedImage:  notSignedImg,
+		},
+	}
+
+	for name, c := range tc {
+		t.Run(name, func(t *testing.T) {
+			v := testCachedSignatureValidator(whv1.RegistrySpec{Registry: "registry.test", SignCheck: true}, "registry.test/image:v1")
+			v.registryPolicyCache.defaultPolicy = c.defaultPolicy
+			// Checked by the default notary server
+			v.signatureCache.Set(signedImg, trust.DefaultNotaryServer, &notary.Signature{Name: signedImg, SignedTags: []notary.SignedTag{
+				{SignedTag: "v1", Digest: digest, Algorithm: "sha256", Signers: []string{"Repo Admin"}},
+			}}, time.Minute)
+			v.signatureCache.Set(notSignedImg, trust.DefaultNotaryServer, nil, time.Minute)
+
+			pod := &corev1.Pod{
+				ObjectMeta: metav1.ObjectMeta{Name: "test-pod", Namespace: testCheckSign},
+				Spec:       corev1.PodSpec{Containers: []corev1.Container{{Name: "test-cont", Image: c.image}}},
+			}
+			valid, reason, err := v.CheckIsValidAndAddDigest(pod)
+			require.NoError(t, err)
+			require.Equal(t, c.expectedValid, valid)
+			require.Equal(t, c.expectedReason, reason)
+			require.Equal(t, c.expectedImage, pod.Spec.Containers[0].Image)
+		})
+	}
+}
+
+func TestValidator_addDigestWhenImageValid_noTrustData(t *testing.T) {
+	testNotarySrv, err := notarytest.New(false)
+	require.NoError(t, err)
+	defer testNotarySrv.Close()
+	u, err := url.Parse(testNotarySrv.URL)
+	require.NoError(t, err)
+
+	failingSrv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer failingSrv.Close()
+
+	img := fmt.Sprintf("%s/%s:%s", u.Host, testImageNotSigned, testTag)
+
+	tc := map[string]struct {
+		notary string
+
+		expectedReason   string
+		expectedErrOccur bool
+	}{
+		"noTrustData": {
+			notary:         testNotarySrv.URL,
+			expectedReason: fmt.Sprintf("Notary: Image '%s' is not signed", img),
+		},
+		"serverFailure": {
+			notary:           failingSrv.URL,
+			expectedErrOccur: true,
+		},
+	}
+
+	for name, c := range tc {
+		t.Run(name, func(t *testing.T) {
+			v := &validator{client: fake.NewSimpleClientset(), whiteList: &WhiteList{}}
+			v.registryPolicyCache = &RegistryPolicyCache{clusterCachedClient: &watcherfake.CachedClient{}, namespaceCachedClient: &watcherfake.CachedClient{
+				Cache: map[string]runtime.Object{
+					testCheckSign + "/policy": &whv1.RegistrySecurityPolicy{
+						ObjectMeta: metav1.ObjectMeta{Name: "policy", Namespace: testCheckSign},
+						Spec:       whv1.RegistrySecurityPolicySpec{Registries: []whv1.RegistrySpec{{Registry: u.Host, Notary: c.notary, SignCheck: true}}},
+					},
+				},
+			}}
+
+			container := &corev1.Container{Name: "test-cont", Image: img}
+			valid, reason, err := v.addDigestWhenImageValid(container, testCheckSign, "", nil, nil, nil)
+			if c.expectedErrOccur {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			require.False(t, valid)
+			require.Equal(t, c.expectedReason, reason)
+		})
+	}
+}
+
+func TestValidator_addDigestWhenImageValid_notReleased(t *testing.T) {
+	const (
+		registry  = "registry.test"
+		notaryURL = "https://notary.test"
+	)
+	img := registry + "/image:v1"
+	digest := strings.Repeat("1", 64)
+
+	tc := map[string]struct {
+		sig *notary.Signature
+
+		expectedReason string
+	}{
+		"delegationWithoutRelease": {
+			sig: &notary.Signature{UnreleasedTags: []notary.SignedTag{
+				{SignedTag: "v1", Digest: digest, Algorithm: "sha256", Signers: []string{"signer-a", "signer-b"}},
+			}},
+			expectedReason: fmt.Sprintf("Notary: Image '%s' is signed by signer-a, signer-b, but not released. Sign it into targets/releases, or add the signers' role to the releaseRoles of the RegistrySecurityPolicy", img),
+		},
+		"releasedByOtherSigner": {
+			sig: &notary.Signature{SignedTags: []notary.SignedTag{
+				{SignedTag: "v1", Digest: digest, Algorithm: "sha256", Signers: []string{"signer-c"}},
+			}},
+			expectedReason: fmt.Sprintf("Notary: Image '%s's signer is invalid", img),
+		},
+	}
+
+	for name, c := range tc {
+		t.Run(name, func(t *testing.T) {
+			v := testCachedSignatureValidator(whv1.RegistrySpec{Registry: registry, Notary: notaryURL, SignCheck: true, Signer: []string{"signer-a", "signer-b"}}, img)
+			v.signatureCache.Set(img, notaryURL, c.sig, time.Minute)
+
+			container := &corev1.Container{Name: "test-cont", Image: img}
+			valid, reason, err := v.addDigestWhenImageValid(container, testCheckSign, "", nil, nil, nil)
+			require.NoError(t, err)
+			require.False(t, valid)
+			require.Equal(t, c.expectedReason, reason)
+		})
+	}
+}
+
+// testCachedSignatureValidator returns a validator with the policy of testCheckSign namespace, whose signature of the image is cached
+func testCachedSignatureValidator(policy whv1.RegistrySpec, img string, signedTags ...notary.SignedTag) *validator {
+	v := &validator{client: fake.NewSimpleClientset(), whiteList: &WhiteList{}, signatureCache: notary.NewSignatureCache()}
+	v.registryPolicyCache = &RegistryPolicyCache{clusterCachedClient: &watcherfake.CachedClient{}, namespaceCachedClient: &watcherfake.CachedClient{
+		Cache: map[string]runtime.Object{
+			testCheckSign + "/policy": &whv1.RegistrySecurityPolicy{
+				ObjectMeta: metav1.ObjectMeta{Name: "policy", Namespace: testCheckSign},
+				Spec:       whv1.RegistrySecurityPolicySpec{Registries: []whv1.RegistrySpec{policy}},
+			},
+		},
+	}}
+	v.signatureCache.Set(img, policy.Notary, &notary.Signature{Name: img, SignedTags: signedTags}, time.Minute)
+	return v
+}
+
+func TestValidator_getBasicAuthForRegistry(t *testing.T) {
+	tc := map[string]struct {
+		host    string
+		authKey string
+	}{
+		"hostWithPort":     {host: "reg-test:5000", authKey: "reg-test:5000"},
+		"urlWithPort":      {host: "reg-test:5000", authKey: "https://reg-test:5000"},
+		"dockerHub":        {host: "docker.io", authKey: "registry-1.docker.io"},
+		"dockerHubNoHost":  {host: "", authKey: "https://registry-1.docker.io"},
+		"insecureWithPort": {host: "insecure-test:5000", authKey: "http://insecure-test:5000"},
+	}
+
+	for name, c := range tc {
+		t.Run(name, func(t *testing.T) {
+			authB, err := json.Marshal(utils.DockerConfigJSON{Auths: map[string]utils.DockerLoginCredential{c.authKey: {utils.DockerConfigAuthKey: "dummy"}}})
+			require.NoError(t, err)
+			cli := fake.NewSimpleClientset(&corev1.Secret{
+				ObjectMeta: metav1.ObjectMeta{Name: testSecretDcj, Namespace: testCheckSign},
+				Type:       corev1.SecretTypeDockerConfigJson,
+				Data:       map[string][]byte{corev1.DockerConfigJsonKey: authB},
+			})
+
+			v := &validator{client: cli, opts: Options{InsecureRegistries: []string{"insecure-test:5000"}}}
+			basicAuth, err := v.getBasicAuthForRegistry(c.host, testCheckSign, "", []corev1.LocalObjectReference{{Name: testSecretDcj}})
+			require.NoError(t, err)
+			require.Equal(t, "dummy", basicAuth)
+		})
+	}
+}
+
+func TestValidator_findRegistryServer(t *testing.T) {
+	tc := map[string]string{
+		"reg-test:5000":      "https://reg-test:5000",
+		"reg-test:443":       "https://reg-test:443",
+		"insecure-test:5000": "http://insecure-test:5000",
+		"insecure-test:5001": "https://insecure-test:5001",
+		"insecure-test":      "https://insecure-test",
+	}
+
+	for host, expected := range tc {
+		t.Run(host, func(t *testing.T) {
+			v := &validator{opts: Options{InsecureRegistries: []string{"insecure-test:5000"}}}
+			require.Equal(t, expected, v.findRegistryServer(host))
+		})
+	}
+}
+
+func TestValidator_getBasicAuthForRegistry_namespaced(t *testing.T) {
+	// The secret exists only in another namespace
+	cli := fake.NewSimpleClientset(&corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: testSecretDcj, Namespace: "other-namespace"},
+		Type:       corev1.SecretTypeDockerConfigJson,
+		Data:       map[string][]byte{corev1.DockerConfigJsonKey: []byte(`{"auths":{}}`)},
+	})
+
+	v := &validator{client: cli}
+	_, err := v.getBasicAuthForRegistry("reg-test", testCheckSign, "", []corev1.LocalObjectReference{{Name: testSecretDcj}})
+	require.Error(t, err)
+	require.Contains(t, err.Error(), testSecretDcj)
+
+	// Only the secret in the pod's namespace is read, without listing
+	require.Len(t, cli.Actions(), 1)
+	require.True(t, cli.Actions()[0].Matches("get", "secrets"))
+	require.Equal(t, testCheckSign, cli.Actions()[0].GetNamespace())
+}
+
+func TestValidator_getBasicAuthForPolicy_dockerConfig(t *testing.T) {
+	dockerConfig := filepath.Join(t.TempDir(), "config.json")
+	require.NoError(t, ioutil.WriteFile(dockerConfig, []byte(`{"auths":{"reg-test:5000":{"auth":"docker-config"},"reg-pull-secret:5000":{"auth":"docker-config"}},"credHelpers":{"reg-helper:5000":"ecr-login"}}`), 0600))
+
+	authB, err := json.Marshal(utils.DockerConfigJSON{Auths: map[string]utils.DockerLoginCredential{"reg-pull-secret:5000": {utils.DockerConfigAuthKey: "pull-secret"}}})
+	require.NoError(t, err)
+	cli := fake.NewSimpleClientset(&corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: testSecretDcj, Namespace: testCheckSign},
+		Type:       corev1.SecretTypeDockerConfigJson,
+		Data:       map[string][]byte{corev1.DockerConfigJsonKey: authB},
+	})
+
+	tc := map[string]struct {
+		host         string
+		dockerConfig string
+
+		expectedAuth     string
+		expectedErrOccur bool
+	}{
+		"dockerConfig": {
+			host:         "reg-test:5000",
+			dockerConfig: dockerConfig,
+			expectedAuth: "docker-config",
+		},
+		"pullSecretFirst": {
+			host:         "reg-pull-secret:5000",
+			dockerConfig: dockerConfig,
+			expectedAuth: "pull-secret",
+		},
+		"credHelper": {
+			host:         "reg-helper:5000",
+			dockerConfig: dockerConfig,
+			expectedAuth: "",
+		},
+		"noDockerConfig": {
+			host:         "reg-test:5000",
+			expectedAuth: "",
+		},
+		"dockerConfigNotExist": {
+			host:             "reg-test:5000",
+			dockerConfig:     filepath.Join(t.TempDir(), "not-exist.json"),
+			expectedErrOccur: true,
+		},
+	}
+
+	for name, c := range tc {
+		t.Run(name, func(t *testing.T) {
+			v := &validator{client: cli, opts: Options{DockerConfigFile: c.dockerConfig}}
+			basicAuth, err := v.getBasicAuthForPolicy(c.host, whv1.RegistrySpec{Registry: c.host}, testCheckSign, "", []corev1.LocalObjectReference{{Name: testSecretDcj}})
+			if c.expectedErrOccur {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, c.expectedAuth, basicAuth)
+		})
+	}
+}
+
+func TestValidator_notaryServer(t *testing.T) {
+	tc := map[string]struct {
+		policy               whv1.RegistrySpec
+		disableDefaultNotary bool
+		notarySocket         string
+		allowedNotaryServers []string
+
+		expectedServer     string
+		expectedErrOccur   bool
+		expectedNotAllowed bool
+		expectedNoNotary   bool
+	}{
+		"notary": {
+			policy:         whv1.RegistrySpec{Registry: "test-registry", Notary: "https://test-notary"},
+			expectedServer: "https://test-notary",
+		},
+		"fallback": {
+			policy:         whv1.RegistrySpec{Registry: "test-registry"},
+			expectedServer: trust.DefaultNotaryServer,
+		},
+		"fallbackDisabled": {
+			policy:               whv1.RegistrySpec{Registry: "test-registry"},
+			disableDefaultNotary: true,
+			expectedErrOccur:     true,
+			expectedNoNotary:     true,
+		},
+		"socket": {
+			policy:         whv1.RegistrySpec{Registry: "test-registry", Notary: "https://test-notary"},
+			notarySocket:   "/var/run/notary.sock",
+			expectedServer: "unix:///var/run/notary.sock",
+		},
+		"allowed": {
+			policy:               whv1.RegistrySpec{Registry: "test-registry", Notary: "https://Test-Notary/"},
+			allowedNotaryServers: []string{"https://other-notary", "https://test-notary"},
+			expectedServer:       "https://Test-Notary/",
+		},
+		"notAllowed": {
+			policy:               whv1.RegistrySpec{Registry: "test-registry", Notary: "https://attacker-notary"},
+			allowedNotaryServers: []string{"https://test-notary"},
+			expectedErrOccur:     true,
+			expectedNotAllowed:   true,
+		},
+		"fallbackNotAllowed": {
+			policy:               whv1.RegistrySpec{Registry: "test-registry"},
+			allowedNotaryServers: []string{"https://test-notary"},
+			expectedErrOccur:     true,
+			expectedNotAllowed:   true,
+		},
+		"socketWithAllowlist": {
+			policy:               whv1.RegistrySpec{Registry: "test-registry", Notary: "https://attacker-notary"},
+			notarySocket:         "/var/run/notary.sock",
+			allowedNotaryServers: []string{"https://test-notary"},
+			expectedServer:       "unix:///var/run/notary.sock",
+		},
+	}
+
+	for name, c := range tc {
+		t.Run(name, func(t *testing.T) {
+			v := &validator{opts: Options{DisableDefaultNotary: c.disableDefaultNotary, NotarySocket: c.notarySocket, AllowedNotaryServers: c.allowedNotaryServers}}
+			server, err := v.notaryServer(c.policy)
+			if c.expectedErrOccur {
+				require.Error(t, err)
+				var notAllowed *notaryNotAllowedError
+				require.Equal(t, c.expectedNotAllowed, errors.As(err, &notAllowed))
+				var noNotary *noNotaryServerError
+				require.Equal(t, c.expectedNoNotary, errors.As(err, &noNotary))
+			} else {
+				require.NoError(t, err)
+				require.Equal(t, c.expectedServer, server)
+			}
+		})
+	}
+}
+
+func TestValidator_CheckIsValidAndAddDigest_notaryNotAllowed(t *testing.T) {
+	const registry = "registry.test"
+	img := registry + "/image:v1"
+
+	v := testCachedSignatureValidator(whv1.RegistrySpec{Registry: registry, Notary: "https://attacker-notary.test", SignCheck: true}, img,
+		notary.SignedTag{SignedTag: "v1", Digest: strings.Repeat("1", 64), Algorithm: "sha256", Signers: []string{"Repo Admin"}})
+	v.opts.AllowedNotaryServers = []string{"https://notary.test"}
+
+	pod := generateTestPod(img, testCheckSign, "")
+	valid, reason, err := v.CheckIsValidAndAddDigest(pod)
+	require.NoError(t, err, "it's denied, not failed to be allowed by the error policy")
+	require.False(t, valid)
+	require.Contains(t, reason, fmt.Sprintf("Notary: Image '%s' is checked by the notary server https://attacker-notary.test, which is not allowed", img))
+	require.Equal(t, img, pod.Spec.Containers[0].Image)
+}
+
+func TestValidator_CheckIsValidAndAddDigest_noNotaryServer(t *testing.T) {
+	const registry = "registry.test"
+	img := registry + "/image:v1"
+
+	v := testCachedSignatureValidator(whv1.RegistrySpec{Registry: registry, SignCheck: true}, img,
+		notary.SignedTag{SignedTag: "v1", Digest: strings.Repeat("1", 64), Algorithm: "sha256", Signers: []string{"Repo Admin"}})
+	v.opts.DisableDefaultNotary = true
+
+	pod := generateTestPod(img, testCheckSign, "")
+	valid, reason, err := v.CheckIsValidAndAddDigest(pod)
+	require.NoError(t, err, "it's denied, not failed to be allowed by the error policy")
+	require.False(t, valid)
+	require.Contains(t, reason, fmt.Sprintf("Notary: Image '%s''s registry %s has no notary server configured, and falling back to %s is disabled", img, registry, trust.DefaultNotaryServer))
+	require.Equal(t, img, pod.Spec.Containers[0].Image)
+}
+
 func testValidator(testCli kubernetes.Interface, testRestCli rest.Interface) *validator {
 	validator := &validator{client: testCli}
 	validator.registryPolicyCache = &RegistryPolicyCache{restClient: testRestCli, clusterCachedClient: &watcherfake.CachedClient{}, namespaceCachedClient: &watcherfake.CachedClient{
@@ -187,6 +1148,38 @@ func testValidator(testCli kubernetes.Interface, testRestCli rest.Interface) *va
 					},
 				},
 			},
+			testRegistryAlias + "/policy4": &whv1.RegistrySecurityPolicy{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      "policy4",
+					Namespace: testRegistryAlias,
+				},
+				Spec: whv1.RegistrySecurityPolicySpec{
+					Registries: []whv1.RegistrySpec{
+						{
+							Registry:  testSrvHost,
+							Aliases:   []string{"localhost:" + testSrvPort(), "registry.internal:" + testSrvPort()},
+							Notary:    notarySrv,
+							SignCheck: true,
+						},
+					},
+				},
+			},
+			testSignatureOptional + "/policy3": &whv1.RegistrySecurityPolicy{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      "policy3",
+					Namespace: testSignatureOptional,
+				},
+				Spec: whv1.RegistrySecurityPolicySpec{
+					Registries: []whv1.RegistrySpec{
+						{
+							Registry:          testSrvHost,
+							Notary:            notarySrv,
+							SignCheck:         true,
+							SignatureOptional: true,
+						},
+					},
+				},
+			},
 		},
 	}}
 	validator.whiteList = &WhiteList{byImages: []imageRef{{name: testImageWhitelisted}}}
@@ -194,6 +1187,14 @@ func testValidator(testCli kubernetes.Interface, testRestCli rest.Interface) *va
 	return validator
 }
 
+// testSrvPort returns the port of the notary mock-up server
+func testSrvPort() string {
+	if i := strings.LastIndex(testSrvHost, ":"); i >= 0 {
+		return testSrvHost[i+1:]
+	}
+	return ""
+}
+
 func createTestWhiteListConfigMap(cli kubernetes.Interface) error {
 	ns, err := k8s.Namespace()
 	if err != nil {
@@ -245,6 +1246,12 @@ func createTestSecret(cli kubernetes.Interface, registryHost string) error {
 	if _, err := cli.CoreV1().Secrets(testCheckSign).Create(context.Background(), dcj, metav1.CreateOptions{}); err != nil {
 		return err
 	}
+	if _, err := cli.CoreV1().Secrets(testSignatureOptional).Create(context.Background(), dcj, metav1.CreateOptions{}); err != nil {
+		return err
+	}
+	if _, err := cli.CoreV1().Secrets(testRegistryAlias).Create(context.Background(), dcj, metav1.CreateOptions{}); err != nil {
+		return err
+	}
 	return nil
 }
 
@@ -278,3 +1285,241 @@ func testValidatorRestClient(regHost string) *restfake.RESTClient {
 		}),
 	}
 }
+
+func TestValidator_CheckIsValidAndAddDigest_pinFormat(t *testing.T) {
+	const registry = "registry.test"
+	digest := strings.Repeat("1", 64)
+
+	tc := map[string]struct {
+		pinFormat string
+		image     string
+
+		expectedImage string
+	}{
+		"default": {
+			image:         registry + "/image:v1",
+			expectedImage: registry + "/image:v1@sha256:" + digest,
+		},
+		"tagDigest": {
+			pinFormat:     PinFormatTagDigest,
+			image:         registry + "/image:v1",
+			expectedImage: registry + "/image:v1@sha256:" + digest,
+		},
+		"digest": {
+			pinFormat:     PinFormatDigest,
+			image:         registry + "/image:v1",
+			expectedImage: registry + "/image@sha256:" + digest,
+		},
+	}
+
+	for caseName, c := range tc {
+		t.Run(caseName, func(t *testing.T) {
+			v := testCachedSignatureValidator(whv1.RegistrySpec{Registry: registry, Notary: "https://notary.test", SignCheck: true}, registry+"/image:v1",
+				notary.SignedTag{SignedTag: "v1", Digest: digest, Algorithm: "sha256", Signers: []string{"Repo Admin"}})
+			v.opts.PinFormat = c.pinFormat
+
+			pod := generateTestPod(c.image, testCheckSign, "")
+			valid, reason, err := v.CheckIsValidAndAddDigest(pod)
+			require.NoError(t, err)
+			require.True(t, valid, reason)
+			require.Equal(t, c.expectedImage, pod.Spec.Containers[0].Image)
+		})
+	}
+}
+
+func TestValidator_CheckIsValidAndAddDigest_digestOnly(t *testing.T) {
+	const registry = "registry.test"
+	digest := "sha256:" + strings.Repeat("1", 64)
+	notSigned := "sha256:" + strings.Repeat("2", 64)
+
+	tc := map[string]struct {
+		image string
+
+		expectedValid  bool
+		expectedReason string
+	}{
+		"signed": {
+			image:         registry + "/image@" + digest,
+			expectedValid: true,
+		},
+		"notSigned": {
+			image:          registry + "/image@" + notSigned,
+			expectedReason: fmt.Sprintf("Notary: Image '%s/image@%s' is not signed", registry, notSigned),
+		},
+	}
+
+	for caseName, c := range tc {
+		t.Run(caseName, func(t *testing.T) {
+			// The signature of the image without a tag holds the tags of its digest
+			v := testCachedSignatureValidator(whv1.RegistrySpec{Registry: registry, Notary: "https://notary.test", SignCheck: true}, registry+"/image@"+digest,
+				notary.SignedTag{SignedTag: "v1", Digest: strings.Repeat("1", 64), Algorithm: "sha256", Signers: []string{"Repo Admin"}},
+				notary.SignedTag{SignedTag: "latest", Digest: strings.Repeat("1", 64), Algorithm: "sha256", Signers: []string{"Repo Admin"}})
+			v.signatureCache.Set(registry+"/image@"+notSigned, "https://notary.test", nil, time.Minute)
+
+			pod := generateTestPod(c.image, testCheckSign, "")
+			valid, reason, err := v.CheckIsValidAndAddDigest(pod)
+			require.NoError(t, err)
+			require.Equal(t, c.expectedValid, valid)
+			require.Equal(t, c.expectedReason, reason)
+			require.Equal(t, c.image, pod.Spec.Containers[0].Image, "the tag is not added")
+		})
+	}
+}
+
+func TestReleaseRolesOf(t *testing.T) {
+	repoRoles := map[string][]string{"team/app": {"targets/stable"}}
+
+	tc := map[string]struct {
+		ref    *imageRef
+		policy whv1.RegistrySpec
+
+		expectedRoles []string
+	}{
+		"default": {
+			ref: &imageRef{host: "registry.test", name: "team/app", tag: "v1"},
+		},
+		"policyRoles": {
+			ref:           &imageRef{host: "registry.test", name: "team/app", tag: "v1"},
+			policy:        whv1.RegistrySpec{ReleaseRoles: []string{"targets/prod"}},
+			expectedRoles: []string{"targets/prod"},
+		},
+		"repositoryRoles": {
+			ref:           &imageRef{host: "registry.test", name: "team/app", tag: "v1"},
+			policy:        whv1.RegistrySpec{RepositoryReleaseRoles: repoRoles},
+			expectedRoles: []string{"targets/stable"},
+		},
+		"policyAndRepositoryRoles": {
+			ref:           &imageRef{host: "registry.test", name: "team/app", tag: "v1"},
+			policy:        whv1.RegistrySpec{ReleaseRoles: []string{"targets/prod"}, RepositoryReleaseRoles: repoRoles},
+			expectedRoles: []string{"targets/prod", "targets/stable"},
+		},
+		"otherRepository": {
+			ref:           &imageRef{host: "registry.test", name: "team/other", tag: "v1"},
+			policy:        whv1.RegistrySpec{ReleaseRoles: []string{"targets/prod"}, RepositoryReleaseRoles: repoRoles},
+			expectedRoles: []string{"targets/prod"},
+		},
+	}
+
+	for caseName, c := range tc {
+		t.Run(caseName, func(t *testing.T) {
+			require.Equal(t, c.expectedRoles, releaseRolesOf(c.ref, c.policy))
+		})
+	}
+}
+
+func TestClientProfile(t *testing.T) {
+	tc := map[string]struct {
+		policy whv1.RegistrySpec
+
+		expectedProfile trust.ClientProfile
+	}{
+		"default": {},
+		"profiled": {
+			policy: whv1.RegistrySpec{Timeout: &metav1.Duration{Duration: time.Minute}, ReadTimeout: &metav1.Duration{Duration: 2 * time.Minute},
+				Retries: 3, RetryBackoff: &metav1.Duration{Duration: 500 * time.Millisecond}},
+			expectedProfile: trust.ClientProfile{Timeout: time.Minute, ReadTimeout: 2 * time.Minute, Retries: 3, Backoff: 500 * time.Millisecond},
+		},
+	}
+
+	for caseName, c := range tc {
+		t.Run(caseName, func(t *testing.T) {
+			require.Equal(t, c.expectedProfile, clientProfile(c.policy))
+		})
+	}
+}
+
+func TestValidator_notaryClientProfile(t *testing.T) {
+	secret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "proxy-auth", Namespace: registryNamespace},
+		Data:       map[string][]byte{proxyAuthSecretKey: []byte("Basic dXNlcjpwYXNz\n")},
+	}
+	noKeySecret := &corev1.Secret{ObjectMeta: metav1.ObjectMeta{Name: "no-key", Namespace: registryNamespace}}
+
+	tc := map[string]struct {
+		policy whv1.RegistrySpec
+
+		expectedErr     bool
+		expectedProfile trust.ClientProfile
+	}{
+		"noProxyAuthSecret": {
+			policy:          whv1.RegistrySpec{Retries: 3},
+			expectedProfile: trust.ClientProfile{Retries: 3},
+		},
+		"proxyAuthSecret": {
+			policy:          whv1.RegistrySpec{Retries: 3, ProxyAuthSecret: "proxy-auth"},
+			expectedProfile: trust.ClientProfile{Retries: 3, ProxyAuthorization: "Basic dXNlcjpwYXNz"},
+		},
+		"proxyAuthSecretNotFound": {
+			policy:      whv1.RegistrySpec{ProxyAuthSecret: "not-found"},
+			expectedErr: true,
+		},
+		"proxyAuthSecretWithoutKey": {
+			policy:      whv1.RegistrySpec{ProxyAuthSecret: "no-key"},
+			expectedErr: true,
+		},
+	}
+
+	for caseName, c := range tc {
+		t.Run(caseName, func(t *testing.T) {
+			v := testValidator(fake.NewSimpleClientset(), nil)
+			v.proxyAuthSecrets = &ProxyAuthSecrets{cachedClient: &watcherfake.CachedClient{Cache: map[string]runtime.Object{
+				registryNamespace + "/proxy-auth": secret,
+				registryNamespace + "/no-key":     noKeySecret,
+			}}}
+			profile, err := v.notaryClientProfile(c.policy)
+			if c.expectedErr {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, c.expectedProfile, profile)
+		})
+	}
+}
+
+func TestValidator_CheckIsValidAndAddDigest_requireAuthenticatedPull(t *testing.T) {
+	const registry = "registry.test"
+	img := registry + "/image:v1"
+	digest := "sha256:" + strings.Repeat("1", 64)
+	dockerConfig := filepath.Join(t.TempDir(), "config.json")
+	require.NoError(t, ioutil.WriteFile(dockerConfig, []byte(`{"auths":{"registry.test":{"auth":"docker-config"}}}`), 0600))
+
+	tc := map[string]struct {
+		requireAuthenticatedPull bool
+		dockerConfig             string
+
+		expectedValid  bool
+		expectedReason string
+	}{
+		"anonymous": {
+			expectedValid: true,
+		},
+		"authenticated": {
+			requireAuthenticatedPull: true,
+			dockerConfig:             dockerConfig,
+			expectedValid:            true,
+		},
+		"anonymousDenied": {
+			requireAuthenticatedPull: true,
+			expectedReason:           fmt.Sprintf("Notary: Image '%s' has no credential for the registry %s, which requires authenticated pulls. Please check the pull secrets of the pod", img, registry),
+		},
+	}
+
+	for caseName, c := range tc {
+		t.Run(caseName, func(t *testing.T) {
+			v := testCachedSignatureValidator(whv1.RegistrySpec{Registry: registry, Notary: "https://notary.test", SignCheck: true, RequireAuthenticatedPull: c.requireAuthenticatedPull}, img)
+			v.opts.DockerConfigFile = c.dockerConfig
+			verifier := &stubVerifier{digest: digest}
+			v.verifier = verifier
+
+			valid, reason, err := v.CheckIsValidAndAddDigest(generateTestPod(img, testCheckSign, ""))
+			require.NoError(t, err)
+			require.Equal(t, c.expectedValid, valid)
+			require.Equal(t, c.expectedReason, reason)
+			if !c.expectedValid {
+				// It's denied without checking the signature anonymously
+				require.Empty(t, verifier.verified)
+			}
+		})
+	}
+}
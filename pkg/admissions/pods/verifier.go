@@ -0,0 +1,127 @@
+package pods
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/tmax-cloud/image-validating-webhook/pkg/notary"
+	whv1 "github.com/tmax-cloud/image-validating-webhook/pkg/type"
+)
+
+// Verifier verifies the signature of an image by the registry's policy, returning the signed digest and the signers of the policy who signed it.
+// It returns a *deniedError if the signature is not valid, and a *notSignedError if the image is not signed by the signers
+type Verifier interface {
+	Verify(ctx context.Context, image, basicAuth string, policy whv1.RegistrySpec) (string, []string, error)
+}
+
+// deniedError tells why the image's signature is not valid, and the kind of its failure.
+// The subject of its remediation hint is the image if it's empty
+type deniedError struct {
+	reason  string
+	kind    denyReason
+	subject string
+}
+
+func (e *deniedError) Error() string {
+	return e.reason
+}
+
+// notSignedError tells the image is not signed by the signers of the policy, so it's validated without the signature (e.g., by its trusted labels)
+type notSignedError struct {
+	// signed is if it's signed by the others
+	signed bool
+	// reason is why it's denied if it can't be validated without the signature either. The default reason is used if it's empty
+	reason string
+}
+
+func (e *notSignedError) Error() string {
+	return "image is not signed by the signers"
+}
+
+// signatureVerifier returns the verifier of the signatures, which is the notary verifier by default.
+// The notary verifier is bound to h, so that a batch's copy of the validator verifies by the signatures of the batch
+func (h *validator) signatureVerifier() Verifier {
+	if h.verifier != nil {
+		return h.verifier
+	}
+	return &notaryVerifier{validator: h}
+}
+
+// notaryVerifier verifies the images by their notary signatures. The notary server of the policy should be resolved
+type notaryVerifier struct {
+	validator *validator
+}
+
+// Verify verifies the image by its notary signature, which is signed with the registry's name even if it's referred by an alias
+func (n *notaryVerifier) Verify(ctx context.Context, image, basicAuth string, policy whv1.RegistrySpec) (string, []string, error) {
+	ref, err := parseImage(image)
+	if err != nil {
+		return "", nil, err
+	}
+	if reason := n.validator.emptySignerReason(image, policy); reason != "" {
+		return "", nil, &deniedError{reason: reason, kind: denyReasonNoSigner}
+	}
+	sig, err := n.validator.fetchSignature(ctx, canonicalRef(ref, policy), basicAuth, policy.Notary, policy)
+	if err != nil {
+		return "", nil, err
+	}
+	sig = sig.WithSignerNames(policy.SignerNames)
+	if reason, keyIDs := adminKeysReason(image, sig, policy); reason != "" {
+		return "", nil, &deniedError{reason: reason, kind: denyReasonAdminKeyMismatch, subject: keyIDs}
+	}
+	// sig is nil if it's not signed
+	if sig == nil || !sig.MatchSigner(policy.Signer) {
+		notSigned := &notSignedError{signed: sig != nil}
+		if sig != nil && ref.tag != "" && sig.GetDigest(ref.tag) == "" {
+			if signers := sig.GetUnreleasedSigners(ref.tag); len(signers) > 0 {
+				notSigned.reason = fmt.Sprintf("Notary: Image '%s' is signed by %s, but not released. Sign it into targets/releases, or add the signers' role to the releaseRoles of the RegistrySecurityPolicy", image, strings.Join(signers, ", "))
+			}
+		}
+		return "", nil, notSigned
+	}
+	if policy.SignerThreshold > 1 {
+		if count := sig.CountSigners(ref.tag, policy.Signer); count < policy.SignerThreshold {
+			return "", nil, &deniedError{reason: fmt.Sprintf("Notary: Image '%s' is signed by %d of the signers, but %d are required", image, count, policy.SignerThreshold), kind: denyReasonSignerThreshold}
+		}
+	}
+
+	digest := sig.GetDigest(ref.tag)
+	if ref.tag != "" && !isWellFormedDigest(digest) {
+		return "", nil, &deniedError{reason: fmt.Sprintf("Notary: Image '%s' has malformed trust data (signed digest '%s')", image, digest), kind: denyReasonMalformedTrustData}
+	}
+	if reason := signatureAgeReason(image, ref, sig, policy); reason != "" {
+		return "", nil, &deniedError{reason: reason, kind: denyReasonSignatureTooOld}
+	}
+	recordSignedAt(ctx, signedAtOf(sig, ref, policy))
+	return digest, matchedSigners(sig.GetSigners(ref.tag), policy.Signer), nil
+}
+
+// adminKeysReason returns why the image is denied if its repository's administrative keys are not pinned by the policy.
+// The observed keys are told, so that they can be copied into the adminKeys if the repository is trusted, and their IDs are returned as well.
+// The repository which is not signed (i.e., sig is nil) has no keys to check
+func adminKeysReason(image string, sig *notary.Signature, policy whv1.RegistrySpec) (string, string) {
+	if sig == nil || len(policy.AdminKeys) == 0 || sig.MatchAdminKeys(policy.AdminKeys) {
+		return "", ""
+	}
+	var roles, allIDs []string
+	for _, role := range sig.AdministrativeKeys {
+		var ids []string
+		for _, key := range role.Keys {
+			ids = append(ids, key.ID)
+		}
+		roles = append(roles, fmt.Sprintf("%s: %s", role.Name, strings.Join(ids, ", ")))
+		allIDs = append(allIDs, ids...)
+	}
+	return fmt.Sprintf("Notary: Image '%s' is signed in the repository whose administrative keys (%s) are not pinned by the adminKeys of the RegistrySecurityPolicy", image, strings.Join(roles, "; ")),
+		strings.Join(allIDs, ", ")
+}
+
+// emptySignerReason returns why the image is denied if the policy has no signer and the empty signer policy denies it.
+// Otherwise, the image released by any signer is allowed, as the signature is matched with no signer
+func (h *validator) emptySignerReason(image string, policy whv1.RegistrySpec) string {
+	if len(policy.Signer) > 0 || h.opts.EmptySignerPolicy != EmptySignerPolicyDeny {
+		return ""
+	}
+	return fmt.Sprintf("Notary: Image '%s' is denied, as the RegistrySecurityPolicy has no signer. Please add the signers to the RegistrySecurityPolicy", image)
+}
@@ -0,0 +1,144 @@
+package pods
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/tmax-cloud/image-validating-webhook/pkg/notary"
+	whv1 "github.com/tmax-cloud/image-validating-webhook/pkg/type"
+)
+
+// stubVerifier verifies every image with the same result, recording the images verified
+type stubVerifier struct {
+	digest  string
+	signers []string
+	err     error
+
+	verified []string
+}
+
+func (s *stubVerifier) Verify(_ context.Context, image, _ string, _ whv1.RegistrySpec) (string, []string, error) {
+	s.verified = append(s.verified, image)
+	return s.digest, s.signers, s.err
+}
+
+func TestValidator_CheckIsValidAndAddDigest_verifier(t *testing.T) {
+	const registry = "registry.test"
+	img := registry + "/image:v1"
+	digest := "sha256:" + strings.Repeat("1", 64)
+
+	tc := map[string]struct {
+		verifier *stubVerifier
+
+		expectedValid  bool
+		expectedReason string
+		expectedErr    bool
+		expectedImage  string
+	}{
+		"allow": {
+			verifier:      &stubVerifier{digest: digest, signers: []string{"signer-a"}},
+			expectedValid: true,
+			expectedImage: img + "@" + digest,
+		},
+		"deny": {
+			verifier:       &stubVerifier{err: &deniedError{reason: "Stub: denied"}},
+			expectedReason: "Stub: denied",
+			expectedImage:  img,
+		},
+		"notSigned": {
+			verifier:       &stubVerifier{err: &notSignedError{}},
+			expectedReason: fmt.Sprintf("Notary: Image '%s' is not signed", img),
+			expectedImage:  img,
+		},
+		"notReleased": {
+			verifier:       &stubVerifier{err: &notSignedError{signed: true, reason: "Stub: not released"}},
+			expectedReason: "Stub: not released",
+			expectedImage:  img,
+		},
+		"error": {
+			verifier:      &stubVerifier{err: errors.New("connection refused")},
+			expectedErr:   true,
+			expectedImage: img,
+		},
+	}
+
+	for caseName, c := range tc {
+		t.Run(caseName, func(t *testing.T) {
+			v := testCachedSignatureValidator(whv1.RegistrySpec{Registry: registry, Notary: "https://notary.test", SignCheck: true}, img)
+			v.verifier = c.verifier
+
+			pod := generateTestPod(img, testCheckSign, "")
+			valid, reason, err := v.CheckIsValidAndAddDigest(pod)
+			if c.expectedErr {
+				require.Error(t, err)
+			} else {
+				require.NoError(t, err)
+			}
+			require.Equal(t, c.expectedValid, valid)
+			require.Equal(t, c.expectedReason, reason)
+			require.Equal(t, c.expectedImage, pod.Spec.Containers[0].Image)
+			require.Equal(t, []string{img}, c.verifier.verified)
+		})
+	}
+}
+
+func TestValidator_CheckIsValidAndAddDigest_emptySignerPolicy(t *testing.T) {
+	const registry = "registry.test"
+	img := registry + "/image:v1"
+
+	tc := map[string]struct {
+		emptySignerPolicy string
+		signers           []string
+
+		expectedValid  bool
+		expectedReason string
+	}{
+		"allowIfSignedByDefault": {
+			expectedValid: true,
+		},
+		"allowIfSigned": {
+			emptySignerPolicy: EmptySignerPolicyAllowIfSigned,
+			expectedValid:     true,
+		},
+		"deny": {
+			emptySignerPolicy: EmptySignerPolicyDeny,
+			expectedReason:    fmt.Sprintf("Notary: Image '%s' is denied, as the RegistrySecurityPolicy has no signer. Please add the signers to the RegistrySecurityPolicy", img),
+		},
+		"denyWithSigners": {
+			emptySignerPolicy: EmptySignerPolicyDeny,
+			signers:           []string{"signer-a"},
+			expectedValid:     true,
+		},
+	}
+
+	for caseName, c := range tc {
+		t.Run(caseName, func(t *testing.T) {
+			v := testCachedSignatureValidator(whv1.RegistrySpec{Registry: registry, Notary: "https://notary.test", SignCheck: true, Signer: c.signers}, img,
+				notary.SignedTag{SignedTag: "v1", Digest: strings.Repeat("1", 64), Algorithm: "sha256", Signers: []string{"Repo Admin", "signer-a"}})
+			v.opts.EmptySignerPolicy = c.emptySignerPolicy
+
+			valid, reason, err := v.CheckIsValidAndAddDigest(generateTestPod(img, testCheckSign, ""))
+			require.NoError(t, err)
+			require.Equal(t, c.expectedValid, valid)
+			require.Equal(t, c.expectedReason, reason)
+		})
+	}
+}
+
+func TestValidator_CheckIsValidAndAddDigest_trustedLabelsSignerMismatch(t *testing.T) {
+	const registry = "registry.test"
+	img := registry + "/image:v1"
+
+	v := testCachedSignatureValidator(whv1.RegistrySpec{Registry: registry, Notary: "https://notary.test", SignCheck: true, TrustedLabels: map[string]string{"io.tmax.build/pipeline": "trusted-ci"}}, img)
+	v.verifier = &stubVerifier{err: &notSignedError{signed: true}}
+
+	// The image signed by the other signers is denied without looking up its labels, which the registry's pushers can set
+	valid, reason, err := v.CheckIsValidAndAddDigest(generateTestPod(img, testCheckSign, ""))
+	require.NoError(t, err)
+	require.False(t, valid)
+	require.Equal(t, "Notary: Image '"+img+"'s signer is invalid", reason)
+}
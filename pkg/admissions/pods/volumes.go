@@ -0,0 +1,84 @@
+package pods
+
+import (
+	"encoding/json"
+	"fmt"
+
+	core "k8s.io/api/core/v1"
+)
+
+const (
+	// imageVolumeContainerPrefix is a prefix of the names of the containers, which stand for the image volumes while validating
+	imageVolumeContainerPrefix = "image-volume/"
+)
+
+// imageVolume is an image volume source (OCI artifact mounted as a volume) of the pod.
+// It's not in the core/v1 api of the client version, so it's parsed from the raw pod
+type imageVolume struct {
+	index     int
+	name      string
+	reference string
+}
+
+type rawImageVolumePod struct {
+	Spec struct {
+		Volumes []struct {
+			Name  string `json:"name"`
+			Image *struct {
+				Reference string `json:"reference"`
+			} `json:"image,omitempty"`
+		} `json:"volumes"`
+	} `json:"spec"`
+}
+
+// podImageVolumes finds the image volumes of the raw pod. It's empty if the ImageVolume feature gate is off
+func podImageVolumes(raw []byte) ([]imageVolume, error) {
+	pod := &rawImageVolumePod{}
+	if err := json.Unmarshal(raw, pod); err != nil {
+		return nil, err
+	}
+
+	var volumes []imageVolume
+	for i, v := range pod.Spec.Volumes {
+		if v.Image == nil || v.Image.Reference == "" {
+			continue
+		}
+		volumes = append(volumes, imageVolume{index: i, name: v.Name, reference: v.Image.Reference})
+	}
+	return volumes, nil
+}
+
+// checkIsValidWithImageVolumes validates the images of the pod's containers and image volumes, adding their digests.
+// Image volumes are validated as the containers, so that they're checked in the same way
+func checkIsValidWithImageVolumes(v Validator, pod *core.Pod, volumes []imageVolume) (bool, string, error) {
+	numContainers := len(pod.Spec.Containers)
+	for _, vol := range volumes {
+		pod.Spec.Containers = append(pod.Spec.Containers, core.Container{Name: imageVolumeContainerPrefix + vol.name, Image: vol.reference})
+	}
+	defer func() {
+		pod.Spec.Containers = pod.Spec.Containers[:numContainers]
+	}()
+
+	isValid, reason, err := v.CheckIsValidAndAddDigest(pod)
+	if err != nil || !isValid {
+		return isValid, reason, err
+	}
+
+	for i := range volumes {
+		volumes[i].reference = pod.Spec.Containers[numContainers+i].Image
+	}
+	return true, "", nil
+}
+
+// imageVolumePatches returns the patches pinning the image volumes' references
+func imageVolumePatches(volumes []imageVolume) []patchOperation {
+	var patch []patchOperation
+	for _, vol := range volumes {
+		patch = append(patch, patchOperation{
+			Op:    "replace",
+			Path:  fmt.Sprintf("/spec/volumes/%d/image/reference", vol.index),
+			Value: vol.reference,
+		})
+	}
+	return patch
+}
@@ -0,0 +1,87 @@
+package pods
+
+import (
+	"time"
+
+	"github.com/tmax-cloud/image-validating-webhook/pkg/notary"
+	"k8s.io/apimachinery/pkg/util/wait"
+	logf "sigs.k8s.io/controller-runtime/pkg/log"
+)
+
+var (
+	warmerLog = logf.Log.WithName("pods/warmer.go")
+)
+
+// signatureCacheWarmer periodically fetches the signatures of the frequently deployed images to the signature cache,
+// so that the admission of them does not wait for the notary server
+type signatureCacheWarmer struct {
+	validator *validator
+
+	images   []string
+	interval time.Duration
+}
+
+func newSignatureCacheWarmer(v *validator, images []string, interval time.Duration) *signatureCacheWarmer {
+	return &signatureCacheWarmer{validator: v, images: images, interval: interval}
+}
+
+// Start warms up the cache every interval, until stopCh is closed
+func (w *signatureCacheWarmer) Start(stopCh <-chan struct{}) {
+	wait.Until(w.warm, w.interval, stopCh)
+}
+
+func (w *signatureCacheWarmer) warm() {
+	for _, img := range w.images {
+		if err := w.warmImage(img); err != nil {
+			warmerLog.Error(err, "failed to warm up signature cache", "image", img)
+		}
+	}
+}
+
+// warmImage fetches the signature of the image, using the cluster-wide policy of its registry.
+// Signatures are fetched anonymously, as there's no pull secret outside of the pods
+func (w *signatureCacheWarmer) warmImage(img string) error {
+	ref, err := parseImage(img)
+	if err != nil {
+		return err
+	}
+
+	valid, policy, err := w.validator.registryPolicyCache.doesMatchClusterPolicy(ref.host)
+	if err != nil {
+		return err
+	}
+	if !valid || !policy.SignCheck {
+		return nil
+	}
+	if len(releaseRolesOf(ref, policy)) > 0 {
+		warmerLog.Info("not caching the image of custom release roles", "image", img)
+		return nil
+	}
+
+	notaryURL, err := w.validator.notaryServer(policy)
+	if err != nil {
+		return err
+	}
+	canonical := canonicalRef(ref, policy)
+	imageURI := canonical.String()
+	notaryImg, err := canonical.toImage(w.validator.imageOptions(), "")
+	if err != nil {
+		return err
+	}
+	profile, err := w.validator.notaryClientProfile(policy)
+	if err != nil {
+		return err
+	}
+	sig, err := notary.FetchProfiledSignature(w.validator.repoPool, notaryImg, notaryURL, profile)
+	if err != nil {
+		return err
+	}
+	if sig == nil {
+		warmerLog.Info("image is not signed, not caching it", "image", img)
+		return nil
+	}
+
+	// Entries outlive a single failed refresh, but not more
+	w.validator.signatureCache.Set(imageURI, notaryURL, sig, 2*w.interval)
+	return nil
+}
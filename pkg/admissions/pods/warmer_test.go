@@ -0,0 +1,143 @@
+package pods
+
+import (
+	"fmt"
+	"net/url"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"github.com/tmax-cloud/image-validating-webhook/pkg/notary"
+	notarytest "github.com/tmax-cloud/image-validating-webhook/pkg/notary/test"
+	whv1 "github.com/tmax-cloud/image-validating-webhook/pkg/type"
+	watcherfake "github.com/tmax-cloud/image-validating-webhook/pkg/watcher/fake"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestSignatureCacheWarmer(t *testing.T) {
+	testSrv, err := notarytest.New(false)
+	require.NoError(t, err)
+	u, err := url.Parse(testSrv.URL)
+	require.NoError(t, err)
+
+	_, err = testSrv.SignImage(testSrv.URL, u.Host, testImageSignCheck, testTag, "111111111111111111111111111111")
+	require.NoError(t, err)
+
+	v := &validator{signatureCache: notary.NewSignatureCache()}
+	v.registryPolicyCache = &RegistryPolicyCache{clusterCachedClient: &watcherfake.CachedClient{
+		Cache: map[string]runtime.Object{
+			"policy": &whv1.ClusterRegistrySecurityPolicy{
+				ObjectMeta: metav1.ObjectMeta{Name: "policy"},
+				Spec: whv1.ClusterRegistrySecurityPolicySpec{
+					Registries: []whv1.RegistrySpec{{Registry: u.Host, Notary: testSrv.URL, SignCheck: true}},
+				},
+			},
+		},
+	}, namespaceCachedClient: &watcherfake.CachedClient{}}
+
+	signedImg := fmt.Sprintf("%s/%s:%s", u.Host, testImageSignCheck, testTag)
+	notSignedImg := fmt.Sprintf("%s/%s:%s", u.Host, testImageNotSigned, testTag)
+
+	stopCh := make(chan struct{})
+	doneCh := make(chan struct{})
+	go func() {
+		newSignatureCacheWarmer(v, []string{signedImg, notSignedImg}, time.Minute).Start(stopCh)
+		close(doneCh)
+	}()
+
+	require.Eventually(t, func() bool {
+		_, exist := v.signatureCache.Get(signedImg, testSrv.URL)
+		return exist
+	}, 10*time.Second, 100*time.Millisecond, "signed image is cached")
+	_, exist := v.signatureCache.Get(notSignedImg, testSrv.URL)
+	require.False(t, exist, "not signed image is not cached")
+
+	// The warmer stops when stopCh is closed
+	close(stopCh)
+	select {
+	case <-doneCh:
+	case <-time.After(10 * time.Second):
+		t.Fatal("warmer is not stopped")
+	}
+}
+
+func TestSignatureCacheWarmer_sharedWithValidator(t *testing.T) {
+	testSrv, err := notarytest.New(false)
+	require.NoError(t, err)
+	u, err := url.Parse(testSrv.URL)
+	require.NoError(t, err)
+
+	_, err = testSrv.SignImage(testSrv.URL, u.Host, testImageSignCheck, testTag, "11111111111111111111111111111111")
+	require.NoError(t, err)
+
+	v := &validator{client: fake.NewSimpleClientset(), whiteList: &WhiteList{}, signatureCache: notary.NewSignatureCache(), opts: Options{SignatureCacheTTL: time.Minute}}
+	v.registryPolicyCache = &RegistryPolicyCache{clusterCachedClient: &watcherfake.CachedClient{
+		Cache: map[string]runtime.Object{
+			"policy": &whv1.ClusterRegistrySecurityPolicy{
+				ObjectMeta: metav1.ObjectMeta{Name: "policy"},
+				Spec: whv1.ClusterRegistrySecurityPolicySpec{
+					Registries: []whv1.RegistrySpec{{Registry: u.Host, Notary: testSrv.URL, SignCheck: true}},
+				},
+			},
+		},
+	}, namespaceCachedClient: &watcherfake.CachedClient{}}
+	w := newSignatureCacheWarmer(v, nil, time.Minute)
+
+	signedImg := fmt.Sprintf("%s/%s:%s", u.Host, testImageSignCheck, testTag)
+
+	// The validator and the warmer fetch the signature to the cache concurrently
+	var wg sync.WaitGroup
+	errCh := make(chan error, 10)
+	for i := 0; i < 5; i++ {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			valid, reason, err := v.CheckIsValidAndAddDigest(generateTestPod(signedImg, testCheckSign, ""))
+			if err == nil && !valid {
+				err = fmt.Errorf("not valid: %s", reason)
+			}
+			errCh <- err
+		}()
+		go func() {
+			defer wg.Done()
+			errCh <- w.warmImage(signedImg)
+		}()
+	}
+	wg.Wait()
+	close(errCh)
+	for err := range errCh {
+		require.NoError(t, err)
+	}
+
+	// The signature cached by either of them is reused by the validator
+	_, exist := v.signatureCache.Get(signedImg, testSrv.URL)
+	require.True(t, exist)
+	hits := v.signatureCache.Stats().Hits
+	valid, reason, err := v.CheckIsValidAndAddDigest(generateTestPod(signedImg, testCheckSign, ""))
+	require.NoError(t, err)
+	require.True(t, valid, reason)
+	require.Equal(t, hits+1, v.signatureCache.Stats().Hits)
+}
+
+func TestSignatureCacheWarmer_warmImage_clusterPolicyOnly(t *testing.T) {
+	const registry = "registry.test"
+	img := registry + "/image:v1"
+
+	v := &validator{signatureCache: notary.NewSignatureCache()}
+	v.registryPolicyCache = &RegistryPolicyCache{clusterCachedClient: &watcherfake.CachedClient{}, namespaceCachedClient: &watcherfake.CachedClient{
+		Cache: map[string]runtime.Object{
+			testCheckSign + "/policy": &whv1.RegistrySecurityPolicy{
+				ObjectMeta: metav1.ObjectMeta{Name: "policy", Namespace: testCheckSign},
+				Spec:       whv1.RegistrySecurityPolicySpec{Registries: []whv1.RegistrySpec{{Registry: registry, Notary: "https://notary.invalid", SignCheck: true}}},
+			},
+		},
+	}}
+
+	// The namespace policies are not used out of their namespaces, so the image isn't fetched from their notary servers
+	require.NoError(t, newSignatureCacheWarmer(v, []string{img}, time.Minute).warmImage(img))
+	_, exist := v.signatureCache.Get(img, "https://notary.invalid")
+	require.False(t, exist)
+}
@@ -8,9 +8,12 @@ import (
 	"regexp"
 	"strings"
 	"sync"
+	"unicode"
 
 	"github.com/tmax-cloud/image-validating-webhook/internal/k8s"
+	"github.com/tmax-cloud/image-validating-webhook/pkg/image"
 	"github.com/tmax-cloud/image-validating-webhook/pkg/watcher"
+	"golang.org/x/net/idna"
 	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/fields"
@@ -35,7 +38,7 @@ const (
 	delimiter = "\n"
 )
 
-var whitelistImageReg = regexp.MustCompile(`^((([^./]+)\.([^/])+)/)?([^:@]+)(:([^@]+))?(@([^:]+:[0-9a-f]+))?`)
+var whitelistImageReg = regexp.MustCompile(`^((([^./]+)\.([^/])+|[^./:]+:[0-9]+|localhost)/)?([^:@]+)(:([^@]+))?(@([^:]+:[0-9a-f]+))?`)
 var wlog = ctrl.Log.WithName("whitelist.go")
 
 // WhiteList stores whitelisted images/namespaces
@@ -43,6 +46,9 @@ type WhiteList struct {
 	byImages     []imageRef
 	byNamespaces []string
 
+	// namespacePatterns are compiled glob patterns of byNamespaces entries containing wildcards
+	namespacePatterns []*regexp.Regexp
+
 	lock sync.Mutex
 
 	clientSet    kubernetes.Interface
@@ -162,6 +168,11 @@ func (w *WhiteList) IsNamespaceWhiteListed(ns string) bool {
 			return true
 		}
 	}
+	for _, pattern := range w.namespacePatterns {
+		if pattern.MatchString(ns) {
+			return true
+		}
+	}
 	return false
 }
 
@@ -213,6 +224,7 @@ func (w *WhiteList) UnmarshalImage(img string) error {
 // UnmarshalNamespace parses namespace whitelist from line-separated lists
 func (w *WhiteList) UnmarshalNamespace(ns string) {
 	w.byNamespaces = parseLineSeparatedList(ns)
+	w.namespacePatterns = compileNamespacePatterns(w.byNamespaces)
 }
 
 // Marshal generates whitelist byte arrays from lists
@@ -224,6 +236,18 @@ func (w *WhiteList) Marshal() (string, string) {
 	return strings.Join(images, delimiter), strings.Join(w.byNamespaces, delimiter)
 }
 
+// entries returns the whitelisted images and namespaces
+func (w *WhiteList) entries() ([]string, []string) {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+
+	images := []string{}
+	for i := range w.byImages {
+		images = append(images, w.byImages[i].String())
+	}
+	return images, append([]string{}, w.byNamespaces...)
+}
+
 // UnmarshalLegacy parses whitelist lists from json array
 func (w *WhiteList) UnmarshalLegacy(img, ns string) error {
 	// Parse byImages
@@ -254,7 +278,27 @@ func (w *WhiteList) UnmarshalLegacyImage(img string) error {
 
 // UnmarshalLegacyNamespace parses namespace whitelist from json array
 func (w *WhiteList) UnmarshalLegacyNamespace(ns string) error {
-	return json.Unmarshal([]byte(ns), &w.byNamespaces)
+	if err := json.Unmarshal([]byte(ns), &w.byNamespaces); err != nil {
+		return err
+	}
+	w.namespacePatterns = compileNamespacePatterns(w.byNamespaces)
+	return nil
+}
+
+// compileNamespacePatterns compiles namespace entries containing glob wildcards (*, ?) into regular expressions.
+// Plain entries are not compiled, as they are matched exactly
+func compileNamespacePatterns(namespaces []string) []*regexp.Regexp {
+	var patterns []*regexp.Regexp
+	for _, ns := range namespaces {
+		if !strings.ContainsAny(ns, "*?") {
+			continue
+		}
+		expr := regexp.QuoteMeta(ns)
+		expr = strings.ReplaceAll(expr, `\*`, ".*")
+		expr = strings.ReplaceAll(expr, `\?`, ".")
+		patterns = append(patterns, regexp.MustCompile("^"+expr+"$"))
+	}
+	return patterns
 }
 
 func parseLineSeparatedList(list string) []string {
@@ -301,6 +345,33 @@ func (r *imageRef) String() string {
 	return b.String()
 }
 
+// toImage converts the reference to an image.Image, which is used to fetch the trust data.
+// It fails if image.Image parses the reference differently, rather than validating one image and pinning another
+func (r *imageRef) toImage(opts image.Options, basicAuth string) (*image.Image, error) {
+	img, err := opts.NewImage(r.String(), basicAuth)
+	if err != nil {
+		return nil, err
+	}
+
+	host, name := r.host, r.name
+	// Docker hub's images are normalized, e.g., alpine to docker.io/library/alpine
+	if image.ServerURLForHost(host) == image.DefaultServer {
+		host = image.DefaultHostname
+		if !strings.Contains(name, "/") {
+			name = "library/" + name
+		}
+	}
+	// The latest tag is implied without a tag and a digest
+	tag := r.tag
+	if tag == "" && r.digest == "" {
+		tag = "latest"
+	}
+	if img.Host != host || img.Name != name || img.Tag != tag || img.Digest != r.digest {
+		return nil, fmt.Errorf("image %s is parsed differently as %s/%s (tag '%s', digest '%s')", r.String(), img.Host, img.Name, img.Tag, img.Digest)
+	}
+	return img, nil
+}
+
 func parseImages(images []string) ([]imageRef, error) {
 	var results []imageRef
 	for _, i := range images {
@@ -313,11 +384,15 @@ func parseImages(images []string) ([]imageRef, error) {
 	return results, nil
 }
 
-func parseImage(image string) (*imageRef, error) {
-	matched := whitelistImageReg.FindAllStringSubmatch(image, -1)
+func parseImage(img string) (*imageRef, error) {
+	matched := whitelistImageReg.FindAllStringSubmatch(img, -1)
 	if len(matched) != 1 || len(matched[0]) != 10 {
 		return nil, fmt.Errorf("image is not in right form")
 	}
+	// Not to ignore the rest silently (e.g., a malformed digest), which the other parsers (e.g., image.Image) don't
+	if matched[0][0] != img {
+		return nil, fmt.Errorf("image %s is not in right form", img)
+	}
 
 	ref := &imageRef{
 		host:   strings.TrimSpace(matched[0][2]),
@@ -330,5 +405,26 @@ func parseImage(image string) (*imageRef, error) {
 		return nil, fmt.Errorf("image name is required")
 	}
 
+	host, err := normalizeHost(ref.host)
+	if err != nil {
+		return nil, fmt.Errorf("image %s has an invalid host by %s", img, err)
+	}
+	ref.host = host
+
 	return ref, nil
 }
+
+// normalizeHost converts an internationalized (Unicode) host to ASCII (punycode), e.g., bücher.example to xn--bcher-kva.example,
+// so that the notary/registry URLs are built from it. ASCII hosts are kept as they are
+func normalizeHost(host string) (string, error) {
+	if strings.IndexFunc(host, func(r rune) bool { return r > unicode.MaxASCII }) < 0 {
+		return host, nil
+	}
+
+	hostname, port := splitHostPort(host)
+	ascii, err := idna.Lookup.ToASCII(hostname)
+	if err != nil {
+		return "", err
+	}
+	return ascii + port, nil
+}
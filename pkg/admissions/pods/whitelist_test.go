@@ -1,8 +1,11 @@
 package pods
 
 import (
-	"github.com/stretchr/testify/require"
+	"strings"
 	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/tmax-cloud/image-validating-webhook/pkg/image"
 )
 
 type imageWhiteListTestCase struct {
@@ -70,6 +73,66 @@ func TestWhiteList_IsImageWhiteListed(t *testing.T) {
 	}
 }
 
+type namespaceWhiteListTestCase struct {
+	list      string
+	namespace string
+
+	expectedWhitelisted bool
+}
+
+func TestWhiteList_IsNamespaceWhiteListed(t *testing.T) {
+	tc := map[string]namespaceWhiteListTestCase{
+		"exact": {
+			list:                "test-ns\ndefault",
+			namespace:           "default",
+			expectedWhitelisted: true,
+		},
+		"exactNotWhiteListed": {
+			list:                "test-ns\ndefault",
+			namespace:           "default-2",
+			expectedWhitelisted: false,
+		},
+		"prefixWildcard": {
+			list:                "ci-*",
+			namespace:           "ci-foo",
+			expectedWhitelisted: true,
+		},
+		"prefixWildcardNotWhiteListed": {
+			list:                "ci-*",
+			namespace:           "production-ci",
+			expectedWhitelisted: false,
+		},
+		"suffixWildcard": {
+			list:                "*-sandbox",
+			namespace:           "team-a-sandbox",
+			expectedWhitelisted: true,
+		},
+		"suffixWildcardNotWhiteListed": {
+			list:                "*-sandbox",
+			namespace:           "team-a-sandbox-2",
+			expectedWhitelisted: false,
+		},
+		"singleCharWildcard": {
+			list:                "ns-?",
+			namespace:           "ns-1",
+			expectedWhitelisted: true,
+		},
+		"dotIsNotWildcard": {
+			list:                "ns.a",
+			namespace:           "nsxa",
+			expectedWhitelisted: false,
+		},
+	}
+
+	for name, c := range tc {
+		t.Run(name, func(t *testing.T) {
+			w := &WhiteList{}
+			w.UnmarshalNamespace(c.list)
+			require.Equal(t, c.expectedWhitelisted, w.IsNamespaceWhiteListed(c.namespace))
+		})
+	}
+}
+
 type whitelistTestCase struct {
 	marshalledImage   string
 	unmarshalledImage []imageRef
@@ -306,6 +369,42 @@ func TestParseImage(t *testing.T) {
 				digest: "sha256:def822f9851ca422481ec6fee59a9966f12b351c62ccb9aca841526ffaa9f748",
 			},
 		},
+		"sha512": {
+			image: "alpine:3@sha512:" + strings.Repeat("ab", 64),
+			ref: imageRef{
+				host:   "",
+				name:   "alpine",
+				tag:    "3",
+				digest: "sha512:" + strings.Repeat("ab", 64),
+			},
+		},
+		"hostWithPort": {
+			image: "reg-test:5000/tmax-cloud/alpine:3",
+			ref: imageRef{
+				host:   "reg-test:5000",
+				name:   "tmax-cloud/alpine",
+				tag:    "3",
+				digest: "",
+			},
+		},
+		"ipWithPort": {
+			image: "172.22.11.2:30500/alpine:3",
+			ref: imageRef{
+				host:   "172.22.11.2:30500",
+				name:   "alpine",
+				tag:    "3",
+				digest: "",
+			},
+		},
+		"localhost": {
+			image: "localhost/alpine:3",
+			ref: imageRef{
+				host:   "localhost",
+				name:   "alpine",
+				tag:    "3",
+				digest: "",
+			},
+		},
 		"containsSlash": {
 			image: "tmax-cloud/alpine:3",
 			ref: imageRef{
@@ -326,12 +425,100 @@ func TestParseImage(t *testing.T) {
 	}
 }
 
+func TestImageRef_toImage(t *testing.T) {
+	const digest = "sha256:def822f9851ca422481ec6fee59a9966f12b351c62ccb9aca841526ffaa9f748"
+
+	tc := map[string]struct {
+		image string
+
+		expectedImage image.Image
+	}{
+		"full": {
+			image:         "reg-test.registry.ipip.nip.io/alpine:3@" + digest,
+			expectedImage: image.Image{Host: "reg-test.registry.ipip.nip.io", Name: "alpine", Tag: "3", Digest: digest},
+		},
+		"port": {
+			image:         "reg-test:5000/tmax-cloud/alpine:3",
+			expectedImage: image.Image{Host: "reg-test:5000", Name: "tmax-cloud/alpine", Tag: "3"},
+		},
+		"digestOnly": {
+			image:         "reg-test:5000/alpine@" + digest,
+			expectedImage: image.Image{Host: "reg-test:5000", Name: "alpine", Digest: digest},
+		},
+		"noTag": {
+			image:         "localhost/alpine",
+			expectedImage: image.Image{Host: "localhost", Name: "alpine", Tag: "latest"},
+		},
+		"dockerHubNoHost": {
+			image:         "alpine:3",
+			expectedImage: image.Image{Host: "docker.io", Name: "library/alpine", Tag: "3"},
+		},
+		"dockerHubContainsSlash": {
+			image:         "tmax-cloud/alpine:3",
+			expectedImage: image.Image{Host: "docker.io", Name: "tmax-cloud/alpine", Tag: "3"},
+		},
+		"dockerHub": {
+			image:         "docker.io/library/alpine:3",
+			expectedImage: image.Image{Host: "docker.io", Name: "library/alpine", Tag: "3"},
+		},
+	}
+
+	for name, c := range tc {
+		t.Run(name, func(t *testing.T) {
+			ref, err := parseImage(c.image)
+			require.NoError(t, err)
+			img, err := ref.toImage(image.Options{}, "")
+			require.NoError(t, err)
+			require.Equal(t, c.expectedImage.Host, img.Host)
+			require.Equal(t, c.expectedImage.Name, img.Name)
+			require.Equal(t, c.expectedImage.Tag, img.Tag)
+			require.Equal(t, c.expectedImage.Digest, img.Digest)
+		})
+	}
+}
+
+func TestParseImage_malformed(t *testing.T) {
+	// Parsed as the latest tag if the malformed digest were ignored
+	for _, img := range []string{"reg-test:5000/alpine@sha256:zz", "reg-test:5000/alpine:3@sha256:ABCDEF"} {
+		_, err := parseImage(img)
+		require.Error(t, err, img)
+		_, err = image.NewImage(img, "")
+		require.Error(t, err, img)
+	}
+}
+
+func TestParseImage_idn(t *testing.T) {
+	// Unicode hosts resolve identically to their punycode equivalents
+	for unicodeImg, punycodeImg := range map[string]string{
+		"bücher.example/alpine:3":      "xn--bcher-kva.example/alpine:3",
+		"Bücher.example:5000/alpine:3": "xn--bcher-kva.example:5000/alpine:3",
+		"레지스트리.kr/tmax-cloud/alpine":   "xn--om2b23a15p8shb5l.kr/tmax-cloud/alpine",
+	} {
+		ref, err := parseImage(unicodeImg)
+		require.NoError(t, err, unicodeImg)
+		expected, err := parseImage(punycodeImg)
+		require.NoError(t, err, punycodeImg)
+		require.Equal(t, expected, ref, unicodeImg)
+
+		img, err := ref.toImage(image.Options{}, "")
+		require.NoError(t, err, unicodeImg)
+		require.Equal(t, expected.host, img.Host, unicodeImg)
+	}
+
+	_, err := parseImage("bü_cher.example/alpine:3")
+	require.Error(t, err, "invalid IDN")
+}
+
 func TestImageRef_String(t *testing.T) {
 	tc := map[string]parseImageTestCase{
 		"full": {
 			ref:   imageRef{host: "docker.io", name: "alpine", tag: "3", digest: "sha256:def822f9851ca422481ec6fee59a9966f12b351c62ccb9aca841526ffaa9f748"},
 			image: "docker.io/alpine:3@sha256:def822f9851ca422481ec6fee59a9966f12b351c62ccb9aca841526ffaa9f748",
 		},
+		"sha512": {
+			ref:   imageRef{host: "docker.io", name: "alpine", tag: "3", digest: "sha512:" + strings.Repeat("ab", 64)},
+			image: "docker.io/alpine:3@sha512:" + strings.Repeat("ab", 64),
+		},
 		"noHost": {
 			ref:   imageRef{name: "alpine", tag: "3", digest: "sha256:def822f9851ca422481ec6fee59a9966f12b351c62ccb9aca841526ffaa9f748"},
 			image: "alpine:3@sha256:def822f9851ca422481ec6fee59a9966f12b351c62ccb9aca841526ffaa9f748",
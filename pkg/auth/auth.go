@@ -1,9 +1,12 @@
 package auth
 
 import (
+	"encoding/json"
 	"fmt"
 	"net/http"
 	"time"
+
+	regclient "github.com/docker/distribution/registry/client"
 )
 
 // TokenType is HTTP Authorization Header's token type
@@ -33,6 +36,52 @@ type TokenResponse struct {
 	RefreshToken string    `json:"refresh_token"`
 }
 
+// tokenAccept is the Accept header of the token requests. Some token servers negotiate the response by it, rejecting the requests without it
+const tokenAccept = "application/json"
+
+// RequestToken requests a bearer token for the scope to the token server(realm) of the service
+func RequestToken(cli *http.Client, realm, service, scope, basicAuth string) (*Token, error) {
+	tokenReq, err := http.NewRequest(http.MethodGet, realm, nil)
+	if err != nil {
+		return nil, err
+	}
+	tokenReq.Header.Set("Accept", tokenAccept)
+	if basicAuth != "" {
+		tokenReq.Header.Set("Authorization", fmt.Sprintf("Basic %s", basicAuth))
+	}
+	tokenQ := tokenReq.URL.Query()
+	tokenQ.Add("service", service)
+	tokenQ.Add("scope", scope)
+	tokenReq.URL.RawQuery = tokenQ.Encode()
+
+	tokenResp, err := cli.Do(tokenReq)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		_ = tokenResp.Body.Close()
+	}()
+	if !regclient.SuccessStatus(tokenResp.StatusCode) {
+		return nil, regclient.HandleErrorResponse(tokenResp)
+	}
+
+	token := &TokenResponse{}
+	if err := json.NewDecoder(tokenResp.Body).Decode(token); err != nil {
+		return nil, err
+	}
+
+	// Some token servers only return access_token
+	value := token.Token
+	if value == "" {
+		value = token.AccessToken
+	}
+
+	return &Token{
+		Type:  TokenTypeBearer,
+		Value: value,
+	}, nil
+}
+
 // RegistryTransport is a spec of token's roundtripper
 type RegistryTransport struct {
 	Base  http.RoundTripper
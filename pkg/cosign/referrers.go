@@ -0,0 +1,273 @@
+package cosign
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+
+	"github.com/google/go-containerregistry/pkg/authn"
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	"github.com/google/go-containerregistry/pkg/v1/remote/transport"
+	godigest "github.com/opencontainers/go-digest"
+	"github.com/pkg/errors"
+	"github.com/sigstore/sigstore/pkg/signature"
+	logf "sigs.k8s.io/controller-runtime/pkg/log"
+)
+
+const (
+	// SignatureArtifactType is the artifact type of the cosign signatures attached to the images by the OCI referrers API
+	// (e.g., cosign sign --registry-referrers-mode=oci-1-1)
+	SignatureArtifactType = "application/vnd.dev.cosign.artifact.sig.v1+json"
+
+	simpleSigningMediaType = "application/vnd.dev.cosign.simplesigning.v1+json"
+	signatureAnnotation    = "dev.cosignproject.cosign/signature"
+	ociIndexMediaType      = "application/vnd.oci.image.index.v1+json"
+	ociManifestMediaType   = "application/vnd.oci.image.manifest.v1+json"
+
+	// maxReferrerSize is the maximum size of the referrers index, the signature manifests and the payloads to read
+	maxReferrerSize = 4 * 1024 * 1024
+)
+
+// ErrNoReferrers means there is no cosign signature attached by the referrers API, either because the registry
+// doesn't support it or the image is not signed that way. The signatures should be found by the tag convention (<digest>.sig) instead
+var ErrNoReferrers = errors.New("Cosign: no signature referrers")
+
+var (
+	referrersLog = logf.Log.WithName("cosign/referrers.go")
+)
+
+// referrersIndex is the response of the referrers API, an OCI image index
+type referrersIndex struct {
+	Manifests []referrerDescriptor `json:"manifests"`
+}
+
+// signatureManifest is the OCI image manifest of a signature artifact
+type signatureManifest struct {
+	Layers []referrerDescriptor `json:"layers"`
+}
+
+type referrerDescriptor struct {
+	MediaType    string            `json:"mediaType"`
+	ArtifactType string            `json:"artifactType,omitempty"`
+	Digest       string            `json:"digest"`
+	Annotations  map[string]string `json:"annotations,omitempty"`
+}
+
+// simpleSigningPayload is the signed payload of a cosign signature
+type simpleSigningPayload struct {
+	Critical struct {
+		Image struct {
+			DockerManifestDigest string `json:"docker-manifest-digest"`
+		} `json:"image"`
+	} `json:"critical"`
+	Optional map[string]interface{} `json:"optional"`
+}
+
+// ValidReferrers verifies the cosign signatures attached to the image's digest by the OCI referrers API, returning the verified digest.
+// A signature is valid if it's signed by any of the keys, for the image's digest, and by any of the signers (its signer annotation).
+// It returns ErrNoReferrers if the registry doesn't support the referrers API or there is no signature attached
+func ValidReferrers(ctx context.Context, ref name.Reference, signers []string, keys []crypto.PublicKey) (string, error) {
+	if len(keys) == 0 {
+		return "", errors.New("There are no keys for valid")
+	}
+
+	repo := ref.Context()
+	// allow insecure registry [x509 error fix]
+	tr := &http.Transport{TLSClientConfig: &tls.Config{InsecureSkipVerify: true}}
+	auth, err := authn.DefaultKeychain.Resolve(repo)
+	if err != nil {
+		return "", err
+	}
+	rt, err := transport.NewWithContext(ctx, repo.Registry, auth, tr, []string{repo.Scope(transport.PullScope)})
+	if err != nil {
+		return "", err
+	}
+	r := &referrersClient{ctx: ctx, client: &http.Client{Transport: rt}, repo: repo}
+
+	digest := ""
+	if d, isDigest := ref.(name.Digest); isDigest {
+		digest = d.DigestStr()
+	} else {
+		desc, err := remote.Head(ref, remote.WithContext(ctx), remote.WithTransport(tr), remote.WithAuth(auth))
+		if err != nil {
+			return "", err
+		}
+		digest = desc.Digest.String()
+	}
+
+	referrers, err := r.listSignatures(digest)
+	if err != nil {
+		return "", err
+	}
+
+	lastErr := error(ErrNoReferrers)
+	for _, referrer := range referrers {
+		if err := r.verifySignatureManifest(referrer.Digest, digest, signers, keys); err != nil {
+			referrersLog.Info("invalid signature referrer", "image", ref.String(), "referrer", referrer.Digest, "reason", err.Error())
+			lastErr = err
+			continue
+		}
+		return digest, nil
+	}
+	return "", lastErr
+}
+
+// referrersClient requests the registry for the referrers of the images of a repository
+type referrersClient struct {
+	ctx    context.Context
+	client *http.Client
+	repo   name.Repository
+}
+
+// listSignatures lists the signature artifacts referring to the digest
+func (r *referrersClient) listSignatures(digest string) ([]referrerDescriptor, error) {
+	b, status, err := r.get("referrers/"+digest+"?artifactType="+url.QueryEscape(SignatureArtifactType), ociIndexMediaType)
+	if err != nil {
+		return nil, err
+	}
+	// The registry doesn't support the referrers API
+	if status == http.StatusNotFound {
+		return nil, ErrNoReferrers
+	}
+	if status != http.StatusOK {
+		return nil, fmt.Errorf("Cosign: listing referrers of %s responded %d", digest, status)
+	}
+
+	index := &referrersIndex{}
+	if err := json.Unmarshal(b, index); err != nil {
+		return nil, err
+	}
+	// The registry may ignore the artifactType filter
+	var signatures []referrerDescriptor
+	for _, m := range index.Manifests {
+		if m.ArtifactType == SignatureArtifactType {
+			signatures = append(signatures, m)
+		}
+	}
+	return signatures, nil
+}
+
+// verifySignatureManifest verifies any of the simple signing layers of the signature manifest is signed for the digest
+func (r *referrersClient) verifySignatureManifest(manifestDigest, digest string, signers []string, keys []crypto.PublicKey) error {
+	b, err := r.getVerified("manifests/"+manifestDigest, ociManifestMediaType, manifestDigest)
+	if err != nil {
+		return err
+	}
+	manifest := &signatureManifest{}
+	if err := json.Unmarshal(b, manifest); err != nil {
+		return err
+	}
+
+	lastErr := fmt.Errorf("Cosign: no simple signing layer in the signature manifest")
+	for _, layer := range manifest.Layers {
+		if layer.MediaType != simpleSigningMediaType {
+			continue
+		}
+		payload, err := r.getVerified("blobs/"+layer.Digest, "", layer.Digest)
+		if err != nil {
+			return err
+		}
+		if lastErr = verifyPayload(payload, layer.Annotations[signatureAnnotation], digest, signers, keys); lastErr == nil {
+			return nil
+		}
+	}
+	return lastErr
+}
+
+// getVerified gets the manifest/blob of the repository, verifying its content matches the digest
+func (r *referrersClient) getVerified(path, accept, digest string) ([]byte, error) {
+	d, err := godigest.Parse(digest)
+	if err != nil {
+		return nil, err
+	}
+	b, status, err := r.get(path, accept)
+	if err != nil {
+		return nil, err
+	}
+	if status != http.StatusOK {
+		return nil, fmt.Errorf("Cosign: getting %s responded %d", path, status)
+	}
+	if d.Algorithm().FromBytes(b) != d {
+		return nil, fmt.Errorf("Cosign: %s doesn't match its digest", path)
+	}
+	return b, nil
+}
+
+// get requests the path under the repository (e.g., manifests/<digest>), returning the body and the status code
+func (r *referrersClient) get(path, accept string) ([]byte, int, error) {
+	u := fmt.Sprintf("%s://%s/v2/%s/%s", r.repo.Registry.Scheme(), r.repo.RegistryStr(), r.repo.RepositoryStr(), path)
+	req, err := http.NewRequestWithContext(r.ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return nil, 0, err
+	}
+	if accept != "" {
+		req.Header.Set("Accept", accept)
+	}
+	resp, err := r.client.Do(req)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer func() {
+		_ = resp.Body.Close()
+	}()
+	b, err := io.ReadAll(io.LimitReader(resp.Body, maxReferrerSize+1))
+	if err != nil {
+		return nil, 0, err
+	}
+	if len(b) > maxReferrerSize {
+		return nil, 0, fmt.Errorf("Cosign: %s exceeds %d bytes", path, maxReferrerSize)
+	}
+	return b, resp.StatusCode, nil
+}
+
+// verifyPayload verifies the simple signing payload is signed by any of the keys, for the digest and by any of the signers
+func verifyPayload(payload []byte, encodedSig, digest string, signers []string, keys []crypto.PublicKey) error {
+	sig, err := base64.StdEncoding.DecodeString(encodedSig)
+	if err != nil {
+		return errors.Wrap(err, "Cosign: malformed signature annotation")
+	}
+	verified := false
+	for _, k := range keys {
+		if verifySignature(k, payload, sig) == nil {
+			verified = true
+			break
+		}
+	}
+	if !verified {
+		return errors.New("Cosign: signature is not verified by any of the keys")
+	}
+
+	p := &simpleSigningPayload{}
+	if err := json.Unmarshal(payload, p); err != nil {
+		return err
+	}
+	if p.Critical.Image.DockerManifestDigest != digest {
+		return fmt.Errorf("Cosign: signature is for another digest %s", p.Critical.Image.DockerManifestDigest)
+	}
+	if len(signers) == 0 {
+		return nil
+	}
+	for _, signer := range signers {
+		if p.Optional["signer"] == signer {
+			return nil
+		}
+	}
+	return errors.New("Cosign: missing or incorrect annotation signer")
+}
+
+// verifySignature verifies the signature of the payload's SHA256 digest by the public key
+func verifySignature(key crypto.PublicKey, payload, sig []byte) error {
+	verifier, err := signature.LoadVerifier(key, crypto.SHA256)
+	if err != nil {
+		return err
+	}
+	return verifier.VerifySignature(bytes.NewReader(sig), bytes.NewReader(payload))
+}
@@ -0,0 +1,160 @@
+package cosign
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/google/go-containerregistry/pkg/name"
+	godigest "github.com/opencontainers/go-digest"
+	"github.com/stretchr/testify/require"
+)
+
+// testReferrersRegistry serves an image test/image:v1 whose cosign signature is attached by the referrers API.
+// If referrers is false, it doesn't support the referrers API, as the registries storing the signatures by the tag convention
+func testReferrersRegistry(t *testing.T, key *ecdsa.PrivateKey, signer string, referrers bool) (*httptest.Server, godigest.Digest) {
+	imageManifest := []byte(`{"schemaVersion":2,"mediaType":"` + ociManifestMediaType + `","config":{},"layers":[]}`)
+	imageDigest := godigest.FromBytes(imageManifest)
+
+	payload := []byte(fmt.Sprintf(`{"critical":{"identity":{"docker-reference":"test/image"},"image":{"docker-manifest-digest":"%s"},"type":"cosign container image signature"},"optional":{"signer":"%s"}}`, imageDigest, signer))
+	payloadDigest := godigest.FromBytes(payload)
+	hashed := sha256.Sum256(payload)
+	sig, err := ecdsa.SignASN1(rand.Reader, key, hashed[:])
+	require.NoError(t, err)
+
+	sigManifest, err := json.Marshal(map[string]interface{}{
+		"schemaVersion": 2,
+		"mediaType":     ociManifestMediaType,
+		"artifactType":  SignatureArtifactType,
+		"layers": []referrerDescriptor{{
+			MediaType:   simpleSigningMediaType,
+			Digest:      payloadDigest.String(),
+			Annotations: map[string]string{signatureAnnotation: base64.StdEncoding.EncodeToString(sig)},
+		}},
+	})
+	require.NoError(t, err)
+	sigDigest := godigest.FromBytes(sigManifest)
+
+	index, err := json.Marshal(referrersIndex{Manifests: []referrerDescriptor{
+		{MediaType: ociManifestMediaType, ArtifactType: "application/vnd.example.sbom", Digest: godigest.FromString("sbom").String()},
+		{MediaType: ociManifestMediaType, ArtifactType: SignatureArtifactType, Digest: sigDigest.String()},
+	}})
+	require.NoError(t, err)
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body []byte
+		contentType := ociManifestMediaType
+		switch r.URL.Path {
+		case "/v2/":
+			return
+		case "/v2/test/image/manifests/v1", "/v2/test/image/manifests/" + imageDigest.String():
+			body = imageManifest
+		case "/v2/test/image/manifests/" + sigDigest.String():
+			body = sigManifest
+		case "/v2/test/image/blobs/" + payloadDigest.String():
+			body, contentType = payload, simpleSigningMediaType
+		case "/v2/test/image/referrers/" + imageDigest.String():
+			if !referrers {
+				w.WriteHeader(http.StatusNotFound)
+				return
+			}
+			body, contentType = index, ociIndexMediaType
+		default:
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Type", contentType)
+		w.Header().Set("Content-Length", fmt.Sprint(len(body)))
+		w.Header().Set("Docker-Content-Digest", godigest.FromBytes(body).String())
+		if r.Method != http.MethodHead {
+			_, _ = w.Write(body)
+		}
+	}))
+	return srv, imageDigest
+}
+
+func TestValidReferrers(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	otherKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	tc := map[string]struct {
+		referrers bool
+		byDigest  bool
+		keys      []crypto.PublicKey
+		signers   []string
+
+		expectedErr    error
+		expectedErrMsg string
+	}{
+		"referrers": {
+			referrers: true,
+			keys:      []crypto.PublicKey{&otherKey.PublicKey, &key.PublicKey},
+			signers:   []string{"signer-a"},
+		},
+		// The signatures verified by the keys are valid by any signer, as they're by the signature tag (see TestValidSignatures)
+		"noSigners": {
+			referrers: true,
+			keys:      []crypto.PublicKey{&key.PublicKey},
+		},
+		"referrersByDigest": {
+			referrers: true,
+			byDigest:  true,
+			keys:      []crypto.PublicKey{&key.PublicKey},
+		},
+		"otherKey": {
+			referrers:      true,
+			keys:           []crypto.PublicKey{&otherKey.PublicKey},
+			expectedErrMsg: "not verified by any of the keys",
+		},
+		"otherSigner": {
+			referrers:      true,
+			keys:           []crypto.PublicKey{&key.PublicKey},
+			signers:        []string{"signer-b"},
+			expectedErrMsg: "missing or incorrect annotation",
+		},
+		// The signatures are stored by the tag convention, so it falls back to it
+		"tagConvention": {
+			keys:        []crypto.PublicKey{&key.PublicKey},
+			expectedErr: ErrNoReferrers,
+		},
+	}
+
+	for caseName, c := range tc {
+		t.Run(caseName, func(t *testing.T) {
+			srv, imageDigest := testReferrersRegistry(t, key, "signer-a", c.referrers)
+			defer srv.Close()
+
+			img := strings.TrimPrefix(srv.URL, "http://") + "/test/image:v1"
+			if c.byDigest {
+				img = strings.TrimPrefix(srv.URL, "http://") + "/test/image@" + imageDigest.String()
+			}
+			ref, err := name.ParseReference(img)
+			require.NoError(t, err)
+
+			digest, err := ValidReferrers(context.Background(), ref, c.signers, c.keys)
+			if c.expectedErr != nil {
+				require.ErrorIs(t, err, c.expectedErr)
+				return
+			}
+			if c.expectedErrMsg != "" {
+				require.Error(t, err)
+				require.Contains(t, err.Error(), c.expectedErrMsg)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, imageDigest.String(), digest)
+		})
+	}
+}
@@ -41,7 +41,7 @@ func Valid(ctx context.Context, ref name.Reference, signer []string, keys []cryp
 	if len(keys) == 0 {
 		// If there are no keys,
 		msg := "There are no keys for valid"
-		return nil, errors.Errorf(msg)
+		return nil, errors.New(msg)
 	}
 	// We return nil if ANY key matches
 	var lastErr error
@@ -74,22 +74,17 @@ func validSignatures(ctx context.Context, ref name.Reference, policySigners []st
 	// allow insecure registry [x509 error fix]
 	opts = append(opts, ociremote.WithRemoteOptions(remote.WithTransport(&http.Transport{TLSClientConfig: &tls.Config{InsecureSkipVerify: true}})))
 
+	// Without the signers, the signatures verified by the keys are valid regardless of their signer annotations, as the signature referrers are
+	if len(policySigners) == 0 {
+		return verifySignatures(ctx, ref, verifier, nil, opts)
+	}
+
 	var lastErr error
 	var lastSig []oci.Signature
 
 	for _, signer := range policySigners {
 		// do cosign verify signature & signer annotations
-		sigs, _, err := cosignVerifySignatures(ctx, ref, &cosign.CheckOpts{
-			RegistryClientOpts: opts,
-			RootCerts:          nil,
-			SigVerifier:        verifier,
-			ClaimVerifier:      cosign.SimpleClaimVerifier,
-			Annotations: map[string]interface{}{
-				"signer": signer,
-			},
-		})
-		msg := fmt.Sprintf("%v", sigs)
-		validLog.Info(msg)
+		sigs, err := verifySignatures(ctx, ref, verifier, map[string]interface{}{"signer": signer}, opts)
 		// if signature is valid & signer is valid, return sig
 		if err == nil {
 			return sigs, nil
@@ -101,6 +96,20 @@ func validSignatures(ctx context.Context, ref name.Reference, policySigners []st
 	return lastSig, lastErr
 }
 
+// verifySignatures verifies the signatures of the image by the verifier, which should have all of the annotations
+func verifySignatures(ctx context.Context, ref name.Reference, verifier signature.Verifier, annotations map[string]interface{}, opts []ociremote.Option) ([]oci.Signature, error) {
+	sigs, _, err := cosignVerifySignatures(ctx, ref, &cosign.CheckOpts{
+		RegistryClientOpts: opts,
+		RootCerts:          nil,
+		SigVerifier:        verifier,
+		ClaimVerifier:      cosign.SimpleClaimVerifier,
+		Annotations:        annotations,
+	})
+	msg := fmt.Sprintf("%v", sigs)
+	validLog.Info(msg)
+	return sigs, err
+}
+
 func GetPublicKey(cfg map[string][]byte) ([]crypto.PublicKey, error) {
 	keys := []crypto.PublicKey{}
 	errs := []error{}
@@ -0,0 +1,64 @@
+package cosign
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/sigstore/cosign/pkg/cosign"
+	"github.com/sigstore/cosign/pkg/oci"
+	"github.com/stretchr/testify/require"
+)
+
+func TestValidSignatures(t *testing.T) {
+	tc := map[string]struct {
+		signers []string
+
+		expectedValid       bool
+		expectedAnnotations []map[string]interface{}
+	}{
+		"signer": {
+			signers:             []string{"signer-b", "signer-a"},
+			expectedValid:       true,
+			expectedAnnotations: []map[string]interface{}{{"signer": "signer-b"}, {"signer": "signer-a"}},
+		},
+		"otherSigner": {
+			signers:             []string{"signer-b"},
+			expectedAnnotations: []map[string]interface{}{{"signer": "signer-b"}},
+		},
+		// The signatures verified by the keys are valid by any signer, as they're by the signature referrers (see TestValidReferrers)
+		"noSigners": {
+			expectedValid:       true,
+			expectedAnnotations: []map[string]interface{}{nil},
+		},
+	}
+
+	for caseName, c := range tc {
+		t.Run(caseName, func(t *testing.T) {
+			// The signatures are signed by signer-a
+			var annotations []map[string]interface{}
+			cosignVerifySignatures = func(_ context.Context, _ name.Reference, co *cosign.CheckOpts) ([]oci.Signature, bool, error) {
+				annotations = append(annotations, co.Annotations)
+				if signer, exist := co.Annotations["signer"]; exist && signer != "signer-a" {
+					return nil, false, errors.New("missing or incorrect annotation")
+				}
+				return []oci.Signature{nil}, false, nil
+			}
+			defer func() {
+				cosignVerifySignatures = cosign.VerifyImageSignatures
+			}()
+
+			ref, err := name.ParseReference("registry.test/test/image:v1")
+			require.NoError(t, err)
+			sigs, err := validSignatures(context.Background(), ref, c.signers, nil)
+			require.Equal(t, c.expectedAnnotations, annotations)
+			if !c.expectedValid {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			require.NotEmpty(t, sigs)
+		})
+	}
+}
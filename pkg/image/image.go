@@ -2,6 +2,7 @@ package image
 
 import (
 	"crypto/tls"
+	"encoding/base64"
 	"fmt"
 	"net/http"
 	"path"
@@ -50,21 +51,33 @@ type Image struct {
 	HTTPClient http.Client
 }
 
-// NewImage creates new image client
+// Options are the options of the image clients. The zero value requests every registry over https
+type Options struct {
+	// InsecureRegistries are the registries served over plain http. They're the hosts with the ports (e.g., registry.local:5000),
+	// if the ports are not the default ones
+	InsecureRegistries []string
+}
+
+// NewImage creates new image client, with the default options
 func NewImage(uri, basicAuth string) (*Image, error) {
+	return Options{}.NewImage(uri, basicAuth)
+}
+
+// NewImage creates new image client with the options
+func (o Options) NewImage(uri, basicAuth string) (*Image, error) {
 	r := &Image{}
 
 	// Set image
 	if uri != "" {
-		if err := r.setImage(uri); err != nil {
+		if err := r.setImage(uri, o); err != nil {
 			Logger.Error(err, "failed to set image", "uri", uri)
 			return nil, err
 		}
 	}
 
 	// Auth
-	r.BasicAuth = basicAuth
-	Logger.Info("Auth", "auth", basicAuth)
+	r.BasicAuth = normalizeBasicAuth(basicAuth)
+	Logger.Info("Auth", "hasAuth", r.BasicAuth != "")
 	r.Token = &auth.Token{}
 
 	// Generate HTTPS client
@@ -78,8 +91,24 @@ func NewImage(uri, basicAuth string) (*Image, error) {
 	return r, nil
 }
 
+// normalizeBasicAuth returns the basic auth encoded by base64, as it's sent in the Authorization header.
+// It may be given as the base64 of <username>:<password> (e.g., the auth of a docker config) or as the raw <username>:<password>,
+// which is unambiguous as ':' is not in the base64 alphabet. The others are sent as they are, with a warning
+func normalizeBasicAuth(basicAuth string) string {
+	if basicAuth == "" {
+		return ""
+	}
+	if strings.Contains(basicAuth, ":") {
+		return base64.StdEncoding.EncodeToString([]byte(basicAuth))
+	}
+	if decoded, err := base64.StdEncoding.DecodeString(basicAuth); err != nil || !strings.Contains(string(decoded), ":") {
+		Logger.Info("basic auth is neither the base64 of <username>:<password> nor <username>:<password>, sending it as it is")
+	}
+	return basicAuth
+}
+
 // setImage sets image from "[<server>/]<imageName>[:<tag>|@<digest>]" form argument
-func (r *Image) setImage(image string) error {
+func (r *Image) setImage(image string, opts Options) error {
 	// Parse image
 	var img reference.Named
 	var err error
@@ -87,13 +116,7 @@ func (r *Image) setImage(image string) error {
 	r.ServerURL = DefaultServer
 	img, err = reference.ParseNamed(image)
 	if err == nil {
-		domain := reference.Domain(img)
-		if r.isDefaultServerDomain(domain) {
-			domain = DefaultServer
-		}
-		if !r.isValidDomain(domain) {
-			r.setServerURL(domain)
-		}
+		r.ServerURL = opts.ServerURLForHost(reference.Domain(img))
 	}
 
 	if r.ServerURL == DefaultServer {
@@ -143,9 +166,40 @@ func (r *Image) setImage(image string) error {
 	return nil
 }
 
+func (o Options) isInsecureRegistry(host string) bool {
+	for _, registry := range o.InsecureRegistries {
+		if strings.EqualFold(registry, host) {
+			return true
+		}
+	}
+	return false
+}
+
+// ServerURLForHost returns the registry server URL of the image's host, with the default options
+func ServerURLForHost(host string) string {
+	return Options{}.ServerURLForHost(host)
+}
+
+// ServerURLForHost returns the registry server URL of the image's host.
+// Docker hub's aliases (and the empty host) are normalized to DefaultServer, and the port of the host is kept.
+// The insecure registries are served over http, and the others over https
+func (o Options) ServerURLForHost(host string) string {
+	if isDefaultServerDomain(host) {
+		return DefaultServer
+	}
+	if strings.HasPrefix(host, "https://") || strings.HasPrefix(host, "http://") {
+		return host
+	}
+	if o.isInsecureRegistry(host) {
+		return "http://" + host
+	}
+	return "https://" + host
+}
+
 // isDefaultServerDomain returns whether the image's domain is docker.io
-func (r *Image) isDefaultServerDomain(domain string) bool {
-	if domain != DefaultHostname &&
+func isDefaultServerDomain(domain string) bool {
+	if domain != "" &&
+		domain != DefaultHostname &&
 		domain != DefaultServer &&
 		domain != DefaultServerHostName &&
 		domain != LegacyDefaultDomain {
@@ -154,19 +208,6 @@ func (r *Image) isDefaultServerDomain(domain string) bool {
 	return true
 }
 
-// isValidDomain returns whether the domain is contained in server url
-func (r *Image) isValidDomain(domain string) bool {
-	return strings.Contains(r.ServerURL, domain)
-}
-
-// setServerURL sets registry server URL
-func (r *Image) setServerURL(url string) {
-	if !strings.HasPrefix(url, "https://") && !strings.HasPrefix(url, "http://") {
-		url = "https://" + url
-	}
-	r.ServerURL = url
-}
-
 // normalizedNamed normalize image for default server
 func (r *Image) normalizedNamed(image string) (reference.Named, error) {
 	var named, norm reference.Named
@@ -228,3 +269,17 @@ func (r *Image) getTagOrDigest(named reference.Named) (tag string, digest digest
 func (r *Image) GetImageNameWithHost() string {
 	return path.Join(r.Host, r.Name)
 }
+
+// GetGUN returns the globally unique name of the image's repository in its notary server.
+// Docker hub's repositories are named by DefaultHostname regardless of the host they're referred by
+// (e.g., registry-1.docker.io/nginx to docker.io/library/nginx), and the official images are in library/
+func (r *Image) GetGUN() string {
+	if !isDefaultServerDomain(r.Host) {
+		return r.GetImageNameWithHost()
+	}
+	name := r.Name
+	if !strings.Contains(name, "/") {
+		name = "library/" + name
+	}
+	return path.Join(DefaultHostname, name)
+}
@@ -22,12 +22,13 @@ type signatureTestCase struct {
 }
 
 const (
-	testLibrary    = "testlibrary/"
-	testImage      = "testimage"
-	testRepository = "test.io"
-	testTag        = "v0.0.1"
-	testDigest     = "sha256:1111111111111111111111111111111111111111111111111111111111111111"
-	wrongDigest    = "sha256:11111111111111111111111111111111111111111111111111111111111"
+	testLibrary            = "testlibrary/"
+	testImage              = "testimage"
+	testRepository         = "test.io"
+	testRepositoryWithPort = "test.io:5000"
+	testTag                = "v0.0.1"
+	testDigest             = "sha256:1111111111111111111111111111111111111111111111111111111111111111"
+	wrongDigest            = "sha256:11111111111111111111111111111111111111111111111111111111111"
 )
 
 func TestNewImage(t *testing.T) {
@@ -83,6 +84,15 @@ func TestNewImage(t *testing.T) {
 			expectedDigest: testDigest,
 			expectedErr:    "",
 		},
+		"withPort": {
+			uri:            testRepositoryWithPort + "/" + testLibrary + testImage + ":" + testTag,
+			basicAuth:      "",
+			expectedHost:   testRepositoryWithPort,
+			expectedName:   testLibrary + testImage,
+			expectedTag:    testTag,
+			expectedDigest: "",
+			expectedErr:    "",
+		},
 		"withWrongDigest": {
 			uri:            testRepository + "/" + testLibrary + testImage + "@" + wrongDigest,
 			basicAuth:      "",
@@ -98,6 +108,7 @@ func TestNewImage(t *testing.T) {
 			r, err := NewImage(c.uri, c.basicAuth)
 			if c.expectedErr == "" {
 				require.Equal(t, c.expectedHost, r.Host)
+				require.Equal(t, ServerURLForHost(c.expectedHost), r.ServerURL)
 				require.Equal(t, c.expectedName, r.Name)
 				require.Equal(t, c.expectedTag, r.Tag)
 				require.Equal(t, c.expectedDigest, r.Digest)
@@ -108,3 +119,69 @@ func TestNewImage(t *testing.T) {
 		})
 	}
 }
+
+func TestServerURLForHost(t *testing.T) {
+	tc := map[string]string{
+		"":                     DefaultServer,
+		"docker.io":            DefaultServer,
+		"index.docker.io":      DefaultServer,
+		"registry-1.docker.io": DefaultServer,
+		"test.io":              "https://test.io",
+		"test.io:5000":         "https://test.io:5000",
+		"localhost:5000":       "https://localhost:5000",
+		"http://test.io:5000":  "http://test.io:5000",
+	}
+
+	for host, expected := range tc {
+		t.Run(host, func(t *testing.T) {
+			require.Equal(t, expected, ServerURLForHost(host))
+		})
+	}
+}
+
+func TestServerURLForHost_insecureRegistries(t *testing.T) {
+	opts := Options{InsecureRegistries: []string{"insecure.io:5000", "Insecure-Default.io"}}
+
+	tc := map[string]string{
+		"insecure.io:5000":    "http://insecure.io:5000",
+		"INSECURE.io:5000":    "http://INSECURE.io:5000",
+		"insecure-default.io": "http://insecure-default.io",
+		"insecure.io:5001":    "https://insecure.io:5001",
+		"insecure.io":         "https://insecure.io",
+		"secure.io:5000":      "https://secure.io:5000",
+		"docker.io":           DefaultServer,
+	}
+
+	for host, expected := range tc {
+		t.Run(host, func(t *testing.T) {
+			require.Equal(t, expected, opts.ServerURLForHost(host))
+		})
+	}
+}
+
+func TestImage_GetGUN(t *testing.T) {
+	tc := map[string]struct {
+		uri         string
+		expectedGUN string
+	}{
+		"official":                {uri: "nginx:1.21", expectedGUN: "docker.io/library/nginx"},
+		"officialWithLibrary":     {uri: "library/nginx:1.21", expectedGUN: "docker.io/library/nginx"},
+		"officialWithHost":        {uri: "docker.io/nginx:1.21", expectedGUN: "docker.io/library/nginx"},
+		"officialWithLegacyHost":  {uri: "index.docker.io/library/nginx:1.21", expectedGUN: "docker.io/library/nginx"},
+		"officialWithServerHost":  {uri: "registry-1.docker.io/nginx:1.21", expectedGUN: "docker.io/library/nginx"},
+		"user":                    {uri: "user/app:v1", expectedGUN: "docker.io/user/app"},
+		"userWithHost":            {uri: "docker.io/user/app:v1", expectedGUN: "docker.io/user/app"},
+		"userWithServerHost":      {uri: "registry-1.docker.io/user/app:v1", expectedGUN: "docker.io/user/app"},
+		"otherRegistry":           {uri: testRepository + "/app:v1", expectedGUN: testRepository + "/app"},
+		"otherRegistryWithPort":   {uri: testRepositoryWithPort + "/" + testLibrary + testImage + ":" + testTag, expectedGUN: testRepositoryWithPort + "/" + testLibrary + testImage},
+		"otherRegistryWithDigest": {uri: testRepository + "/user/app@" + testDigest, expectedGUN: testRepository + "/user/app"},
+	}
+
+	for name, c := range tc {
+		t.Run(name, func(t *testing.T) {
+			r, err := NewImage(c.uri, "")
+			require.NoError(t, err)
+			require.Equal(t, c.expectedGUN, r.GetGUN())
+		})
+	}
+}
@@ -0,0 +1,198 @@
+package image
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+
+	regclient "github.com/docker/distribution/registry/client"
+	"github.com/docker/distribution/registry/client/auth/challenge"
+	"github.com/tmax-cloud/image-validating-webhook/pkg/auth"
+)
+
+// Media types of the image manifests
+const (
+	MediaTypeDockerManifest     = "application/vnd.docker.distribution.manifest.v2+json"
+	MediaTypeDockerManifestList = "application/vnd.docker.distribution.manifest.list.v2+json"
+	MediaTypeOCIManifest        = "application/vnd.oci.image.manifest.v1+json"
+	MediaTypeOCIIndex           = "application/vnd.oci.image.index.v1+json"
+)
+
+// Manifest is an image manifest or a manifest list(index)
+type Manifest struct {
+	MediaType string       `json:"mediaType"`
+	Config    Descriptor   `json:"config"`
+	Layers    []Descriptor `json:"layers,omitempty"`
+	Manifests []Descriptor `json:"manifests,omitempty"`
+}
+
+// IsList returns true if the manifest is a manifest list(index)
+func (m *Manifest) IsList() bool {
+	return m.MediaType == MediaTypeDockerManifestList || m.MediaType == MediaTypeOCIIndex || len(m.Manifests) > 0
+}
+
+// Descriptor describes a content in the registry
+type Descriptor struct {
+	MediaType string    `json:"mediaType"`
+	Digest    string    `json:"digest"`
+	Size      int64     `json:"size"`
+	Platform  *Platform `json:"platform,omitempty"`
+}
+
+// Platform is a platform of the manifest in the manifest list
+type Platform struct {
+	Architecture string `json:"architecture"`
+	OS           string `json:"os"`
+	Variant      string `json:"variant,omitempty"`
+}
+
+// Config is an image config
+type Config struct {
+	Architecture string          `json:"architecture,omitempty"`
+	Variant      string          `json:"variant,omitempty"`
+	OS           string          `json:"os,omitempty"`
+	Config       ContainerConfig `json:"config,omitempty"`
+}
+
+// ContainerConfig is a container config in the image config
+type ContainerConfig struct {
+	Labels map[string]string `json:"Labels,omitempty"`
+}
+
+// GetManifest fetches the manifest of the image's digest (or tag, if there is no digest) from the registry
+// It returns the manifest and its digest
+func (r *Image) GetManifest() (*Manifest, string, error) {
+	ref := r.Digest
+	if ref == "" {
+		ref = r.Tag
+	}
+	return r.getManifest(ref)
+}
+
+// GetConfig fetches the image config from the registry.
+// If the image refers to a manifest list, the config of the linux/amd64 manifest (or the first one) is fetched.
+// It returns the config and the digest of the manifest the image refers to
+func (r *Image) GetConfig() (*Config, string, error) {
+	manifest, digest, err := r.GetManifest()
+	if err != nil {
+		return nil, "", err
+	}
+
+	if manifest.IsList() {
+		if len(manifest.Manifests) == 0 {
+			return nil, "", fmt.Errorf("manifest list of %s is empty", r.GetImageNameWithHost())
+		}
+		selected := manifest.Manifests[0]
+		for _, m := range manifest.Manifests {
+			if m.Platform != nil && m.Platform.OS == "linux" && m.Platform.Architecture == "amd64" {
+				selected = m
+				break
+			}
+		}
+		manifest, _, err = r.getManifest(selected.Digest)
+		if err != nil {
+			return nil, "", err
+		}
+	}
+
+	// Images built from scratch may not have a config blob
+	cfg := &Config{}
+	if manifest.Config.Digest == "" {
+		return cfg, digest, nil
+	}
+
+	b, err := r.get(fmt.Sprintf("%s/v2/%s/blobs/%s", r.ServerURL, r.Name, manifest.Config.Digest), "")
+	if err != nil {
+		return nil, "", err
+	}
+	if len(b) == 0 {
+		return cfg, digest, nil
+	}
+	if err := json.Unmarshal(b, cfg); err != nil {
+		return nil, "", err
+	}
+
+	return cfg, digest, nil
+}
+
+func (r *Image) getManifest(ref string) (*Manifest, string, error) {
+	accept := strings.Join([]string{MediaTypeDockerManifest, MediaTypeDockerManifestList, MediaTypeOCIManifest, MediaTypeOCIIndex}, ",")
+	b, err := r.get(fmt.Sprintf("%s/v2/%s/manifests/%s", r.ServerURL, r.Name, ref), accept)
+	if err != nil {
+		return nil, "", err
+	}
+
+	manifest := &Manifest{}
+	if err := json.Unmarshal(b, manifest); err != nil {
+		return nil, "", err
+	}
+
+	return manifest, fmt.Sprintf("sha256:%x", sha256.Sum256(b)), nil
+}
+
+// get requests the url to the registry, fetching a token if it's required
+func (r *Image) get(url, accept string) ([]byte, error) {
+	resp, err := r.doRequest(url, accept)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode == http.StatusUnauthorized {
+		if err := r.fetchToken(resp); err != nil {
+			_ = resp.Body.Close()
+			return nil, err
+		}
+		_ = resp.Body.Close()
+		resp, err = r.doRequest(url, accept)
+		if err != nil {
+			return nil, err
+		}
+	}
+	defer func() {
+		_ = resp.Body.Close()
+	}()
+
+	if !regclient.SuccessStatus(resp.StatusCode) {
+		return nil, regclient.HandleErrorResponse(resp)
+	}
+
+	return ioutil.ReadAll(resp.Body)
+}
+
+func (r *Image) doRequest(url, accept string) (*http.Response, error) {
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	if accept != "" {
+		req.Header.Set("Accept", accept)
+	}
+	if r.Token != nil && r.Token.Value != "" {
+		req.Header.Set("Authorization", fmt.Sprintf("%s %s", r.Token.Type, r.Token.Value))
+	} else if r.BasicAuth != "" {
+		req.Header.Set("Authorization", fmt.Sprintf("Basic %s", r.BasicAuth))
+	}
+	return r.HTTPClient.Do(req)
+}
+
+// fetchToken fetches a pull token for the image, using the challenge of the unauthorized response
+func (r *Image) fetchToken(resp *http.Response) error {
+	for _, c := range challenge.ResponseChallenges(resp) {
+		if !strings.EqualFold(c.Scheme, string(auth.TokenTypeBearer)) {
+			continue
+		}
+		realm, realmExist := c.Parameters["realm"]
+		if !realmExist {
+			continue
+		}
+		token, err := auth.RequestToken(&r.HTTPClient, realm, c.Parameters["service"], fmt.Sprintf("repository:%s:pull", r.Name), r.BasicAuth)
+		if err != nil {
+			return err
+		}
+		r.Token = token
+		return nil
+	}
+	return fmt.Errorf("unauthorized: there is no bearer challenge for %s", r.GetImageNameWithHost())
+}
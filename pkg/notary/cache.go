@@ -0,0 +1,112 @@
+package notary
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
+)
+
+var (
+	signatureCacheHits = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "image_validation_webhook_signature_cache_hits_total",
+		Help: "Number of the signatures found in the signature cache",
+	})
+	signatureCacheMisses = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "image_validation_webhook_signature_cache_misses_total",
+		Help: "Number of the signatures not found (or expired) in the signature cache",
+	})
+	signatureCacheEvictions = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "image_validation_webhook_signature_cache_evictions_total",
+		Help: "Number of the expired signatures removed from the signature cache",
+	})
+	signatureCacheEntries = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "image_validation_webhook_signature_cache_entries",
+		Help: "Number of the entries of the signature cache, including the expired ones not removed yet",
+	})
+)
+
+func init() {
+	metrics.Registry.MustRegister(signatureCacheHits, signatureCacheMisses, signatureCacheEvictions, signatureCacheEntries)
+}
+
+// SignatureCache is a cache of the signatures fetched from the notary servers
+type SignatureCache struct {
+	lock    sync.RWMutex
+	entries map[string]cachedSignature
+
+	hits   uint64
+	misses uint64
+}
+
+// CacheStats is the statistics of a cache
+type CacheStats struct {
+	Entries int    `json:"entries"`
+	Hits    uint64 `json:"hits"`
+	Misses  uint64 `json:"misses"`
+}
+
+// HitRate is the ratio of the hits to the lookups. It's 0 if nothing is looked up
+func (s CacheStats) HitRate() float64 {
+	if s.Hits+s.Misses == 0 {
+		return 0
+	}
+	return float64(s.Hits) / float64(s.Hits+s.Misses)
+}
+
+type cachedSignature struct {
+	sig      *Signature
+	expireAt time.Time
+}
+
+// NewSignatureCache creates an empty signature cache
+func NewSignatureCache() *SignatureCache {
+	return &SignatureCache{entries: map[string]cachedSignature{}}
+}
+
+// Get gets the cached signature of the image from the notary server, if it's not expired
+func (c *SignatureCache) Get(imageURI, notaryServer string) (*Signature, bool) {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+
+	entry, exist := c.entries[signatureCacheKey(imageURI, notaryServer)]
+	if !exist || time.Now().After(entry.expireAt) {
+		atomic.AddUint64(&c.misses, 1)
+		signatureCacheMisses.Inc()
+		return nil, false
+	}
+	atomic.AddUint64(&c.hits, 1)
+	signatureCacheHits.Inc()
+	return entry.sig, true
+}
+
+// Set caches the signature of the image from the notary server for ttl. The expired signatures are removed, and counted as evictions
+func (c *SignatureCache) Set(imageURI, notaryServer string, sig *Signature, ttl time.Duration) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	now := time.Now()
+	// Clean up the expired entries
+	for k, entry := range c.entries {
+		if now.After(entry.expireAt) {
+			delete(c.entries, k)
+			signatureCacheEvictions.Inc()
+		}
+	}
+	c.entries[signatureCacheKey(imageURI, notaryServer)] = cachedSignature{sig: sig, expireAt: now.Add(ttl)}
+	signatureCacheEntries.Set(float64(len(c.entries)))
+}
+
+// Stats returns the statistics of the cache. Expired entries are counted until the next Set removes them
+func (c *SignatureCache) Stats() CacheStats {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+
+	return CacheStats{Entries: len(c.entries), Hits: atomic.LoadUint64(&c.hits), Misses: atomic.LoadUint64(&c.misses)}
+}
+
+func signatureCacheKey(imageURI, notaryServer string) string {
+	return notaryServer + "/" + imageURI
+}
@@ -0,0 +1,66 @@
+package notary
+
+import (
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSignatureCache(t *testing.T) {
+	cache := NewSignatureCache()
+	sig := &Signature{Name: "test.registry/signed"}
+
+	_, exist := cache.Get("test.registry/signed:test", "https://notary")
+	require.False(t, exist, "empty cache")
+
+	cache.Set("test.registry/signed:test", "https://notary", sig, time.Minute)
+	cached, exist := cache.Get("test.registry/signed:test", "https://notary")
+	require.True(t, exist)
+	require.Equal(t, sig, cached)
+
+	_, exist = cache.Get("test.registry/signed:test", "https://other-notary")
+	require.False(t, exist, "other notary server")
+
+	cache.Set("test.registry/signed:test", "https://notary", sig, -time.Minute)
+	_, exist = cache.Get("test.registry/signed:test", "https://notary")
+	require.False(t, exist, "expired")
+
+	stats := cache.Stats()
+	require.Equal(t, CacheStats{Entries: 1, Hits: 1, Misses: 3}, stats)
+	require.Equal(t, 0.25, stats.HitRate())
+}
+
+func TestSignatureCache_metrics(t *testing.T) {
+	cache := NewSignatureCache()
+	hits, misses, evictions := testutil.ToFloat64(signatureCacheHits), testutil.ToFloat64(signatureCacheMisses), testutil.ToFloat64(signatureCacheEvictions)
+
+	_, _ = cache.Get("test.registry/signed:test", "https://notary")
+	require.Equal(t, misses+1, testutil.ToFloat64(signatureCacheMisses), "miss")
+
+	cache.Set("test.registry/signed:test", "https://notary", &Signature{}, -time.Minute)
+	cache.Set("test.registry/other:test", "https://notary", &Signature{}, time.Minute)
+	require.Equal(t, evictions+1, testutil.ToFloat64(signatureCacheEvictions), "expired one is removed by setting another")
+	require.Equal(t, float64(1), testutil.ToFloat64(signatureCacheEntries))
+
+	_, _ = cache.Get("test.registry/other:test", "https://notary")
+	require.Equal(t, hits+1, testutil.ToFloat64(signatureCacheHits), "hit")
+	_, _ = cache.Get("test.registry/signed:test", "https://notary")
+	require.Equal(t, misses+2, testutil.ToFloat64(signatureCacheMisses), "removed")
+
+	cache.Set("test.registry/signed:test", "https://notary", &Signature{}, time.Minute)
+	cache.Set("test.registry/signed:test", "https://notary", &Signature{}, time.Minute)
+	require.Equal(t, evictions+1, testutil.ToFloat64(signatureCacheEvictions), "not expired one is not evicted")
+	require.Equal(t, float64(2), testutil.ToFloat64(signatureCacheEntries))
+}
+
+func TestSignatureCache_sweep(t *testing.T) {
+	cache := NewSignatureCache()
+	for _, image := range []string{"test.registry/a:test", "test.registry/b:test", "test.registry/c:test"} {
+		cache.Set(image, "https://notary", &Signature{}, -time.Minute)
+	}
+	cache.Set("test.registry/d:test", "https://notary", &Signature{}, time.Minute)
+
+	require.Equal(t, 1, cache.Stats().Entries, "the expired ones of the other images are removed as well")
+}
@@ -1,13 +1,11 @@
 package notary
 
 import (
-	"fmt"
-	"os"
 	"strings"
+	"time"
 
 	logf "sigs.k8s.io/controller-runtime/pkg/log"
 
-	"github.com/tmax-cloud/image-validating-webhook/internal/utils"
 	"github.com/tmax-cloud/image-validating-webhook/pkg/image"
 	"github.com/tmax-cloud/image-validating-webhook/pkg/trust"
 )
@@ -16,36 +14,88 @@ var (
 	signatureLog = logf.Log.WithName("signature.go")
 )
 
-// Signature is a sign info of an image
+// releasedSigner is the signer of the tags signed into the released roles (i.e., targets, targets/releases or the release roles), rather than by a delegation
+const releasedSigner = "Repo Admin"
+
+// Signature is a sign info of an image, in the same form as docker trust inspect shows
 type Signature struct {
 	Name       string      `json:"Name"`
 	SignedTags []SignedTag `json:"SignedTags"`
+	// UnreleasedTags are the tags signed only into the delegation roles, which are not regarded as signed
+	UnreleasedTags []SignedTag `json:"UnreleasedTags,omitempty"`
+	// AdministrativeKeys are the keys of the root (Root) and the targets (Repository) roles of the repository
+	AdministrativeKeys []AdminRole `json:"AdministrativeKeys,omitempty"`
+}
+
+// AdminRole is the keys of an administrative role
+type AdminRole struct {
+	Name string     `json:"Name"`
+	Keys []AdminKey `json:"Keys"`
+}
+
+// AdminKey is a key of an administrative role
+type AdminKey struct {
+	ID string `json:"ID"`
 }
 
 // SignedTag is a tag-signature info
 type SignedTag struct {
 	SignedTag string   `json:"SignedTag"`
 	Digest    string   `json:"Digest"`
+	Algorithm string   `json:"Algorithm,omitempty"`
 	Signers   []string `json:"Signers"`
+	// SignedAt is when the tag was last signed into the released roles, estimated by the default expiry of the roles. It's zero if it's unknown
+	SignedAt time.Time `json:"SignedAt"`
+	// Expires is when the released roles which last signed the tag expire. It's zero if it's unknown
+	Expires time.Time `json:"Expires"`
 }
 
-// GetDigest gets signed digest for the tag
+// GetDigest gets signed digest for the tag, prefixed with its algorithm (e.g., sha256:<hex>).
+// If the tag is empty (i.e., the image is referred only by its digest), the digest all the signed tags share is returned,
+// as the signature of such an image holds only the tags of its digest. It's empty if they don't share one
 func (s *Signature) GetDigest(tag string) string {
+	if tag == "" {
+		return s.sharedDigest()
+	}
 	digest := ""
 	for _, signedTag := range s.SignedTags {
 		if signedTag.SignedTag == tag {
-			digest = signedTag.Digest
+			digest = signedTag.DigestWithAlgorithm()
+		}
+	}
+	return digest
+}
+
+func (s *Signature) sharedDigest() string {
+	digest := ""
+	for _, signedTag := range s.SignedTags {
+		d := signedTag.DigestWithAlgorithm()
+		if digest != "" && d != digest {
+			return ""
 		}
+		digest = d
 	}
 	return digest
 }
 
+// DigestWithAlgorithm returns the digest prefixed with its algorithm. sha256 is assumed if the algorithm is unknown
+func (t *SignedTag) DigestWithAlgorithm() string {
+	if t.Digest == "" {
+		return ""
+	}
+	algorithm := t.Algorithm
+	if algorithm == "" {
+		algorithm = "sha256"
+	}
+	return algorithm + ":" + t.Digest
+}
+
 // MatchSigner find match who signed
 func (s *Signature) MatchSigner(policySigners []string) bool {
 	for _, signedTag := range s.SignedTags {
 		for _, signers := range signedTag.Signers {
 			// when image signer is Repository Administrator, just return true
-			if signers == "Repo Admin" {
+			if signers == releasedSigner {
 				return true
 			}
 			for _, sgr := range policySigners {
@@ -59,51 +109,240 @@ func (s *Signature) MatchSigner(policySigners []string) bool {
 	return false
 }
 
-// FetchSignature fetches a signature from the notary server
-func FetchSignature(imageURI, basicAuth, notaryServer string) (*Signature, error) {
-	img, err := image.NewImage(imageURI, basicAuth)
-	if err != nil {
-		signatureLog.Error(err, "failed new image")
-		return nil, err
+// MatchAdminKeys checks if any of the administrative keys of the repository is one of the pinned key IDs, i.e., it's the repository the keys pin
+func (s *Signature) MatchAdminKeys(pinnedKeyIDs []string) bool {
+	for _, role := range s.AdministrativeKeys {
+		for _, key := range role.Keys {
+			if containsString(pinnedKeyIDs, key.ID) {
+				return true
+			}
+		}
 	}
+	return false
+}
+
+// CountSigners counts the distinct policy signers who signed the tag. All the signed tags are counted if the tag is empty
+func (s *Signature) CountSigners(tag string, policySigners []string) int {
+	signed := map[string]struct{}{}
+	for _, signedTag := range s.SignedTags {
+		if tag != "" && signedTag.SignedTag != tag {
+			continue
+		}
+		for _, signer := range signedTag.Signers {
+			signed[signer] = struct{}{}
+		}
+	}
+
+	count := 0
+	counted := map[string]struct{}{}
+	for _, sgr := range policySigners {
+		_, isSigned := signed[sgr]
+		_, isCounted := counted[sgr]
+		if isSigned && !isCounted {
+			counted[sgr] = struct{}{}
+			count++
+		}
+	}
+	return count
+}
+
+// GetSigners returns the distinct signers of the tag. Signers of all the signed tags are returned if the tag is empty
+func (s *Signature) GetSigners(tag string) []string {
+	var signers []string
+	seen := map[string]struct{}{}
+	for _, signedTag := range s.SignedTags {
+		if tag != "" && signedTag.SignedTag != tag {
+			continue
+		}
+		for _, signer := range signedTag.Signers {
+			if _, exist := seen[signer]; !exist {
+				seen[signer] = struct{}{}
+				signers = append(signers, signer)
+			}
+		}
+	}
+	return signers
+}
+
+// GetSignedAt returns when the tag was last signed. The latest of all the signed tags is returned if the tag is empty.
+// It's zero if it's unknown
+func (s *Signature) GetSignedAt(tag string) time.Time {
+	var signedAt time.Time
+	for _, signedTag := range s.SignedTags {
+		if tag != "" && signedTag.SignedTag != tag {
+			continue
+		}
+		if signedTag.SignedAt.After(signedAt) {
+			signedAt = signedTag.SignedAt
+		}
+	}
+	return signedAt
+}
 
-	// Use notary client
-	// Here, we create a new cache directory per requests.
-	// (Be aware that FetchSigner is called from inside the http.Handler. It can be called simultaneously as goroutines)
-	// By doing so, we can clean the cache directory after the process in easier way.
-	tempDir := fmt.Sprintf("%s/notary/%s", os.TempDir(), utils.RandomString(10))
-	not, err := trust.NewReadOnly(img, notaryServer, tempDir)
+// GetSignedAtByExpiry returns when the tag was last signed as GetSignedAt does, estimated by the expiry its roles are signed with
+// (e.g., by notary's --expiry) instead of the default one. It's zero if it's unknown
+func (s *Signature) GetSignedAtByExpiry(tag string, expiry time.Duration) time.Time {
+	var expires time.Time
+	for _, signedTag := range s.SignedTags {
+		if tag != "" && signedTag.SignedTag != tag {
+			continue
+		}
+		if signedTag.Expires.After(expires) {
+			expires = signedTag.Expires
+		}
+	}
+	if expires.IsZero() {
+		return time.Time{}
+	}
+	return expires.Add(-expiry)
+}
+
+// GetUnreleasedSigners returns the signers of the tag, who signed it only into the delegation roles
+func (s *Signature) GetUnreleasedSigners(tag string) []string {
+	var signers []string
+	for _, unreleasedTag := range s.UnreleasedTags {
+		if unreleasedTag.SignedTag == tag {
+			signers = append(signers, unreleasedTag.Signers...)
+		}
+	}
+	return signers
+}
+
+// WithSignerNames returns the copy of the signature whose signers are renamed by the names, which are keyed by their delegation roles (e.g., targets/ci-bot or ci-bot).
+// The signers not in the names keep their names. The signature itself is not changed, as it may be shared by the cache
+func (s *Signature) WithSignerNames(names map[string]string) *Signature {
+	if s == nil || len(names) == 0 {
+		return s
+	}
+	byRole := map[string]string{}
+	for role, name := range names {
+		byRole[strings.TrimPrefix(role, "targets/")] = name
+	}
+	renamed := *s
+	renamed.SignedTags = renameSigners(s.SignedTags, byRole)
+	renamed.UnreleasedTags = renameSigners(s.UnreleasedTags, byRole)
+	return &renamed
+}
+
+// renameSigners returns the copy of the tags whose signers are renamed, counting the roles renamed to the same signer once
+func renameSigners(tags []SignedTag, byRole map[string]string) []SignedTag {
+	if tags == nil {
+		return nil
+	}
+	renamed := make([]SignedTag, len(tags))
+	for i, tag := range tags {
+		renamed[i] = tag
+		renamed[i].Signers = nil
+		for _, signer := range tag.Signers {
+			if name, exist := byRole[signer]; exist && signer != releasedSigner {
+				signer = name
+			}
+			if !containsString(renamed[i].Signers, signer) {
+				renamed[i].Signers = append(renamed[i].Signers, signer)
+			}
+		}
+	}
+	return renamed
+}
+
+func containsString(list []string, str string) bool {
+	for _, s := range list {
+		if s == str {
+			return true
+		}
+	}
+	return false
+}
+
+// FetchSignature fetches a signature from the notary server.
+// The tags signed into the releaseRoles (e.g., targets/prod) are regarded as released, as well as targets and targets/releases
+func FetchSignature(imageURI, basicAuth, notaryServer string, releaseRoles ...string) (*Signature, error) {
+	return FetchPooledSignature(nil, imageURI, basicAuth, notaryServer, releaseRoles...)
+}
+
+// FetchPooledSignature fetches a signature from the notary server, reusing the notary repository of the pool.
+// If the pool is nil, a new notary repository is used for the request (Be aware that it's called from inside the http.Handler.
+// It can be called simultaneously as goroutines, each of which has its own cache directory)
+func FetchPooledSignature(pool *trust.RepoPool, imageURI, basicAuth, notaryServer string, releaseRoles ...string) (*Signature, error) {
+	img, err := image.NewImage(imageURI, basicAuth)
 	if err != nil {
-		signatureLog.Error(err, "failed new image read in notary")
+		signatureLog.Error(err, "failed new image")
 		return nil, err
 	}
+	return FetchImageSignature(pool, img, notaryServer, releaseRoles...)
+}
 
-	defer func() {
-		if err := not.ClearDir(); err != nil {
-			errMsg := fmt.Sprintf("deleting notary temp dir error by %s", err)
-			signatureLog.Error(err, errMsg)
-		}
-	}()
+// FetchImageSignature fetches a signature of the parsed image from the notary server, as FetchPooledSignature does.
+// The image's basic auth is used for the notary server
+func FetchImageSignature(pool *trust.RepoPool, img *image.Image, notaryServer string, releaseRoles ...string) (*Signature, error) {
+	return FetchProfiledSignature(pool, img, notaryServer, trust.ClientProfile{}, releaseRoles...)
+}
 
-	signedRepo, err := not.GetSignedMetadata(img.Tag)
+// FetchProfiledSignature fetches a signature of the parsed image as FetchImageSignature does,
+// requesting the notary server by the timeout and the retries of the profile
+func FetchProfiledSignature(pool *trust.RepoPool, img *image.Image, notaryServer string, profile trust.ClientProfile, releaseRoles ...string) (*Signature, error) {
+	signedRepo, err := pool.GetProfiledSignedMetadata(img, notaryServer, profile, img.Tag, releaseRoles...)
 	if err != nil {
 		// If the image is not signed
-		// TODO - registry's GetSignedMetadata error handle - not using error string!
-		if strings.Contains(err.Error(), "does not have trust data for") {
+		if trust.IsNoTrustData(err) {
 			return nil, nil
 		}
 		signatureLog.Error(err, "failed Get Signed Metadata")
 		return nil, err
 	}
 
-	// Convert trust.trustRepo to Signature
-	sig := Signature{Name: signedRepo.Name}
-	for _, t := range signedRepo.SignedTags {
-		sig.SignedTags = append(sig.SignedTags, SignedTag{
+	return NewSignature(img, signedRepo), nil
+}
+
+// NewSignature builds the signature of the image from the trust data read by the notary client, as docker trust inspect parses it.
+// For the image without a tag, only the tags of its digest are kept
+func NewSignature(img *image.Image, signedRepo *trust.SignedRepo) *Signature {
+	return &Signature{
+		Name:               signedRepo.Name,
+		SignedTags:         newSignedTags(img, signedRepo.SignedTags),
+		UnreleasedTags:     newSignedTags(img, signedRepo.UnreleasedTags),
+		AdministrativeKeys: newAdminRoles(signedRepo.AdministrativeKeys),
+	}
+}
+
+func newSignedTags(img *image.Image, rows []trust.SignedTagRow) []SignedTag {
+	var tags []SignedTag
+	for _, t := range rows {
+		if isOtherDigest(img, t.Algorithm, t.Digest) {
+			continue
+		}
+		tags = append(tags, SignedTag{
 			SignedTag: t.SignedTag,
 			Digest:    t.Digest,
+			Algorithm: t.Algorithm,
 			Signers:   t.Signers,
+			SignedAt:  t.SignedAt,
+			Expires:   t.Expires,
 		})
 	}
-	return &sig, nil
+	return tags
+}
+
+// newAdminRoles returns the keys of the administrative roles, Root first and Repository next as docker trust inspect does
+func newAdminRoles(adminKeys map[string][]string) []AdminRole {
+	var roles []AdminRole
+	for _, name := range []string{trust.RootAdminRole, trust.RepositoryAdminRole} {
+		keyIDs, exist := adminKeys[name]
+		if !exist {
+			continue
+		}
+		role := AdminRole{Name: name, Keys: []AdminKey{}}
+		for _, id := range keyIDs {
+			role.Keys = append(role.Keys, AdminKey{ID: id})
+		}
+		roles = append(roles, role)
+	}
+	return roles
+}
+
+// isOtherDigest checks if the signed digest is not of the image without a tag.
+// All the signed tags are fetched for the image without a tag. Keep only the ones of its digest,
+// not to hold (and cache) thousands of tags of the repository
+func isOtherDigest(img *image.Image, algorithm, digest string) bool {
+	return img.Tag == "" && img.Digest != "" && algorithm+":"+digest != img.Digest
 }
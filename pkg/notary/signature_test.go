@@ -1,13 +1,20 @@
 package notary
 
 import (
+	"encoding/json"
 	"fmt"
+	"net/http"
+	"net/http/httptest"
 	"os"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/sirupsen/logrus"
 	"github.com/stretchr/testify/require"
+	"github.com/tmax-cloud/image-validating-webhook/pkg/image"
 	notarytest "github.com/tmax-cloud/image-validating-webhook/pkg/notary/test"
+	"github.com/tmax-cloud/image-validating-webhook/pkg/trust"
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/log/zap"
 )
@@ -54,6 +61,12 @@ func TestFetchSignature(t *testing.T) {
 			imgTag:               testImageTag,
 			expectedSignatureNil: true,
 		},
+		"unsignedTag": {
+			imgHost:              testRegistryHost,
+			imgRepo:              testImageSigned,
+			imgTag:               "not-signed-tag",
+			expectedSignatureNil: true,
+		},
 	}
 
 	for name, c := range tc {
@@ -74,3 +87,162 @@ func TestFetchSignature(t *testing.T) {
 		})
 	}
 }
+
+func TestFetchSignature_digest(t *testing.T) {
+	testSrv, err := notarytest.New(false)
+	require.NoError(t, err)
+
+	digests := []string{"11111111111111111111111111111111", "22222222222222222222222222222222"}
+	_, err = testSrv.SignImageTags(testSrv.URL, testRegistryHost, testImageSigned, map[string]string{"v0": digests[0], "v1": digests[1]})
+	require.NoError(t, err)
+
+	// Only the tags of the digest are kept for the image without a tag
+	sig, err := FetchSignature(fmt.Sprintf("%s/%s@sha256:%x", testRegistryHost, testImageSigned, digests[1]), "", testSrv.URL)
+	require.NoError(t, err)
+	require.NotNil(t, sig)
+	require.Len(t, sig.SignedTags, 1)
+	require.Equal(t, "v1", sig.SignedTags[0].SignedTag)
+}
+
+func TestFetchSignature_serverFailure(t *testing.T) {
+	testSrv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer testSrv.Close()
+
+	// Real failures are not regarded as the image is not signed
+	sig, err := FetchSignature(fmt.Sprintf("%s/%s:%s", testRegistryHost, testImageSigned, testImageTag), "", testSrv.URL)
+	require.Error(t, err)
+	require.Nil(t, sig)
+}
+
+func TestSignature_GetDigest(t *testing.T) {
+	sig := &Signature{SignedTags: []SignedTag{
+		{SignedTag: "sha256", Digest: "1111", Algorithm: "sha256"},
+		{SignedTag: "sha512", Digest: "2222", Algorithm: "sha512"},
+		{SignedTag: "unknown", Digest: "3333"},
+	}}
+
+	require.Equal(t, "sha256:1111", sig.GetDigest("sha256"))
+	require.Equal(t, "sha512:2222", sig.GetDigest("sha512"))
+	require.Equal(t, "sha256:3333", sig.GetDigest("unknown"), "sha256 is assumed")
+	require.Equal(t, "", sig.GetDigest("not-signed"))
+	require.Equal(t, "", sig.GetDigest(""), "no digest shared by the tags")
+
+	digestOnly := &Signature{SignedTags: []SignedTag{
+		{SignedTag: "v1", Digest: "1111", Algorithm: "sha256"},
+		{SignedTag: "latest", Digest: "1111"},
+	}}
+	require.Equal(t, "sha256:1111", digestOnly.GetDigest(""))
+}
+
+func TestSignature_CountSigners(t *testing.T) {
+	sig := &Signature{SignedTags: []SignedTag{
+		{SignedTag: "v1", Digest: "1111", Signers: []string{"signer-a", "signer-b", "signer-x"}},
+		{SignedTag: "v2", Digest: "2222", Signers: []string{"signer-c"}},
+	}}
+
+	require.Equal(t, 2, sig.CountSigners("v1", []string{"signer-a", "signer-b", "signer-c"}))
+	require.Equal(t, 1, sig.CountSigners("v1", []string{"signer-a", "signer-a"}), "distinct signers")
+	require.Equal(t, 1, sig.CountSigners("v2", []string{"signer-a", "signer-b", "signer-c"}))
+	require.Equal(t, 3, sig.CountSigners("", []string{"signer-a", "signer-b", "signer-c"}), "all tags")
+	require.Equal(t, 0, sig.CountSigners("not-signed", []string{"signer-a"}))
+}
+
+func TestSignature_GetSignedAtByExpiry(t *testing.T) {
+	expires := time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC)
+	sig := &Signature{SignedTags: []SignedTag{
+		{SignedTag: "v1", Digest: "1111", Expires: expires},
+		{SignedTag: "v2", Digest: "2222", Expires: expires.Add(24 * time.Hour)},
+		{SignedTag: "v3", Digest: "3333"},
+	}}
+
+	require.Equal(t, expires.Add(-30*24*time.Hour), sig.GetSignedAtByExpiry("v1", 30*24*time.Hour))
+	require.Equal(t, expires.Add(-29*24*time.Hour), sig.GetSignedAtByExpiry("", 30*24*time.Hour), "latest of all tags")
+	require.True(t, sig.GetSignedAtByExpiry("v3", 30*24*time.Hour).IsZero(), "unknown")
+}
+
+func TestSignature_WithSignerNames(t *testing.T) {
+	sig := &Signature{
+		SignedTags: []SignedTag{
+			{SignedTag: "v1", Digest: "1111", Signers: []string{"ci-bot", "release-bot", "alice"}},
+			{SignedTag: "v2", Digest: "2222", Signers: []string{"Repo Admin"}},
+		},
+		UnreleasedTags: []SignedTag{{SignedTag: "v3", Digest: "3333", Signers: []string{"ci-bot"}}},
+	}
+
+	renamed := sig.WithSignerNames(map[string]string{"targets/ci-bot": "ci@example.com", "release-bot": "ci@example.com", "Repo Admin": "admin"})
+	require.Equal(t, []string{"ci@example.com", "alice"}, renamed.GetSigners("v1"), "the roles renamed to the same signer are counted once")
+	require.Equal(t, []string{"Repo Admin"}, renamed.GetSigners("v2"), "the released signer is not renamed")
+	require.Equal(t, []string{"ci@example.com"}, renamed.GetUnreleasedSigners("v3"))
+	require.True(t, renamed.MatchSigner([]string{"ci@example.com"}))
+	require.Equal(t, []string{"ci-bot", "release-bot", "alice"}, sig.GetSigners("v1"), "the signature is not changed")
+
+	require.Same(t, sig, sig.WithSignerNames(nil))
+	require.Nil(t, (*Signature)(nil).WithSignerNames(map[string]string{"ci-bot": "ci@example.com"}))
+}
+
+func TestSignature_MatchAdminKeys(t *testing.T) {
+	sig := &Signature{AdministrativeKeys: []AdminRole{
+		{Name: trust.RootAdminRole, Keys: []AdminKey{{ID: "root-1"}}},
+		{Name: trust.RepositoryAdminRole, Keys: []AdminKey{{ID: "targets-1"}, {ID: "targets-2"}}},
+	}}
+
+	require.True(t, sig.MatchAdminKeys([]string{"root-1"}))
+	require.True(t, sig.MatchAdminKeys([]string{"other", "targets-2"}))
+	require.False(t, sig.MatchAdminKeys([]string{"other"}))
+	require.False(t, (&Signature{}).MatchAdminKeys([]string{"root-1"}), "no administrative keys")
+}
+
+func TestNewSignature(t *testing.T) {
+	// docker trust inspect output of the repository
+	const inspected = `[
+    {
+        "Name": "test.registry/signed",
+        "SignedTags": [
+            {"SignedTag": "v1", "Digest": "1111111111111111111111111111111111111111111111111111111111111111", "Signers": ["ci-bot", "alice"]},
+            {"SignedTag": "v2", "Digest": "2222222222222222222222222222222222222222222222222222222222222222", "Signers": ["Repo Admin"]}
+        ],
+        "Signers": [
+            {"Name": "alice", "Keys": [{"ID": "alice-key"}]},
+            {"Name": "ci-bot", "Keys": [{"ID": "ci-bot-key"}]}
+        ],
+        "AdministrativeKeys": [
+            {"Name": "Root", "Keys": [{"ID": "root-key"}]},
+            {"Name": "Repository", "Keys": [{"ID": "targets-key-1"}, {"ID": "targets-key-2"}]}
+        ]
+    }
+]`
+	var parsed []Signature
+	require.NoError(t, json.Unmarshal([]byte(inspected), &parsed))
+	require.Len(t, parsed, 1)
+	cli := &parsed[0]
+
+	img, err := image.NewImage("test.registry/signed:v1", "")
+	require.NoError(t, err)
+	native := NewSignature(img, &trust.SignedRepo{
+		Name: "test.registry/signed",
+		SignedTags: []trust.SignedTagRow{
+			{SignedTagKey: trust.SignedTagKey{SignedTag: "v1", Digest: strings.Repeat("1", 64), Algorithm: "sha256"}, Signers: []string{"ci-bot", "alice"}},
+			{SignedTagKey: trust.SignedTagKey{SignedTag: "v2", Digest: strings.Repeat("2", 64), Algorithm: "sha256"}, Signers: []string{"Repo Admin"}},
+		},
+		AdministrativeKeys: map[string][]string{
+			trust.RepositoryAdminRole: {"targets-key-1", "targets-key-2"},
+			trust.RootAdminRole:       {"root-key"},
+		},
+	})
+
+	require.Equal(t, cli.Name, native.Name)
+	require.Equal(t, cli.AdministrativeKeys, native.AdministrativeKeys)
+	for _, tag := range []string{"v1", "v2", "not-signed", ""} {
+		require.Equal(t, cli.GetDigest(tag), native.GetDigest(tag), tag)
+		require.Equal(t, cli.GetSigners(tag), native.GetSigners(tag), tag)
+		require.Equal(t, cli.CountSigners(tag, []string{"ci-bot", "alice"}), native.CountSigners(tag, []string{"ci-bot", "alice"}), tag)
+	}
+	for _, signers := range [][]string{{"alice"}, {"bob"}, nil} {
+		require.Equal(t, cli.MatchSigner(signers), native.MatchSigner(signers))
+	}
+	for _, keyIDs := range [][]string{{"root-key"}, {"targets-key-2"}, {"other"}} {
+		require.Equal(t, cli.MatchAdminKeys(keyIDs), native.MatchAdminKeys(keyIDs))
+	}
+}
@@ -216,6 +216,11 @@ func (rt *testRoundTrip) RoundTrip(r *http.Request) (*http.Response, error) {
 
 // SignImage signs an image and publish it to the notary mock server
 func (s *Server) SignImage(srvURL, imgHost, imgRepo, imgTag, digest string) (string, error) {
+	return s.SignImageTags(srvURL, imgHost, imgRepo, map[string]string{imgTag: digest})
+}
+
+// SignImageTags signs the tags(tag-digest map) of an image at once and publish them to the notary mock server
+func (s *Server) SignImageTags(srvURL, imgHost, imgRepo string, tagDigests map[string]string) (string, error) {
 	// Init notary client and sign images
 	tempDir := fmt.Sprintf("%s/notary/test-sign-image", os.TempDir())
 	rt := &testRoundTrip{}
@@ -242,13 +247,15 @@ func (s *Server) SignImage(srvURL, imgHost, imgRepo, imgTag, digest string) (str
 		}
 	}
 
-	target := &client.Target{
-		Name:   imgTag,
-		Hashes: notarydata.Hashes{"sha256": []byte(digest)},
-		Length: 32,
-	}
-	if err := repo.AddTarget(target, notarydata.CanonicalTargetsRole); err != nil {
-		return "", err
+	for imgTag, digest := range tagDigests {
+		target := &client.Target{
+			Name:   imgTag,
+			Hashes: notarydata.Hashes{"sha256": []byte(digest)},
+			Length: 32,
+		}
+		if err := repo.AddTarget(target, notarydata.CanonicalTargetsRole); err != nil {
+			return "", err
+		}
 	}
 
 	if err := repo.Publish(); err != nil {
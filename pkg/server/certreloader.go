@@ -0,0 +1,89 @@
+package server
+
+import (
+	"crypto/tls"
+	"os"
+	"sync"
+	"time"
+
+	logf "sigs.k8s.io/controller-runtime/pkg/log"
+)
+
+var certLog = logf.Log.WithName("certreloader.go")
+
+// certReloader serves the certificate of the files, reloading it when the files are changed (e.g., rotated by cert-manager),
+// so that the rotated certificate is served without restarting the server
+type certReloader struct {
+	certFile string
+	keyFile  string
+
+	lock    sync.RWMutex
+	cert    *tls.Certificate
+	certMod fileVersion
+	keyMod  fileVersion
+}
+
+// fileVersion tells if a file is changed. A mounted secret is updated by swapping a symlink, which changes both
+type fileVersion struct {
+	modTime time.Time
+	size    int64
+}
+
+func statFile(path string) (fileVersion, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return fileVersion{}, err
+	}
+	return fileVersion{modTime: info.ModTime(), size: info.Size()}, nil
+}
+
+// newCertReloader loads the certificate of the files. It fails if they are not a valid key pair
+func newCertReloader(certFile, keyFile string) (*certReloader, error) {
+	r := &certReloader{certFile: certFile, keyFile: keyFile}
+	if _, err := r.reload(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// reload loads the certificate if the files are changed. It returns true if it's reloaded
+func (r *certReloader) reload() (bool, error) {
+	certMod, err := statFile(r.certFile)
+	if err != nil {
+		return false, err
+	}
+	keyMod, err := statFile(r.keyFile)
+	if err != nil {
+		return false, err
+	}
+
+	r.lock.RLock()
+	changed := r.cert == nil || certMod != r.certMod || keyMod != r.keyMod
+	r.lock.RUnlock()
+	if !changed {
+		return false, nil
+	}
+
+	cert, err := tls.LoadX509KeyPair(r.certFile, r.keyFile)
+	if err != nil {
+		return false, err
+	}
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.cert, r.certMod, r.keyMod = &cert, certMod, keyMod
+	return true, nil
+}
+
+// GetCertificate returns the certificate, reloading it if the files are changed.
+// If the changed files can't be loaded (e.g., the key is not written yet), the last certificate is served
+func (r *certReloader) GetCertificate(_ *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	if reloaded, err := r.reload(); err != nil {
+		certLog.Error(err, "couldn't reload the certificate, serving the last one", "certFile", r.certFile, "keyFile", r.keyFile)
+	} else if reloaded {
+		certLog.Info("reloaded the certificate", "certFile", r.certFile)
+	}
+
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+	return r.cert, nil
+}
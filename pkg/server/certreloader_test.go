@@ -0,0 +1,63 @@
+package server
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestCertReloader(t *testing.T) {
+	dir := t.TempDir()
+	certFile, keyFile := filepath.Join(dir, "tls.crt"), filepath.Join(dir, "tls.key")
+	writeCert := func(modTime time.Time) *x509.Certificate {
+		cert, key := testCertificate(t, nil, nil)
+		keyDer, err := x509.MarshalECPrivateKey(key)
+		require.NoError(t, err)
+		require.NoError(t, ioutil.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Raw}), 0600))
+		require.NoError(t, ioutil.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer}), 0600))
+		require.NoError(t, os.Chtimes(certFile, modTime, modTime))
+		require.NoError(t, os.Chtimes(keyFile, modTime, modTime))
+		return cert
+	}
+
+	first := writeCert(time.Now().Add(-time.Minute))
+	reloader, err := newCertReloader(certFile, keyFile)
+	require.NoError(t, err)
+
+	testSrv := httptest.NewUnstartedServer(&testHandler{})
+	testSrv.TLS = &tls.Config{GetCertificate: reloader.GetCertificate}
+	testSrv.StartTLS()
+	defer testSrv.Close()
+	// Without the certificate of the test server, as the webhook server has none
+	testSrv.TLS.Certificates = nil
+
+	servedCert := func() *x509.Certificate {
+		cli := &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{InsecureSkipVerify: true}, DisableKeepAlives: true}}
+		resp, err := cli.Get(testSrv.URL)
+		require.NoError(t, err)
+		_ = resp.Body.Close()
+		return resp.TLS.PeerCertificates[0]
+	}
+	require.Equal(t, first.SerialNumber, servedCert().SerialNumber)
+
+	// Rotated
+	second := writeCert(time.Now())
+	require.Equal(t, second.SerialNumber, servedCert().SerialNumber)
+
+	// The last certificate is served, if the rotated files are not a valid key pair
+	require.NoError(t, ioutil.WriteFile(keyFile, []byte("not a key"), 0600))
+	require.Equal(t, second.SerialNumber, servedCert().SerialNumber)
+
+	// No certificate
+	_, err = newCertReloader(filepath.Join(dir, "not-exist.crt"), keyFile)
+	require.Error(t, err)
+}
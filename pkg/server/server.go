@@ -1,9 +1,18 @@
 package server
 
 import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"net"
 	"net/http"
+	"sync"
 
 	"github.com/gorilla/mux"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/rest"
 )
@@ -13,6 +22,34 @@ type HandlerConfig struct {
 	RestCfg    *rest.Config
 	ClientSet  kubernetes.Interface
 	RestClient rest.Interface
+	// StopCh is closed when the server is stopped
+	StopCh <-chan struct{}
+
+	sharedLock sync.Mutex
+	// shared are the objects shared by the handlers initiated with the config, by their keys
+	shared map[interface{}]sharedObject
+}
+
+type sharedObject struct {
+	obj interface{}
+	err error
+}
+
+// Shared returns the object of the key shared by the handlers initiated with the config, building it by build only once.
+// The error of build is returned for the key as well, not to build it again. Other configs share none of them
+func (c *HandlerConfig) Shared(key interface{}, build func() (interface{}, error)) (interface{}, error) {
+	c.sharedLock.Lock()
+	defer c.sharedLock.Unlock()
+
+	if s, exist := c.shared[key]; exist {
+		return s.obj, s.err
+	}
+	if c.shared == nil {
+		c.shared = map[interface{}]sharedObject{}
+	}
+	obj, err := build()
+	c.shared[key] = sharedObject{obj: obj, err: err}
+	return obj, err
 }
 
 // HandlerInitFunc is a function for initializing the Handler
@@ -36,9 +73,22 @@ func AddHandlerInitiator(path string, methods []string, handlerInit HandlerInitF
 	})
 }
 
+// GRPCServiceInitFunc is a function for registering a gRPC service to the gRPC server
+type GRPCServiceInitFunc func(cfg *HandlerConfig, srv *grpc.Server) error
+
+// grpcServiceInitiators is a list of GRPCServiceInitFunc, which will be called when the Server starts with a gRPC address
+var grpcServiceInitiators []GRPCServiceInitFunc
+
+// AddGRPCServiceInitiator appends an initiator func of a gRPC service to the list
+func AddGRPCServiceInitiator(serviceInit GRPCServiceInitFunc) {
+	grpcServiceInitiators = append(grpcServiceInitiators, serviceInit)
+}
+
 // Server is a multi-purpose http server
 type Server struct {
 	server *http.Server
+	// grpcAddr is the address the gRPC services are served on. They're not served if it's empty
+	grpcAddr string
 
 	certFile string
 	keyFile  string
@@ -48,6 +98,10 @@ type Server struct {
 	cfg        *rest.Config
 	clientSet  kubernetes.Interface
 	restClient rest.Interface
+	// handlerCfg is the config passed to the handlers and the gRPC services, so that they share it
+	handlerCfg *HandlerConfig
+
+	stopCh <-chan struct{}
 }
 
 // New initiates a new Server instance
@@ -66,19 +120,118 @@ func New(certFile, keyFile, addr string, cfg *rest.Config, clientSet kubernetes.
 	return srv
 }
 
-// Start adds all the handlers to the server and starts the server
-func (s *Server) Start() {
+// SetClientCAs makes the server verify the client certificates by the CA files (e.g., the CA of the API server's client certificate).
+// If requireClientCert is true, the clients without a verified certificate are rejected, so that only the API server can call the webhook
+func (s *Server) SetClientCAs(caFiles []string, requireClientCert bool) error {
+	tlsConfig, err := newClientAuthTLSConfig(caFiles, requireClientCert)
+	if err != nil {
+		return err
+	}
+	s.server.TLSConfig = tlsConfig
+	return nil
+}
+
+// SetGRPCListen makes the server serve the gRPC services on the address, with the same certificate as the http server.
+// The gRPC services authenticate their callers by themselves, as they're not called by the API server
+func (s *Server) SetGRPCListen(addr string) {
+	s.grpcAddr = addr
+}
+
+func newClientAuthTLSConfig(caFiles []string, requireClientCert bool) (*tls.Config, error) {
+	pool := x509.NewCertPool()
+	for _, f := range caFiles {
+		pem, err := ioutil.ReadFile(f)
+		if err != nil {
+			return nil, err
+		}
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificate is found in %s", f)
+		}
+	}
+
+	clientAuth := tls.VerifyClientCertIfGiven
+	if requireClientCert {
+		clientAuth = tls.RequireAndVerifyClientCert
+	}
+	return &tls.Config{ClientCAs: pool, ClientAuth: clientAuth}, nil
+}
+
+// Start adds all the handlers to the server and starts the server, until stopCh is closed
+func (s *Server) Start(stopCh <-chan struct{}) {
+	s.stopCh = stopCh
 	if err := s.addHandlersToServer(); err != nil {
 		panic(err)
 	}
-	if err := s.server.ListenAndServeTLS(s.certFile, s.keyFile); err != nil {
+
+	go func() {
+		<-stopCh
+		_ = s.server.Shutdown(context.Background())
+	}()
+
+	// Serve the certificate reloaded when it's rotated, without restarting
+	reloader, err := newCertReloader(s.certFile, s.keyFile)
+	if err != nil {
 		panic(err)
 	}
+	if s.server.TLSConfig == nil {
+		s.server.TLSConfig = &tls.Config{}
+	}
+	s.server.TLSConfig.GetCertificate = reloader.GetCertificate
+
+	if s.grpcAddr != "" {
+		if err := s.startGRPC(reloader.GetCertificate, stopCh); err != nil {
+			panic(err)
+		}
+	}
+
+	if err := s.server.ListenAndServeTLS("", ""); err != nil && err != http.ErrServerClosed {
+		panic(err)
+	}
+}
+
+// startGRPC registers the gRPC services to a new gRPC server and starts it, until stopCh is closed
+func (s *Server) startGRPC(getCertificate func(*tls.ClientHelloInfo) (*tls.Certificate, error), stopCh <-chan struct{}) error {
+	grpcServer := grpc.NewServer(grpc.Creds(credentials.NewTLS(&tls.Config{GetCertificate: getCertificate})))
+	if err := s.addGRPCServicesToServer(grpcServer); err != nil {
+		return err
+	}
+
+	lis, err := net.Listen("tcp", s.grpcAddr)
+	if err != nil {
+		return err
+	}
+	go func() {
+		<-stopCh
+		grpcServer.GracefulStop()
+	}()
+	go func() {
+		if err := grpcServer.Serve(lis); err != nil {
+			panic(err)
+		}
+	}()
+	return nil
+}
+
+func (s *Server) addGRPCServicesToServer(grpcServer *grpc.Server) error {
+	for _, initFunc := range grpcServiceInitiators {
+		if err := initFunc(s.getHandlerConfig(), grpcServer); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// getHandlerConfig returns the config shared by the handlers and the gRPC services
+func (s *Server) getHandlerConfig() *HandlerConfig {
+	if s.handlerCfg == nil {
+		s.handlerCfg = &HandlerConfig{RestCfg: s.cfg, ClientSet: s.clientSet, RestClient: s.restClient, StopCh: s.stopCh}
+	}
+	return s.handlerCfg
 }
 
 func (s *Server) addHandlersToServer() error {
 	// Add handlers to the mux
-	cfg := &HandlerConfig{RestCfg: s.cfg, ClientSet: s.clientSet, RestClient: s.restClient}
+	cfg := s.getHandlerConfig()
 	for _, i := range handlerInitiators {
 		h, err := i.initFunc(cfg)
 		if err != nil {
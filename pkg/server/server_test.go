@@ -1,12 +1,24 @@
 package server
 
 import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
 	"github.com/gorilla/mux"
 	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
 	"io/ioutil"
+	"math/big"
 	"net/http"
 	"net/http/httptest"
+	"path/filepath"
 	"testing"
+	"time"
 )
 
 type testHandler struct{}
@@ -98,3 +110,150 @@ func TestServer(t *testing.T) {
 		})
 	}
 }
+
+func TestServer_addGRPCServicesToServer(t *testing.T) {
+	var handlerCfg, serviceCfg *HandlerConfig
+	AddHandlerInitiator("/test-grpc", []string{http.MethodGet}, func(cfg *HandlerConfig) (http.Handler, error) {
+		handlerCfg = cfg
+		return &testHandler{}, nil
+	})
+	AddGRPCServiceInitiator(func(cfg *HandlerConfig, _ *grpc.Server) error {
+		serviceCfg = cfg
+		return nil
+	})
+
+	s := Server{mux: mux.NewRouter(), server: &http.Server{}}
+	require.NoError(t, s.addHandlersToServer())
+	require.NoError(t, s.addGRPCServicesToServer(grpc.NewServer()))
+	require.NotNil(t, serviceCfg)
+	require.Same(t, handlerCfg, serviceCfg, "the handlers and the gRPC services share the config")
+}
+
+func TestHandlerConfig_Shared(t *testing.T) {
+	type testKey struct{}
+	built := 0
+	build := func() (interface{}, error) {
+		built++
+		return new(int), nil
+	}
+
+	cfg := &HandlerConfig{}
+	obj, err := cfg.Shared(testKey{}, build)
+	require.NoError(t, err)
+	shared, err := cfg.Shared(testKey{}, build)
+	require.NoError(t, err)
+	require.Same(t, obj, shared, "the handlers of a config share the object")
+	require.Equal(t, 1, built)
+
+	other, err := (&HandlerConfig{}).Shared(testKey{}, build)
+	require.NoError(t, err)
+	require.NotSame(t, obj, other, "other configs don't share it")
+	require.Equal(t, 2, built)
+
+	// The error is shared as well
+	failing := &HandlerConfig{}
+	_, err = failing.Shared(testKey{}, func() (interface{}, error) { return nil, errors.New("test error") })
+	require.Error(t, err)
+	_, err = failing.Shared(testKey{}, build)
+	require.Error(t, err)
+	require.Equal(t, 2, built)
+}
+
+func TestServer_SetClientCAs(t *testing.T) {
+	dir := t.TempDir()
+
+	caCert, caKey := testCertificate(t, nil, nil)
+	caFile := filepath.Join(dir, "ca.crt")
+	require.NoError(t, ioutil.WriteFile(caFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: caCert.Raw}), 0600))
+
+	otherCACert, otherCAKey := testCertificate(t, nil, nil)
+
+	tc := map[string]struct {
+		clientCA          *x509.Certificate
+		clientCAKey       *ecdsa.PrivateKey
+		requireClientCert bool
+
+		expectedErrOccur bool
+	}{
+		"verified": {
+			clientCA:          caCert,
+			clientCAKey:       caKey,
+			requireClientCert: true,
+		},
+		"noCertRequired": {
+			requireClientCert: true,
+			expectedErrOccur:  true,
+		},
+		"noCertNotRequired": {
+			requireClientCert: false,
+		},
+		"otherCA": {
+			clientCA:          otherCACert,
+			clientCAKey:       otherCAKey,
+			requireClientCert: true,
+			expectedErrOccur:  true,
+		},
+		"otherCANotRequired": {
+			clientCA:         otherCACert,
+			clientCAKey:      otherCAKey,
+			expectedErrOccur: true,
+		},
+	}
+
+	for name, c := range tc {
+		t.Run(name, func(t *testing.T) {
+			s := &Server{server: &http.Server{}}
+			require.NoError(t, s.SetClientCAs([]string{caFile}, c.requireClientCert))
+
+			testSrv := httptest.NewUnstartedServer(&testHandler{})
+			testSrv.TLS = s.server.TLSConfig
+			testSrv.StartTLS()
+			defer testSrv.Close()
+
+			cli := testSrv.Client()
+			if c.clientCA != nil {
+				cert, key := testCertificate(t, c.clientCA, c.clientCAKey)
+				cli.Transport.(*http.Transport).TLSClientConfig.Certificates = []tls.Certificate{{Certificate: [][]byte{cert.Raw}, PrivateKey: key}}
+			}
+
+			resp, err := cli.Get(testSrv.URL)
+			if c.expectedErrOccur {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			_ = resp.Body.Close()
+			require.Equal(t, http.StatusOK, resp.StatusCode)
+		})
+	}
+
+	s := &Server{server: &http.Server{}}
+	require.Error(t, s.SetClientCAs([]string{filepath.Join(dir, "not-exist.crt")}, true), "no ca file")
+}
+
+// testCertificate generates a client certificate signed by the parent, or a CA certificate if there's no parent
+func testCertificate(t *testing.T, parent *x509.Certificate, parentKey *ecdsa.PrivateKey) (*x509.Certificate, *ecdsa.PrivateKey) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: "test-client"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	if parent == nil {
+		template.Subject.CommonName = "test-ca"
+		template.IsCA = true
+		template.BasicConstraintsValid = true
+		template.KeyUsage = x509.KeyUsageCertSign
+		parent, parentKey = template, key
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, parent, &key.PublicKey, parentKey)
+	require.NoError(t, err)
+	cert, err := x509.ParseCertificate(der)
+	require.NoError(t, err)
+	return cert, key
+}
@@ -0,0 +1,131 @@
+package trust
+
+import (
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"k8s.io/apimachinery/pkg/util/wait"
+)
+
+// cacheInUseGrace is how long a cache directory not owned by the pool is regarded as in use since it's last modified.
+// The one-shot repositories of the in-flight requests are cleared by themselves
+const cacheInUseGrace = time.Minute
+
+// CacheCleaner bounds the total size of the TUF caches under the path, evicting the least recently used ones.
+// Each cache directory is of a notary repository, i.e., an image repository (GUN)
+type CacheCleaner struct {
+	path    string
+	maxSize int64
+	// pool owns some of the cache directories. It may be nil
+	pool *RepoPool
+}
+
+// NewCacheCleaner returns a cleaner bounding the TUF caches under the path to maxSize bytes.
+// The caches of the pool's repositories are evicted from the pool, unless they're in use
+func NewCacheCleaner(path string, maxSize int64, pool *RepoPool) *CacheCleaner {
+	return &CacheCleaner{path: path, maxSize: maxSize, pool: pool}
+}
+
+// Start cleans the caches every interval, until stopCh is closed
+func (c *CacheCleaner) Start(interval time.Duration, stopCh <-chan struct{}) {
+	wait.Until(func() {
+		if _, err := c.Clean(); err != nil {
+			trustLog.Error(err, "failed to clean the notary cache directory", "path", c.path)
+		}
+	}, interval, stopCh)
+}
+
+// cacheDir is a cache directory with its total size and the last time it's used
+type cacheDir struct {
+	path     string
+	size     int64
+	lastUsed time.Time
+}
+
+// Clean evicts the least recently used caches until their total size is within the limit, returning the number of the evicted ones.
+// The caches in use are not evicted, so the total size may still exceed the limit
+func (c *CacheCleaner) Clean() (int, error) {
+	dirs, total, err := c.listCacheDirs()
+	if err != nil {
+		return 0, err
+	}
+	if total <= c.maxSize {
+		return 0, nil
+	}
+
+	sort.Slice(dirs, func(i, j int) bool {
+		return dirs[i].lastUsed.Before(dirs[j].lastUsed)
+	})
+
+	evicted := 0
+	for _, d := range dirs {
+		if total <= c.maxSize {
+			break
+		}
+		if !c.evict(d) {
+			continue
+		}
+		total -= d.size
+		evicted++
+	}
+	trustLog.Info("evicted notary caches", "path", c.path, "evicted", evicted, "size", total, "maxSize", c.maxSize)
+	return evicted, nil
+}
+
+// evict removes the cache directory, through the pool if it's owned by the pool. It returns false if it's in use
+func (c *CacheCleaner) evict(d cacheDir) bool {
+	if c.pool.evictDir(d.path) {
+		return true
+	}
+	if time.Since(d.lastUsed) < cacheInUseGrace {
+		return false
+	}
+	if err := os.RemoveAll(d.path); err != nil {
+		trustLog.Error(err, "failed to evict the notary cache", "path", d.path)
+		return false
+	}
+	return true
+}
+
+// listCacheDirs lists the cache directories under the path, with their total size
+func (c *CacheCleaner) listCacheDirs() ([]cacheDir, int64, error) {
+	entries, err := os.ReadDir(c.path)
+	if os.IsNotExist(err) {
+		return nil, 0, nil
+	}
+	if err != nil {
+		return nil, 0, err
+	}
+
+	var dirs []cacheDir
+	var total int64
+	for _, e := range entries {
+		if !e.IsDir() {
+			continue
+		}
+		d := cacheDir{path: filepath.Join(c.path, e.Name())}
+		// Files may be removed while walking, by the requests clearing their caches
+		_ = filepath.WalkDir(d.path, func(_ string, entry fs.DirEntry, err error) error {
+			if err != nil {
+				return nil
+			}
+			info, err := entry.Info()
+			if err != nil {
+				return nil
+			}
+			if !entry.IsDir() {
+				d.size += info.Size()
+			}
+			if info.ModTime().After(d.lastUsed) {
+				d.lastUsed = info.ModTime()
+			}
+			return nil
+		})
+		dirs = append(dirs, d)
+		total += d.size
+	}
+	return dirs, total, nil
+}
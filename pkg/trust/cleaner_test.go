@@ -0,0 +1,92 @@
+package trust
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"github.com/tmax-cloud/image-validating-webhook/pkg/image"
+	notarytest "github.com/tmax-cloud/image-validating-webhook/pkg/notary/test"
+)
+
+// testCacheDir creates a cache directory of the gun with a metadata file of the size, used at the time
+func testCacheDir(t *testing.T, path, gun string, size int, usedAt time.Time) string {
+	dir, err := newCacheDir(path)
+	require.NoError(t, err)
+	metadataDir := filepath.Join(dir, "tuf", gun, "metadata")
+	require.NoError(t, os.MkdirAll(metadataDir, 0700))
+	require.NoError(t, os.WriteFile(filepath.Join(metadataDir, "targets.json"), make([]byte, size), 0600))
+	require.NoError(t, filepath.Walk(dir, func(p string, _ os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		return os.Chtimes(p, usedAt, usedAt)
+	}))
+	return dir
+}
+
+func TestCacheCleaner_Clean(t *testing.T) {
+	path := t.TempDir()
+
+	// 20 GUN caches of 1KiB, used an hour ago in order
+	usedAt := time.Now().Add(-time.Hour)
+	var dirs []string
+	for i := 0; i < 20; i++ {
+		dirs = append(dirs, testCacheDir(t, path, fmt.Sprintf("test.io/repo-%02d", i), 1024, usedAt.Add(time.Duration(i)*time.Second)))
+	}
+	// Caches used just now may be of the in-flight requests
+	recent := testCacheDir(t, path, "test.io/recent", 1024, time.Now())
+
+	cleaner := NewCacheCleaner(path, 10*1024, nil)
+	evicted, err := cleaner.Clean()
+	require.NoError(t, err)
+	require.Equal(t, 11, evicted)
+
+	for i, dir := range dirs {
+		_, err := os.Stat(dir)
+		if i < 11 {
+			require.True(t, os.IsNotExist(err), "least recently used %s is evicted", dir)
+		} else {
+			require.NoError(t, err, "recently used %s is kept", dir)
+		}
+	}
+	_, err = os.Stat(recent)
+	require.NoError(t, err)
+
+	// Within the limit
+	evicted, err = cleaner.Clean()
+	require.NoError(t, err)
+	require.Equal(t, 0, evicted)
+}
+
+func TestCacheCleaner_Clean_pool(t *testing.T) {
+	testSrv, err := notarytest.New(false)
+	require.NoError(t, err)
+	_, err = testSrv.SignImage(testSrv.URL, "test.io", "cleaned-repo", "signed-tag", "11111111111111111111111111111111")
+	require.NoError(t, err)
+
+	path := t.TempDir()
+	pool := NewRepoPool(path, time.Hour)
+	img, err := image.NewImage("test.io/cleaned-repo:signed-tag", "")
+	require.NoError(t, err)
+	_, err = pool.GetSignedMetadata(img, testSrv.URL, img.Tag)
+	require.NoError(t, err)
+	require.Len(t, pool.repos, 1)
+
+	// The pooled repository's cache is evicted from the pool, even if it's used just now
+	evicted, err := NewCacheCleaner(path, 1, pool).Clean()
+	require.NoError(t, err)
+	require.Equal(t, 1, evicted)
+	require.Len(t, pool.repos, 0)
+	entries, err := os.ReadDir(path)
+	require.NoError(t, err)
+	require.Len(t, entries, 0)
+
+	// The pool creates a new repository
+	_, err = pool.GetSignedMetadata(img, testSrv.URL, img.Tag)
+	require.NoError(t, err)
+	pool.Clear()
+}
@@ -0,0 +1,39 @@
+package trust
+
+import (
+	"context"
+	"net"
+	"strings"
+)
+
+// hostAlias returns the IP the host is resolved to by the aliases, which are keyed by the hosts case-insensitively
+func hostAlias(aliases map[string]string, host string) (string, bool) {
+	if ip, exist := aliases[host]; exist {
+		return ip, true
+	}
+	for h, ip := range aliases {
+		if strings.EqualFold(h, host) {
+			return ip, true
+		}
+	}
+	return "", false
+}
+
+// dialWithHostAliases returns the dial func dialing the IP of the host alias instead of the host, keeping the port, as /etc/hosts does.
+// The TLS server name is still the host, as it's set by the transport from the url. The hosts without aliases are resolved by DNS
+func dialWithHostAliases(dial dialFunc, aliases map[string]string) dialFunc {
+	if len(aliases) == 0 {
+		return dial
+	}
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		host, port, err := net.SplitHostPort(addr)
+		if err != nil {
+			return dial(ctx, network, addr)
+		}
+		if ip, exist := hostAlias(aliases, host); exist {
+			trustLog.Info("dialing the host alias", "host", host, "ip", ip)
+			addr = net.JoinHostPort(ip, port)
+		}
+		return dial(ctx, network, addr)
+	}
+}
@@ -0,0 +1,219 @@
+package trust
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/tmax-cloud/image-validating-webhook/pkg/image"
+)
+
+// DefaultCachePath is the path of the TUF caches of the notary repositories
+var DefaultCachePath = filepath.Join(os.TempDir(), "notary")
+
+// RepoPool keeps the notary repositories, reusing them across the requests of the same image repository (GUN),
+// notary server and credential. It saves fetching the token and initializing the TUF cache for each request,
+// while the trust data is still updated from the notary server by each request.
+// A repository is recreated after the TTL, as its token may expire
+type RepoPool struct {
+	path string
+	ttl  time.Duration
+
+	lock  sync.Mutex
+	repos map[repoPoolKey]*pooledRepo
+}
+
+type repoPoolKey struct {
+	gun       string
+	notaryURL string
+	// authHash is the hash of the basic auth, not to share a repository between the different credentials
+	authHash string
+	// profile is the client profile the repository requests by, formatted to be comparable (fmt prints the maps in the order of their keys)
+	profile string
+}
+
+func newRepoPoolKey(img *image.Image, notaryURL string, profile ClientProfile) repoPoolKey {
+	return repoPoolKey{gun: img.GetGUN(), notaryURL: notaryURL, authHash: fmt.Sprintf("%x", sha256.Sum256([]byte(img.BasicAuth))), profile: fmt.Sprintf("%+v", profile)}
+}
+
+// pooledRepo is a repository of the pool. A notary repository is not safe for concurrent use, so it's used by one request at a time
+type pooledRepo struct {
+	lock    sync.Mutex
+	repo    ReadOnly
+	expires time.Time
+	// dir is the cache directory of the repository
+	dir string
+	// removed is true if it's removed from the pool, so that it's not used anymore
+	removed bool
+}
+
+// NewRepoPool returns a new repository pool, whose repositories keep their TUF caches under the path
+func NewRepoPool(path string, ttl time.Duration) *RepoPool {
+	return &RepoPool{path: path, ttl: ttl, repos: map[repoPoolKey]*pooledRepo{}}
+}
+
+// GetSignedMetadata returns the trust repository of the image, using the pooled notary repository.
+// If the pool is nil, a new notary repository is created under DefaultCachePath and cleared after it's used
+func (p *RepoPool) GetSignedMetadata(img *image.Image, notaryURL, tag string, releaseRoles ...string) (*SignedRepo, error) {
+	return p.GetProfiledSignedMetadata(img, notaryURL, ClientProfile{}, tag, releaseRoles...)
+}
+
+// GetProfiledSignedMetadata returns the trust repository of the image as GetSignedMetadata does, requesting the notary server by the profile.
+// The repositories of the different profiles are pooled separately
+func (p *RepoPool) GetProfiledSignedMetadata(img *image.Image, notaryURL string, profile ClientProfile, tag string, releaseRoles ...string) (*SignedRepo, error) {
+	if p == nil {
+		return getSignedMetadataOnce(img, notaryURL, profile, tag, releaseRoles)
+	}
+
+	key := newRepoPoolKey(img, notaryURL, profile)
+
+	for {
+		entry := p.entry(key)
+		entry.lock.Lock()
+		if entry.removed {
+			// Swept while waiting for the lock
+			entry.lock.Unlock()
+			continue
+		}
+		signedRepo, err := entry.getSignedMetadata(img, notaryURL, profile, p.path, p.ttl, tag, releaseRoles)
+		entry.lock.Unlock()
+		return signedRepo, err
+	}
+}
+
+// getSignedMetadataOnce gets the trust repository with a new notary repository.
+// Concurrent requests create their own cache directories under DefaultCachePath, which are cleared after they're used
+func getSignedMetadataOnce(img *image.Image, notaryURL string, profile ClientProfile, tag string, releaseRoles []string) (*SignedRepo, error) {
+	repo, err := NewProfiledReadOnly(img, notaryURL, DefaultCachePath, profile)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err := repo.ClearDir(); err != nil {
+			trustLog.Error(err, "failed to clear the notary cache directory")
+		}
+	}()
+	return repo.GetSignedMetadata(tag, releaseRoles...)
+}
+
+// entry returns the pool's entry of the key, sweeping the expired ones which are not in use
+func (p *RepoPool) entry(key repoPoolKey) *pooledRepo {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	if entry, exist := p.repos[key]; exist {
+		return entry
+	}
+
+	now := time.Now()
+	for k, e := range p.repos {
+		if !e.lock.TryLock() {
+			continue
+		}
+		if now.After(e.expires) {
+			e.clear()
+			e.removed = true
+			delete(p.repos, k)
+		}
+		e.lock.Unlock()
+	}
+
+	entry := &pooledRepo{}
+	p.repos[key] = entry
+	return entry
+}
+
+// Len returns the number of the pooled repositories, including the expired ones not removed yet
+func (p *RepoPool) Len() int {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	return len(p.repos)
+}
+
+// Clear removes all the repositories which are not in use, with their TUF caches
+func (p *RepoPool) Clear() {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	for k, e := range p.repos {
+		if !e.lock.TryLock() {
+			continue
+		}
+		e.clear()
+		e.removed = true
+		delete(p.repos, k)
+		e.lock.Unlock()
+	}
+}
+
+// getSignedMetadata gets the trust repository, (re)creating the notary repository if it's not created yet or expired.
+// It should be called with the lock held
+func (e *pooledRepo) getSignedMetadata(img *image.Image, notaryURL string, profile ClientProfile, path string, ttl time.Duration, tag string, releaseRoles []string) (*SignedRepo, error) {
+	if e.repo == nil || time.Now().After(e.expires) {
+		e.clear()
+		repo, err := NewProfiledReadOnly(img, notaryURL, path, profile)
+		if err != nil {
+			return nil, err
+		}
+		e.repo = repo
+		e.expires = time.Now().Add(ttl)
+		if n, isNotaryRepo := repo.(*notaryRepo); isNotaryRepo {
+			e.dir = n.notaryPath
+		}
+	}
+	// Mark it used for CacheCleaner, as the notary client doesn't rewrite the TUF cache which is not changed
+	if e.dir != "" {
+		now := time.Now()
+		_ = os.Chtimes(e.dir, now, now)
+	}
+
+	signedRepo, err := e.repo.GetSignedMetadata(tag, releaseRoles...)
+	// Recreate the repository for the next request if it fails, e.g., by an expired token.
+	// Images which are not signed are not failures of the repository
+	if err != nil && !IsNoTrustData(err) {
+		e.clear()
+	}
+	return signedRepo, err
+}
+
+func (e *pooledRepo) clear() {
+	if e.repo == nil {
+		return
+	}
+	if err := e.repo.ClearDir(); err != nil {
+		trustLog.Error(err, "failed to clear the pooled notary repository")
+	}
+	e.repo = nil
+	e.dir = ""
+}
+
+// evictDir removes the repository whose cache directory is the dir, with the cache.
+// It returns false if the dir is not of the pool's repositories which are not in use
+func (p *RepoPool) evictDir(dir string) bool {
+	if p == nil {
+		return false
+	}
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	for k, e := range p.repos {
+		// The repositories in use are used within cacheInUseGrace since they're marked used, so they're not evicted as the others
+		if !e.lock.TryLock() {
+			continue
+		}
+		if e.dir != dir {
+			e.lock.Unlock()
+			continue
+		}
+		e.clear()
+		e.removed = true
+		delete(p.repos, k)
+		e.lock.Unlock()
+		return true
+	}
+	return false
+}
@@ -0,0 +1,158 @@
+package trust
+
+import (
+	"fmt"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"github.com/tmax-cloud/image-validating-webhook/internal/utils"
+	"github.com/tmax-cloud/image-validating-webhook/pkg/image"
+	notarytest "github.com/tmax-cloud/image-validating-webhook/pkg/notary/test"
+)
+
+func TestRepoPool_GetSignedMetadata(t *testing.T) {
+	testSrv, err := notarytest.New(false)
+	require.NoError(t, err)
+	_, err = testSrv.SignImage(testSrv.URL, "test.io", "pooled-repo", "signed-tag", "11111111111111111111111111111111")
+	require.NoError(t, err)
+
+	path := fmt.Sprintf("%s/notary/%s", os.TempDir(), utils.RandomString(10))
+	defer func() {
+		_ = os.RemoveAll(path)
+	}()
+	pool := NewRepoPool(path, time.Minute)
+
+	img, err := image.NewImage("test.io/pooled-repo:signed-tag", "")
+	require.NoError(t, err)
+
+	// The repository is reused
+	repo, err := pool.GetSignedMetadata(img, testSrv.URL, img.Tag)
+	require.NoError(t, err)
+	require.Equal(t, "test.io/pooled-repo", repo.Name)
+	require.Len(t, pool.repos, 1)
+	pooled := pool.repos[newRepoPoolKey(img, testSrv.URL, ClientProfile{})].repo
+	require.NotNil(t, pooled)
+
+	_, err = pool.GetSignedMetadata(img, testSrv.URL, img.Tag)
+	require.NoError(t, err)
+	require.Len(t, pool.repos, 1)
+	for _, e := range pool.repos {
+		require.Same(t, pooled, e.repo, "reused")
+	}
+
+	// Unsigned tags do not discard the repository
+	_, err = pool.GetSignedMetadata(img, testSrv.URL, "unsigned-tag")
+	require.True(t, IsNoTrustData(err))
+	for _, e := range pool.repos {
+		require.Same(t, pooled, e.repo, "reused")
+	}
+
+	// Another credential does not share the repository
+	authImg, err := image.NewImage("test.io/pooled-repo:signed-tag", "dGVzdDp0ZXN0")
+	require.NoError(t, err)
+	_, err = pool.GetSignedMetadata(authImg, testSrv.URL, img.Tag)
+	require.NoError(t, err)
+	require.Len(t, pool.repos, 2)
+
+	// Another client profile does not share the repository, which requests by the profile
+	profile := ClientProfile{Timeout: time.Minute, Retries: 2}
+	_, err = pool.GetProfiledSignedMetadata(img, testSrv.URL, profile, img.Tag)
+	require.NoError(t, err)
+	require.Len(t, pool.repos, 3)
+	profiled, isNotaryRepo := pool.repos[newRepoPoolKey(img, testSrv.URL, profile)].repo.(*notaryRepo)
+	require.True(t, isNotaryRepo)
+	require.Equal(t, time.Minute, profiled.transport.ResponseHeaderTimeout)
+	require.IsType(t, &retryTransport{}, profiled.roundTripper)
+
+	// Expired repositories are recreated
+	for _, e := range pool.repos {
+		e.expires = time.Now().Add(-time.Second)
+	}
+	_, err = pool.GetSignedMetadata(img, testSrv.URL, img.Tag)
+	require.NoError(t, err)
+	require.NotSame(t, pooled, pool.repos[newRepoPoolKey(img, testSrv.URL, ClientProfile{})].repo, "recreated")
+
+	pool.Clear()
+	require.Len(t, pool.repos, 0)
+	entries, err := os.ReadDir(path)
+	require.NoError(t, err)
+	require.Len(t, entries, 0, "cache directories are cleared")
+}
+
+func TestRepoPool_concurrent(t *testing.T) {
+	testSrv, err := notarytest.New(false)
+	require.NoError(t, err)
+	_, err = testSrv.SignImage(testSrv.URL, "test.io", "concurrent-repo", "signed-tag", "11111111111111111111111111111111")
+	require.NoError(t, err)
+
+	path := fmt.Sprintf("%s/notary/%s", os.TempDir(), utils.RandomString(10))
+	defer func() {
+		_ = os.RemoveAll(path)
+	}()
+	// Every repository expires immediately, to be swept and recreated by the concurrent requests
+	pool := NewRepoPool(path, time.Nanosecond)
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 20)
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			img, err := image.NewImage("test.io/concurrent-repo:signed-tag", fmt.Sprintf("%d", i%2))
+			if err != nil {
+				errs <- err
+				return
+			}
+			for j := 0; j < 5; j++ {
+				if _, err := pool.GetSignedMetadata(img, testSrv.URL, img.Tag); err != nil {
+					errs <- err
+				}
+			}
+		}(i)
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		require.NoError(t, err)
+	}
+
+	pool.Clear()
+	entries, err := os.ReadDir(path)
+	require.NoError(t, err)
+	require.Len(t, entries, 0, "cache directories are cleared")
+}
+
+func BenchmarkRepoPool(b *testing.B) {
+	testSrv, err := notarytest.New(false)
+	require.NoError(b, err)
+	_, err = testSrv.SignImage(testSrv.URL, "test.io", "bench-repo", "signed-tag", "11111111111111111111111111111111")
+	require.NoError(b, err)
+
+	path := fmt.Sprintf("%s/notary/%s", os.TempDir(), utils.RandomString(10))
+	defer func() {
+		_ = os.RemoveAll(path)
+	}()
+	img, err := image.NewImage("test.io/bench-repo:signed-tag", "")
+	require.NoError(b, err)
+
+	b.Run("new", func(b *testing.B) {
+		var pool *RepoPool
+		for i := 0; i < b.N; i++ {
+			if _, err := pool.GetSignedMetadata(img, testSrv.URL, img.Tag); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+	b.Run("pooled", func(b *testing.B) {
+		pool := NewRepoPool(path, time.Minute)
+		defer pool.Clear()
+		for i := 0; i < b.N; i++ {
+			if _, err := pool.GetSignedMetadata(img, testSrv.URL, img.Tag); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
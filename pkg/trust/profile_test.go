@@ -0,0 +1,353 @@
+package trust
+
+import (
+	"context"
+	"encoding/base64"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestClientProfile_roundTripper(t *testing.T) {
+	tc := map[string]struct {
+		profile  ClientProfile
+		failures int64
+		delay    time.Duration
+		body     string
+		deadline time.Duration
+
+		expectedErr      bool
+		expectedStatus   int
+		expectedRequests int64
+	}{
+		"default": {
+			failures:         1,
+			expectedStatus:   http.StatusServiceUnavailable,
+			expectedRequests: 1,
+		},
+		"retried": {
+			profile:          ClientProfile{Retries: 2, Backoff: time.Millisecond},
+			failures:         2,
+			expectedStatus:   http.StatusOK,
+			expectedRequests: 3,
+		},
+		"retriesExhausted": {
+			profile:          ClientProfile{Retries: 1, Backoff: time.Millisecond},
+			failures:         2,
+			expectedStatus:   http.StatusServiceUnavailable,
+			expectedRequests: 2,
+		},
+		"beyondDeadline": {
+			profile:          ClientProfile{Retries: 3, Backoff: time.Second},
+			failures:         4,
+			deadline:         500 * time.Millisecond,
+			expectedStatus:   http.StatusServiceUnavailable,
+			expectedRequests: 1,
+		},
+		"withBody": {
+			profile:          ClientProfile{Retries: 2, Backoff: time.Millisecond},
+			failures:         1,
+			body:             "body",
+			expectedStatus:   http.StatusServiceUnavailable,
+			expectedRequests: 1,
+		},
+		"timeout": {
+			profile:          ClientProfile{Timeout: 10 * time.Millisecond},
+			delay:            time.Second,
+			expectedErr:      true,
+			expectedRequests: 1,
+		},
+		"timeoutRetried": {
+			profile:          ClientProfile{Timeout: 10 * time.Millisecond, Retries: 1, Backoff: time.Millisecond},
+			delay:            time.Second,
+			expectedErr:      true,
+			expectedRequests: 2,
+		},
+	}
+
+	for name, c := range tc {
+		t.Run(name, func(t *testing.T) {
+			var requests int64
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+				n := atomic.AddInt64(&requests, 1)
+				if c.delay > 0 {
+					select {
+					case <-time.After(c.delay):
+					case <-req.Context().Done():
+					}
+					return
+				}
+				if n <= c.failures {
+					w.WriteHeader(http.StatusServiceUnavailable)
+				}
+			}))
+			defer srv.Close()
+
+			var body io.Reader = http.NoBody
+			method := http.MethodGet
+			if c.body != "" {
+				body, method = strings.NewReader(c.body), http.MethodPost
+			}
+			ctx := context.Background()
+			if c.deadline > 0 {
+				var cancel context.CancelFunc
+				ctx, cancel = context.WithTimeout(ctx, c.deadline)
+				defer cancel()
+			}
+			req, err := http.NewRequestWithContext(ctx, method, srv.URL, body)
+			require.NoError(t, err)
+
+			resp, err := c.profile.roundTripper(newTransport(nil)).RoundTrip(req)
+			if c.expectedErr {
+				require.Error(t, err)
+			} else {
+				require.NoError(t, err)
+				_ = resp.Body.Close()
+				require.Equal(t, c.expectedStatus, resp.StatusCode)
+			}
+			require.Equal(t, c.expectedRequests, atomic.LoadInt64(&requests))
+		})
+	}
+}
+
+func TestClientProfile_retriesCapped(t *testing.T) {
+	rt := ClientProfile{Retries: 100, Backoff: time.Hour}.roundTripper(newTransport(nil))
+	require.IsType(t, &retryTransport{}, rt)
+	require.Equal(t, maxRetries, rt.(*retryTransport).retries)
+	require.Equal(t, maxRetryBackoff, rt.(*retryTransport).backoff)
+}
+
+func TestClientProfile_readTimeout(t *testing.T) {
+	tc := map[string]struct {
+		profile ClientProfile
+		stall   time.Duration
+
+		expectedErr bool
+	}{
+		"stalledMidBody": {
+			profile:     ClientProfile{Timeout: time.Second, ReadTimeout: 50 * time.Millisecond},
+			stall:       5 * time.Second,
+			expectedErr: true,
+		},
+		"readInTime": {
+			profile: ClientProfile{ReadTimeout: time.Second},
+			stall:   10 * time.Millisecond,
+		},
+		"noReadTimeout": {
+			profile: ClientProfile{Timeout: 10 * time.Millisecond},
+			stall:   100 * time.Millisecond,
+		},
+	}
+
+	for name, c := range tc {
+		t.Run(name, func(t *testing.T) {
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+				// The headers and the first half of the body are sent in time, but the other half is stalled
+				_, _ = w.Write([]byte(`{"signed":`))
+				w.(http.Flusher).Flush()
+				select {
+				case <-time.After(c.stall):
+				case <-req.Context().Done():
+					return
+				}
+				_, _ = w.Write([]byte(`{}}`))
+			}))
+			defer srv.Close()
+
+			req, err := http.NewRequest(http.MethodGet, srv.URL, nil)
+			require.NoError(t, err)
+			resp, err := c.profile.roundTripper(newTransport(nil)).RoundTrip(req)
+			require.NoError(t, err)
+			defer func() {
+				_ = resp.Body.Close()
+			}()
+
+			start := time.Now()
+			body, err := io.ReadAll(resp.Body)
+			if c.expectedErr {
+				require.Error(t, err)
+				require.Less(t, time.Since(start), c.stall, "the read is cut off by the deadline")
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, `{"signed":{}}`, string(body))
+		})
+	}
+}
+
+func TestClientProfile_maxResponseSize(t *testing.T) {
+	metadata := `{"signed":{"targets":{"v1":{"length":1}}},"padding":"` + strings.Repeat("a", 2048) + `"}`
+
+	tc := map[string]struct {
+		profile ClientProfile
+		chunked bool
+
+		expectedErr     bool
+		expectedReadErr bool
+	}{
+		"oversized": {
+			profile:     ClientProfile{MaxResponseSize: 1024},
+			expectedErr: true,
+		},
+		"oversizedChunked": {
+			profile:         ClientProfile{MaxResponseSize: 1024},
+			chunked:         true,
+			expectedReadErr: true,
+		},
+		"withinLimit": {
+			profile: ClientProfile{MaxResponseSize: int64(len(metadata))},
+			chunked: true,
+		},
+		"noLimit": {},
+	}
+
+	for name, c := range tc {
+		t.Run(name, func(t *testing.T) {
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+				if c.chunked {
+					// The length is unknown in advance
+					_, _ = w.Write([]byte(metadata[:10]))
+					w.(http.Flusher).Flush()
+					_, _ = w.Write([]byte(metadata[10:]))
+					return
+				}
+				w.Header().Set("Content-Length", strconv.Itoa(len(metadata)))
+				_, _ = w.Write([]byte(metadata))
+			}))
+			defer srv.Close()
+
+			req, err := http.NewRequest(http.MethodGet, srv.URL, nil)
+			require.NoError(t, err)
+			resp, err := c.profile.roundTripper(newTransport(nil)).RoundTrip(req)
+			if c.expectedErr {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			defer func() {
+				_ = resp.Body.Close()
+			}()
+
+			body, err := io.ReadAll(resp.Body)
+			if c.expectedReadErr {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, metadata, string(body))
+		})
+	}
+}
+
+func TestClientProfile_proxyAuthorization(t *testing.T) {
+	authorization := "Basic " + base64.StdEncoding.EncodeToString([]byte("user:pass"))
+
+	tc := map[string]struct {
+		proxyUser *url.Userinfo
+		profile   ClientProfile
+
+		expectedAuthorized bool
+	}{
+		"urlCredentials": {
+			proxyUser:          url.UserPassword("user", "pass"),
+			expectedAuthorized: true,
+		},
+		"profileAuthorization": {
+			profile:            ClientProfile{ProxyAuthorization: authorization},
+			expectedAuthorized: true,
+		},
+		"profileOverridesURLCredentials": {
+			proxyUser:          url.UserPassword("user", "wrong"),
+			profile:            ClientProfile{ProxyAuthorization: authorization, Retries: 1, Backoff: time.Millisecond},
+			expectedAuthorized: true,
+		},
+		"noCredentials": {},
+	}
+
+	proxy := newTestProxy(authorization)
+	defer proxy.Close()
+	targets := map[string]*httptest.Server{
+		"https": httptest.NewTLSServer(http.HandlerFunc(func(http.ResponseWriter, *http.Request) {})),
+		"http":  httptest.NewServer(http.HandlerFunc(func(http.ResponseWriter, *http.Request) {})),
+	}
+	for _, target := range targets {
+		defer target.Close()
+	}
+
+	for name, c := range tc {
+		for scheme, target := range targets {
+			t.Run(name+"/"+scheme, func(t *testing.T) {
+				proxyURL, err := url.Parse(proxy.URL)
+				require.NoError(t, err)
+				proxyURL.User = c.proxyUser
+				transport := newTransport(nil)
+				transport.Proxy = http.ProxyURL(proxyURL)
+
+				req, err := http.NewRequest(http.MethodGet, target.URL, nil)
+				require.NoError(t, err)
+				resp, err := c.profile.roundTripper(transport).RoundTrip(req)
+				if !c.expectedAuthorized && scheme == "https" {
+					// The tunnel is not established
+					require.Error(t, err)
+					return
+				}
+				require.NoError(t, err)
+				_ = resp.Body.Close()
+				if c.expectedAuthorized {
+					require.Equal(t, http.StatusOK, resp.StatusCode)
+				} else {
+					require.Equal(t, http.StatusProxyAuthRequired, resp.StatusCode)
+				}
+			})
+		}
+	}
+}
+
+// newTestProxy returns the forward proxy requiring the authorization, which tunnels the CONNECT requests and forwards the others
+func newTestProxy(authorization string) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.Header.Get("Proxy-Authorization") != authorization {
+			w.Header().Set("Proxy-Authenticate", `Basic realm="proxy"`)
+			w.WriteHeader(http.StatusProxyAuthRequired)
+			return
+		}
+
+		if req.Method != http.MethodConnect {
+			resp, err := (&http.Transport{}).RoundTrip(&http.Request{Method: req.Method, URL: req.URL, Header: http.Header{}})
+			if err != nil {
+				w.WriteHeader(http.StatusBadGateway)
+				return
+			}
+			_ = resp.Body.Close()
+			w.WriteHeader(resp.StatusCode)
+			return
+		}
+
+		upstream, err := net.Dial("tcp", req.Host)
+		if err != nil {
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		conn, _, err := w.(http.Hijacker).Hijack()
+		if err != nil {
+			_ = upstream.Close()
+			return
+		}
+		_, _ = conn.Write([]byte("HTTP/1.1 200 Connection established\r\n\r\n"))
+		go func() {
+			_, _ = io.Copy(upstream, conn)
+			_ = upstream.Close()
+		}()
+		_, _ = io.Copy(conn, upstream)
+		_ = conn.Close()
+	}))
+}
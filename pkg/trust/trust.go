@@ -1,15 +1,18 @@
 package trust
 
 import (
+	"context"
 	"crypto/tls"
 	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"net"
 	"net/http"
 	"net/url"
 	"os"
 	"path"
+	"path/filepath"
 	"sort"
 	"strings"
 	"time"
@@ -34,27 +37,37 @@ var (
 	ReleasesRole = data.RoleName(path.Join(data.CanonicalTargetsRole.String(), "releases"))
 )
 
-// trustTagKey represents a unique signed tag and hex-encoded hash pair
-type trustTagKey struct {
+// SignedTagKey represents a unique signed tag and hex-encoded hash pair
+type SignedTagKey struct {
 	SignedTag string
 	Digest    string
+	// Algorithm is the algorithm of the Digest (e.g., sha256, sha512)
+	Algorithm string
 }
 
-// trustTagRow encodes all human-consumable information for a signed tag, including signers
-type trustTagRow struct {
-	trustTagKey
+// SignedTagRow encodes all human-consumable information for a signed tag, including signers
+type SignedTagRow struct {
+	SignedTagKey
 	Signers []string
+	// SignedAt is when the tag was last signed into the released roles, estimated by DefaultRoleExpiry. It's zero if it's unknown
+	SignedAt time.Time
+	// Expires is when the released roles which last signed the tag expire, by which the signing time is estimated. It's zero if it's unknown
+	Expires time.Time
 }
 
-// trustRepo represents consumable information about a trusted repository
-type trustRepo struct {
+// SignedRepo represents consumable information about a trusted repository
+type SignedRepo struct {
 	Name       string
-	SignedTags []trustTagRow
+	SignedTags []SignedTagRow
+	// UnreleasedTags are the tags signed only into the delegation roles, which are not released
+	UnreleasedTags []SignedTagRow
+	// AdministrativeKeys are the key IDs of the administrative roles, keyed by RootAdminRole and RepositoryAdminRole
+	AdministrativeKeys map[string][]string
 }
 
 // ReadOnly can get sign data
 type ReadOnly interface {
-	GetSignedMetadata(string) (*trustRepo, error)
+	GetSignedMetadata(tag string, releaseRoles ...string) (*SignedRepo, error)
 	ClearDir() error
 }
 
@@ -73,54 +86,104 @@ type notaryRepo struct {
 	token           *auth.Token
 	image           *image.Image
 	passPhrase      trustPass
+	transport       *http.Transport
+	// roundTripper is the transport with the client profile applied, by which the requests are sent
+	roundTripper http.RoundTripper
 }
 
 const (
 	// DefaultNotaryServer is url of docker hub's notary server
 	DefaultNotaryServer = "https://notary.docker.io"
 	releasedRoleName    = "Repo Admin"
+
+	// DefaultRoleExpiry is the expiry the notary client signs the targets and the delegation roles with by default(3 years)
+	DefaultRoleExpiry = notary.NotaryTargetsExpiry
+
+	// UnixSocketScheme is the scheme of the notary server url served over a unix domain socket, e.g., unix:///var/run/notary.sock
+	UnixSocketScheme = "unix://"
+
+	// RootAdminRole is the name of the root role's keys in the administrative keys
+	RootAdminRole = "Root"
+	// RepositoryAdminRole is the name of the targets role's keys in the administrative keys
+	RepositoryAdminRole = "Repository"
 )
 
-// NewReadOnly returns new readonly object to get sign data
+type dialFunc func(ctx context.Context, network, addr string) (net.Conn, error)
+
+// notaryEndpoint returns the http url of the notary server and the dial func to reach it.
+// A unix://<socket path> url is requested by plain http over the socket, and the others are dialed by their host aliases if there are
+func notaryEndpoint(notaryURL string, hostAliases map[string]string) (string, dialFunc) {
+	dialer := &net.Dialer{
+		Timeout:   30 * time.Second,
+		KeepAlive: 30 * time.Second,
+	}
+	if !strings.HasPrefix(notaryURL, UnixSocketScheme) {
+		return notaryURL, dialWithHostAliases(dialer.DialContext, hostAliases)
+	}
+
+	socket := strings.TrimPrefix(notaryURL, UnixSocketScheme)
+	return "http://localhost", func(ctx context.Context, _, _ string) (net.Conn, error) {
+		return dialer.DialContext(ctx, "unix", socket)
+	}
+}
+
+// newTransport returns http.DefaultTransport, dialing with the dial func and skipping tls verification
+func newTransport(dial dialFunc) *http.Transport {
+	return &http.Transport{
+		Proxy:                 http.ProxyFromEnvironment,
+		DialContext:           dial,
+		ForceAttemptHTTP2:     true,
+		MaxIdleConns:          100,
+		IdleConnTimeout:       90 * time.Second,
+		TLSHandshakeTimeout:   10 * time.Second,
+		ExpectContinueTimeout: 1 * time.Second,
+		TLSClientConfig:       &tls.Config{InsecureSkipVerify: true},
+	}
+}
+
+// NewReadOnly returns new readonly object to get sign data.
+// The TUF cache is kept in a unique directory under the path, which is removed by ClearDir.
+// So the path can be shared by the concurrent requests or the replicas (e.g., a PVC), without corrupting each other's cache
 func NewReadOnly(image *image.Image, notaryURL, path string) (ReadOnly, error) {
+	return NewProfiledReadOnly(image, notaryURL, path, ClientProfile{})
+}
+
+// NewProfiledReadOnly returns new readonly object as NewReadOnly does, requesting the notary server by the timeout and the retries of the profile
+func NewProfiledReadOnly(image *image.Image, notaryURL, path string, profile ClientProfile) (ReadOnly, error) {
+	notaryPath, err := newCacheDir(path)
+	if err != nil {
+		return nil, err
+	}
 	n := &notaryRepo{
-		notaryPath: path,
+		notaryPath: notaryPath,
 		image:      image,
 	}
 
 	// Notary Server url
 	if notaryURL == "" {
-		n.notaryServerURL = DefaultNotaryServer
-	} else {
-		n.notaryServerURL = notaryURL
+		notaryURL = DefaultNotaryServer
 	}
+	var dial dialFunc
+	n.notaryServerURL, dial = notaryEndpoint(notaryURL, profile.HostAliases)
+	n.transport = newTransport(dial)
+	n.roundTripper = profile.roundTripper(n.transport)
 
 	token, err := n.getToken()
 	if err != nil {
+		_ = n.ClearDir()
 		return nil, err
 	}
 
 	// Generate Transport
 	rt := &auth.RegistryTransport{
-		Base: &http.Transport{ // Base is DefaultTransport, added TLSClientConfig
-			Proxy: http.ProxyFromEnvironment,
-			DialContext: (&net.Dialer{
-				Timeout:   30 * time.Second,
-				KeepAlive: 30 * time.Second,
-			}).DialContext,
-			ForceAttemptHTTP2:     true,
-			MaxIdleConns:          100,
-			IdleConnTimeout:       90 * time.Second,
-			TLSHandshakeTimeout:   10 * time.Second,
-			ExpectContinueTimeout: 1 * time.Second,
-			TLSClientConfig:       &tls.Config{InsecureSkipVerify: true},
-		},
+		Base:  n.roundTripper,
 		Token: token,
 	}
 
 	// Initialize Notary repository
-	repo, err := client.NewFileCachedRepository(n.notaryPath, data.GUN(image.GetImageNameWithHost()), n.notaryServerURL, rt, n.passRetriever(), trustpinning.TrustPinConfig{})
+	repo, err := client.NewFileCachedRepository(n.notaryPath, data.GUN(image.GetGUN()), n.notaryServerURL, rt, n.passRetriever(), trustpinning.TrustPinConfig{})
 	if err != nil {
+		_ = n.ClearDir()
 		return nil, err
 	}
 	n.repo = repo
@@ -128,6 +191,14 @@ func NewReadOnly(image *image.Image, notaryURL, path string) (ReadOnly, error) {
 	return n, nil
 }
 
+// newCacheDir creates a unique cache directory under the path, named with the process id to tell its owner
+func newCacheDir(path string) (string, error) {
+	if err := os.MkdirAll(path, 0700); err != nil {
+		return "", err
+	}
+	return os.MkdirTemp(path, fmt.Sprintf("%d-", os.Getpid()))
+}
+
 // getToken returns token to get sign from notary server
 func (n *notaryRepo) getToken() (*auth.Token, error) {
 	if n.token == nil || n.token.Type == "" || n.token.Value == "" {
@@ -155,22 +226,55 @@ func (n *notaryRepo) checkPingResponse(pingResp int) bool {
 	return false
 }
 
-func (n *notaryRepo) fetchToken() error {
-	trustLog.Info("Fetching token...")
-	// Ping
-	u, err := url.Parse(n.notaryServerURL)
+// Ping checks if the notary server is reachable.
+// The server is considered reachable if it responds to the ping request with either a success or an auth challenge
+func Ping(notaryURL string, hostAliases map[string]string) error {
+	if notaryURL == "" {
+		notaryURL = DefaultNotaryServer
+	}
+	serverURL, dial := notaryEndpoint(notaryURL, hostAliases)
+	pingReq, err := newPingRequest(serverURL)
 	if err != nil {
 		return err
 	}
+	cli := &http.Client{
+		Timeout:   10 * time.Second,
+		Transport: newTransport(dial),
+	}
+	pingResp, err := cli.Do(pingReq)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = pingResp.Body.Close()
+	}()
+	if regclient.SuccessStatus(pingResp.StatusCode) || pingResp.StatusCode == http.StatusUnauthorized {
+		return nil
+	}
+	return fmt.Errorf("unexpected ping response %d from %s", pingResp.StatusCode, notaryURL)
+}
+
+// newPingRequest returns the ping request of the notary server, which is under the path prefix of the url if there is (e.g., https://example.com/notary/v2)
+func newPingRequest(notaryURL string) (*http.Request, error) {
+	u, err := url.Parse(notaryURL)
+	if err != nil {
+		return nil, err
+	}
 	u.Path = path.Join(u.Path, "v2")
-	pingReq, err := http.NewRequest(http.MethodGet, u.String(), nil)
+	return http.NewRequest(http.MethodGet, u.String(), nil)
+}
+
+func (n *notaryRepo) fetchToken() error {
+	trustLog.Info("Fetching token...")
+	// Ping
+	pingReq, err := newPingRequest(n.notaryServerURL)
 	if err != nil {
 		return err
 	}
 	if n.image.BasicAuth != "" {
 		pingReq.Header.Set("Authorization", fmt.Sprintf("Basic %s", n.image.BasicAuth))
 	}
-	pingResp, err := n.image.HTTPClient.Do(pingReq)
+	pingResp, err := (&http.Client{Transport: n.roundTripper}).Do(pingReq)
 	if err != nil {
 		return err
 	}
@@ -183,62 +287,43 @@ func (n *notaryRepo) fetchToken() error {
 		return nil
 	}
 
-	challenges := challenge.ResponseChallenges(pingResp)
-	if len(challenges) < 1 {
-		return fmt.Errorf("header does not contain WWW-Authenticate")
-	}
-	realm, realmExist := challenges[0].Parameters["realm"]
-	service, serviceExist := challenges[0].Parameters["service"]
-	if !realmExist || !serviceExist {
-		return fmt.Errorf("there is no realm or service in parameters")
+	realm, service, ok := tokenChallenge(challenge.ResponseChallenges(pingResp))
+	if !ok {
+		return fmt.Errorf("there is no challenge with realm and service in WWW-Authenticate")
 	}
 
 	// Get Token
 	return n.setToken(service, realm)
 }
 
-func (n *notaryRepo) setToken(service string, realm string) error {
-	img := n.image.GetImageNameWithHost()
-
-	param := map[string]string{
-		"service": service,
-		"scope":   fmt.Sprintf("repository:%s:pull,push", img),
-	}
-	tokenReq, err := http.NewRequest(http.MethodGet, realm, nil)
-	if err != nil {
-		return err
-	}
-	if n.image.BasicAuth != "" {
-		tokenReq.Header.Set("Authorization", fmt.Sprintf("Basic %s", n.image.BasicAuth))
-	}
-	tokenQ := tokenReq.URL.Query()
-	for k, v := range param {
-		tokenQ.Add(k, v)
+// tokenChallenge returns the realm and the service of the challenge to request a token by.
+// Registries may respond several challenges (e.g., Basic and Bearer), so the bearer one is preferred, then the first one having both
+func tokenChallenge(challenges []challenge.Challenge) (string, string, bool) {
+	var realm, service string
+	found := false
+	for _, c := range challenges {
+		r, realmExist := c.Parameters["realm"]
+		s, serviceExist := c.Parameters["service"]
+		if !realmExist || !serviceExist {
+			continue
+		}
+		if strings.EqualFold(c.Scheme, string(auth.TokenTypeBearer)) {
+			return r, s, true
+		}
+		if !found {
+			realm, service, found = r, s, true
+		}
 	}
-	tokenReq.URL.RawQuery = tokenQ.Encode()
+	return realm, service, found
+}
 
-	tokenResp, err := n.image.HTTPClient.Do(tokenReq)
+func (n *notaryRepo) setToken(service string, realm string) error {
+	scope := fmt.Sprintf("repository:%s:pull,push", n.image.GetGUN())
+	token, err := auth.RequestToken(&n.image.HTTPClient, realm, service, scope, n.image.BasicAuth)
 	if err != nil {
 		return err
 	}
-	defer func() {
-		_ = tokenResp.Body.Close()
-	}()
-	if !regclient.SuccessStatus(tokenResp.StatusCode) {
-		err := regclient.HandleErrorResponse(tokenResp)
-		return err
-	}
-
-	decoder := json.NewDecoder(tokenResp.Body)
-	token := &auth.TokenResponse{}
-	if err := decoder.Decode(token); err != nil {
-		return err
-	}
-
-	n.token = &auth.Token{
-		Type:  "Bearer",
-		Value: token.Token,
-	}
+	n.token = token
 
 	return nil
 }
@@ -261,20 +346,22 @@ func (n *notaryRepo) ClearDir() error {
 	return os.RemoveAll(n.notaryPath)
 }
 
-// GetSignedMetadata returns trust repository
-func (n *notaryRepo) GetSignedMetadata(tag string) (*trustRepo, error) {
+// GetSignedMetadata returns trust repository.
+// The targets signed into the releaseRoles (e.g., targets/prod) are released, as well as the ones signed into targets or targets/releases
+func (n *notaryRepo) GetSignedMetadata(tag string, releaseRoles ...string) (*SignedRepo, error) {
 	allSignedTargets, err := n.repo.GetAllTargetMetadataByName(tag)
 	if err != nil {
 		trustLog.Error(err, "failed to get all target metadata")
-		return &trustRepo{}, err
+		return &SignedRepo{}, err
 	}
 
-	signatureRows := matchReleasedSignatures(allSignedTargets)
+	signatureRows := matchReleasedSignatures(allSignedTargets, releaseRoles)
+	setSignedAt(signatureRows, allSignedTargets, releaseRoles, n.roleExpires)
 
 	// get the administrative roles
-	_, err = n.repo.ListRoles()
+	roles, err := n.repo.ListRoles()
 	if err != nil {
-		return &trustRepo{}, fmt.Errorf("no signers for %s", n.notaryServerURL)
+		return &SignedRepo{}, fmt.Errorf("no signers for %s", n.notaryServerURL)
 	}
 
 	// get delegation roles with the canonical key IDs
@@ -291,35 +378,114 @@ func (n *notaryRepo) GetSignedMetadata(tag string) (*trustRepo, error) {
 		}
 	}
 
-	return &trustRepo{
-		Name:       n.repo.GetGUN().String(),
-		SignedTags: signatureRows,
+	return &SignedRepo{
+		Name:               n.repo.GetGUN().String(),
+		SignedTags:         signatureRows,
+		UnreleasedTags:     matchUnreleasedSignatures(allSignedTargets, releaseRoles),
+		AdministrativeKeys: administrativeKeys(roles),
 	}, nil
 }
 
-func matchReleasedSignatures(allTargets []client.TargetSignedStruct) []trustTagRow {
-	signatureRows := []trustTagRow{}
-	// do a first pass to get filter on tags signed into "targets" or "targets/releases"
-	releasedTargetRows := map[trustTagKey][]string{}
+// administrativeKeys returns the sorted key IDs of the root and the targets roles, as docker trust inspect shows them
+func administrativeKeys(roles []client.RoleWithSignatures) map[string][]string {
+	adminKeys := map[string][]string{}
+	for _, r := range roles {
+		var name string
+		switch r.Name {
+		case data.CanonicalRootRole:
+			name = RootAdminRole
+		case data.CanonicalTargetsRole:
+			name = RepositoryAdminRole
+		default:
+			continue
+		}
+		keyIDs := append([]string{}, r.KeyIDs...)
+		sort.Strings(keyIDs)
+		adminKeys[name] = keyIDs
+	}
+	return adminKeys
+}
+
+// roleExpires returns when the role's metadata expires, by the metadata in the TUF cache.
+// TUF metadata has no signing time, so it's estimated from the expiry, as the role is signed with a fixed expiry.
+// The timestamp and the snapshot roles are not used, as they're re-signed by the notary server periodically regardless of the releases
+func (n *notaryRepo) roleExpires(role data.RoleName) (time.Time, error) {
+	b, err := os.ReadFile(filepath.Join(n.notaryPath, "tuf", filepath.FromSlash(n.repo.GetGUN().String()), "metadata", role.String()+".json"))
+	if err != nil {
+		return time.Time{}, err
+	}
+	meta := &data.SignedMeta{}
+	if err := json.Unmarshal(b, meta); err != nil {
+		return time.Time{}, err
+	}
+	return meta.Signed.Expires, nil
+}
+
+// setSignedAt sets when the rows were last signed, by the latest expiry of the released roles which signed them.
+// The signing time is the expiry minus DefaultRoleExpiry, which is only an estimate for the roles signed with the other expiries
+func setSignedAt(rows []SignedTagRow, allTargets []client.TargetSignedStruct, releaseRoles []string, roleExpires func(data.RoleName) (time.Time, error)) {
+	expires := map[data.RoleName]time.Time{}
+	latest := map[SignedTagKey]time.Time{}
 	for _, tgt := range allTargets {
-		if isReleasedTarget(tgt.Role.Name) {
-			releasedKey := trustTagKey{tgt.Target.Name, hex.EncodeToString(tgt.Target.Hashes[notary.SHA256])}
+		if !isReleasedTarget(tgt.Role.Name, releaseRoles) {
+			continue
+		}
+		t, exist := expires[tgt.Role.Name]
+		if !exist {
+			var err error
+			if t, err = roleExpires(tgt.Role.Name); err != nil {
+				trustLog.Error(err, "failed to get the expiry of the role", "role", tgt.Role.Name)
+			}
+			expires[tgt.Role.Name] = t
+		}
+		if key := newTrustTagKey(tgt.Target); t.After(latest[key]) {
+			latest[key] = t
+		}
+	}
+	for i := range rows {
+		rows[i].Expires = latest[rows[i].SignedTagKey]
+		if !rows[i].Expires.IsZero() {
+			rows[i].SignedAt = rows[i].Expires.Add(-DefaultRoleExpiry)
+		} else {
+			rows[i].SignedAt = time.Time{}
+		}
+	}
+}
+
+// IsNoTrustData returns true if the error means the repository or the tag has no trust data, i.e., it's not signed.
+// Other errors (e.g., the notary server is unreachable) are real failures
+func IsNoTrustData(err error) bool {
+	var repoNotExist client.ErrRepositoryNotExist
+	var noSuchTarget client.ErrNoSuchTarget
+	return errors.As(err, &repoNotExist) || errors.As(err, &noSuchTarget)
+}
+
+func matchReleasedSignatures(allTargets []client.TargetSignedStruct, releaseRoles []string) []SignedTagRow {
+	// do a first pass to get filter on tags signed into "targets", "targets/releases" or the release roles
+	releasedTargetRows := map[SignedTagKey][]string{}
+	for _, tgt := range allTargets {
+		if isReleasedTarget(tgt.Role.Name, releaseRoles) {
+			releasedKey := newTrustTagKey(tgt.Target)
 			releasedTargetRows[releasedKey] = []string{}
 		}
 	}
 
 	// now fill out all signers on released keys
 	for _, tgt := range allTargets {
-		targetKey := trustTagKey{tgt.Target.Name, hex.EncodeToString(tgt.Target.Hashes[notary.SHA256])}
+		targetKey := newTrustTagKey(tgt.Target)
 		// only considered released targets
-		if _, ok := releasedTargetRows[targetKey]; ok && !isReleasedTarget(tgt.Role.Name) {
-			releasedTargetRows[targetKey] = append(releasedTargetRows[targetKey], notaryRoleToSigner(tgt.Role.Name))
+		if signers, ok := releasedTargetRows[targetKey]; ok && !isReleasedTarget(tgt.Role.Name, releaseRoles) {
+			// a role may sign the same target more than once (e.g., in its delegation paths), count it once
+			if signer := notaryRoleToSigner(tgt.Role.Name, releaseRoles); !containsSigner(signers, signer) {
+				releasedTargetRows[targetKey] = append(signers, signer)
+			}
 		}
 	}
 
 	// compile the final output as a sorted slice
+	signatureRows := make([]SignedTagRow, 0, len(releasedTargetRows))
 	for targetKey, signers := range releasedTargetRows {
-		signatureRows = append(signatureRows, trustTagRow{targetKey, signers})
+		signatureRows = append(signatureRows, SignedTagRow{SignedTagKey: targetKey, Signers: signers})
 	}
 	sort.Slice(signatureRows, func(i, j int) bool {
 		return sortorder.NaturalLess(signatureRows[i].SignedTag, signatureRows[j].SignedTag)
@@ -327,16 +493,76 @@ func matchReleasedSignatures(allTargets []client.TargetSignedStruct) []trustTagR
 	return signatureRows
 }
 
+// matchUnreleasedSignatures returns the tags signed only into the delegation roles, with their signers.
+// They are signed, but not released as none of them is signed into targets, targets/releases or the release roles
+func matchUnreleasedSignatures(allTargets []client.TargetSignedStruct, releaseRoles []string) []SignedTagRow {
+	releasedTags := map[string]struct{}{}
+	for _, tgt := range allTargets {
+		if isReleasedTarget(tgt.Role.Name, releaseRoles) {
+			releasedTags[tgt.Target.Name] = struct{}{}
+		}
+	}
+
+	unreleasedTargetRows := map[SignedTagKey][]string{}
+	for _, tgt := range allTargets {
+		if _, released := releasedTags[tgt.Target.Name]; released {
+			continue
+		}
+		targetKey := newTrustTagKey(tgt.Target)
+		signers := unreleasedTargetRows[targetKey]
+		if signer := notaryRoleToSigner(tgt.Role.Name, releaseRoles); !containsSigner(signers, signer) {
+			signers = append(signers, signer)
+		}
+		unreleasedTargetRows[targetKey] = signers
+	}
+
+	signatureRows := make([]SignedTagRow, 0, len(unreleasedTargetRows))
+	for targetKey, signers := range unreleasedTargetRows {
+		signatureRows = append(signatureRows, SignedTagRow{SignedTagKey: targetKey, Signers: signers})
+	}
+	sort.Slice(signatureRows, func(i, j int) bool {
+		return sortorder.NaturalLess(signatureRows[i].SignedTag, signatureRows[j].SignedTag)
+	})
+	return signatureRows
+}
+
+func containsSigner(signers []string, signer string) bool {
+	for _, s := range signers {
+		if s == signer {
+			return true
+		}
+	}
+	return false
+}
+
+// newTrustTagKey returns the key of the target, using its sha256 hash or sha512 hash if there's no sha256 hash
+func newTrustTagKey(target client.Target) SignedTagKey {
+	for _, algorithm := range []string{notary.SHA256, notary.SHA512} {
+		if hash, exist := target.Hashes[algorithm]; exist {
+			return SignedTagKey{SignedTag: target.Name, Digest: hex.EncodeToString(hash), Algorithm: algorithm}
+		}
+	}
+	return SignedTagKey{SignedTag: target.Name}
+}
+
 // isReleasedTarget checks if a role name is "released":
-// either targets/releases or targets TUF roles
-func isReleasedTarget(role data.RoleName) bool {
-	return role == data.CanonicalTargetsRole || role == ReleasesRole
+// either targets/releases, targets TUF roles or one of the release roles
+func isReleasedTarget(role data.RoleName, releaseRoles []string) bool {
+	if role == data.CanonicalTargetsRole || role == ReleasesRole {
+		return true
+	}
+	for _, r := range releaseRoles {
+		if role == data.RoleName(r) {
+			return true
+		}
+	}
+	return false
 }
 
 // notaryRoleToSigner converts TUF role name to a human-understandable signer name
-func notaryRoleToSigner(tufRole data.RoleName) string {
-	//  don't show a signer for "targets" or "targets/releases"
-	if isReleasedTarget(data.RoleName(tufRole.String())) {
+func notaryRoleToSigner(tufRole data.RoleName, releaseRoles []string) string {
+	//  don't show a signer for "targets", "targets/releases" or the release roles
+	if isReleasedTarget(data.RoleName(tufRole.String()), releaseRoles) {
 		return releasedRoleName
 	}
 	return strings.TrimPrefix(tufRole.String(), "targets/")
@@ -2,12 +2,24 @@ package trust
 
 import (
 	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
 	"os"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/sirupsen/logrus"
 	"github.com/stretchr/testify/require"
+	"github.com/theupdateframework/notary"
+	"github.com/theupdateframework/notary/client"
+	"github.com/theupdateframework/notary/storage"
+	"github.com/theupdateframework/notary/tuf/data"
 	"github.com/tmax-cloud/image-validating-webhook/internal/utils"
+	"github.com/tmax-cloud/image-validating-webhook/pkg/auth"
 	"github.com/tmax-cloud/image-validating-webhook/pkg/image"
 	notarytest "github.com/tmax-cloud/image-validating-webhook/pkg/notary/test"
 	ctrl "sigs.k8s.io/controller-runtime"
@@ -80,6 +92,11 @@ func TestNewReadOnly(t *testing.T) {
 				repo, err := n.GetSignedMetadata(c.image.Tag)
 				require.NoError(t, err)
 				require.Equal(t, repo.Name, fmt.Sprintf("%s/%s", c.image.Host, c.image.Name))
+				// Signed just now
+				require.Len(t, repo.SignedTags, 1)
+				require.WithinDuration(t, time.Now(), repo.SignedTags[0].SignedAt, time.Minute)
+				require.NotEmpty(t, repo.AdministrativeKeys[RootAdminRole])
+				require.NotEmpty(t, repo.AdministrativeKeys[RepositoryAdminRole])
 			} else {
 				_, err = n.GetSignedMetadata(c.image.Tag)
 				require.Contains(t, err.Error(), c.expectedErrMsg)
@@ -87,3 +104,499 @@ func TestNewReadOnly(t *testing.T) {
 		})
 	}
 }
+
+func TestNewReadOnly_sharedPath(t *testing.T) {
+	testSrv, err := notarytest.New(false)
+	require.NoError(t, err)
+	_, err = testSrv.SignImage(testSrv.URL, "test.io", "shared-repo", "signed-tag", "111111111111111111111111111111")
+	require.NoError(t, err)
+
+	// Two repos of the same image share the cache path, e.g., a PVC shared by the replicas
+	path := fmt.Sprintf("%s/notary/%s", os.TempDir(), utils.RandomString(10))
+	defer func() {
+		_ = os.RemoveAll(path)
+	}()
+	img, err := image.NewImage("test.io/shared-repo:signed-tag", "")
+	require.NoError(t, err)
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 2)
+	for i := 0; i < 2; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 5; j++ {
+				n, err := NewReadOnly(img, testSrv.URL, path)
+				if err != nil {
+					errs <- err
+					return
+				}
+				_, err = n.GetSignedMetadata(img.Tag)
+				// Clearing one's cache does not affect the other's
+				if clearErr := n.ClearDir(); err == nil {
+					err = clearErr
+				}
+				if err != nil {
+					errs <- err
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		require.NoError(t, err)
+	}
+
+	// All the caches are cleared
+	entries, err := os.ReadDir(path)
+	require.NoError(t, err)
+	require.Empty(t, entries)
+}
+
+func TestPing(t *testing.T) {
+	authSrv, err := notarytest.New(true)
+	require.NoError(t, err)
+	defer authSrv.Close()
+
+	noAuthSrv, err := notarytest.New(false)
+	require.NoError(t, err)
+	defer noAuthSrv.Close()
+
+	closedSrv, err := notarytest.New(false)
+	require.NoError(t, err)
+	closedSrv.Close()
+
+	require.NoError(t, Ping(authSrv.URL, nil), "auth challenge")
+	require.NoError(t, Ping(noAuthSrv.URL, nil), "no auth")
+	require.Error(t, Ping(closedSrv.URL, nil), "unreachable")
+}
+
+func TestPing_unixSocket(t *testing.T) {
+	socket := fmt.Sprintf("%s/notary-%s.sock", os.TempDir(), utils.RandomString(10))
+	listener, err := net.Listen("unix", socket)
+	require.NoError(t, err)
+
+	// Canned notary ping response
+	srv := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.URL.Path != "/v2" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Header().Set("WWW-Authenticate", `Bearer realm="http://localhost/token",service="notary-server"`)
+		w.WriteHeader(http.StatusUnauthorized)
+	})}
+	go func() {
+		_ = srv.Serve(listener)
+	}()
+	defer func() {
+		_ = srv.Close()
+	}()
+
+	require.NoError(t, Ping(UnixSocketScheme+socket, nil))
+	require.Error(t, Ping(UnixSocketScheme+socket+"-not-exist", nil), "no socket")
+}
+
+func TestNewReadOnly_unixSocket(t *testing.T) {
+	testSrv, err := notarytest.New(false)
+	require.NoError(t, err)
+	defer testSrv.Close()
+
+	// Serve the notary mock server over the unix socket
+	socket := fmt.Sprintf("%s/notary-%s.sock", os.TempDir(), utils.RandomString(10))
+	listener, err := net.Listen("unix", socket)
+	require.NoError(t, err)
+	srv := &http.Server{Handler: testSrv.Config.Handler}
+	go func() {
+		_ = srv.Serve(listener)
+	}()
+	defer func() {
+		_ = srv.Close()
+	}()
+
+	_, err = testSrv.SignImage(testSrv.URL, "test.io", "socket-repo", "signed-tag", "111111111111111111111111111111")
+	require.NoError(t, err)
+
+	img, err := image.NewImage("test.io/socket-repo:signed-tag", "")
+	require.NoError(t, err)
+	not, err := NewReadOnly(img, UnixSocketScheme+socket, fmt.Sprintf("%s/notary/%s", os.TempDir(), utils.RandomString(10)))
+	require.NoError(t, err)
+	defer func() {
+		require.NoError(t, not.ClearDir())
+	}()
+
+	repo, err := not.GetSignedMetadata("signed-tag")
+	require.NoError(t, err)
+	require.Equal(t, "test.io/socket-repo", repo.Name)
+}
+
+func TestNewPingRequest(t *testing.T) {
+	tc := map[string]struct {
+		notaryURL string
+
+		expectedURL string
+	}{
+		"noPrefix":          {notaryURL: "https://notary.test", expectedURL: "https://notary.test/v2"},
+		"prefix":            {notaryURL: "https://notary.test/notary", expectedURL: "https://notary.test/notary/v2"},
+		"prefixWithSlash":   {notaryURL: "https://notary.test/notary/", expectedURL: "https://notary.test/notary/v2"},
+		"nestedPrefix":      {notaryURL: "https://notary.test:8443/a/notary", expectedURL: "https://notary.test:8443/a/notary/v2"},
+		"noPrefixWithSlash": {notaryURL: "https://notary.test/", expectedURL: "https://notary.test/v2"},
+	}
+
+	for name, c := range tc {
+		t.Run(name, func(t *testing.T) {
+			req, err := newPingRequest(c.notaryURL)
+			require.NoError(t, err)
+			require.Equal(t, c.expectedURL, req.URL.String())
+		})
+	}
+}
+
+func TestNewReadOnly_pathPrefix(t *testing.T) {
+	testSrv, err := notarytest.New(false)
+	require.NoError(t, err)
+	defer testSrv.Close()
+
+	// Serve the notary mock server under /notary, as a prefix-routing ingress does
+	prefixSrv := httptest.NewTLSServer(http.StripPrefix("/notary", testSrv.Config.Handler))
+	defer prefixSrv.Close()
+
+	_, err = testSrv.SignImage(testSrv.URL, "test.io", "prefix-repo", "signed-tag", "111111111111111111111111111111")
+	require.NoError(t, err)
+
+	img, err := image.NewImage("test.io/prefix-repo:signed-tag", "")
+	require.NoError(t, err)
+	not, err := NewReadOnly(img, prefixSrv.URL+"/notary", fmt.Sprintf("%s/notary/%s", os.TempDir(), utils.RandomString(10)))
+	require.NoError(t, err)
+	defer func() {
+		require.NoError(t, not.ClearDir())
+	}()
+
+	repo, err := not.GetSignedMetadata("signed-tag")
+	require.NoError(t, err)
+	require.Equal(t, "test.io/prefix-repo", repo.Name)
+	require.Len(t, repo.SignedTags, 1)
+}
+
+func TestNotaryRepo_fetchToken(t *testing.T) {
+	tc := map[string]struct {
+		challenges []string
+
+		expectedToken *auth.Token
+		expectedErr   bool
+	}{
+		"bearer": {
+			challenges:    []string{`Bearer realm="%s/token",service="notary"`},
+			expectedToken: &auth.Token{Type: auth.TokenTypeBearer, Value: "test-token"},
+		},
+		"basicAndBearer": {
+			challenges:    []string{`Basic realm="notary"`, `Bearer realm="%s/token",service="notary"`},
+			expectedToken: &auth.Token{Type: auth.TokenTypeBearer, Value: "test-token"},
+		},
+		"bearerNotFirst": {
+			challenges:    []string{`Basic realm="%s/basic-token",service="notary"`, `Digest realm="notary"`, `Bearer realm="%s/token",service="notary"`},
+			expectedToken: &auth.Token{Type: auth.TokenTypeBearer, Value: "test-token"},
+		},
+		"otherWithRealmAndService": {
+			challenges:    []string{`Digest realm="notary"`, `Basic realm="%s/basic-token",service="notary"`},
+			expectedToken: &auth.Token{Type: auth.TokenTypeBearer, Value: "basic-token"},
+		},
+		"bearerWithoutService": {
+			challenges:  []string{`Bearer realm="%s/token"`},
+			expectedErr: true,
+		},
+		"onlyBasic": {
+			challenges:  []string{`Basic realm="notary"`},
+			expectedErr: true,
+		},
+		"noChallenge": {
+			expectedErr: true,
+		},
+	}
+
+	for name, c := range tc {
+		t.Run(name, func(t *testing.T) {
+			var srvURL string
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+				switch req.URL.Path {
+				case "/v2":
+					for _, ch := range c.challenges {
+						w.Header().Add("WWW-Authenticate", strings.ReplaceAll(ch, "%s", srvURL))
+					}
+					w.WriteHeader(http.StatusUnauthorized)
+				case "/token":
+					if req.Header.Get("Accept") != "application/json" || req.URL.Query().Get("service") != "notary" {
+						w.WriteHeader(http.StatusBadRequest)
+						return
+					}
+					_, _ = w.Write([]byte(`{"token":"test-token"}`))
+				case "/basic-token":
+					_, _ = w.Write([]byte(`{"token":"basic-token"}`))
+				default:
+					w.WriteHeader(http.StatusNotFound)
+				}
+			}))
+			defer srv.Close()
+			srvURL = srv.URL
+
+			img, err := image.NewImage("test.io/token-repo:signed-tag", "")
+			require.NoError(t, err)
+			n := &notaryRepo{notaryServerURL: srv.URL, image: img, transport: newTransport((&net.Dialer{}).DialContext)}
+			err = n.fetchToken()
+			if c.expectedErr {
+				require.Error(t, err)
+			} else {
+				require.NoError(t, err)
+				require.Equal(t, c.expectedToken, n.token)
+			}
+		})
+	}
+}
+
+func TestIsNoTrustData(t *testing.T) {
+	tc := map[string]struct {
+		err error
+
+		expectedNoTrustData bool
+	}{
+		"repositoryNotExist": {
+			err:                 client.ErrRepositoryNotExist{},
+			expectedNoTrustData: true,
+		},
+		"noSuchTarget": {
+			err:                 client.ErrNoSuchTarget("test-tag"),
+			expectedNoTrustData: true,
+		},
+		"wrapped": {
+			err:                 fmt.Errorf("failed to get metadata: %w", client.ErrNoSuchTarget("test-tag")),
+			expectedNoTrustData: true,
+		},
+		"serverUnavailable": {
+			err: storage.ErrServerUnavailable{},
+		},
+		"other": {
+			err: fmt.Errorf("connection refused"),
+		},
+	}
+
+	for name, c := range tc {
+		t.Run(name, func(t *testing.T) {
+			require.Equal(t, c.expectedNoTrustData, IsNoTrustData(c.err))
+		})
+	}
+}
+
+func TestAdministrativeKeys(t *testing.T) {
+	roles := []client.RoleWithSignatures{
+		{Role: data.Role{Name: data.CanonicalRootRole, RootRole: data.RootRole{KeyIDs: []string{"root-2", "root-1"}}}},
+		{Role: data.Role{Name: data.CanonicalTargetsRole, RootRole: data.RootRole{KeyIDs: []string{"targets-1"}}}},
+		{Role: data.Role{Name: data.CanonicalSnapshotRole, RootRole: data.RootRole{KeyIDs: []string{"snapshot-1"}}}},
+		{Role: data.Role{Name: ReleasesRole, RootRole: data.RootRole{KeyIDs: []string{"releases-1"}}}},
+	}
+
+	require.Equal(t, map[string][]string{
+		RootAdminRole:       {"root-1", "root-2"},
+		RepositoryAdminRole: {"targets-1"},
+	}, administrativeKeys(roles))
+	require.Equal(t, []string{"root-2", "root-1"}, roles[0].KeyIDs, "the roles are not changed")
+}
+
+func TestMatchReleasedSignatures(t *testing.T) {
+	targets := []client.TargetSignedStruct{
+		{
+			Role:   data.DelegationRole{BaseRole: data.BaseRole{Name: data.CanonicalTargetsRole}},
+			Target: client.Target{Name: "sha256-tag", Hashes: data.Hashes{notary.SHA256: []byte{0x11}, notary.SHA512: []byte{0x22}}},
+		},
+		{
+			Role:   data.DelegationRole{BaseRole: data.BaseRole{Name: ReleasesRole}},
+			Target: client.Target{Name: "sha512-tag", Hashes: data.Hashes{notary.SHA512: []byte{0x33}}},
+		},
+	}
+
+	rows := matchReleasedSignatures(targets, nil)
+	require.Len(t, rows, 2)
+	require.Equal(t, SignedTagKey{SignedTag: "sha256-tag", Digest: "11", Algorithm: notary.SHA256}, rows[0].SignedTagKey)
+	require.Equal(t, SignedTagKey{SignedTag: "sha512-tag", Digest: "33", Algorithm: notary.SHA512}, rows[1].SignedTagKey)
+}
+
+func TestMatchReleasedSignatures_releaseRoles(t *testing.T) {
+	prodTarget := client.Target{Name: "prod-tag", Hashes: data.Hashes{notary.SHA256: []byte{0x11}}}
+	stableTarget := client.Target{Name: "stable-tag", Hashes: data.Hashes{notary.SHA256: []byte{0x22}}}
+	targets := []client.TargetSignedStruct{
+		{Role: data.DelegationRole{BaseRole: data.BaseRole{Name: "targets/prod"}}, Target: prodTarget},
+		{Role: data.DelegationRole{BaseRole: data.BaseRole{Name: "targets/signer-1"}}, Target: prodTarget},
+		// The tag is signed only into the custom release role of the repository
+		{Role: data.DelegationRole{BaseRole: data.BaseRole{Name: "targets/stable"}}, Target: stableTarget},
+		{Role: data.DelegationRole{BaseRole: data.BaseRole{Name: "targets/signer-2"}}, Target: stableTarget},
+	}
+
+	tc := map[string]struct {
+		releaseRoles []string
+
+		expectedRows []SignedTagRow
+	}{
+		"defaultRoles": {
+			expectedRows: []SignedTagRow{},
+		},
+		"customRole": {
+			releaseRoles: []string{"targets/prod"},
+			expectedRows: []SignedTagRow{
+				{SignedTagKey: SignedTagKey{SignedTag: "prod-tag", Digest: "11", Algorithm: notary.SHA256}, Signers: []string{"signer-1"}},
+			},
+		},
+		"otherCustomRole": {
+			releaseRoles: []string{"targets/staging"},
+			expectedRows: []SignedTagRow{},
+		},
+		"repositoryRole": {
+			releaseRoles: []string{"targets/staging", "targets/stable"},
+			expectedRows: []SignedTagRow{
+				{SignedTagKey: SignedTagKey{SignedTag: "stable-tag", Digest: "22", Algorithm: notary.SHA256}, Signers: []string{"signer-2"}},
+			},
+		},
+	}
+
+	for name, c := range tc {
+		t.Run(name, func(t *testing.T) {
+			require.Equal(t, c.expectedRows, matchReleasedSignatures(targets, c.releaseRoles))
+		})
+	}
+}
+
+func TestMatchUnreleasedSignatures(t *testing.T) {
+	unreleasedTarget := client.Target{Name: "unreleased-tag", Hashes: data.Hashes{notary.SHA256: []byte{0x11}}}
+	releasedTarget := client.Target{Name: "released-tag", Hashes: data.Hashes{notary.SHA256: []byte{0x22}}}
+	targets := []client.TargetSignedStruct{
+		{Role: data.DelegationRole{BaseRole: data.BaseRole{Name: "targets/signer-1"}}, Target: unreleasedTarget},
+		{Role: data.DelegationRole{BaseRole: data.BaseRole{Name: "targets/signer-2"}}, Target: unreleasedTarget},
+		{Role: data.DelegationRole{BaseRole: data.BaseRole{Name: ReleasesRole}}, Target: releasedTarget},
+		{Role: data.DelegationRole{BaseRole: data.BaseRole{Name: "targets/signer-1"}}, Target: releasedTarget},
+	}
+
+	tc := map[string]struct {
+		releaseRoles []string
+
+		expectedRows []SignedTagRow
+	}{
+		"delegationWithoutRelease": {
+			expectedRows: []SignedTagRow{
+				{SignedTagKey: SignedTagKey{SignedTag: "unreleased-tag", Digest: "11", Algorithm: notary.SHA256}, Signers: []string{"signer-1", "signer-2"}},
+			},
+		},
+		"releasedByCustomRole": {
+			releaseRoles: []string{"targets/signer-1"},
+			expectedRows: []SignedTagRow{},
+		},
+	}
+
+	for name, c := range tc {
+		t.Run(name, func(t *testing.T) {
+			require.Equal(t, c.expectedRows, matchUnreleasedSignatures(targets, c.releaseRoles))
+		})
+	}
+}
+
+func TestSetSignedAt(t *testing.T) {
+	tagTarget := client.Target{Name: "tag", Hashes: data.Hashes{notary.SHA256: []byte{0x11}}}
+	otherTarget := client.Target{Name: "other-tag", Hashes: data.Hashes{notary.SHA256: []byte{0x22}}}
+	targets := []client.TargetSignedStruct{
+		{Role: data.DelegationRole{BaseRole: data.BaseRole{Name: data.CanonicalTargetsRole}}, Target: tagTarget},
+		{Role: data.DelegationRole{BaseRole: data.BaseRole{Name: ReleasesRole}}, Target: tagTarget},
+		{Role: data.DelegationRole{BaseRole: data.BaseRole{Name: "targets/signer-1"}}, Target: tagTarget},
+		{Role: data.DelegationRole{BaseRole: data.BaseRole{Name: ReleasesRole}}, Target: otherTarget},
+	}
+	rows := matchReleasedSignatures(targets, nil)
+
+	old := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	recent := time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC)
+	roleExpires := map[data.RoleName]time.Time{
+		data.CanonicalTargetsRole: old.Add(DefaultRoleExpiry),
+		ReleasesRole:              recent.Add(DefaultRoleExpiry),
+		// Not a released role
+		"targets/signer-1": time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC).Add(DefaultRoleExpiry),
+	}
+	setSignedAt(rows, targets, nil, func(role data.RoleName) (time.Time, error) {
+		return roleExpires[role], nil
+	})
+
+	require.Len(t, rows, 2)
+	require.Equal(t, "other-tag", rows[0].SignedTag)
+	require.Equal(t, recent, rows[0].SignedAt)
+	require.Equal(t, recent.Add(DefaultRoleExpiry), rows[0].Expires)
+	require.Equal(t, "tag", rows[1].SignedTag)
+	require.Equal(t, recent, rows[1].SignedAt)
+
+	// Unknown if the metadata can't be read
+	setSignedAt(rows, targets, nil, func(role data.RoleName) (time.Time, error) {
+		return time.Time{}, fmt.Errorf("no metadata")
+	})
+	require.True(t, rows[0].SignedAt.IsZero())
+}
+
+func TestMatchReleasedSignatures_manyTargets(t *testing.T) {
+	targets := testManyTargets(5000)
+
+	rows := matchReleasedSignatures(targets, nil)
+	require.Len(t, rows, 5000)
+	for _, row := range rows {
+		require.Equal(t, []string{"signer-1", "signer-2"}, row.Signers, "signers of %s", row.SignedTag)
+	}
+}
+
+func BenchmarkMatchReleasedSignatures(b *testing.B) {
+	targets := testManyTargets(10000)
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		matchReleasedSignatures(targets, nil)
+	}
+}
+
+// testManyTargets returns the targets of n released tags, each signed by two signers, one of which signed it twice
+func testManyTargets(n int) []client.TargetSignedStruct {
+	var targets []client.TargetSignedStruct
+	for i := 0; i < n; i++ {
+		target := client.Target{Name: fmt.Sprintf("tag-%d", i), Hashes: data.Hashes{notary.SHA256: []byte(fmt.Sprintf("%032d", i))}}
+		targets = append(targets,
+			client.TargetSignedStruct{Role: data.DelegationRole{BaseRole: data.BaseRole{Name: ReleasesRole}}, Target: target},
+			client.TargetSignedStruct{Role: data.DelegationRole{BaseRole: data.BaseRole{Name: "targets/signer-1"}}, Target: target},
+			client.TargetSignedStruct{Role: data.DelegationRole{BaseRole: data.BaseRole{Name: "targets/signer-2"}}, Target: target},
+			client.TargetSignedStruct{Role: data.DelegationRole{BaseRole: data.BaseRole{Name: "targets/signer-2"}}, Target: target},
+		)
+	}
+	return targets
+}
+
+func TestNewReadOnly_hostAliases(t *testing.T) {
+	testSrv, err := notarytest.New(false)
+	require.NoError(t, err)
+	defer testSrv.Close()
+
+	_, err = testSrv.SignImage(testSrv.URL, "test.io", "aliased-repo", "signed-tag", "111111111111111111111111111111")
+	require.NoError(t, err)
+
+	// The fake host doesn't resolve by DNS, but to the local listener by its alias
+	srvURL, err := url.Parse(testSrv.URL)
+	require.NoError(t, err)
+	notaryURL := "https://notary.fake:" + srvURL.Port()
+	profile := ClientProfile{HostAliases: map[string]string{"Notary.Fake": srvURL.Hostname()}}
+
+	img, err := image.NewImage("test.io/aliased-repo:signed-tag", "")
+	require.NoError(t, err)
+	not, err := NewProfiledReadOnly(img, notaryURL, fmt.Sprintf("%s/notary/%s", os.TempDir(), utils.RandomString(10)), profile)
+	require.NoError(t, err)
+	defer func() {
+		require.NoError(t, not.ClearDir())
+	}()
+
+	repo, err := not.GetSignedMetadata("signed-tag")
+	require.NoError(t, err)
+	require.Equal(t, "test.io/aliased-repo", repo.Name)
+	require.NoError(t, Ping(notaryURL, profile.HostAliases))
+
+	// Without the alias, the host doesn't resolve
+	_, err = NewReadOnly(img, notaryURL, fmt.Sprintf("%s/notary/%s", os.TempDir(), utils.RandomString(10)))
+	require.Error(t, err)
+}
@@ -6,6 +6,7 @@
 package v1
 
 import (
+	"k8s.io/apimachinery/pkg/apis/meta/v1"
 	runtime "k8s.io/apimachinery/pkg/runtime"
 )
 
@@ -172,11 +173,90 @@ func (in *RegistrySecurityPolicySpec) DeepCopy() *RegistrySecurityPolicySpec {
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *RegistrySpec) DeepCopyInto(out *RegistrySpec) {
 	*out = *in
+	if in.Aliases != nil {
+		in, out := &in.Aliases, &out.Aliases
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
 	if in.Signer != nil {
 		in, out := &in.Signer, &out.Signer
 		*out = make([]string, len(*in))
 		copy(*out, *in)
 	}
+	if in.SignerNames != nil {
+		in, out := &in.SignerNames, &out.SignerNames
+		*out = make(map[string]string, len(*in))
+		for key, val := range *in {
+			(*out)[key] = val
+		}
+	}
+	if in.AdminKeys != nil {
+		in, out := &in.AdminKeys, &out.AdminKeys
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.ReleaseRoles != nil {
+		in, out := &in.ReleaseRoles, &out.ReleaseRoles
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.RepositoryReleaseRoles != nil {
+		in, out := &in.RepositoryReleaseRoles, &out.RepositoryReleaseRoles
+		*out = make(map[string][]string, len(*in))
+		for key, val := range *in {
+			var outVal []string
+			if val == nil {
+				(*out)[key] = nil
+			} else {
+				in, out := &val, &outVal
+				*out = make([]string, len(*in))
+				copy(*out, *in)
+			}
+			(*out)[key] = outVal
+		}
+	}
+	if in.RequiredArchitectures != nil {
+		in, out := &in.RequiredArchitectures, &out.RequiredArchitectures
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.TrustedLabels != nil {
+		in, out := &in.TrustedLabels, &out.TrustedLabels
+		*out = make(map[string]string, len(*in))
+		for key, val := range *in {
+			(*out)[key] = val
+		}
+	}
+	if in.MaxSignatureAge != nil {
+		in, out := &in.MaxSignatureAge, &out.MaxSignatureAge
+		*out = new(v1.Duration)
+		**out = **in
+	}
+	if in.SignatureExpiry != nil {
+		in, out := &in.SignatureExpiry, &out.SignatureExpiry
+		*out = new(v1.Duration)
+		**out = **in
+	}
+	if in.SignatureAgeWarning != nil {
+		in, out := &in.SignatureAgeWarning, &out.SignatureAgeWarning
+		*out = new(v1.Duration)
+		**out = **in
+	}
+	if in.Timeout != nil {
+		in, out := &in.Timeout, &out.Timeout
+		*out = new(v1.Duration)
+		**out = **in
+	}
+	if in.ReadTimeout != nil {
+		in, out := &in.ReadTimeout, &out.ReadTimeout
+		*out = new(v1.Duration)
+		**out = **in
+	}
+	if in.RetryBackoff != nil {
+		in, out := &in.RetryBackoff, &out.RetryBackoff
+		*out = new(v1.Duration)
+		**out = **in
+	}
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new RegistrySpec.
@@ -6,6 +6,7 @@ kubectl delete -f deploy/validating-webhook.yaml
 kubectl delete -f deploy/service.yaml
 kubectl delete -f deploy/deployment.yaml
 kubectl delete -f deploy/whitelist-configmap.yaml
+kubectl delete -f deploy/denylist-configmap.yaml
 
 kubectl delete -f deploy/role/role-binding.yaml
 kubectl delete -f deploy/role/role.yaml